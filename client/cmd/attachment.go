@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attachFile uploads an EPUB or PDF file to a book. Without a title the file must be an EPUB, which is
+// attached to the book titled in its metadata. The server creates a missing book from the metadata of
+// an EPUB.
+func attachFile(cmd *cobra.Command, args []string) (string, error) {
+	path := args[len(args)-1]
+	file, err := os.Open(path)
+	if err != nil {
+		return "", usageError(err)
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", usageError(err)
+	}
+	// the server tells an EPUB from other zip archives by its content
+	contentType := http.DetectContentType(head[:n])
+	switch contentType {
+	case "application/zip":
+		contentType = "application/epub+zip"
+	case "application/pdf":
+		if len(args) == 1 {
+			return "", usageError(fmt.Errorf("%s is a PDF, give the title of the book to attach it to", path))
+		}
+	default:
+		return "", usageError(fmt.Errorf("%s is not an EPUB or PDF", path))
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	name, _ := cmd.Flags().GetString("name")
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	params.Set("name", name)
+
+	endpoint := "/book/files"
+	if len(args) == 2 {
+		endpoint = filesEndpoint(args[0])
+	}
+	headers := map[string]string{"Content-Type": contentType}
+	resp, err := sendBody(http.MethodPost, endpoint, params, file, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	response, err := readResponse(resp)
+	if err != nil {
+		return "", err
+	}
+
+	return prettyPrintResponse(response, false, response.Message)
+}
+
+// listFiles lists the files attached to a book
+func listFiles(cmd *cobra.Command, args []string) (string, error) {
+	attachments, err := getAttachments(args[0])
+	if err != nil {
+		return "", err
+	}
+	if len(attachments) == 0 {
+		return fmt.Sprintf("No files attached to %q", args[0]), nil
+	}
+
+	rows := make([][]string, len(attachments))
+	for i, attachment := range attachments {
+		rows[i] = []string{
+			attachment.Name,
+			attachment.ContentType,
+			formatSize(attachment.Size),
+			attachment.Hash[:12],
+			attachment.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return formatTable([]string{"name", "type", "size", "hash", "attached"}, rows), nil
+}
+
+// downloadFile downloads a file attached to a book, named by its file name or a prefix of its hash, to
+// --output or else its file name, an output of - being standard output. The file may be left out when
+// the book has a single file.
+func downloadFile(cmd *cobra.Command, args []string) (string, error) {
+	attachments, err := getAttachments(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	var matches []api.Attachment
+	for _, attachment := range attachments {
+		if len(args) == 1 || attachment.Name == args[1] || strings.HasPrefix(attachment.Hash, strings.ToLower(args[1])) {
+			matches = append(matches, attachment)
+		}
+	}
+	switch {
+	case len(attachments) == 0:
+		return "", &APIError{StatusCode: http.StatusNotFound, Code: api.CodeFileNotFound, Message: fmt.Sprintf("No files attached to %q", args[0])}
+	case len(matches) == 0:
+		return "", &APIError{StatusCode: http.StatusNotFound, Code: api.CodeFileNotFound, Message: fmt.Sprintf("No file %q attached to %q", args[1], args[0])}
+	case len(matches) > 1 && len(args) == 1:
+		return "", usageError(fmt.Errorf("%q has %d files, give the name or hash of the file to download", args[0], len(matches)))
+	case len(matches) > 1:
+		return "", usageError(fmt.Errorf("%q matches %d files of %q, give more of the hash", args[1], len(matches), args[0]))
+	}
+
+	attachment := matches[0]
+	path, _ := cmd.Flags().GetString("output")
+	if path == "-" {
+		return download(cmd, filesEndpoint(args[0])+"/"+attachment.Hash, nil, "")
+	}
+	if path == "" {
+		// the name comes from the server, so only its last element is used to stay in the current folder
+		path = filepath.Base(attachment.Name)
+		if path == "." || path == ".." || path == string(filepath.Separator) {
+			return "", usageError(fmt.Errorf("%q is not a file name, give a path to download to with --output", attachment.Name))
+		}
+	}
+	if _, err := download(cmd, filesEndpoint(args[0])+"/"+attachment.Hash, nil, path); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Downloaded %s (%s) to %s", attachment.Name, formatSize(attachment.Size), path), nil
+}
+
+// getAttachments returns the files attached to a book
+func getAttachments(title string) ([]api.Attachment, error) {
+	resp, err := makeRequest(http.MethodGet, filesEndpoint(title), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var attachments []api.Attachment
+	if err := decodeData(resp, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// filesEndpoint is the files endpoint of a book, identified by its title escaped as a path segment
+func filesEndpoint(title string) string {
+	return "/book/" + url.PathEscape(title) + "/files"
+}
+
+// formatSize formats a number of bytes in the largest binary unit it reaches
+func formatSize(size int64) string {
+	if size < 1<<10 {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	value, unit := float64(size)/(1<<10), "KiB"
+	for _, next := range []string{"MiB", "GiB"} {
+		if value < 1<<10 {
+			break
+		}
+		value, unit = value/(1<<10), next
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// barWidth is the width in characters of the longest bar of a bar chart
+const barWidth = 30
+
+// sparklineWidth is the most characters a sparkline is drawn in, so that it fits a terminal line with its labels
+const sparklineWidth = 60
+
+// barBlocks are the partial blocks drawing the end of a bar in eighths of a character
+var barBlocks = []rune(" ▏▎▍▌▋▊▉")
+
+// sparkBlocks are the heights of a sparkline from lowest to highest
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// bar draws a horizontal bar for value, scaled so that maxValue fills barWidth characters
+func bar(value int, maxValue int) string {
+	if maxValue <= 0 || value <= 0 {
+		return ""
+	}
+	eighths := value * barWidth * 8 / maxValue
+	if eighths == 0 {
+		// every non zero value is visible
+		eighths = 1
+	}
+	bar := strings.Repeat("█", eighths/8)
+	if eighths%8 > 0 {
+		bar += string(barBlocks[eighths%8])
+	}
+	return bar
+}
+
+// sparkline draws the values as a line of blocks scaled between zero and the largest value
+func sparkline(values []int) string {
+	maxValue := 0
+	for _, value := range values {
+		if value > maxValue {
+			maxValue = value
+		}
+	}
+
+	var builder strings.Builder
+	for _, value := range values {
+		level := 0
+		if maxValue > 0 {
+			level = value * (len(sparkBlocks) - 1) / maxValue
+		}
+		// a non zero value is drawn above an empty one
+		if value > 0 && level == 0 {
+			level = 1
+		}
+		builder.WriteRune(sparkBlocks[level])
+	}
+	return builder.String()
+}
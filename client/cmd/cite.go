@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"bms/shared/citation"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// citeCollection prints the books of a collection as BibTeX, RIS or CSL-JSON citations, or writes them to a file
+func citeCollection(cmd *cobra.Command, args []string) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	path, _ := cmd.Flags().GetString("output")
+
+	switch citation.Format(format) {
+	case citation.BibTeX, citation.RIS, citation.CSLJSON:
+	default:
+		return "", usageError(fmt.Errorf("invalid format %q, must be bibtex, ris or csl-json", format))
+	}
+
+	params := url.Values{}
+	params.Set("collection_name", args[0])
+	params.Set("format", format)
+	return download(cmd, "/collection/cite", params, path)
+}
+
+// importCitations reads the entries of a BibTeX or RIS file, or standard input for -, and imports them
+// as books in the collection, which is created if it does not exist
+func importCitations(cmd *cobra.Command, args []string) (string, error) {
+	formatName, _ := cmd.Flags().GetString("format")
+	mode, _ := cmd.Flags().GetString("mode")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	if mode != string(api.ImportSkip) && mode != string(api.ImportOverwrite) && mode != string(api.ImportMerge) {
+		return "", usageError(fmt.Errorf("invalid mode %q, must be skip, overwrite or merge", mode))
+	}
+	format := citation.Format(formatName)
+	switch format {
+	case "auto", citation.BibTeX, citation.RIS:
+	default:
+		return "", usageError(fmt.Errorf("invalid format %q, must be auto, bibtex or ris", formatName))
+	}
+
+	var data []byte
+	var err error
+	if args[1] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(args[1])
+	}
+	if err != nil {
+		return "", usageError(err)
+	}
+
+	if formatName == "auto" {
+		format, err = citation.DetectFormat(args[1], data)
+		if err != nil {
+			return "", &ExitError{Code: ExitInvalid, Err: err}
+		}
+	}
+	entries, err := citation.Read(bytes.NewReader(data), format)
+	if err != nil {
+		return "", &ExitError{Code: ExitInvalid, Err: err}
+	}
+	if len(entries) == 0 {
+		return "", &ExitError{Code: ExitInvalid, Err: errors.New("no entries to import")}
+	}
+
+	books := make([]api.ImportBook, len(entries))
+	var failures []string
+	for i, entry := range entries {
+		if entry.Book.Title == "" {
+			failures = append(failures, fmt.Sprintf("%s: title cannot be empty", entryPosition(i, entry)))
+		}
+		books[i] = api.ImportBook{Book: entry.Book, Collections: []string{args[0]}}
+	}
+	if len(failures) > 0 {
+		failures = append(failures, fmt.Sprintf("%d of %d entries are invalid, nothing was imported", len(failures), len(entries)))
+		return "", &ExitError{Code: ExitInvalid, Err: errors.New(strings.Join(failures, "\n"))}
+	}
+
+	positions := make([]string, len(entries))
+	for i, entry := range entries {
+		positions[i] = entryPosition(i, entry)
+	}
+	report, err := postImport(books, positions, mode, dryRun)
+	if err != nil {
+		return "", err
+	}
+	return formatImportReport(report), nil
+}
+
+// entryPosition describes an entry by its position in the file and its citation key
+func entryPosition(index int, entry citation.Entry) string {
+	if entry.Key == "" {
+		return fmt.Sprintf("entry %d", index+1)
+	}
+	return fmt.Sprintf("entry %d (%s)", index+1, entry.Key)
+}
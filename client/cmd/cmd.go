@@ -1,12 +1,15 @@
 package cmd
 
 import (
+	"bms/shared/api"
 	"github.com/spf13/cobra"
 )
 
 var RootCmd = &cobra.Command{
 	Use:   "bms",
 	Short: "Book management CLI",
+	// errors are printed to stderr by cobra, usage is only shown on --help
+	SilenceUsage: true,
 }
 
 var bookCmd = &cobra.Command{
@@ -17,36 +20,108 @@ var bookCmd = &cobra.Command{
 var listBookCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List books",
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(listBooks(cmd, args))
-	},
+	RunE:  runCommand(listBooks),
+}
+
+var searchBookCmd = &cobra.Command{
+	Use:   "search",
+	Short: "Search books by title, author and description",
+	Args:  exactArgs(1),
+	RunE:  runCommand(searchBooks),
+}
+
+var bookFacetsCmd = &cobra.Command{
+	Use:   "facets",
+	Short: "Count books by genre, author, edition, publisher, year or decade",
+	RunE:  runCommand(bookFacets),
 }
 
 var createBookCmd = &cobra.Command{
 	Use:   "create",
-	Short: "Create a book",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(createBook(cmd, args))
-	},
+	Short: "Create a book, or with --from-isbn a book from the metadata of an ISBN",
+	Args:  createBookArgs,
+	RunE:  runCommand(createBook),
+}
+
+var importBookCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import books from a CSV, JSON, NDJSON, MARC21 or MARCXML file, - reads standard input",
+	Args:  exactArgs(1),
+	RunE:  runCommand(importBooks),
+}
+
+var exportBookCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export books as CSV, JSON, NDJSON, YAML, MARC21 or MARCXML",
+	RunE:  runCommand(exportBooks),
 }
 
 var setBookCmd = &cobra.Command{
 	Use:   "set",
 	Short: "Set a book",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(setBook(cmd, args))
-	},
+	Args:  exactArgs(1),
+	RunE:  runCommand(setBook),
 }
 
 var removeBookCmd = &cobra.Command{
 	Use:   "remove",
 	Short: "Remove a book",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(removeBook(cmd, args))
-	},
+	Args:  exactArgs(1),
+	RunE:  runCommand(removeBook),
+}
+
+var enrichBookCmd = &cobra.Command{
+	Use:   "enrich",
+	Short: "Fill in the fields of a book from the metadata of its ISBN, showing the changes before applying them",
+	Args:  exactArgs(1),
+	RunE:  runCommand(enrichBook),
+}
+
+var coverBookCmd = &cobra.Command{
+	Use:   "cover",
+	Short: "Commands involving book covers",
+}
+
+var setCoverCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set the cover of a book to a JPEG, PNG or GIF image",
+	Args:  exactArgs(2),
+	RunE:  runCommand(setCover),
+}
+
+var attachBookCmd = &cobra.Command{
+	Use:   "attach",
+	Short: "Attach an EPUB or PDF file to a book, an EPUB without a title is attached to the book in its metadata, which is created if missing",
+	Args:  rangeArgs(1, 2),
+	RunE:  runCommand(attachFile),
+}
+
+var filesBookCmd = &cobra.Command{
+	Use:   "files",
+	Short: "List the files attached to a book",
+	Args:  exactArgs(1),
+	RunE:  runCommand(listFiles),
+}
+
+var downloadBookCmd = &cobra.Command{
+	Use:   "download",
+	Short: "Download a file attached to a book, which may be left out if the book has a single file",
+	Args:  rangeArgs(1, 2),
+	RunE:  runCommand(downloadFile),
+}
+
+var historyBookCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the revision history of a book",
+	Args:  exactArgs(1),
+	RunE:  runCommand(bookHistory),
+}
+
+var revertBookCmd = &cobra.Command{
+	Use:   "revert",
+	Short: "Revert a book to an earlier revision",
+	Args:  exactArgs(1),
+	RunE:  runCommand(revertBook),
 }
 
 var collectionCmd = &cobra.Command{
@@ -57,76 +132,221 @@ var collectionCmd = &cobra.Command{
 var createCollectionCmd = &cobra.Command{
 	Use:   "create",
 	Short: "Create a collection",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(createCollection(cmd, args))
-	},
+	Args:  exactArgs(1),
+	RunE:  runCommand(createCollection),
 }
 
 var removeCollectionCmd = &cobra.Command{
 	Use:   "remove",
 	Short: "Remove a collection",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(removeCollection(cmd, args))
-	},
+	Args:  exactArgs(1),
+	RunE:  runCommand(removeCollection),
 }
 
 var addBookToCollectionCmd = &cobra.Command{
 	Use:   "add-book",
 	Short: "Add a book to a collection",
-	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(addBookToCollection(cmd, args))
-	},
+	Args:  exactArgs(2),
+	RunE:  runCommand(addBookToCollection),
 }
 
 var removeBookFromCollectionCmd = &cobra.Command{
 	Use:   "remove-book",
 	Short: "Remove a book from a collection",
-	Args:  cobra.ExactArgs(2),
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(removeBookFromCollection(cmd, args))
-	},
+	Args:  exactArgs(2),
+	RunE:  runCommand(removeBookFromCollection),
 }
 
 var listCollectionCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List books in a collection",
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Println(listCollection(cmd, args))
-	},
+	RunE:  runCommand(listCollection),
+}
+
+var citeCollectionCmd = &cobra.Command{
+	Use:   "cite",
+	Short: "Cite the books in a collection as BibTeX, RIS or CSL-JSON",
+	Args:  exactArgs(1),
+	RunE:  runCommand(citeCollection),
+}
+
+var importCollectionCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import books from a BibTeX or RIS file into a collection, - reads standard input",
+	Args:  exactArgs(2),
+	RunE:  runCommand(importCitations),
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import books from other catalogues",
+}
+
+var importGoodreadsCmd = &cobra.Command{
+	Use:   "goodreads",
+	Short: "Import a Goodreads library export, shelves become collections, - reads standard input",
+	Args:  exactArgs(1),
+	RunE:  runCommand(importGoodreads),
+}
+
+var importLibraryThingCmd = &cobra.Command{
+	Use:   "librarything",
+	Short: "Import a LibraryThing CSV or tab separated export, collections are kept, - reads standard input",
+	Args:  exactArgs(1),
+	RunE:  runCommand(importLibraryThing),
+}
+
+var importCalibreCmd = &cobra.Command{
+	Use:   "calibre",
+	Short: "Import a Calibre library from its folder or metadata.db, series become collections",
+	Args:  exactArgs(1),
+	RunE:  runCommand(importCalibre),
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Commands involving the audit log",
+}
+
+var listAuditCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List audit log entries",
+	RunE:  runCommand(listAudit),
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show library statistics",
+	RunE:  runCommand(getStats),
 }
 
 func init() {
+	RootCmd.SetFlagErrorFunc(flagError)
+
 	// optional args for createBookCmd
 	createBookCmd.Flags().StringP("title", "", "", "Title of the book")
 	createBookCmd.Flags().StringP("author", "", "", "Author of the book")
 	createBookCmd.Flags().StringP("genre", "", "", "Genre of the book")
-	createBookCmd.Flags().StringP("publish_date", "", "", "publish date of the book")
+	createBookCmd.Flags().Var(&api.Date{}, "publish_date", "publish date of the book (YYYY, YYYY-MM or YYYY-MM-DD)")
 	createBookCmd.Flags().StringP("description", "", "", "Description of the book")
 	createBookCmd.Flags().StringP("edition", "", "", "Edition of the book")
+	createBookCmd.Flags().StringP("publisher", "", "", "Publisher of the book")
+	createBookCmd.Flags().StringP("from-isbn", "", "", "Look up the book by ISBN, the title argument and field flags replace the fields found")
+	addMetadataFlags(createBookCmd)
 
 	// optional args for listBookCmd
 
-	listBookCmd.Flags().StringP("title", "", "", "Get book with title")
-	listBookCmd.Flags().StringP("author", "", "", "Filter books by author")
-	listBookCmd.Flags().StringP("genre", "", "", "Filter books by genre")
-	listBookCmd.Flags().StringP("publish_start", "", "", "Filter books from publish start date (YYYY-MM-DD)")
-	listBookCmd.Flags().StringP("publish_end", "", "", "Filter books to publish end date (YYYY-MM-DD)")
+	addBookFilterFlags(listBookCmd)
+	listBookCmd.Flags().StringP("sort", "", "", "Sort books by comma separated fields, prefixed with - for descending order (title, author, genre, edition, publisher, publish_date, version)")
+	addPageFlags(listBookCmd)
+
+	// args for bookFacetsCmd
+	bookFacetsCmd.Flags().StringSliceP("by", "", nil, "Fields to count books by: genre, author, edition, publisher, year, decade")
+	bookFacetsCmd.Flags().IntP("limit", "", 0, "Maximum number of values per field (server default 20)")
+	bookFacetsCmd.MarkFlagRequired("by")
+	addBookFilterFlags(bookFacetsCmd)
+
+	// optional args for importBookCmd
+	importBookCmd.Flags().StringP("format", "", "auto", "File format: auto, csv, json, ndjson, marc (MARC21 ISO 2709) or marcxml (auto detects it from the file extension or content)")
+	importBookCmd.Flags().StringSliceP("map", "", nil, "Map a file column to a book field as column=field, or drop it with column=-")
+	importBookCmd.Flags().StringP("mode", "", "skip", "How to import books whose title already exists: skip, overwrite or merge")
+	importBookCmd.Flags().BoolP("dry-run", "", false, "Validate the file and report what would be imported without importing")
+
+	// optional args for exportBookCmd
+	exportBookCmd.Flags().StringP("format", "", "json", "File format: csv, json, ndjson, yaml, marc (MARC21 ISO 2709) or marcxml")
+	exportBookCmd.Flags().StringP("collection", "", "", "Only export the books in a collection")
+	exportBookCmd.Flags().StringP("sort", "", "", "Sort books by comma separated fields, prefixed with - for descending order (title, author, genre, edition, publisher, publish_date, version)")
+	exportBookCmd.Flags().StringP("output", "", "", "Write the export to a file instead of standard output")
+	addBookFilterFlags(exportBookCmd)
+
+	// optional args for searchBookCmd
+	searchBookCmd.Flags().StringP("author", "", "", "Filter results by author")
+	searchBookCmd.Flags().StringP("genre", "", "", "Filter results by genre")
+	searchBookCmd.Flags().StringP("match", "", "", "How the author and genre filters match: exact (default), icase, prefix, contains or trigram")
+	addPageFlags(searchBookCmd)
 
 	// optional args for setBookCmd
 	setBookCmd.Flags().StringP("author", "", "", "Author of the book")
 	setBookCmd.Flags().StringP("genre", "", "", "Genre of the book")
-	setBookCmd.Flags().StringP("publish_date", "", "", "publish date of the book (YYYY-MM-DD)")
+	setBookCmd.Flags().Var(&api.Date{}, "publish_date", "publish date of the book (YYYY, YYYY-MM or YYYY-MM-DD)")
 	setBookCmd.Flags().StringP("description", "", "", "Description of the book")
 	setBookCmd.Flags().StringP("edition", "", "", "Edition of the book")
+	setBookCmd.Flags().StringP("publisher", "", "", "Publisher of the book")
+	setBookCmd.Flags().StringArrayP("unset", "", nil, "Clear a field (author, publish_date, edition, publisher, description, genre), may be repeated")
+	setBookCmd.Flags().IntP("if-match", "", 0, "Only update if the book is at this version (defaults to the version read before updating)")
+	setBookCmd.Flags().BoolP("show-diff", "", false, "Show how your change differs from the latest version on a conflict")
+
+	// optional args for enrichBookCmd
+	enrichBookCmd.Flags().StringP("isbn", "", "", "ISBN to look up (defaults to the ISBN recorded for the book)")
+	enrichBookCmd.Flags().BoolP("overwrite", "", false, "Replace fields that are already set, not only empty fields")
+	enrichBookCmd.Flags().BoolP("show-diff", "", false, "Show how the changes differ from the latest version on a conflict")
+	addMetadataFlags(enrichBookCmd)
+
+	// optional args for listCollectionCmd
+	listCollectionCmd.Flags().StringP("sort", "", "", "Sort collections by name, or the books in a collection by title, prefix with - for descending order")
+	addPageFlags(listCollectionCmd)
+
+	// optional args for attachBookCmd and downloadBookCmd
+	attachBookCmd.Flags().StringP("name", "", "", "File name to attach the file under, the name of the file by default")
+	downloadBookCmd.Flags().StringP("output", "", "", "Write the file to a path instead of its name in the current folder, - for standard output")
+
+	// optional args for citeCollectionCmd
+	citeCollectionCmd.Flags().StringP("format", "", "bibtex", "Citation format: bibtex, ris or csl-json")
+	citeCollectionCmd.Flags().StringP("output", "", "", "Write the citations to a file instead of standard output")
+
+	// optional args for importCollectionCmd
+	importCollectionCmd.Flags().StringP("format", "", "auto", "File format: auto, bibtex or ris (auto detects it from the file extension or content)")
+	importCollectionCmd.Flags().StringP("mode", "", "skip", "How to import books whose title already exists: skip, overwrite or merge")
+	importCollectionCmd.Flags().BoolP("dry-run", "", false, "Validate the file and report what would be imported without importing")
+
+	// optional args for the import subcommands
+	for _, command := range []*cobra.Command{importGoodreadsCmd, importLibraryThingCmd, importCalibreCmd} {
+		command.Flags().StringP("mode", "", "merge", "How to import books whose title already exists: skip, overwrite or merge")
+		command.Flags().BoolP("dry-run", "", false, "Read the export and report what would be imported without importing")
+	}
+	importCalibreCmd.Flags().BoolP("tags-as-collections", "", false, "Also add books to a collection for each of their tags")
+
+	// required args for revertBookCmd
+	revertBookCmd.Flags().IntP("to", "", 0, "Revision number to revert to")
+	revertBookCmd.MarkFlagRequired("to")
+
+	// optional args for revertBookCmd
+	revertBookCmd.Flags().IntP("if-match", "", 0, "Only revert if the book is at this version (defaults to the version read before reverting)")
+
+	// optional args for listAuditCmd
+	listAuditCmd.Flags().StringP("entity", "", "", "Filter entries by entity (book, collection, reading, identifier, cover, file)")
+	listAuditCmd.Flags().StringP("id", "", "", "Filter entries by entity id (book title, collection name)")
+	listAuditCmd.Flags().StringP("actor", "", "", "Filter entries by actor")
+	listAuditCmd.Flags().StringP("action", "", "", "Filter entries by action (create, set, import, remove, add-book, remove-book)")
+	listAuditCmd.Flags().StringP("since", "", "", "Show entries since a duration ago (7d, 12h) or date (YYYY-MM-DD)")
+	listAuditCmd.Flags().StringP("until", "", "", "Show entries until a duration ago (7d, 12h) or date (YYYY-MM-DD)")
+	listAuditCmd.Flags().IntP("limit", "", 0, "Maximum number of entries to return")
+	listAuditCmd.Flags().IntP("offset", "", 0, "Number of entries to skip")
+
+	// optional args for statsCmd
+	statsCmd.Flags().StringP("format", "", "table", "Output format: table, json or csv")
+	statsCmd.Flags().StringP("chart", "", "", "Draw the table counts as a bar chart, or the additions and publish years as sparklines: bar or sparkline")
+	statsCmd.Flags().IntP("months", "", 0, "Number of months of additions to show (server default 12)")
 
 	// book subcommands
 	bookCmd.AddCommand(listBookCmd)
+	bookCmd.AddCommand(searchBookCmd)
+	bookCmd.AddCommand(bookFacetsCmd)
 	bookCmd.AddCommand(createBookCmd)
+	bookCmd.AddCommand(importBookCmd)
+	bookCmd.AddCommand(exportBookCmd)
 	bookCmd.AddCommand(setBookCmd)
 	bookCmd.AddCommand(removeBookCmd)
+	bookCmd.AddCommand(enrichBookCmd)
+	bookCmd.AddCommand(coverBookCmd)
+	bookCmd.AddCommand(attachBookCmd)
+	bookCmd.AddCommand(filesBookCmd)
+	bookCmd.AddCommand(downloadBookCmd)
+	bookCmd.AddCommand(historyBookCmd)
+	bookCmd.AddCommand(revertBookCmd)
+
+	// cover subcommands
+	coverBookCmd.AddCommand(setCoverCmd)
 
 	// collection subcommands
 	collectionCmd.AddCommand(createCollectionCmd)
@@ -134,8 +354,45 @@ func init() {
 	collectionCmd.AddCommand(removeBookFromCollectionCmd)
 	collectionCmd.AddCommand(listCollectionCmd)
 	collectionCmd.AddCommand(removeCollectionCmd)
+	collectionCmd.AddCommand(citeCollectionCmd)
+	collectionCmd.AddCommand(importCollectionCmd)
+
+	// import subcommands
+	importCmd.AddCommand(importGoodreadsCmd)
+	importCmd.AddCommand(importLibraryThingCmd)
+	importCmd.AddCommand(importCalibreCmd)
+
+	// audit subcommands
+	auditCmd.AddCommand(listAuditCmd)
 
 	// root subcommands
 	RootCmd.AddCommand(bookCmd)
 	RootCmd.AddCommand(collectionCmd)
+	RootCmd.AddCommand(importCmd)
+	RootCmd.AddCommand(auditCmd)
+	RootCmd.AddCommand(statsCmd)
+}
+
+// addBookFilterFlags adds the book filter flags of a command listing or counting books
+func addBookFilterFlags(command *cobra.Command) {
+	command.Flags().StringP("title", "", "", "Get book with title")
+	command.Flags().StringP("author", "", "", "Filter books by author")
+	command.Flags().StringP("genre", "", "", "Filter books by genre")
+	command.Flags().Var(&api.Date{}, "publish_start", "Filter books from publish start date (YYYY, YYYY-MM or YYYY-MM-DD)")
+	command.Flags().Var(&api.Date{}, "publish_end", "Filter books to publish end date (YYYY, YYYY-MM or YYYY-MM-DD)")
+	command.Flags().StringP("where", "", "", "Filter books with an expression, e.g. \"genre IN ('fantasy', 'sci-fi') AND publish_date > 1990\"")
+	command.Flags().StringP("match", "", "", "How the title, author and genre filters match: exact (default), icase, prefix, contains or trigram")
+}
+
+// addMetadataFlags adds the flags of a command looking up books by ISBN
+func addMetadataFlags(command *cobra.Command) {
+	command.Flags().StringP("fixtures", "", "", "Look up ISBNs in a JSON file of books keyed by ISBN instead of Open Library")
+	command.Flags().BoolP("yes", "y", false, "Apply the changes without asking for confirmation")
+}
+
+// addPageFlags adds the pagination flags of a list command
+func addPageFlags(command *cobra.Command) {
+	command.Flags().IntP("limit", "", 0, "Maximum number of results per page (server default 100)")
+	command.Flags().StringP("page-token", "", "", "Continue from the page token printed by a previous list")
+	command.Flags().BoolP("all", "", false, "Follow page tokens and list every page")
 }
@@ -9,89 +9,151 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var (
 	ServerUrl = "http://localhost:8080"
+	// Actor identifies the user in the server audit log
+	Actor = defaultActor()
 )
 
+// defaultActor returns the BMS_ACTOR environment variable, falling back to the OS user
+func defaultActor() string {
+	if actor := os.Getenv("BMS_ACTOR"); actor != "" {
+		return actor
+	}
+	return os.Getenv("USER")
+}
+
 func makeRequest(method string, endpoint string, params url.Values, payload interface{}) (api.Response, error) {
-	// Create the URL with query parameters
-	requestURL, err := url.Parse(ServerUrl + endpoint)
+	return makeRequestWithHeaders(method, endpoint, params, payload, nil)
+}
+
+// makeRequestWithHeaders makes a request with additional headers such as If-Match
+func makeRequestWithHeaders(method string, endpoint string, params url.Values, payload interface{}, headers map[string]string) (api.Response, error) {
+	resp, err := sendRequest(method, endpoint, params, payload, headers)
 	if err != nil {
 		return api.Response{}, err
 	}
-	requestURL.RawQuery = params.Encode()
+	defer resp.Body.Close()
+
+	return readResponse(resp)
+}
 
+// sendRequest sends a JSON request to the server, the caller must close the response body
+func sendRequest(method string, endpoint string, params url.Values, payload interface{}, headers map[string]string) (*http.Response, error) {
 	// Convert payload to JSON
 	var payloadBytes []byte
 	if payload != nil {
+		var err error
 		payloadBytes, err = json.Marshal(payload)
 		if err != nil {
-			return api.Response{}, err
+			return nil, err
 		}
 	}
 
+	return sendBody(method, endpoint, params, bytes.NewReader(payloadBytes), headers)
+}
+
+// sendBody sends a request with a body that is JSON unless the headers give another Content-Type,
+// the caller must close the response body
+func sendBody(method string, endpoint string, params url.Values, body io.Reader, headers map[string]string) (*http.Response, error) {
+	// Create the URL with query parameters
+	requestURL, err := url.Parse(ServerUrl + endpoint)
+	if err != nil {
+		return nil, err
+	}
+	requestURL.RawQuery = params.Encode()
+
 	// Create the HTTP request
-	request, err := http.NewRequest(method, requestURL.String(), bytes.NewBuffer(payloadBytes))
+	request, err := http.NewRequest(method, requestURL.String(), body)
 	if err != nil {
-		return api.Response{}, err
+		return nil, err
 	}
 	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	if Actor != "" {
+		request.Header.Set(api.ActorHeader, Actor)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
 
 	// Send the HTTP request
 	client := http.Client{}
 	resp, err := client.Do(request)
 	if err != nil {
-		return api.Response{}, err
+		return nil, &ExitError{Code: ExitUnavailable, Err: err}
 	}
-	defer resp.Body.Close()
+	return resp, nil
+}
 
+// readResponse reads the JSON response of a bms handler
+func readResponse(resp *http.Response) (api.Response, error) {
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return api.Response{}, err
 	}
 
+	// responses that are not from a bms handler, such as an unknown route, are reported by status
 	var response api.Response
 	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return api.Response{}, err
+		return api.Response{}, &APIError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("unexpected response from server: %s", resp.Status)}
 	}
 
 	return response, nil
 }
 
-// prettyPrintResponse formats and prints the response for the cli
-func prettyPrintResponse(response api.Response, printJson bool, successMessage string) string {
-	if response.Type == "error" {
-		return fmt.Sprintf("Error: %s", response.Message)
+// decodeData decodes the data of a response into the given value
+func decodeData(response api.Response, v any) error {
+	data, err := json.Marshal(response.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// getDateFlag returns the value of a flag registered with an api.Date value
+func getDateFlag(cmd *cobra.Command, name string) api.Date {
+	if date, ok := cmd.Flags().Lookup(name).Value.(*api.Date); ok {
+		return *date
+	}
+	return api.Date{}
+}
+
+// prettyPrintResponse formats the response for the cli, error responses are returned as an error
+func prettyPrintResponse(response api.Response, printJson bool, successMessage string) (string, error) {
+	if err := responseError(response); err != nil {
+		return "", err
 	}
 
 	jsonData, err := json.MarshalIndent(response.Data, "", " ")
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
 	}
 
 	if printJson {
-		return fmt.Sprintf("%s", string(jsonData))
-	}
-
-	if successMessage != "" {
-		return successMessage
+		return string(jsonData), nil
 	}
 
-	return ""
+	return successMessage, nil
 }
 
-// listBooks lists all books in system
-func listBooks(cmd *cobra.Command, args []string) string {
+// bookFilterParams returns the URL parameters for the book filter flags added by addBookFilterFlags
+func bookFilterParams(cmd *cobra.Command) url.Values {
 	title, _ := cmd.Flags().GetString("title")
 	author, _ := cmd.Flags().GetString("author")
 	genre, _ := cmd.Flags().GetString("genre")
-	publishDateStart, _ := cmd.Flags().GetString("publish_start")
-	publishDateEnd, _ := cmd.Flags().GetString("publish_end")
+	publishDateStart := getDateFlag(cmd, "publish_start")
+	publishDateEnd := getDateFlag(cmd, "publish_end")
+	where, _ := cmd.Flags().GetString("where")
+	match, _ := cmd.Flags().GetString("match")
 
 	params := url.Values{}
 	if title != "" {
@@ -103,39 +165,147 @@ func listBooks(cmd *cobra.Command, args []string) string {
 	if genre != "" {
 		params.Add("genre", genre)
 	}
-	if publishDateStart != "" {
-		params.Add("publish_start", publishDateStart)
+	if !publishDateStart.IsZero() {
+		params.Add("publish_start", publishDateStart.String())
+	}
+	if !publishDateEnd.IsZero() {
+		params.Add("publish_end", publishDateEnd.String())
+	}
+	if where != "" {
+		params.Add("q", where)
 	}
-	if publishDateEnd != "" {
-		params.Add("publish_end", publishDateEnd)
+	if match != "" {
+		params.Add("match", match)
 	}
+	return params
+}
 
-	response, err := makeRequest(http.MethodGet, "/book/list", params, nil)
+// listBooks lists all books in system
+func listBooks(cmd *cobra.Command, args []string) (string, error) {
+	params := bookFilterParams(cmd)
+	if sort, _ := cmd.Flags().GetString("sort"); sort != "" {
+		params.Add("sort", sort)
+	}
+
+	return listPages(cmd, "/book/list", params)
+}
+
+// bookFacets prints the number of books matching the filters by each value of the --by fields
+func bookFacets(cmd *cobra.Command, args []string) (string, error) {
+	by, _ := cmd.Flags().GetStringSlice("by")
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	params := bookFilterParams(cmd)
+	params.Set("by", strings.Join(by, ","))
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := makeRequest(http.MethodGet, "/book/facets", params, nil)
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
+	}
+	if err := responseError(resp); err != nil {
+		return "", err
 	}
 
-	return prettyPrintResponse(response, true, "")
+	var facets api.BookFacets
+	err = decodeData(resp, &facets)
+	if err != nil {
+		return "", err
+	}
+
+	return formatFacets(facets), nil
 }
 
-// createBook creates a new book
-func createBook(cmd *cobra.Command, args []string) string {
-	title := args[0]
+// searchBooks lists the books matching a full-text query, most relevant first
+func searchBooks(cmd *cobra.Command, args []string) (string, error) {
 	author, _ := cmd.Flags().GetString("author")
 	genre, _ := cmd.Flags().GetString("genre")
-	publishDateStr, _ := cmd.Flags().GetString("publish_date")
-	description, _ := cmd.Flags().GetString("description")
-	edition, _ := cmd.Flags().GetString("edition")
 
-	var publishDate time.Time
-	var err error
-	if publishDateStr != "" {
-		publishDate, err = time.Parse(api.PublishTimeLayoutDMY, publishDateStr)
+	params := url.Values{}
+	params.Set("q", args[0])
+	if author != "" {
+		params.Add("author", author)
+	}
+	if genre != "" {
+		params.Add("genre", genre)
+	}
+	if match, _ := cmd.Flags().GetString("match"); match != "" {
+		params.Add("match", match)
+	}
+
+	return listPages(cmd, "/book/search", params)
+}
+
+// listPages requests a page of a listing with the --limit and --page-token flags, or every page with --all.
+// The token of the next page is printed to stderr so that the output stays valid JSON.
+func listPages(cmd *cobra.Command, endpoint string, params url.Values) (string, error) {
+	limit, _ := cmd.Flags().GetInt("limit")
+	pageToken, _ := cmd.Flags().GetString("page-token")
+	all, _ := cmd.Flags().GetBool("all")
+
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	if pageToken != "" {
+		params.Set("cursor", pageToken)
+	}
+
+	if !all {
+		response, err := makeRequest(http.MethodGet, endpoint, params, nil)
+		if err != nil {
+			return "", err
+		}
+		output, err := prettyPrintResponse(response, true, "")
 		if err != nil {
-			return fmt.Sprintf("Error: %s", err)
+			return "", err
 		}
+		if response.Pagination != nil && response.Pagination.NextCursor != "" {
+			cmd.PrintErrln("Next page token: " + response.Pagination.NextCursor)
+		}
+		return output, nil
 	}
 
+	items := make([]any, 0)
+	for {
+		response, err := makeRequest(http.MethodGet, endpoint, params, nil)
+		if err != nil {
+			return "", err
+		}
+		if err := responseError(response); err != nil {
+			return "", err
+		}
+
+		var page []any
+		err = decodeData(response, &page)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, page...)
+
+		if response.Pagination == nil || response.Pagination.NextCursor == "" {
+			break
+		}
+		params.Set("cursor", response.Pagination.NextCursor)
+	}
+
+	return prettyPrintResponse(api.Response{Data: items}, true, "")
+}
+
+// createBook creates a new book, or with --from-isbn a book from the metadata of an ISBN
+func createBook(cmd *cobra.Command, args []string) (string, error) {
+	if isbn, _ := cmd.Flags().GetString("from-isbn"); isbn != "" {
+		return createBookFromISBN(cmd, args, isbn)
+	}
+	title := args[0]
+	author, _ := cmd.Flags().GetString("author")
+	genre, _ := cmd.Flags().GetString("genre")
+	publishDate := getDateFlag(cmd, "publish_date")
+	description, _ := cmd.Flags().GetString("description")
+	edition, _ := cmd.Flags().GetString("edition")
+	publisher, _ := cmd.Flags().GetString("publisher")
+
 	book := api.Book{
 		Title:       title,
 		Author:      author,
@@ -143,34 +313,26 @@ func createBook(cmd *cobra.Command, args []string) string {
 		PublishDate: publishDate,
 		Description: description,
 		Edition:     edition,
+		Publisher:   publisher,
 	}
 
 	resp, err := makeRequest(http.MethodPost, "/book/create", nil, book)
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
 	}
 
 	return prettyPrintResponse(resp, false, resp.Message)
 }
 
 // setBook sets a book's attributes optionally given the book title
-func setBook(cmd *cobra.Command, args []string) string {
+func setBook(cmd *cobra.Command, args []string) (string, error) {
 	title := args[0]
 	author, _ := cmd.Flags().GetString("author")
 	genre, _ := cmd.Flags().GetString("genre")
-	publishDateStr, _ := cmd.Flags().GetString("publish_date")
+	publishDate := getDateFlag(cmd, "publish_date")
 	description, _ := cmd.Flags().GetString("description")
 	edition, _ := cmd.Flags().GetString("edition")
-
-	var publishDate time.Time
-	var err error
-	if publishDateStr != "" {
-		publishDate, err = time.Parse(api.PublishTimeLayoutDMY, publishDateStr)
-		if err != nil {
-			fmt.Println(publishDateStr)
-			return fmt.Sprintf("Error: %s", err)
-		}
-	}
+	publisher, _ := cmd.Flags().GetString("publisher")
 
 	book := api.Book{
 		Title:       title,
@@ -179,18 +341,172 @@ func setBook(cmd *cobra.Command, args []string) string {
 		PublishDate: publishDate,
 		Description: description,
 		Edition:     edition,
+		Publisher:   publisher,
 	}
 
-	resp, err := makeRequest(http.MethodPut, "/book/set", nil, book)
+	headers, err := ifMatchHeaders(cmd, title)
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
+	}
+
+	// clearing fields needs a merge patch, as the partial update ignores empty fields
+	unset, _ := cmd.Flags().GetStringArray("unset")
+	var resp api.Response
+	if len(unset) == 0 {
+		resp, err = makeRequestWithHeaders(http.MethodPut, "/book/set", nil, book, headers)
+	} else {
+		var patch map[string]any
+		patch, err = bookMergePatch(book, unset)
+		if err != nil {
+			return "", usageError(err)
+		}
+		headers["Content-Type"] = api.MergePatchContentType
+		resp, err = makeRequestWithHeaders(http.MethodPatch, "/book/set", nil, patch, headers)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", conflictError(cmd, resp, book, unset)
 	}
 
 	return prettyPrintResponse(resp, false, resp.Message)
 }
 
+// ifMatchHeaders returns the If-Match header of a change to a book, so that concurrent edits are not
+// overwritten. The version is given by the --if-match flag or else read from the server.
+func ifMatchHeaders(cmd *cobra.Command, title string) (map[string]string, error) {
+	version, _ := cmd.Flags().GetInt("if-match")
+	if version == 0 {
+		current, err := getBook(title)
+		if err != nil {
+			return nil, err
+		}
+		version = current.Version
+	}
+	return map[string]string{"If-Match": strconv.Quote(strconv.Itoa(version))}, nil
+}
+
+// bookMergePatch builds a JSON Merge Patch setting the non-empty fields of book and clearing the unset fields
+func bookMergePatch(book api.Book, unset []string) (map[string]any, error) {
+	patch := map[string]any{"title": book.Title}
+	if book.Author != "" {
+		patch["author"] = book.Author
+	}
+	if !book.PublishDate.IsZero() {
+		patch["publish_date"] = book.PublishDate.String()
+	}
+	if book.Edition != "" {
+		patch["edition"] = book.Edition
+	}
+	if book.Publisher != "" {
+		patch["publisher"] = book.Publisher
+	}
+	if book.Description != "" {
+		patch["description"] = book.Description
+	}
+	if book.Genre != "" {
+		patch["genre"] = book.Genre
+	}
+
+	for _, field := range unset {
+		switch field {
+		case "author", "publish_date", "edition", "publisher", "description", "genre":
+		default:
+			return nil, fmt.Errorf("cannot unset %q, must be one of author, publish_date, edition, publisher, description, genre", field)
+		}
+		if _, ok := patch[field]; ok {
+			return nil, fmt.Errorf("cannot both set and unset %q", field)
+		}
+		patch[field] = nil
+	}
+
+	return patch, nil
+}
+
+// getBook fetches a single book with its current version
+func getBook(title string) (api.Book, error) {
+	params := url.Values{}
+	params.Set("title", title)
+
+	resp, err := makeRequest(http.MethodGet, "/book/get", params, nil)
+	if err != nil {
+		return api.Book{}, err
+	}
+	if err := responseError(resp); err != nil {
+		return api.Book{}, err
+	}
+
+	var book api.Book
+	err = decodeData(resp, &book)
+	return book, err
+}
+
+// conflictError reports a stale write, optionally with the difference between
+// the book on the server and the book as it would be with the requested change
+func conflictError(cmd *cobra.Command, resp api.Response, change api.Book, unset []string) error {
+	conflict := &APIError{StatusCode: resp.StatusCode, Code: resp.Code}
+	conflict.Message = fmt.Sprintf("%s\nThe book was changed by someone else, re-run the command to apply your change to the latest version", resp.Message)
+
+	showDiff, _ := cmd.Flags().GetBool("show-diff")
+	var current api.Book
+	if !showDiff || resp.Data == nil || decodeData(resp, &current) != nil {
+		return conflict
+	}
+
+	changes := diffBooks(current, applyBookChange(current, change, unset))
+	if len(changes) == 0 {
+		conflict.Message += fmt.Sprintf("\nVersion %d already has your change", current.Version)
+	} else {
+		conflict.Message += fmt.Sprintf("\nYour change against version %d:\n%s", current.Version, strings.TrimSuffix(formatChanges(changes, "  "), "\n"))
+	}
+	return conflict
+}
+
+// applyBookChange returns the book with the non-empty fields of change applied and the unset fields cleared,
+// as the server would on set
+func applyBookChange(book api.Book, change api.Book, unset []string) api.Book {
+	if change.Author != "" {
+		book.Author = change.Author
+	}
+	if !change.PublishDate.IsZero() {
+		book.PublishDate = change.PublishDate
+	}
+	if change.Edition != "" {
+		book.Edition = change.Edition
+	}
+	if change.Publisher != "" {
+		book.Publisher = change.Publisher
+	}
+	if change.Description != "" {
+		book.Description = change.Description
+	}
+	if change.Genre != "" {
+		book.Genre = change.Genre
+	}
+
+	for _, field := range unset {
+		switch field {
+		case "author":
+			book.Author = ""
+		case "publish_date":
+			book.PublishDate = api.Date{}
+		case "edition":
+			book.Edition = ""
+		case "publisher":
+			book.Publisher = ""
+		case "description":
+			book.Description = ""
+		case "genre":
+			book.Genre = ""
+		}
+	}
+	return book
+}
+
 // removeBook removes a book from the system
-func removeBook(cmd *cobra.Command, args []string) string {
+func removeBook(cmd *cobra.Command, args []string) (string, error) {
 	title := args[0]
 
 	params := url.Values{}
@@ -198,7 +514,69 @@ func removeBook(cmd *cobra.Command, args []string) string {
 
 	resp, err := makeRequest(http.MethodDelete, "/book/remove", params, nil)
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
+	}
+
+	return prettyPrintResponse(resp, false, resp.Message)
+}
+
+// bookHistory shows each revision of a book with the fields changed from the previous revision
+func bookHistory(cmd *cobra.Command, args []string) (string, error) {
+	params := url.Values{}
+	params.Set("title", args[0])
+
+	resp, err := makeRequest(http.MethodGet, "/book/history", params, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := responseError(resp); err != nil {
+		return "", err
+	}
+
+	var revisions []api.BookRevision
+	err = decodeData(resp, &revisions)
+	if err != nil {
+		return "", err
+	}
+	if len(revisions) == 0 {
+		return fmt.Sprintf("No revisions recorded for %q", args[0]), nil
+	}
+
+	var builder strings.Builder
+	previous := api.Book{}
+	for _, revision := range revisions {
+		builder.WriteString(fmt.Sprintf("Revision %d (%s) by %s at %s\n",
+			revision.Revision, revision.Action, revision.Actor, revision.Timestamp.Format(time.RFC3339)))
+		changes := diffBooks(previous, revision.Book)
+		if len(changes) == 0 {
+			builder.WriteString("  no changes\n")
+		}
+		builder.WriteString(formatChanges(changes, "  "))
+		previous = revision.Book
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n"), nil
+}
+
+// revertBook reverts a book to an earlier revision, recorded as a new revision
+func revertBook(cmd *cobra.Command, args []string) (string, error) {
+	to, _ := cmd.Flags().GetInt("to")
+
+	params := url.Values{}
+	params.Set("title", args[0])
+	params.Set("to", strconv.Itoa(to))
+
+	headers, err := ifMatchHeaders(cmd, args[0])
+	if err != nil {
+		return "", err
+	}
+	resp, err := makeRequestWithHeaders(http.MethodPost, "/book/revert", params, nil, headers)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", conflictError(cmd, resp, api.Book{}, nil)
 	}
 
 	return prettyPrintResponse(resp, false, resp.Message)
@@ -207,31 +585,25 @@ func removeBook(cmd *cobra.Command, args []string) string {
 // listCollection either:
 // list all collections if collection_name arg is not provided
 // list all books in collection_name arg if arg is provided
-func listCollection(cmd *cobra.Command, args []string) string {
+func listCollection(cmd *cobra.Command, args []string) (string, error) {
+	params := url.Values{}
+	if sort, _ := cmd.Flags().GetString("sort"); sort != "" {
+		params.Set("sort", sort)
+	}
+
 	if len(args) == 0 {
-		response, err := makeRequest(http.MethodGet, "/collection/list", nil, nil)
-		if err != nil {
-			return fmt.Sprintf("Error: %s", err)
-		}
-		return prettyPrintResponse(response, true, "")
+		return listPages(cmd, "/collection/list", params)
 	} else {
 		collectionName := args[0]
 
-		params := url.Values{}
 		params.Set("collection_name", collectionName)
 
-		resp, err := makeRequest(http.MethodGet, "/collection/list/books", params, nil)
-
-		if err != nil {
-			return fmt.Sprintf("Error: %s", err)
-		}
-
-		return prettyPrintResponse(resp, true, "")
+		return listPages(cmd, "/collection/list/books", params)
 	}
 }
 
 // createCollection creates a new collection
-func createCollection(cmd *cobra.Command, args []string) string {
+func createCollection(cmd *cobra.Command, args []string) (string, error) {
 	collectionName := args[0]
 
 	// post request with url parameters
@@ -240,14 +612,14 @@ func createCollection(cmd *cobra.Command, args []string) string {
 	resp, err := makeRequest(http.MethodPost, "/collection/create", params, nil)
 
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
 	}
 
 	return prettyPrintResponse(resp, false, resp.Message)
 }
 
 // removeCollection removes a collection
-func removeCollection(cmd *cobra.Command, args []string) string {
+func removeCollection(cmd *cobra.Command, args []string) (string, error) {
 	collectionName := args[0]
 
 	// post request with url parameters
@@ -256,14 +628,14 @@ func removeCollection(cmd *cobra.Command, args []string) string {
 	resp, err := makeRequest(http.MethodDelete, "/collection/remove", params, nil)
 
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
 	}
 
 	return prettyPrintResponse(resp, false, resp.Message)
 }
 
 // addBookToCollection adds a book to a collection
-func addBookToCollection(cmd *cobra.Command, args []string) string {
+func addBookToCollection(cmd *cobra.Command, args []string) (string, error) {
 	collectionName := args[0]
 	bookTitle := args[1]
 
@@ -274,14 +646,14 @@ func addBookToCollection(cmd *cobra.Command, args []string) string {
 	resp, err := makeRequest(http.MethodPost, "/collection/add-book", params, nil)
 
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
 	}
 
 	return prettyPrintResponse(resp, false, resp.Message)
 }
 
 // removeBookFromCollection removes a book from a collection
-func removeBookFromCollection(cmd *cobra.Command, args []string) string {
+func removeBookFromCollection(cmd *cobra.Command, args []string) (string, error) {
 	collectionName := args[0]
 	bookTitle := args[1]
 
@@ -292,8 +664,72 @@ func removeBookFromCollection(cmd *cobra.Command, args []string) string {
 	resp, err := makeRequest(http.MethodDelete, "/collection/remove-book", params, nil)
 
 	if err != nil {
-		return fmt.Sprintf("Error: %s", err)
+		return "", err
 	}
 
 	return prettyPrintResponse(resp, false, resp.Message)
 }
+
+// parseRelativeTime parses either a duration ago (30m, 12h, 7d, 2w) or a YYYY-MM-DD date
+func parseRelativeTime(value string, now time.Time) (time.Time, error) {
+	if date, err := time.Parse(api.PublishTimeLayoutDMY, value); err == nil {
+		return date, nil
+	}
+
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if strings.HasSuffix(value, suffix) {
+			count, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid duration %q", value)
+			}
+			return now.Add(-time.Duration(count) * unit), nil
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid duration %q", value)
+	}
+	return now.Add(-duration), nil
+}
+
+// listAudit lists audit log entries, newest first
+func listAudit(cmd *cobra.Command, args []string) (string, error) {
+	params := url.Values{}
+	for _, flag := range []string{"entity", "id", "actor", "action"} {
+		value, _ := cmd.Flags().GetString(flag)
+		if value != "" {
+			params.Add(flag, value)
+		}
+	}
+
+	now := time.Now()
+	for _, flag := range []string{"since", "until"} {
+		value, _ := cmd.Flags().GetString(flag)
+		if value == "" {
+			continue
+		}
+		t, err := parseRelativeTime(value, now)
+		if err != nil {
+			return "", usageError(err)
+		}
+		params.Add(flag, t.Format(time.RFC3339))
+	}
+
+	limit, _ := cmd.Flags().GetInt("limit")
+	if limit > 0 {
+		params.Add("limit", strconv.Itoa(limit))
+	}
+	offset, _ := cmd.Flags().GetInt("offset")
+	if offset > 0 {
+		params.Add("offset", strconv.Itoa(offset))
+	}
+
+	response, err := makeRequest(http.MethodGet, "/audit", params, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return prettyPrintResponse(response, true, "")
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// coverTypes are the image types accepted as covers by the server
+var coverTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// setCover uploads a JPEG, PNG or GIF image as the cover of a book, replacing its cover
+func setCover(cmd *cobra.Command, args []string) (string, error) {
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		return "", usageError(err)
+	}
+	contentType := http.DetectContentType(data)
+	if !coverTypes[contentType] {
+		return "", usageError(fmt.Errorf("%s is not a JPEG, PNG or GIF image", args[1]))
+	}
+
+	headers := map[string]string{"Content-Type": contentType}
+	resp, err := sendBody(http.MethodPut, coverEndpoint(args[0]), nil, bytes.NewReader(data), headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	response, err := readResponse(resp)
+	if err != nil {
+		return "", err
+	}
+
+	return prettyPrintResponse(response, false, response.Message)
+}
+
+// coverEndpoint is the cover endpoint of a book, identified by its title escaped as a path segment
+func coverEndpoint(title string) string {
+	return "/book/" + url.PathEscape(title) + "/cover"
+}
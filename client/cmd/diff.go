@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldChange is a single field that differs between two versions of a book
+type fieldChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+// formatBookField formats a book field value for display
+func formatBookField(value reflect.Value) string {
+	return fmt.Sprint(value.Interface())
+}
+
+// diffBooks returns the fields that differ between two books, named by their JSON keys
+func diffBooks(from api.Book, to api.Book) []fieldChange {
+	changes := make([]fieldChange, 0)
+
+	fromValue := reflect.ValueOf(from)
+	toValue := reflect.ValueOf(to)
+	bookType := fromValue.Type()
+	for i := 0; i < bookType.NumField(); i++ {
+		// the version changes on every write, so it is not a field change in itself
+		name := strings.Split(bookType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "version" {
+			continue
+		}
+
+		fromField := formatBookField(fromValue.Field(i))
+		toField := formatBookField(toValue.Field(i))
+		if fromField != toField {
+			changes = append(changes, fieldChange{Field: name, From: fromField, To: toField})
+		}
+	}
+
+	return changes
+}
+
+// formatChanges renders field changes one per line
+func formatChanges(changes []fieldChange, indent string) string {
+	var builder strings.Builder
+	for _, change := range changes {
+		builder.WriteString(fmt.Sprintf("%s%s: %q -> %q\n", indent, change.Field, change.From, change.To))
+	}
+	return builder.String()
+}
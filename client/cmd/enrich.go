@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"bms/shared/enrich"
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// enrichBook fills in the fields of a book from the metadata of its ISBN, given with --isbn or recorded
+// for the book. Only empty fields are filled unless --overwrite is set, and the changes are applied
+// once confirmed.
+func enrichBook(cmd *cobra.Command, args []string) (string, error) {
+	overwrite, _ := cmd.Flags().GetBool("overwrite")
+	provider, err := metadataProvider(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := getBook(args[0])
+	if err != nil {
+		return "", err
+	}
+	isbn, _ := cmd.Flags().GetString("isbn")
+	if isbn == "" {
+		isbn, err = getISBN(current.Title)
+		if err != nil {
+			return "", err
+		}
+		if isbn == "" {
+			return "", usageError(fmt.Errorf("no ISBN is recorded for %q, give one with --isbn", current.Title))
+		}
+	}
+	isbn, err = enrich.NormalizeISBN(isbn)
+	if err != nil {
+		return "", usageError(err)
+	}
+
+	found, err := lookupISBN(provider, isbn)
+	if err != nil {
+		return "", err
+	}
+	enriched := enrich.Merge(current, found, overwrite)
+	changes := diffBooks(current, enriched)
+	if len(changes) == 0 {
+		return fmt.Sprintf("Nothing to change, %q already has the fields found in %s", current.Title, provider.Name()), nil
+	}
+
+	cmd.Printf("Changes from %s for ISBN %s:\n%s", provider.Name(), isbn, formatChanges(changes, "  "))
+	if !confirm(cmd, "Apply these changes?") {
+		return "No changes were made", nil
+	}
+
+	headers := map[string]string{"If-Match": strconv.Quote(strconv.Itoa(current.Version))}
+	resp, err := makeRequestWithHeaders(http.MethodPut, "/book/set", nil, enriched, headers)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", conflictError(cmd, resp, enriched, nil)
+	}
+	return prettyPrintResponse(resp, false, resp.Message)
+}
+
+// createBookFromISBN creates a book from the metadata of an ISBN, recording the ISBN as its identifier.
+// The title argument and the field flags replace the fields found, and the book is created once confirmed.
+func createBookFromISBN(cmd *cobra.Command, args []string, isbn string) (string, error) {
+	provider, err := metadataProvider(cmd)
+	if err != nil {
+		return "", err
+	}
+	isbn, err = enrich.NormalizeISBN(isbn)
+	if err != nil {
+		return "", usageError(err)
+	}
+
+	found, err := lookupISBN(provider, isbn)
+	if err != nil {
+		return "", err
+	}
+	author, _ := cmd.Flags().GetString("author")
+	genre, _ := cmd.Flags().GetString("genre")
+	description, _ := cmd.Flags().GetString("description")
+	edition, _ := cmd.Flags().GetString("edition")
+	publisher, _ := cmd.Flags().GetString("publisher")
+	change := api.Book{
+		Author:      author,
+		Genre:       genre,
+		PublishDate: getDateFlag(cmd, "publish_date"),
+		Description: description,
+		Edition:     edition,
+		Publisher:   publisher,
+	}
+	book := applyBookChange(found, change, nil)
+	if len(args) > 0 {
+		book.Title = args[0]
+	}
+	if book.Title == "" {
+		return "", usageError(fmt.Errorf("%s has no title for ISBN %s, give the title as an argument", provider.Name(), isbn))
+	}
+
+	cmd.Printf("Book from %s for ISBN %s:\n%s", provider.Name(), isbn, formatChanges(diffBooks(api.Book{}, book), "  "))
+	if !confirm(cmd, "Create this book?") {
+		return "No book was created", nil
+	}
+
+	// the import endpoint creates the book and records its ISBN together
+	books := []api.ImportBook{{Book: book, Identifiers: map[string]string{"isbn": isbn}}}
+	report, err := postImport(books, []string{"ISBN " + isbn}, string(api.ImportSkip), false)
+	if err != nil {
+		return "", err
+	}
+	if report.Created == 0 {
+		return "", &APIError{
+			StatusCode: http.StatusConflict,
+			Code:       api.CodeBookExists,
+			Message:    fmt.Sprintf("Book %q already exists, use bms book enrich to fill in its fields", book.Title),
+		}
+	}
+	return "Book created successfully", nil
+}
+
+// createBookArgs takes the title of the book, which may be left out with --from-isbn
+func createBookArgs(cmd *cobra.Command, args []string) error {
+	if isbn, _ := cmd.Flags().GetString("from-isbn"); isbn != "" {
+		if err := cobra.MaximumNArgs(1)(cmd, args); err != nil {
+			return usageError(err)
+		}
+		return nil
+	}
+	return exactArgs(1)(cmd, args)
+}
+
+// metadataProvider returns the fixture file given with --fixtures, or Open Library
+func metadataProvider(cmd *cobra.Command) (enrich.MetadataProvider, error) {
+	path, _ := cmd.Flags().GetString("fixtures")
+	if path == "" {
+		return enrich.NewOpenLibrary(), nil
+	}
+	provider, err := enrich.ReadFixtures(path)
+	if err != nil {
+		return nil, usageError(err)
+	}
+	return provider, nil
+}
+
+// lookupISBN looks up an ISBN, an unknown ISBN is not found and a failing provider is unavailable
+func lookupISBN(provider enrich.MetadataProvider, isbn string) (api.Book, error) {
+	book, err := provider.LookupISBN(isbn)
+	if errors.Is(err, enrich.ErrNotFound) {
+		return api.Book{}, &ExitError{Code: ExitNotFound, Err: fmt.Errorf("%s has no book with ISBN %s", provider.Name(), isbn)}
+	}
+	if err != nil {
+		return api.Book{}, &ExitError{Code: ExitUnavailable, Err: fmt.Errorf("error looking up ISBN %s in %s: %w", isbn, provider.Name(), err)}
+	}
+	return book, nil
+}
+
+// getISBN returns the ISBN recorded for a book, or "" if it has none
+func getISBN(title string) (string, error) {
+	params := url.Values{}
+	params.Set("title", title)
+
+	resp, err := makeRequest(http.MethodGet, "/book/identifiers", params, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := responseError(resp); err != nil {
+		return "", err
+	}
+
+	var identifiers []api.Identifier
+	if err := decodeData(resp, &identifiers); err != nil {
+		return "", err
+	}
+	for _, identifier := range identifiers {
+		if identifier.Type == "isbn" {
+			return identifier.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// confirm asks a yes or no question on standard input, answered yes by --yes. Anything but y or yes,
+// including the end of the input, is no.
+func confirm(cmd *cobra.Command, question string) bool {
+	if yes, _ := cmd.Flags().GetBool("yes"); yes {
+		return true
+	}
+
+	cmd.Print(question + " [y/N] ")
+	answer, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil {
+		// end the prompt line when the input ends without an answer
+		cmd.Println()
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
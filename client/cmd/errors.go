@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Exit codes of the bms command
+const (
+	ExitOK          = 0
+	ExitFailure     = 1 // unexpected or server side failure
+	ExitUsage       = 2 // invalid arguments or flags
+	ExitNotFound    = 3 // the book, collection or revision does not exist
+	ExitConflict    = 4 // the entity already exists or was changed concurrently
+	ExitInvalid     = 5 // the server rejected the request as invalid
+	ExitUnavailable = 6 // the server could not be reached
+)
+
+// APIError is an error response returned by the server
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ExitError is a client side failure with the exit code it maps to
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// usageError marks an error as caused by invalid arguments or flags
+func usageError(err error) error {
+	return &ExitError{Code: ExitUsage, Err: err}
+}
+
+// responseError returns the error carried by an error response, or nil on success
+func responseError(response api.Response) error {
+	if response.Type != "error" {
+		return nil
+	}
+
+	message := response.Message
+	if response.StatusCode == http.StatusNotFound {
+		var notFound api.NotFoundData
+		if decodeData(response, &notFound) == nil && len(notFound.Suggestions) > 0 {
+			message += "\nDid you mean " + quoteList(notFound.Suggestions) + "?"
+		}
+	}
+	return &APIError{StatusCode: response.StatusCode, Code: response.Code, Message: message}
+}
+
+// quoteList quotes each value and joins them as "a", "b" or "c"
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
+
+// ExitCode returns the process exit code for an error returned by RootCmd.Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusNotFound:
+			return ExitNotFound
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			return ExitConflict
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+			return ExitInvalid
+		}
+	}
+
+	return ExitFailure
+}
+
+// exactArgs is cobra.ExactArgs reporting a usage error
+func exactArgs(n int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(n)(cmd, args); err != nil {
+			return usageError(err)
+		}
+		return nil
+	}
+}
+
+// rangeArgs is cobra.RangeArgs reporting a usage error
+func rangeArgs(min int, max int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := cobra.RangeArgs(min, max)(cmd, args); err != nil {
+			return usageError(err)
+		}
+		return nil
+	}
+}
+
+// flagError reports invalid flags as a usage error
+func flagError(cmd *cobra.Command, err error) error {
+	return usageError(fmt.Errorf("%w\nRun '%s --help' for usage", err, cmd.CommandPath()))
+}
+
+// runCommand adapts a command handler to cobra, printing its output on success
+func runCommand(handler func(cmd *cobra.Command, args []string) (string, error)) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		output, err := handler(cmd, args)
+		if err != nil {
+			return err
+		}
+		if output != "" {
+			cmd.Println(output)
+		}
+		return nil
+	}
+}
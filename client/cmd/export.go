@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bms/shared/bookio"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// exportBooks streams the books matching the book filters, or the books of a collection, to standard
+// output or a file
+func exportBooks(cmd *cobra.Command, args []string) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	sort, _ := cmd.Flags().GetString("sort")
+	collection, _ := cmd.Flags().GetString("collection")
+	path, _ := cmd.Flags().GetString("output")
+
+	switch bookio.Format(format) {
+	case bookio.CSV, bookio.JSON, bookio.NDJSON, bookio.YAML, bookio.MARC, bookio.MARCXML:
+	default:
+		return "", usageError(fmt.Errorf("invalid format %q, must be csv, json, ndjson, yaml, marc or marcxml", format))
+	}
+
+	params := bookFilterParams(cmd)
+	params.Set("format", format)
+	if sort != "" {
+		params.Set("sort", sort)
+	}
+	if collection != "" {
+		params.Set("collection", collection)
+	}
+
+	return download(cmd, "/book/export", params, path)
+}
+
+// download streams a file from the server to standard output, or to path if it is not empty. A partly
+// written file is removed if the download fails.
+func download(cmd *cobra.Command, endpoint string, params url.Values, path string) (output string, err error) {
+	resp, err := sendRequest(http.MethodGet, endpoint, params, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// errors are JSON responses, a successful export is the file itself
+	if resp.StatusCode != http.StatusOK {
+		response, err := readResponse(resp)
+		if err != nil {
+			return "", err
+		}
+		if err := responseError(response); err != nil {
+			return "", err
+		}
+		return "", &APIError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("unexpected response from server: %s", resp.Status)}
+	}
+
+	out := cmd.OutOrStdout()
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+			}
+		}()
+		out = file
+	}
+
+	// the server closes the connection without finishing the body if the export fails part way
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("download failed before the end of the file: %w", err)
+	}
+	return "", nil
+}
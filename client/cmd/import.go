@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"bms/shared/bookio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// importBooks reads books from a CSV, JSON, NDJSON or MARC file, or standard input for -, and imports
+// them in one request. Nothing is imported if any row is invalid.
+func importBooks(cmd *cobra.Command, args []string) (string, error) {
+	formatName, _ := cmd.Flags().GetString("format")
+	pairs, _ := cmd.Flags().GetStringSlice("map")
+	mode, _ := cmd.Flags().GetString("mode")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	if mode != string(api.ImportSkip) && mode != string(api.ImportOverwrite) && mode != string(api.ImportMerge) {
+		return "", usageError(fmt.Errorf("invalid mode %q, must be skip, overwrite or merge", mode))
+	}
+	format := bookio.Format(formatName)
+	switch format {
+	case "auto", bookio.CSV, bookio.JSON, bookio.NDJSON, bookio.MARC, bookio.MARCXML:
+	default:
+		return "", usageError(fmt.Errorf("invalid format %q, must be auto, csv, json, ndjson, marc or marcxml", formatName))
+	}
+	mapping, err := bookio.ParseMapping(pairs)
+	if err != nil {
+		return "", usageError(err)
+	}
+
+	var data []byte
+	if args[0] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return "", usageError(err)
+	}
+
+	if formatName == "auto" {
+		format, err = bookio.DetectFormat(args[0], data)
+		if err != nil {
+			return "", &ExitError{Code: ExitInvalid, Err: err}
+		}
+	}
+	records, err := bookio.ReadRecords(bytes.NewReader(data), format)
+	if err != nil {
+		return "", &ExitError{Code: ExitInvalid, Err: err}
+	}
+	if len(records) == 0 {
+		return "", &ExitError{Code: ExitInvalid, Err: errors.New("no books to import")}
+	}
+	if unknown := bookio.UnknownColumns(records, mapping); len(unknown) > 0 {
+		return "", usageError(fmt.Errorf("unknown columns %s, map them to a field with --map column=field or drop them with --map column=-", quoteList(unknown)))
+	}
+	if err := bookio.DuplicateFields(records, mapping); err != nil {
+		return "", usageError(fmt.Errorf("%w, drop one of them with --map column=-", err))
+	}
+
+	books := make([]api.Book, 0, len(records))
+	var failures []string
+	for _, record := range records {
+		book, err := bookio.ToBook(record, mapping)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		books = append(books, book)
+	}
+	if len(failures) > 0 {
+		failures = append(failures, fmt.Sprintf("%d of %d rows are invalid, nothing was imported", len(failures), len(records)))
+		return "", &ExitError{Code: ExitInvalid, Err: errors.New(strings.Join(failures, "\n"))}
+	}
+
+	positions := make([]string, len(records))
+	for i, record := range records {
+		positions[i] = record.Position()
+	}
+	report, err := postImport(books, positions, mode, dryRun)
+	if err != nil {
+		return "", err
+	}
+	if unmapped := formatUnmapped(records); unmapped != "" {
+		return formatImportReport(report) + "\n" + unmapped, nil
+	}
+	return formatImportReport(report), nil
+}
+
+// postImport sends books to the import endpoint and returns the report. Rows rejected by the server are
+// listed by their position in the file, given in the order of the books.
+func postImport(books any, positions []string, mode string, dryRun bool) (api.ImportReport, error) {
+	params := url.Values{}
+	params.Set("mode", mode)
+	if dryRun {
+		params.Set("dry_run", "true")
+	}
+	resp, err := makeRequest(http.MethodPost, "/book/import", params, books)
+	if err != nil {
+		return api.ImportReport{}, err
+	}
+
+	var report api.ImportReport
+	if err := responseError(resp); err != nil {
+		if resp.Code == api.CodeValidationFailed && decodeData(resp, &report) == nil {
+			var failures []string
+			for _, row := range report.Rows {
+				if row.Status == api.ImportFailed && row.Index < len(positions) {
+					failures = append(failures, fmt.Sprintf("%s: %s", positions[row.Index], row.Error))
+				}
+			}
+			failures = append(failures, resp.Message)
+			return api.ImportReport{}, &APIError{StatusCode: resp.StatusCode, Code: resp.Code, Message: strings.Join(failures, "\n")}
+		}
+		return api.ImportReport{}, err
+	}
+
+	err = decodeData(resp, &report)
+	return report, err
+}
+
+// formatUnmapped lists the MARC fields and subfields that were not imported, with the number of
+// records they appeared in, in order of first appearance
+func formatUnmapped(records []bookio.Record) string {
+	counts := map[string]int{}
+	var names []string
+	for _, record := range records {
+		for _, name := range record.Unmapped {
+			if counts[name] == 0 {
+				names = append(names, name)
+			}
+			counts[name]++
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	rows := make([][]string, len(names))
+	for i, name := range names {
+		rows[i] = []string{name, strconv.Itoa(counts[name])}
+	}
+	return "Unmapped MARC fields, not imported:\n" + formatTable([]string{"field", "records"}, rows)
+}
+
+// formatImportReport summarises the number of books per import status and the collections they were added to
+func formatImportReport(report api.ImportReport) string {
+	summary := strings.Join([]string{
+		strconv.Itoa(report.Created) + " created",
+		strconv.Itoa(report.Updated) + " updated",
+		strconv.Itoa(report.Unchanged) + " unchanged",
+		strconv.Itoa(report.Skipped) + " skipped",
+	}, ", ")
+	if report.CollectionsCreated > 0 || report.AddedToCollections > 0 {
+		summary += fmt.Sprintf("\nCollections: %d created, %d books added", report.CollectionsCreated, report.AddedToCollections)
+	}
+	if report.DryRun {
+		return "Dry run, nothing was imported: " + summary
+	}
+	return summary
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"bms/shared/sources"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// importGoodreads imports a Goodreads library export, turning shelves into collections
+func importGoodreads(cmd *cobra.Command, args []string) (string, error) {
+	return importSource(cmd, args[0], sources.ReadGoodreads)
+}
+
+// importLibraryThing imports a LibraryThing export, turning its collections into collections
+func importLibraryThing(cmd *cobra.Command, args []string) (string, error) {
+	return importSource(cmd, args[0], sources.ReadLibraryThing)
+}
+
+// importCalibre imports the books of a Calibre library, turning series and optionally tags into collections.
+// Existing books are merged, so importing the same library again changes nothing.
+func importCalibre(cmd *cobra.Command, args []string) (string, error) {
+	tagCollections, _ := cmd.Flags().GetBool("tags-as-collections")
+	options := sources.CalibreOptions{TagCollections: tagCollections}
+	result, err := sources.ReadCalibre(args[0], options)
+	if err != nil {
+		return "", &ExitError{Code: ExitInvalid, Err: err}
+	}
+	return importResult(cmd, result)
+}
+
+// importSource reads the export of another catalogue from a file, or standard input for -, and imports
+// its books. Rows that cannot be imported are skipped and listed after the summary.
+func importSource(cmd *cobra.Command, path string, read func(io.Reader) (sources.Result, error)) (string, error) {
+	in := cmd.InOrStdin()
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", usageError(err)
+		}
+		defer file.Close()
+		in = file
+	}
+	result, err := read(in)
+	if err != nil {
+		return "", &ExitError{Code: ExitInvalid, Err: err}
+	}
+	return importResult(cmd, result)
+}
+
+// importResult imports the books read from another catalogue
+func importResult(cmd *cobra.Command, result sources.Result) (string, error) {
+	mode, _ := cmd.Flags().GetString("mode")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	if mode != string(api.ImportSkip) && mode != string(api.ImportOverwrite) && mode != string(api.ImportMerge) {
+		return "", usageError(fmt.Errorf("invalid mode %q, must be skip, overwrite or merge", mode))
+	}
+	if len(result.Entries) == 0 {
+		return "", &ExitError{Code: ExitInvalid, Err: errors.New("no books to import")}
+	}
+
+	books := make([]api.ImportBook, len(result.Entries))
+	positions := make([]string, len(result.Entries))
+	for i, entry := range result.Entries {
+		books[i] = entry.Book
+		positions[i] = entry.Position
+	}
+	report, err := postImport(books, positions, mode, dryRun)
+	if err != nil {
+		return "", err
+	}
+	return formatSourceReport(report, api.ImportMode(mode), result.Skipped), nil
+}
+
+// formatSourceReport summarises an import from another catalogue. Existing books are reported as merged
+// in merge mode, and the skipped count includes the rows of the export that were not sent.
+func formatSourceReport(report api.ImportReport, mode api.ImportMode, skipped []sources.Skip) string {
+	updated := " updated"
+	if mode == api.ImportMerge {
+		updated = " merged"
+	}
+	summary := strings.Join([]string{
+		strconv.Itoa(report.Created) + " created",
+		strconv.Itoa(report.Updated) + updated,
+		strconv.Itoa(report.Unchanged) + " unchanged",
+		strconv.Itoa(report.Skipped+len(skipped)) + " skipped",
+	}, ", ")
+	if report.DryRun {
+		summary = "Dry run, nothing was imported: " + summary
+	}
+	lines := []string{summary}
+	if report.CollectionsCreated > 0 || report.AddedToCollections > 0 {
+		lines = append(lines, fmt.Sprintf("Collections: %d created, %d books added", report.CollectionsCreated, report.AddedToCollections))
+	}
+	if report.ReadingsRecorded > 0 {
+		lines = append(lines, fmt.Sprintf("Ratings and read dates: %d recorded", report.ReadingsRecorded))
+	}
+	if report.IdentifiersRecorded > 0 {
+		lines = append(lines, fmt.Sprintf("Identifiers: %d recorded", report.IdentifiersRecorded))
+	}
+	if len(skipped) > 0 {
+		lines = append(lines, "Skipped rows:")
+		for _, skip := range skipped {
+			lines = append(lines, fmt.Sprintf("  %s: %s", skip.Position, skip.Reason))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// statsSection is a list of counts in the stats report
+type statsSection struct {
+	// name identifies the section in CSV output
+	name   string
+	header string
+	// timeSeries sections can be drawn as sparklines
+	timeSeries bool
+	counts     []api.FacetValue
+}
+
+// statsSections returns the count sections of the stats report in order
+func statsSections(stats api.Stats) []statsSection {
+	return []statsSection{
+		{name: "genre", header: "genre", counts: stats.BooksPerGenre},
+		{name: "collection", header: "collection", counts: stats.BooksPerCollection},
+		{name: "month_added", header: "month added", timeSeries: true, counts: stats.AdditionsPerMonth},
+		{name: "publish_year", header: "publish year", timeSeries: true, counts: stats.PublishYears},
+	}
+}
+
+// getStats prints the library statistics as a table, JSON or CSV
+func getStats(cmd *cobra.Command, args []string) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	chart, _ := cmd.Flags().GetString("chart")
+	months, _ := cmd.Flags().GetInt("months")
+
+	if format != "table" && format != "json" && format != "csv" {
+		return "", usageError(fmt.Errorf("invalid format %q, must be table, json or csv", format))
+	}
+	if chart != "" && chart != "bar" && chart != "sparkline" {
+		return "", usageError(fmt.Errorf("invalid chart %q, must be bar or sparkline", chart))
+	}
+	if chart != "" && format != "table" {
+		return "", usageError(fmt.Errorf("--chart can only be used with --format=table"))
+	}
+
+	params := url.Values{}
+	if months > 0 {
+		params.Set("months", strconv.Itoa(months))
+	}
+	resp, err := makeRequest(http.MethodGet, "/stats", params, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := responseError(resp); err != nil {
+		return "", err
+	}
+
+	var stats api.Stats
+	err = decodeData(resp, &stats)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(stats, "", " ")
+		return string(data), err
+	case "csv":
+		return formatStatsCSV(stats)
+	}
+	return formatStatsTable(stats, chart), nil
+}
+
+// countLabel returns the label of a counted value, (none) for a missing value
+func countLabel(value api.FacetValue) string {
+	if value.Value == nil {
+		return "(none)"
+	}
+	return *value.Value
+}
+
+// formatStatsTable renders the totals and a table per section, with a bar column or
+// with the time series drawn as sparklines
+func formatStatsTable(stats api.Stats, chart string) string {
+	tables := []string{formatTable([]string{"books", "collections"},
+		[][]string{{strconv.Itoa(stats.TotalBooks), strconv.Itoa(stats.TotalCollections)}})}
+
+	for _, section := range statsSections(stats) {
+		if chart == "sparkline" && section.timeSeries {
+			tables = append(tables, formatSparkline(section))
+			continue
+		}
+
+		maxCount := 0
+		for _, count := range section.counts {
+			if count.Count > maxCount {
+				maxCount = count.Count
+			}
+		}
+
+		headers := []string{section.header, "books"}
+		if chart == "bar" {
+			headers = append(headers, "")
+		}
+		rows := make([][]string, len(section.counts))
+		for i, count := range section.counts {
+			rows[i] = []string{countLabel(count), strconv.Itoa(count.Count)}
+			if chart == "bar" {
+				rows[i] = append(rows[i], bar(count.Count, maxCount))
+			}
+		}
+		tables = append(tables, formatTable(headers, rows))
+	}
+
+	return strings.Join(tables, "\n\n")
+}
+
+// formatSparkline draws a time series section as a sparkline between its first and last labels.
+// Missing years of the publish year histogram are drawn as zero, and years are summed by decade,
+// century or millennium when there are more than fit in sparklineWidth.
+func formatSparkline(section statsSection) string {
+	title := strings.ToUpper(section.header)
+	if len(section.counts) == 0 {
+		return title + "\n(none)"
+	}
+
+	var values []int
+	bucket := ""
+	if section.name == "publish_year" {
+		values, bucket = YearValues(section.counts)
+	} else {
+		for _, count := range section.counts {
+			values = append(values, count.Count)
+		}
+	}
+	maxCount := 0
+	for _, value := range values {
+		if value > maxCount {
+			maxCount = value
+		}
+	}
+
+	first := countLabel(section.counts[0])
+	last := countLabel(section.counts[len(section.counts)-1])
+	if bucket != "" {
+		return fmt.Sprintf("%s\n%s %s %s (by %s, max %d)", title, first, sparkline(values), last, bucket, maxCount)
+	}
+	return fmt.Sprintf("%s\n%s %s %s (max %d)", title, first, sparkline(values), last, maxCount)
+}
+
+// YearValues returns the counts of every year from the first to the last of the publish year counts,
+// or of every decade, century or millennium, named by the returned bucket, when the years do not fit
+// in a sparkline
+func YearValues(counts []api.FacetValue) ([]int, string) {
+	years := make([]int, len(counts))
+	for i, count := range counts {
+		years[i], _ = strconv.Atoi(countLabel(count))
+	}
+
+	size, bucket := 1, ""
+	for _, next := range []struct {
+		size int
+		name string
+	}{{10, "decade"}, {100, "century"}, {1000, "millennium"}} {
+		if years[len(years)-1]/size-years[0]/size < sparklineWidth {
+			break
+		}
+		size, bucket = next.size, next.name
+	}
+
+	values := make([]int, years[len(years)-1]/size-years[0]/size+1)
+	for i, count := range counts {
+		values[years[i]/size-years[0]/size] += count.Count
+	}
+	return values, bucket
+}
+
+// formatStatsCSV writes the statistics as section, value and count rows
+func formatStatsCSV(stats api.Stats) (string, error) {
+	var builder strings.Builder
+	writer := csv.NewWriter(&builder)
+
+	records := [][]string{
+		{"section", "value", "count"},
+		{"total", "books", strconv.Itoa(stats.TotalBooks)},
+		{"total", "collections", strconv.Itoa(stats.TotalCollections)},
+	}
+	for _, section := range statsSections(stats) {
+		for _, count := range section.counts {
+			value := ""
+			if count.Value != nil {
+				value = *count.Value
+			}
+			records = append(records, []string{section.name, value, strconv.Itoa(count.Count)})
+		}
+	}
+
+	err := writer.WriteAll(records)
+	return strings.TrimSuffix(builder.String(), "\n"), err
+}
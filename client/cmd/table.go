@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"bms/shared/api"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// formatTable aligns rows of cells in columns under upper case headers
+func formatTable(headers []string, rows [][]string) string {
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.ToUpper(strings.Join(headers, "\t")))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+
+	// columns are padded even when the last cell of a row is empty
+	lines := strings.Split(strings.TrimSuffix(builder.String(), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatFacets renders each facet as a table of values and counts, followed by the total
+func formatFacets(facets api.BookFacets) string {
+	tables := make([]string, 0, len(facets.Facets)+1)
+	for _, facet := range facets.Facets {
+		rows := make([][]string, len(facet.Values))
+		for i, value := range facet.Values {
+			label := "(none)"
+			if value.Value != nil {
+				label = *value.Value
+			}
+			rows[i] = []string{label, strconv.Itoa(value.Count)}
+		}
+		tables = append(tables, formatTable([]string{facet.Field, "books"}, rows))
+	}
+	tables = append(tables, fmt.Sprintf("%d books matched", facets.Total))
+	return strings.Join(tables, "\n\n")
+}
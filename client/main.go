@@ -5,12 +5,11 @@ package main
 
 import (
 	"bms/client/cmd"
-	"fmt"
+	"os"
 )
 
 func main() {
+	// cobra prints the error to stderr, only the exit code is left to set
 	err := cmd.RootCmd.Execute()
-	if err != nil {
-		fmt.Println(err)
-	}
+	os.Exit(cmd.ExitCode(err))
 }
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"bms/server/blob"
 	"database/sql"
 	"fmt"
 	"github.com/go-chi/chi/v5"
@@ -16,6 +17,13 @@ type Config struct {
 	DbPassword string
 	DbName     string
 	ServerPort string
+	// RepositoryName, RepositoryIdentifier and AdminEmail describe the catalog to OAI-PMH harvesters.
+	// The identifier is a domain name that prefixes the OAI identifiers of books.
+	RepositoryName       string
+	RepositoryIdentifier string
+	AdminEmail           string
+	// BlobDir is the folder cover images and attached files are stored in
+	BlobDir string
 }
 
 // App server struct
@@ -43,14 +51,35 @@ func NewApp(config Config) *App {
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
+	router.Use(negotiateProblemDetails)
 
-	handler := &Handler{db: db}
+	blobs, err := blob.NewFileStore(config.BlobDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler := &Handler{db: db, config: config, blobs: blobs}
 
 	// book endpoints
 	router.Post("/book/create", handler.createBook)
+	router.Post("/book/import", handler.importBooks)
+	router.Get("/book/export", handler.exportBooks)
 	router.Get("/book/list", handler.listBooks)
+	router.Get("/book/search", handler.searchBooks)
+	router.Get("/book/facets", handler.getBookFacets)
+	router.Get("/book/get", handler.getBookByTitle)
 	router.Put("/book/set", handler.setBook)
+	router.Patch("/book/set", handler.patchBook)
 	router.Delete("/book/remove", handler.removeBook)
+	router.Get("/book/history", handler.getBookHistory)
+	router.Post("/book/revert", handler.revertBook)
+	router.Get("/book/identifiers", handler.getBookIdentifiers)
+	router.Put("/book/{id}/cover", handler.setCover)
+	router.Get("/book/{id}/cover", handler.getCoverImage)
+	router.Post("/book/files", handler.importFile)
+	router.Post("/book/{id}/files", handler.attachFile)
+	router.Get("/book/{id}/files", handler.listAttachments)
+	router.Get("/book/{id}/files/{hash}", handler.downloadAttachment)
 
 	// collection endpoints
 	router.Post("/collection/create", handler.createCollection)
@@ -59,6 +88,29 @@ func NewApp(config Config) *App {
 	router.Delete("/collection/remove-book", handler.removeBookFromCollection)
 	router.Get("/collection/list", handler.getCollections)
 	router.Get("/collection/list/books", handler.getBooksInCollection)
+	router.Get("/collection/cite", handler.citeCollection)
+
+	// OPDS catalog endpoints
+	router.Get("/opds", handler.opdsRoot)
+	router.Get("/opds/books", handler.opdsBooks)
+	router.Get("/opds/new", handler.opdsNew)
+	router.Get("/opds/collections", handler.opdsCollections)
+	router.Get("/opds/collection", handler.opdsCollection)
+	router.Get("/opds/search", handler.opdsSearch)
+	router.Get("/opds/opensearch.xml", handler.opdsSearchDescription)
+
+	// OAI-PMH endpoint
+	router.Get("/oai", handler.oaiPMH)
+	router.Post("/oai", handler.oaiPMH)
+
+	// SRU endpoint
+	router.Get("/sru", handler.sruSearch)
+
+	// audit endpoints
+	router.Get("/audit", handler.listAudit)
+
+	// statistics endpoints
+	router.Get("/stats", handler.getStats)
 
 	// Start the server
 	server := &http.Server{
@@ -81,18 +133,48 @@ func (a *App) Run() {
 	}
 }
 
-// createTables creates the books and collections tables if they don't exist
+// createTables creates the books, collections, readings, identifiers, covers, files, audit and revision tables if they don't exist
 func createTables(db *sql.DB) {
 	// unique non empty string title
 	createBooksTableQuery := `CREATE TABLE IF NOT EXISTS books (
 		title VARCHAR(255) NOT NULL PRIMARY KEY,
 		author VARCHAR(255),
 		publish_date DATE,
+		publish_date_precision VARCHAR(5),
 		edition VARCHAR(10),
+		publisher VARCHAR(255),
 		description TEXT,
-		genre VARCHAR(255)
+		genre VARCHAR(255),
+		version INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMPTZ DEFAULT now(),
+		search_vector tsvector GENERATED ALWAYS AS (` + bookSearchVectorSQL + `) STORED
 	);`
 
+	// books created before versioning start at version 1
+	addBookVersionQuery := `ALTER TABLE books ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;`
+
+	addBookPublisherQuery := `ALTER TABLE books ADD COLUMN IF NOT EXISTS publisher VARCHAR(255);`
+
+	// the full-text search document is kept up to date by Postgres
+	addBookSearchVectorQuery := `ALTER TABLE books ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (` + bookSearchVectorSQL + `) STORED;`
+	createBookSearchIndexQuery := `CREATE INDEX IF NOT EXISTS books_search_idx ON books USING GIN (search_vector);`
+
+	// publish dates may be known to the year, month or day. Existing dates are full dates, except the
+	// 0001-01-01 placeholder that was stored for books created without one
+	addPublishDatePrecisionQuery := `ALTER TABLE books ADD COLUMN IF NOT EXISTS publish_date_precision VARCHAR(5);`
+	migratePublishDatesQuery := `UPDATE books SET
+		publish_date = NULLIF(publish_date, '0001-01-01'),
+		publish_date_precision = CASE WHEN publish_date = '0001-01-01' THEN NULL ELSE 'day' END
+		WHERE publish_date IS NOT NULL AND publish_date_precision IS NULL;`
+
+	// book listings are ordered by title without a leading article by default
+	createBooksSortTitleIndexQuery := `CREATE INDEX IF NOT EXISTS books_sort_title_idx ON books ((` + sortTitleSQL("title") + `), title);`
+
+	// trigram matching for fuzzy title filters and suggestions
+	createTrigramExtensionQuery := `CREATE EXTENSION IF NOT EXISTS pg_trgm;`
+	createBookTitleTrigramIndexQuery := `CREATE INDEX IF NOT EXISTS books_title_trgm_idx ON books USING GIN (title gin_trgm_ops);`
+
 	createCollectionsTableQuery := `CREATE TABLE IF NOT EXISTS collections (
 		name VARCHAR(255) NOT NULL PRIMARY KEY
 	);`
@@ -105,18 +187,117 @@ func createTables(db *sql.DB) {
     	FOREIGN KEY (collection_name) REFERENCES collections (name)
 	);`
 
-	_, err := db.Exec(createBooksTableQuery)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// ratings and read dates imported from reading logs, at most one per book
+	createBookReadingsTableQuery := `CREATE TABLE IF NOT EXISTS book_readings (
+		book_title VARCHAR(255) NOT NULL PRIMARY KEY REFERENCES books (title),
+		rating NUMERIC(2, 1) CHECK (rating > 0 AND rating <= 5),
+		read_date DATE,
+		read_date_precision VARCHAR(5)
+	);`
 
-	_, err = db.Exec(createCollectionsTableQuery)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// identifiers of books in other schemes, such as isbn or goodreads, one value per scheme
+	createBookIdentifiersTableQuery := `CREATE TABLE IF NOT EXISTS book_identifiers (
+		book_title VARCHAR(255) NOT NULL REFERENCES books (title),
+		type VARCHAR(50) NOT NULL,
+		value VARCHAR(255) NOT NULL,
+		PRIMARY KEY (book_title, type)
+	);`
 
-	_, err = db.Exec(createCollectionSubscriptions)
-	if err != nil {
-		log.Fatal(err)
+	// the cover image of a book, the image itself is in the blob store under its hash
+	createBookCoversTableQuery := `CREATE TABLE IF NOT EXISTS book_covers (
+		book_title VARCHAR(255) NOT NULL PRIMARY KEY REFERENCES books (title),
+		hash CHAR(64) NOT NULL,
+		content_type VARCHAR(50) NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		size BIGINT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+
+	// the EPUB and PDF files attached to a book, the files themselves are in the blob store under their hash
+	createBookFilesTableQuery := `CREATE TABLE IF NOT EXISTS book_files (
+		book_title VARCHAR(255) NOT NULL REFERENCES books (title),
+		hash CHAR(64) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		content_type VARCHAR(50) NOT NULL,
+		size BIGINT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (book_title, hash)
+	);`
+
+	// every mutation is recorded with the before and after state of the entity
+	createAuditLogTableQuery := `CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		actor VARCHAR(255) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		method VARCHAR(10) NOT NULL,
+		endpoint VARCHAR(255) NOT NULL,
+		action VARCHAR(50) NOT NULL,
+		entity VARCHAR(50) NOT NULL,
+		entity_id VARCHAR(255) NOT NULL,
+		before JSONB,
+		after JSONB
+	);`
+
+	createAuditLogIndexQuery := `CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id);`
+	// collection membership is looked up by book for OAI-PMH datestamps and set harvests
+	createAuditLogBookTitleIndexQuery := `CREATE INDEX IF NOT EXISTS audit_log_book_title_idx ON audit_log
+		((COALESCE(after, before)->>'book_title'), created_at) WHERE entity = 'collection';`
+
+	// numbered snapshots of each book, kept after the book is removed
+	createBookRevisionsTableQuery := `CREATE TABLE IF NOT EXISTS book_revisions (
+		title VARCHAR(255) NOT NULL,
+		revision INTEGER NOT NULL,
+		actor VARCHAR(255) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		action VARCHAR(50) NOT NULL,
+		book JSONB NOT NULL,
+		PRIMARY KEY (title, revision)
+	);`
+
+	// books created before created_at was recorded are dated by their latest create revision, failing
+	// that their earliest revision or audit entry, and failing that the Unix epoch, so that the date of
+	// a book never changes. The default is only set afterwards, as adding the column with a default
+	// would date every existing book to the migration
+	addBookCreatedAtQuery := `ALTER TABLE books ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;`
+	migrateBookCreatedAtQuery := `UPDATE books SET created_at = COALESCE(
+		(SELECT COALESCE(MAX(created_at) FILTER (WHERE action = 'create'), MIN(created_at))
+			FROM book_revisions WHERE book_revisions.title = books.title),
+		(SELECT MIN(created_at) FROM audit_log WHERE entity = 'book' AND entity_id = books.title),
+		'epoch'::timestamptz
+	) WHERE created_at IS NULL;`
+	setBookCreatedAtDefaultQuery := `ALTER TABLE books ALTER COLUMN created_at SET DEFAULT now(),
+		ALTER COLUMN created_at SET NOT NULL;`
+
+	queries := []string{
+		createBooksTableQuery,
+		addBookVersionQuery,
+		addBookPublisherQuery,
+		addPublishDatePrecisionQuery,
+		migratePublishDatesQuery,
+		addBookSearchVectorQuery,
+		createBookSearchIndexQuery,
+		createBooksSortTitleIndexQuery,
+		createTrigramExtensionQuery,
+		createBookTitleTrigramIndexQuery,
+		createCollectionsTableQuery,
+		createCollectionSubscriptions,
+		createBookReadingsTableQuery,
+		createBookIdentifiersTableQuery,
+		createBookCoversTableQuery,
+		createBookFilesTableQuery,
+		createAuditLogTableQuery,
+		createAuditLogIndexQuery,
+		createAuditLogBookTitleIndexQuery,
+		createBookRevisionsTableQuery,
+		addBookCreatedAtQuery,
+		migrateBookCreatedAtQuery,
+		setBookCreatedAtDefaultQuery,
+	}
+	for _, query := range queries {
+		_, err := db.Exec(query)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 }
@@ -0,0 +1,364 @@
+package app
+
+import (
+	"bms/shared/api"
+	"bms/shared/sources"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// attachmentMaxBytes is the largest file attached to a book
+	attachmentMaxBytes = 200 << 20
+	// attachmentCacheControl lets clients keep a file for good, as its URL names the hash of its content
+	attachmentCacheControl = "private, max-age=31536000, immutable"
+)
+
+// attachmentExtensions are the file types that can be attached to books, with the extension of their default name
+var attachmentExtensions = map[string]string{sources.EPUBType: ".epub", "application/pdf": ".pdf"}
+
+// attachmentKey is the blob key of an attached file. Files are stored by hash, so a file attached to
+// several books is stored once.
+func attachmentKey(hash string) string {
+	return "files/" + hash
+}
+
+// attachmentName is the default file name of a file attached to a book, its title with the path
+// separators replaced, shortened to fit the name column with the extension
+func attachmentName(title string, extension string) string {
+	name := strings.NewReplacer("/", "-", `\`, "-").Replace(title)
+	for len(name)+len(extension) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + extension
+}
+
+// attachFile attaches the EPUB or PDF file in the request body to a book
+func (h *Handler) attachFile(w http.ResponseWriter, r *http.Request) {
+	title, err := bookIDParam(r)
+	if err != nil || title == "" {
+		respondError(w, err, http.StatusBadRequest, "Invalid book id")
+		return
+	}
+	h.attach(w, r, title)
+}
+
+// importFile attaches the EPUB file in the request body to the book with the title in its metadata
+func (h *Handler) importFile(w http.ResponseWriter, r *http.Request) {
+	h.attach(w, r, "")
+}
+
+// attach stores a file and attaches it to a book, under the name parameter or else the title of the book.
+// An EPUB attached to a book that does not exist creates the book from its OPF metadata, recording its
+// ISBN, and without a title the book is the one titled in the metadata. Attaching the same content to
+// a book again keeps the file it has.
+func (h *Handler) attach(w http.ResponseWriter, r *http.Request, title string) {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if len(name) > 255 || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		respondError(w, nil, http.StatusBadRequest, "Name must be a file name of at most 255 characters")
+		return
+	}
+
+	// the upload is spooled to a temporary file, as files are too large to hold in memory
+	upload, err := os.CreateTemp("", "bms-upload-*")
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error attaching file")
+		return
+	}
+	defer os.Remove(upload.Name())
+	defer upload.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(upload, hash), http.MaxBytesReader(w, r.Body, attachmentMaxBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, nil, http.StatusRequestEntityTooLarge, fmt.Sprintf("File is larger than %d MiB", attachmentMaxBytes>>20))
+		return
+	}
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// the type is sniffed from the content, an EPUB being a zip archive that says it is one
+	head := make([]byte, 512)
+	n, err := upload.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		respondError(w, err, http.StatusInternalServerError, "Error attaching file")
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	if contentType == "application/zip" && sources.IsEPUB(upload, size) {
+		contentType = sources.EPUBType
+	}
+	if _, ok := attachmentExtensions[contentType]; !ok {
+		respondError(w, nil, http.StatusUnsupportedMediaType, "File must be an EPUB or PDF")
+		return
+	}
+
+	// the metadata of an EPUB is only needed for a book that does not exist, so an EPUB without readable
+	// metadata can still be attached to an existing book
+	var metadata api.ImportBook
+	hasMetadata := false
+	if contentType == sources.EPUBType {
+		metadata, err = sources.ReadEPUB(upload, size)
+		hasMetadata = err == nil
+	}
+	if title == "" {
+		if contentType != sources.EPUBType {
+			respondError(w, nil, http.StatusBadRequest, "Only an EPUB can be imported, attach a PDF to a book by its title")
+			return
+		}
+		if !hasMetadata {
+			respondError(w, err, http.StatusBadRequest, "Invalid EPUB")
+			return
+		}
+		if metadata.Title == "" {
+			respondError(w, nil, http.StatusBadRequest, "EPUB has no title, attach it to a book by its title")
+			return
+		}
+		title = metadata.Title
+	}
+	metadata.Title = title
+	if name == "" {
+		name = attachmentName(title, attachmentExtensions[contentType])
+	}
+
+	attachment := api.Attachment{
+		BookTitle:   title,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		Hash:        hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	// a file stored for an attachment that is not committed is removed once the transaction has ended,
+	// unless a book has it attached
+	stored, committed := false, false
+	defer func() {
+		if stored && !committed {
+			h.removeUnusedAttachment(attachment.Hash)
+		}
+	}()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error attaching file")
+		return
+	}
+	defer tx.Rollback()
+
+	created := false
+	if hasMetadata {
+		var status string
+		status, err = importBook(tx, r, api.ImportSkip, metadata.Book)
+		created = status == api.ImportCreated
+		if err == nil && created {
+			err = recordIdentifiers(tx, r, metadata, &api.ImportReport{})
+		}
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error creating book from EPUB")
+			return
+		}
+	} else {
+		book, err := getBookForUpdate(tx, title)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error attaching file")
+			return
+		}
+		if book == nil {
+			respondBookNotFound(w, tx, title)
+			return
+		}
+	}
+
+	// the file is stored before its row under the lock of its hash, so that an attachment row always has
+	// its file and the file is not removed as unused before the row is committed
+	err = lockBlob(tx, attachmentKey(attachment.Hash))
+	if err == nil {
+		stored = true
+		err = h.blobs.Put(attachmentKey(attachment.Hash), io.NewSectionReader(upload, 0, size))
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error attaching file")
+		return
+	}
+
+	err = tx.QueryRow(`INSERT INTO book_files (book_title, hash, name, content_type, size) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (book_title, hash) DO NOTHING RETURNING created_at`,
+		title, attachment.Hash, attachment.Name, attachment.ContentType, attachment.Size).Scan(&attachment.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		existing, err := getAttachment(tx, title, attachment.Hash)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error attaching file")
+			return
+		}
+		respondJSON(w, existing, "File is already attached to the book", http.StatusOK)
+		return
+	}
+	if err == nil {
+		err = recordAudit(tx, r, "attach", "file", title, nil, attachment)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error attaching file")
+		return
+	}
+	committed = true
+
+	if created {
+		respondJSON(w, attachment, fmt.Sprintf("Book %q created from the EPUB metadata and file attached", title), http.StatusCreated)
+		return
+	}
+	respondJSON(w, attachment, "File attached successfully", http.StatusCreated)
+}
+
+// listAttachments returns the files attached to a book, oldest first
+func (h *Handler) listAttachments(w http.ResponseWriter, r *http.Request) {
+	title, err := bookIDParam(r)
+	if err != nil || title == "" {
+		respondError(w, err, http.StatusBadRequest, "Invalid book id")
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM books WHERE title = $1)`, title).Scan(&exists)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book files")
+		return
+	}
+	if !exists {
+		respondBookNotFound(w, h.db, title)
+		return
+	}
+
+	rows, err := h.db.Query(`SELECT `+attachmentColumns+` FROM book_files WHERE book_title = $1 ORDER BY created_at, name`, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book files")
+		return
+	}
+	defer rows.Close()
+
+	attachments := make([]api.Attachment, 0)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting book files")
+			return
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book files")
+		return
+	}
+
+	respondJSON(w, attachments, "Book files retrieved successfully", http.StatusOK)
+}
+
+// downloadAttachment serves a file attached to a book, named by its hash. The response carries the hash
+// in its ETag, so conditional and range requests are answered by http.ServeContent.
+func (h *Handler) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	title, err := bookIDParam(r)
+	if err != nil || title == "" {
+		respondError(w, err, http.StatusBadRequest, "Invalid book id")
+		return
+	}
+	hash := strings.ToLower(chi.URLParam(r, "hash"))
+
+	attachment, err := getAttachment(h.db, title, hash)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book file")
+		return
+	}
+	if attachment == nil {
+		var exists bool
+		err = h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM books WHERE title = $1)`, title).Scan(&exists)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting book file")
+		} else if !exists {
+			respondBookNotFound(w, h.db, title)
+		} else {
+			respondErrorCode(w, nil, http.StatusNotFound, api.CodeFileNotFound, "File not found")
+		}
+		return
+	}
+
+	content, err := h.blobs.Get(attachmentKey(attachment.Hash))
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book file")
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, attachment.Hash))
+	w.Header().Set("Cache-Control", attachmentCacheControl)
+	http.ServeContent(w, r, "", attachment.CreatedAt, content)
+}
+
+// attachmentColumns are the columns read by scanAttachment
+const attachmentColumns = `book_title, name, content_type, size, hash, created_at`
+
+// scanAttachment reads a row of attachmentColumns
+func scanAttachment(row rowScanner) (api.Attachment, error) {
+	var attachment api.Attachment
+	err := row.Scan(&attachment.BookTitle, &attachment.Name, &attachment.ContentType, &attachment.Size, &attachment.Hash, &attachment.CreatedAt)
+	return attachment, err
+}
+
+// getAttachment returns a file attached to a book, or nil if the book has no file with the hash
+func getAttachment(q querier, title string, hash string) (*api.Attachment, error) {
+	attachment, err := scanAttachment(q.QueryRow(`SELECT `+attachmentColumns+` FROM book_files WHERE book_title = $1 AND hash = $2`, title, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// removeAttachments detaches every file from a book, returning the hashes of the files
+func removeAttachments(q querier, title string) ([]string, error) {
+	rows, err := q.Query(`DELETE FROM book_files WHERE book_title = $1 RETURNING hash`, title)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// removeUnusedAttachment deletes a file once no book has it attached. Failing to delete it only leaves
+// an unused file, so errors are logged.
+func (h *Handler) removeUnusedAttachment(hash string) {
+	key := attachmentKey(hash)
+	err := h.removeUnusedBlobs(key, `SELECT EXISTS (SELECT 1 FROM book_files WHERE hash = $1)`, hash, []string{key})
+	if err != nil {
+		log.Printf("Error removing unused file: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"bms/shared/api"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// actorFromRequest returns the actor responsible for a request
+func actorFromRequest(r *http.Request) string {
+	actor := strings.TrimSpace(r.Header.Get(api.ActorHeader))
+	if actor == "" {
+		return "anonymous"
+	}
+	return actor
+}
+
+// marshalAuditState converts the before or after state of an entity to JSON, nil is stored as NULL
+func marshalAuditState(state any) (any, error) {
+	if state == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// recordAudit writes an audit_log entry for a mutation made by the request
+func recordAudit(q querier, r *http.Request, action string, entity string, entityID string, before any, after any) error {
+	beforeJSON, err := marshalAuditState(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalAuditState(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(
+		`INSERT INTO audit_log (actor, method, endpoint, action, entity, entity_id, before, after) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		actorFromRequest(r), r.Method, r.URL.Path, action, entity, entityID, beforeJSON, afterJSON)
+	return err
+}
+
+// parseAuditTime parses a since/until filter as RFC 3339 or YYYY-MM-DD
+func parseAuditTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(api.PublishTimeLayoutDMY, value)
+}
+
+// listAudit returns audit entries, newest first, with optional filters
+func (h *Handler) listAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	conditions := []string{}
+	values := []any{}
+	counter := 1
+	filters := [][2]string{{"entity", "entity"}, {"id", "entity_id"}, {"actor", "actor"}, {"action", "action"}}
+	// the history of a book includes it being added to and removed from collections, which are
+	// recorded as collection entries holding its title, so the entity and id filters are replaced
+	if query.Get("entity") == "book" && query.Get("id") != "" {
+		conditions = append(conditions, fmt.Sprintf(
+			"(entity = 'book' AND entity_id = $%[1]d OR entity = 'collection' AND COALESCE(after, before)->>'book_title' = $%[1]d)", counter))
+		values = append(values, query.Get("id"))
+		counter++
+		filters = filters[2:]
+	}
+	for _, filter := range filters {
+		if value := query.Get(filter[0]); value != "" {
+			genSQLConditions(&conditions, &values, "=", filter[1], value, &counter)
+		}
+	}
+
+	timeFilters := [][2]string{{"since", ">="}, {"until", "<="}}
+	for _, filter := range timeFilters {
+		param, op := filter[0], filter[1]
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseAuditTime(value)
+		if err != nil {
+			respondError(w, err, http.StatusBadRequest, fmt.Sprintf("Invalid %s time", param))
+			return
+		}
+		conditions = append(conditions, fmt.Sprintf("created_at %s $%d", op, counter))
+		values = append(values, t)
+		counter++
+	}
+
+	limit := defaultAuditLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxAuditLimit {
+			respondError(w, err, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit))
+			return
+		}
+		limit = parsed
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			respondError(w, err, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+		offset = parsed
+	}
+
+	sqlQuery := "SELECT id, actor, created_at, method, endpoint, action, entity, entity_id, before, after FROM audit_log"
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", counter, counter+1)
+	values = append(values, limit, offset)
+
+	rows, err := h.db.Query(sqlQuery, values...)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting audit log")
+		return
+	}
+	defer rows.Close()
+
+	entries := make([]api.AuditEntry, 0)
+	for rows.Next() {
+		var entry api.AuditEntry
+		var before, after []byte
+		err := rows.Scan(&entry.ID, &entry.Actor, &entry.Timestamp, &entry.Method, &entry.Endpoint,
+			&entry.Action, &entry.Entity, &entry.EntityID, &before, &after)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting audit log")
+			return
+		}
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, entry)
+	}
+
+	respondJSON(w, entries, "Audit log retrieved successfully", http.StatusOK)
+}
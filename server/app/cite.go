@@ -0,0 +1,88 @@
+package app
+
+import (
+	"bms/shared/api"
+	"bms/shared/citation"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// citeCollection writes the books of a collection as BibTeX, RIS or CSL-JSON citations. Books are
+// ordered by title, so that the citation keys derived from author and year stay the same between
+// requests while the collection does not change.
+func (h *Handler) citeCollection(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.URL.Query().Get("collection_name")
+	if collectionName == "" {
+		respondError(w, nil, http.StatusBadRequest, "collection_name cannot be empty")
+		return
+	}
+	format := citation.Format(r.URL.Query().Get("format"))
+	if format == "" {
+		format = citation.BibTeX
+	}
+	switch format {
+	case citation.BibTeX, citation.RIS, citation.CSLJSON:
+	default:
+		respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("invalid format %q, must be bibtex, ris or csl-json", format))
+		return
+	}
+
+	var exists bool
+	err := h.db.QueryRow("SELECT EXISTS (SELECT 1 FROM collections WHERE name = $1)", collectionName).Scan(&exists)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error citing collection")
+		return
+	}
+	if !exists {
+		respondErrorCode(w, nil, http.StatusNotFound, api.CodeCollectionNotFound, "Collection not found")
+		return
+	}
+
+	rows, err := h.db.Query(`SELECT `+bookColumns+` FROM books
+		WHERE title IN (SELECT book_title FROM collection_subscriptions WHERE collection_name = $1)
+		ORDER BY title`, collectionName)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error citing collection")
+		return
+	}
+	defer rows.Close()
+
+	var books []api.Book
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error citing collection")
+			return
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error citing collection")
+		return
+	}
+
+	keys := citation.Keys(books)
+	entries := make([]citation.Entry, len(books))
+	for i, book := range books {
+		entries[i] = citation.Entry{Key: keys[i], Book: book}
+	}
+
+	w.Header().Set("Content-Type", citation.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, collectionFileName(collectionName), citation.Extension(format)))
+	w.WriteHeader(http.StatusOK)
+	if err := citation.Write(w, format, entries); err != nil {
+		log.Printf("Error citing collection: %v", err)
+	}
+}
+
+// collectionFileName returns a collection name usable as a file name in a Content-Disposition header
+func collectionFileName(name string) string {
+	runes := []rune(name)
+	for i, r := range runes {
+		if r < ' ' || r == '"' || r == '\\' || r == '/' || r > '~' {
+			runes[i] = '_'
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,333 @@
+package app
+
+import (
+	"bms/server/blob"
+	"bms/server/thumbnail"
+	"bms/shared/api"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// coverMaxBytes is the largest cover image accepted
+	coverMaxBytes = 10 << 20
+	// coverMaxPixels bounds the size of a decoded cover, as a small file can hold a very large image
+	coverMaxPixels = 50_000_000
+	// coverCacheControl lets clients reuse a cover for an hour, and revalidate it with its ETag after
+	coverCacheControl = "public, max-age=3600"
+)
+
+// coverSizes are the longest sides in pixels of the thumbnails served for the size parameter
+var coverSizes = map[string]int{"small": 96, "medium": 240, "large": 480}
+
+// coverTypes are the image types accepted as covers
+var coverTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// coverKey is the blob key of a cover image. Images are stored by hash, so a cover shared by
+// several books is stored once.
+func coverKey(hash string) string {
+	return "covers/" + hash
+}
+
+// thumbnailKey is the blob key of the thumbnail of a cover image in a size
+func thumbnailKey(hash string, size string) string {
+	return "thumbnails/" + hash + "-" + size + ".jpg"
+}
+
+// bookIDParam returns the title of the book named by the {id} path segment, the title escaped as a path segment
+func bookIDParam(r *http.Request) (string, error) {
+	id := chi.URLParam(r, "id")
+	// chi matches the escaped path when it differs from the decoded path, such as for a title with a slash
+	if r.URL.RawPath != "" {
+		return url.PathUnescape(id)
+	}
+	return id, nil
+}
+
+// setCover stores the JPEG, PNG or GIF image in the request body as the cover of a book, replacing its cover
+func (h *Handler) setCover(w http.ResponseWriter, r *http.Request) {
+	title, err := bookIDParam(r)
+	if err != nil || title == "" {
+		respondError(w, err, http.StatusBadRequest, "Invalid book id")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, coverMaxBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, nil, http.StatusRequestEntityTooLarge, fmt.Sprintf("Cover image is larger than %d MiB", coverMaxBytes>>20))
+		return
+	}
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// the type is sniffed from the content, as it is what the image decoders go by
+	contentType := http.DetectContentType(data)
+	if !coverTypes[contentType] {
+		respondError(w, nil, http.StatusUnsupportedMediaType, "Cover must be a JPEG, PNG or GIF image")
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && config.Width*config.Height > coverMaxPixels {
+		err = fmt.Errorf("%dx%d pixels is too large", config.Width, config.Height)
+	}
+	if err == nil {
+		_, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest, "Invalid cover image")
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	cover := api.Cover{
+		BookTitle:   title,
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+		Size:        int64(len(data)),
+		Hash:        hex.EncodeToString(sum[:]),
+	}
+
+	// an image stored for a cover that is not committed is removed once the transaction has ended,
+	// unless another book has it as its cover
+	stored, committed := false, false
+	defer func() {
+		if stored && !committed {
+			h.removeUnusedCover(cover.Hash)
+		}
+	}()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error setting cover")
+		return
+	}
+	defer tx.Rollback()
+
+	// the book is locked so that it is not removed before its cover row is committed
+	book, err := getBookForUpdate(tx, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error setting cover")
+		return
+	}
+	if book == nil {
+		respondBookNotFound(w, tx, title)
+		return
+	}
+
+	// the image is stored before its row under the lock of its hash, so that a cover row always has
+	// its image and the image is not removed as unused before the row is committed
+	err = lockBlob(tx, coverKey(cover.Hash))
+	if err == nil {
+		stored = true
+		err = h.blobs.Put(coverKey(cover.Hash), bytes.NewReader(data))
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error setting cover")
+		return
+	}
+
+	before, err := getCover(tx, title, true)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error setting cover")
+		return
+	}
+	err = tx.QueryRow(`INSERT INTO book_covers (book_title, hash, content_type, width, height, size) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (book_title) DO UPDATE SET hash = EXCLUDED.hash, content_type = EXCLUDED.content_type,
+		width = EXCLUDED.width, height = EXCLUDED.height, size = EXCLUDED.size, updated_at = now()
+		RETURNING updated_at`,
+		title, cover.Hash, cover.ContentType, cover.Width, cover.Height, cover.Size).Scan(&cover.UpdatedAt)
+	if err == nil {
+		err = recordAudit(tx, r, "set", "cover", title, before, cover)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error setting cover")
+		return
+	}
+	committed = true
+
+	if before == nil {
+		respondJSON(w, cover, "Cover set successfully", http.StatusCreated)
+		return
+	}
+	if before.Hash != cover.Hash {
+		h.removeUnusedCover(before.Hash)
+	}
+	respondJSON(w, cover, "Cover set successfully", http.StatusOK)
+}
+
+// getCoverImage serves the cover of a book, or with the size parameter a JPEG thumbnail of it. Thumbnails
+// are generated on first request and stored. Responses carry the hash of the image in their ETag, so
+// conditional and range requests are answered by http.ServeContent.
+func (h *Handler) getCoverImage(w http.ResponseWriter, r *http.Request) {
+	title, err := bookIDParam(r)
+	if err != nil || title == "" {
+		respondError(w, err, http.StatusBadRequest, "Invalid book id")
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "original"
+	}
+	if _, ok := coverSizes[size]; !ok && size != "original" {
+		respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("Invalid size %q, must be small, medium, large or original", size))
+		return
+	}
+
+	cover, err := getCover(h.db, title, false)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting cover")
+		return
+	}
+	if cover == nil {
+		var exists bool
+		err = h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM books WHERE title = $1)`, title).Scan(&exists)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting cover")
+		} else if !exists {
+			respondBookNotFound(w, h.db, title)
+		} else {
+			respondErrorCode(w, nil, http.StatusNotFound, api.CodeCoverNotFound, "Cover not found")
+		}
+		return
+	}
+
+	var content io.ReadSeekCloser
+	contentType := cover.ContentType
+	if size == "original" {
+		content, err = h.blobs.Get(coverKey(cover.Hash))
+	} else {
+		content, err = h.coverThumbnail(cover.Hash, size)
+		contentType = "image/jpeg"
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting cover")
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, cover.Hash, size))
+	w.Header().Set("Cache-Control", coverCacheControl)
+	http.ServeContent(w, r, "", cover.UpdatedAt, content)
+}
+
+// coverThumbnail opens the thumbnail of a cover image in a size, generating and storing it if it is missing.
+// Failing to store it only means it is generated again, so the error is logged.
+func (h *Handler) coverThumbnail(hash string, size string) (io.ReadSeekCloser, error) {
+	content, err := h.blobs.Get(thumbnailKey(hash, size))
+	if !errors.Is(err, blob.ErrNotFound) {
+		return content, err
+	}
+
+	original, err := h.blobs.Get(coverKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer original.Close()
+	img, _, err := image.Decode(original)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := thumbnail.Encode(&buf, thumbnail.Fit(img, coverSizes[size])); err != nil {
+		return nil, err
+	}
+	if err := h.blobs.Put(thumbnailKey(hash, size), bytes.NewReader(buf.Bytes())); err != nil {
+		log.Printf("Error storing cover thumbnail: %v", err)
+	}
+	return nopCloser{bytes.NewReader(buf.Bytes())}, nil
+}
+
+// nopCloser is a seekable reader with nothing to close
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// getCover returns the cover of a book, or nil if it has none, optionally locking it for an update
+func getCover(q querier, title string, forUpdate bool) (*api.Cover, error) {
+	query := `SELECT book_title, hash, content_type, width, height, size, updated_at FROM book_covers WHERE book_title = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var cover api.Cover
+	err := q.QueryRow(query, title).Scan(&cover.BookTitle, &cover.Hash, &cover.ContentType, &cover.Width, &cover.Height, &cover.Size, &cover.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cover, nil
+}
+
+// removeUnusedCover deletes a cover image and its thumbnails once no book has it as its cover. Failing
+// to delete them only leaves unused files, so errors are logged.
+func (h *Handler) removeUnusedCover(hash string) {
+	keys := []string{coverKey(hash)}
+	for size := range coverSizes {
+		keys = append(keys, thumbnailKey(hash, size))
+	}
+	err := h.removeUnusedBlobs(coverKey(hash), `SELECT EXISTS (SELECT 1 FROM book_covers WHERE hash = $1)`, hash, keys)
+	if err != nil {
+		log.Printf("Error removing unused cover: %v", err)
+	}
+}
+
+// lockBlob takes a lock on a blob key until the end of the transaction. A blob is stored and its row
+// written under the lock, and removeUnusedBlobs checks and deletes it under the lock, so that a blob
+// is never deleted between being stored and its row being committed.
+func lockBlob(q querier, key string) error {
+	_, err := q.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+	return err
+}
+
+// removeUnusedBlobs deletes the blobs of a hash when the used query, given the hash, finds no row
+// using it. The check and the deletes hold the lock of lockBlob on key.
+func (h *Handler) removeUnusedBlobs(key string, used string, hash string, keys []string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var inUse bool
+	err = lockBlob(tx, key)
+	if err == nil {
+		err = tx.QueryRow(used, hash).Scan(&inUse)
+	}
+	if err != nil || inUse {
+		return err
+	}
+	for _, blobKey := range keys {
+		if err := h.blobs.Delete(blobKey); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"bms/shared/api"
+	"encoding/json"
+	"github.com/lib/pq"
+	"net/http"
+	"strings"
+)
+
+// constraintError is the response for a violation of a named constraint
+type constraintError struct {
+	statusCode int
+	code       string
+	message    string
+}
+
+// constraintErrors maps the table constraints to the error returned when they are violated
+var constraintErrors = map[string]constraintError{
+	"books_pkey":                                    {http.StatusConflict, api.CodeBookExists, "book already exists"},
+	"collections_pkey":                              {http.StatusConflict, api.CodeCollectionExists, "collection already exists"},
+	"collection_subscriptions_pkey":                 {http.StatusConflict, api.CodeBookAlreadyInCollection, "book is already in the collection"},
+	"collection_subscriptions_book_title_fkey":      {http.StatusNotFound, api.CodeBookNotFound, "book does not exist"},
+	"collection_subscriptions_collection_name_fkey": {http.StatusNotFound, api.CodeCollectionNotFound, "collection does not exist"},
+}
+
+// classifyPQError returns the status, code and client safe message for a Postgres error.
+// ok is false for errors that are not caused by the request, such as a lost connection.
+func classifyPQError(err *pq.Error) (statusCode int, code string, message string, ok bool) {
+	if mapped, found := constraintErrors[err.Constraint]; found {
+		return mapped.statusCode, mapped.code, mapped.message, true
+	}
+
+	switch {
+	case err.Code.Name() == "unique_violation":
+		return http.StatusConflict, api.CodeConflict, err.Message, true
+	case err.Code.Class() == "23", err.Code.Class() == "22":
+		// integrity constraint violations and data exceptions such as a value too long for its column
+		return http.StatusUnprocessableEntity, api.CodeValidationFailed, err.Message, true
+	}
+	return 0, "", "", false
+}
+
+// defaultErrorCode returns the error code for a status when no more specific code applies
+func defaultErrorCode(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return api.CodeInvalidRequest
+	case http.StatusNotFound:
+		return api.CodeNotFound
+	case http.StatusConflict:
+		return api.CodeConflict
+	case http.StatusPreconditionFailed:
+		return api.CodeVersionConflict
+	case http.StatusUnsupportedMediaType:
+		return api.CodeUnsupportedMediaType
+	case http.StatusUnprocessableEntity:
+		return api.CodeValidationFailed
+	}
+	return api.CodeInternal
+}
+
+// problemResponseWriter marks the response of a request that accepts RFC 7807 problem details
+type problemResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w problemResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// negotiateProblemDetails makes error responses problem details when the client accepts application/problem+json
+func negotiateProblemDetails(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), api.ProblemContentType) {
+			w = problemResponseWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsProblemDetails reports whether the response writer was marked by negotiateProblemDetails
+func acceptsProblemDetails(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(problemResponseWriter); ok {
+			return true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// respondProblem writes an error as RFC 7807 problem details, returning false if the client did not ask for them
+func respondProblem(w http.ResponseWriter, data any, statusCode int, code string, message string) bool {
+	if !acceptsProblemDetails(w) {
+		return false
+	}
+
+	problem := api.ProblemDetails{
+		Type:   api.ProblemTypePrefix + code,
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: message,
+		Code:   code,
+		Data:   data,
+	}
+
+	w.Header().Set("Content-Type", api.ProblemContentType)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(problem)
+	return true
+}
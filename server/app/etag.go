@@ -0,0 +1,69 @@
+package app
+
+import (
+	"bms/shared/api"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag formats a book version as a strong entity tag
+func etag(version int) string {
+	return fmt.Sprintf("%q", strconv.Itoa(version))
+}
+
+// parseIfMatch returns the version required by the If-Match header.
+// ok is false when the header is absent or "*", in which case any version matches.
+func parseIfMatch(r *http.Request) (version int, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	// versions are compared strongly, so a weak W/ prefix is accepted but ignored
+	header = strings.TrimPrefix(header, "W/")
+	version, err = strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match header %q", r.Header.Get("If-Match"))
+	}
+	return version, true, nil
+}
+
+// versionMatches reports whether the current book is at the expected version.
+// When it is missing or stale a 412 is written with the current book as data.
+func versionMatches(w http.ResponseWriter, current *api.Book, expected int) bool {
+	if current == nil {
+		respondErrorCode(w, nil, http.StatusPreconditionFailed, api.CodeBookNotFound, "Book not found")
+		return false
+	}
+	if current.Version != expected {
+		w.Header().Set("ETag", etag(current.Version))
+		respondErrorCode(w, current, http.StatusPreconditionFailed, api.CodeVersionConflict,
+			fmt.Sprintf("Book has been modified, expected version %d but current version is %d", expected, current.Version))
+		return false
+	}
+	return true
+}
+
+// getBookByTitle returns a single book with its version as the ETag
+func (h *Handler) getBookByTitle(w http.ResponseWriter, r *http.Request) {
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		respondError(w, nil, http.StatusBadRequest, "Title cannot be empty")
+		return
+	}
+
+	book, err := getBook(h.db, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book")
+		return
+	}
+	if book == nil {
+		respondBookNotFound(w, h.db, title)
+		return
+	}
+
+	w.Header().Set("ETag", etag(book.Version))
+	respondJSON(w, book, "Book retrieved successfully", http.StatusOK)
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"bms/shared/api"
+	"bms/shared/bookio"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// exportFlushRows is how many books are written between flushes of an export to the client
+const exportFlushRows = 500
+
+// exportBooks streams the books matching the book filters as a CSV, JSON, NDJSON, YAML or MARC file,
+// optionally only the books of one collection. Books are written as they are read from the
+// database, so the table is never held in memory.
+func (h *Handler) exportBooks(w http.ResponseWriter, r *http.Request) {
+	format := bookio.Format(r.URL.Query().Get("format"))
+	if format == "" {
+		format = bookio.JSON
+	}
+	switch format {
+	case bookio.CSV, bookio.JSON, bookio.NDJSON, bookio.YAML, bookio.MARC, bookio.MARCXML:
+	default:
+		respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("invalid format %q, must be csv, json, ndjson, yaml, marc or marcxml", format))
+		return
+	}
+
+	conditions, values, err := parseBookFilters(r, "q")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	keys, err := parseSort(r, bookSortFields, "title", "title")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if collection := r.URL.Query().Get("collection"); collection != "" {
+		var exists bool
+		err := h.db.QueryRow("SELECT EXISTS (SELECT 1 FROM collections WHERE name = $1)", collection).Scan(&exists)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error exporting books")
+			return
+		}
+		if !exists {
+			respondErrorCode(w, nil, http.StatusNotFound, api.CodeCollectionNotFound, "Collection not found")
+			return
+		}
+		values = append(values, collection)
+		conditions = append(conditions, fmt.Sprintf("title IN (SELECT book_title FROM collection_subscriptions WHERE collection_name = $%d)", len(values)))
+	}
+
+	query := "SELECT " + bookColumns + " FROM books"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += orderBy(keys)
+
+	rows, err := h.db.QueryContext(r.Context(), query, values...)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error exporting books")
+		return
+	}
+	defer rows.Close()
+
+	// errors raised while executing the query arrive with the first row, before the status is sent
+	more := rows.Next()
+	if !more && rows.Err() != nil {
+		respondError(w, rows.Err(), http.StatusInternalServerError, "Error exporting books")
+		return
+	}
+
+	w.Header().Set("Content-Type", bookio.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	writer, _ := bookio.NewWriter(w, format)
+	controller := http.NewResponseController(w)
+	for count := 1; more; count++ {
+		book, err := scanBook(rows)
+		if err == nil {
+			err = writer.Write(book)
+		}
+		if err != nil {
+			abortExport(err)
+		}
+		if count%exportFlushRows == 0 {
+			controller.Flush()
+		}
+		more = rows.Next()
+	}
+	if err := rows.Err(); err != nil {
+		abortExport(err)
+	}
+	if err := writer.Close(); err != nil {
+		abortExport(err)
+	}
+}
+
+// abortExport ends an export that failed after the status was sent. The connection is closed
+// without finishing the response, so that clients see a truncated file as an error.
+func abortExport(err error) {
+	log.Printf("Error exporting books: %v", err)
+	panic(http.ErrAbortHandler)
+}
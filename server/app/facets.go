@@ -0,0 +1,100 @@
+package app
+
+import (
+	"bms/shared/api"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFacetLimit = 20
+	maxFacetLimit     = 1000
+)
+
+// bookFacetFields maps the fields books can be counted by to their SQL expressions.
+// Books without a value are counted under NULL.
+var bookFacetFields = map[string]string{
+	"genre":     "NULLIF(genre, '')",
+	"author":    "NULLIF(author, '')",
+	"edition":   "NULLIF(edition, '')",
+	"publisher": "NULLIF(publisher, '')",
+	"year":      "date_part('year', publish_date)::int::text",
+	"decade":    "(date_part('year', publish_date)::int / 10 * 10)::text || 's'",
+}
+
+// getBookFacets counts the books matching the book filters by the values of each requested facet field
+func (h *Handler) getBookFacets(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		respondError(w, nil, http.StatusBadRequest, "by cannot be empty")
+		return
+	}
+	fields := strings.Split(by, ",")
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+		if _, ok := bookFacetFields[fields[i]]; !ok {
+			respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("cannot count by %q, facet fields are author, decade, edition, genre, publisher and year", fields[i]))
+			return
+		}
+	}
+
+	limit := defaultFacetLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxFacetLimit {
+			respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxFacetLimit))
+			return
+		}
+	}
+
+	conditions, values, err := parseBookFilters(r, "q")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// the counts are read from one snapshot so that they add up to the total
+	tx, err := h.db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book facets")
+		return
+	}
+	defer tx.Rollback()
+
+	facets := api.BookFacets{Facets: make([]api.Facet, 0, len(fields))}
+	facets.Total, err = countRows(tx, "books", conditions, values)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book facets")
+		return
+	}
+
+	for _, field := range fields {
+		facet, err := countFacet(tx, field, where, values, limit)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting book facets")
+			return
+		}
+		facets.Facets = append(facets.Facets, facet)
+	}
+
+	respondJSON(w, facets, "Book facets retrieved successfully", http.StatusOK)
+}
+
+// countFacet returns the most common values of a facet field among the filtered books
+func countFacet(q querier, field string, where string, values []any, limit int) (api.Facet, error) {
+	query := fmt.Sprintf("SELECT %s AS value, COUNT(*) FROM books%s GROUP BY value ORDER BY COUNT(*) DESC, value LIMIT %d",
+		bookFacetFields[field], where, limit)
+	counts, err := queryCounts(q, query, values...)
+	if err != nil {
+		return api.Facet{}, err
+	}
+	return api.Facet{Field: field, Values: counts}, nil
+}
@@ -1,27 +1,58 @@
 package app
 
 import (
+	"bms/server/blob"
+	"bms/server/filter"
 	"bms/shared/api"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
+	"log"
 	"net/http"
 	"strings"
 )
 
 type Handler struct {
-	db *sql.DB
+	db     *sql.DB
+	config Config
+	blobs  blob.Store
 }
 
 func respondError(w http.ResponseWriter, err error, statusCode int, message string) {
-	if err != nil {
+	code := defaultErrorCode(statusCode)
+
+	// errors caused by the request are reported as client errors without the raw database error
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if pqStatusCode, pqCode, pqMessage, ok := classifyPQError(pqErr); ok {
+			statusCode, code, err = pqStatusCode, pqCode, errors.New(pqMessage)
+		}
+	}
+
+	if err != nil && statusCode >= http.StatusInternalServerError {
+		log.Printf("%s: %v", message, err)
+	} else if err != nil {
 		message = message + "\n" + err.Error()
 	}
 
+	respondErrorCode(w, nil, statusCode, code, message)
+}
+
+// respondErrorCode writes an error response with a machine readable code and optional data,
+// such as the current state of a conflicting entity
+func respondErrorCode(w http.ResponseWriter, data interface{}, statusCode int, code string, message string) {
+	if respondProblem(w, data, statusCode, code, message) {
+		return
+	}
+
 	response := api.Response{
 		Type:       "error",
 		StatusCode: statusCode,
+		Code:       code,
 		Message:    message,
+		Data:       data,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -30,25 +61,99 @@ func respondError(w http.ResponseWriter, err error, statusCode int, message stri
 }
 
 func respondJSON(w http.ResponseWriter, data interface{}, message string, statusCode int) {
+	respondJSONPage(w, data, nil, message, statusCode)
+}
+
+// respondJSONPage writes a success response for a page of a listing
+func respondJSONPage(w http.ResponseWriter, data interface{}, pagination *api.Pagination, message string, statusCode int) {
 	response := api.Response{
 		Type:       "success",
 		StatusCode: statusCode,
 		Message:    message,
+		Pagination: pagination,
 	}
 	if data != nil {
 		response.Data = data
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
 
+// bookColumns are the columns selected when reading a book, in scanBook order
+const bookColumns = "title, author, publish_date, publish_date_precision, edition, publisher, description, genre, version"
+
+// publishDateEndSQL is the last day covered by a book's publish date, so that
+// a partial date such as 1954 matches any range that overlaps 1954-01-01 to 1954-12-31
+const publishDateEndSQL = `(publish_date + CASE publish_date_precision
+	WHEN 'year' THEN INTERVAL '1 year' WHEN 'month' THEN INTERVAL '1 month' ELSE INTERVAL '1 day' END - INTERVAL '1 day')`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanBook scans a row selected with bookColumns followed by any extra columns,
+// cleared (NULL) fields are returned empty
+func scanBook(row rowScanner, extra ...any) (api.Book, error) {
+	var book api.Book
+	var author, precision, edition, publisher, description, genre sql.NullString
+	var publishDate sql.NullTime
+	dests := []any{&book.Title, &author, &publishDate, &precision, &edition, &publisher, &description, &genre, &book.Version}
+	err := row.Scan(append(dests, extra...)...)
+	book.Author = author.String
+	book.PublishDate = api.DateFromTime(publishDate.Time, api.DatePrecision(precision.String))
+	book.Edition = edition.String
+	book.Publisher = publisher.String
+	book.Description = description.String
+	book.Genre = genre.String
+	return book, err
+}
+
+// getBook returns the book with the given title, or nil if it does not exist
+func getBook(q querier, title string) (*api.Book, error) {
+	return queryBook(q, "SELECT "+bookColumns+" FROM books WHERE title = $1", title)
+}
+
+// getBookForUpdate returns the book with the given title locked until the end of the transaction
+func getBookForUpdate(q querier, title string) (*api.Book, error) {
+	return queryBook(q, "SELECT "+bookColumns+" FROM books WHERE title = $1 FOR UPDATE", title)
+}
+
+// queryBook returns the single book selected by query, or nil if there is none
+func queryBook(q querier, query string, args ...any) (*api.Book, error) {
+	book, err := scanBook(q.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// datePrecision returns the precision stored alongside a date, NULL for an unknown date
+func datePrecision(date api.Date) any {
+	if date.IsZero() {
+		return nil
+	}
+	return string(date.Precision)
+}
+
 func genSQLConditions(conditions *[]string, values *[]any, op string, field string, value string, counter *int) {
 	*conditions = append(*conditions, fmt.Sprintf("%s %s $%d", field, op, *counter))
 	*values = append(*values, value)
 	*counter++
 }
 
+// insertBook inserts a new book at version 1
+func insertBook(q querier, book api.Book) error {
+	_, err := q.Exec(
+		"INSERT INTO books (title, author, publish_date, publish_date_precision, edition, publisher, description, genre) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		book.Title, book.Author, book.PublishDate, datePrecision(book.PublishDate), book.Edition, book.Publisher, book.Description, book.Genre)
+	return err
+}
+
 func (h *Handler) createBook(w http.ResponseWriter, r *http.Request) {
 	var book api.Book
 	err := json.NewDecoder(r.Body).Decode(&book)
@@ -62,18 +167,39 @@ func (h *Handler) createBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = h.db.Exec(
-		"INSERT INTO books (title, author, publish_date, edition, description, genre) VALUES ($1, $2, $3, $4, $5, $6)",
-		book.Title, book.Author, book.PublishDate.Format("2006-01-02"), book.Edition, book.Description, book.Genre)
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error creating book")
+		return
+	}
+	defer tx.Rollback()
+
+	err = insertBook(tx, book)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error creating book")
+		return
+	}
+
+	after, err := getBook(tx, book.Title)
+	if err == nil {
+		_, err = recordRevision(tx, r, "create", nil, *after)
+	}
+	if err == nil {
+		err = recordAudit(tx, r, "create", "book", book.Title, nil, after)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error creating book")
 		return
 	}
 
+	w.Header().Set("ETag", etag(after.Version))
 	respondJSON(w, nil, "Book created successfully", http.StatusCreated)
 }
 
-// addBook adds a book to the database
+// setBook partially updates a book, fields left empty in the request body are not changed
 func (h *Handler) setBook(w http.ResponseWriter, r *http.Request) {
 	var book api.Book
 	err := json.NewDecoder(r.Body).Decode(&book)
@@ -94,11 +220,15 @@ func (h *Handler) setBook(w http.ResponseWriter, r *http.Request) {
 		genSQLConditions(&conditions, &values, "=", "author", book.Author, &counter)
 	}
 	if !book.PublishDate.IsZero() {
-		genSQLConditions(&conditions, &values, "=", "publish_date", book.PublishDate.Format(api.PublishTimeLayoutDMY), &counter)
+		genSQLConditions(&conditions, &values, "=", "publish_date", book.PublishDate.Start().Format(api.PublishTimeLayoutDMY), &counter)
+		genSQLConditions(&conditions, &values, "=", "publish_date_precision", string(book.PublishDate.Precision), &counter)
 	}
 	if book.Edition != "" {
 		genSQLConditions(&conditions, &values, "=", "edition", book.Edition, &counter)
 	}
+	if book.Publisher != "" {
+		genSQLConditions(&conditions, &values, "=", "publisher", book.Publisher, &counter)
+	}
 	if book.Description != "" {
 		genSQLConditions(&conditions, &values, "=", "description", book.Description, &counter)
 	}
@@ -111,11 +241,63 @@ func (h *Handler) setBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.updateBook(w, r, "set", book.Title, conditions, values)
+}
+
+// updateBook applies SET assignments to a book, honouring If-Match and recording a revision
+// and audit entry for the change. The assignments use placeholders $1 to $len(values).
+func (h *Handler) updateBook(w http.ResponseWriter, r *http.Request, action string, title string, assignments []string, values []any) {
+	ifMatch, checkVersion, err := parseIfMatch(r)
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest, "Invalid request headers")
+		return
+	}
+
+	assignments = append(assignments, "version = version + 1")
 	updateQuery :=
-		fmt.Sprintf("UPDATE books SET "+strings.Join(conditions, ", ")+" WHERE title = $%d", counter)
-	values = append(values, book.Title)
+		fmt.Sprintf("UPDATE books SET "+strings.Join(assignments, ", ")+" WHERE title = $%d", len(values)+1)
+	values = append(values, title)
 
-	_, err = h.db.Exec(updateQuery, values...)
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error updating book")
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := getBookForUpdate(tx, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error updating book")
+		return
+	}
+	if checkVersion && !versionMatches(w, before, ifMatch) {
+		return
+	}
+	if before == nil {
+		respondBookNotFound(w, tx, title)
+		return
+	}
+
+	_, err = tx.Exec(updateQuery, values...)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error updating book")
+		return
+	}
+
+	after, err := getBook(tx, title)
+	if err == nil {
+		_, err = recordRevision(tx, r, action, before, *after)
+	}
+	if err == nil {
+		err = recordAudit(tx, r, action, "book", title, before, after)
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error updating book")
+		return
+	}
+	w.Header().Set("ETag", etag(after.Version))
+
+	err = tx.Commit()
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error updating book")
 		return
@@ -132,60 +314,211 @@ func (h *Handler) removeBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// delete book subscriptions from collection_subscriptions table first
-	_, err := h.db.Exec(`DELETE FROM collection_subscriptions WHERE book_title = $1`, title)
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book")
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := getBookForUpdate(tx, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book")
+		return
+	}
+	if before == nil {
+		respondBookNotFound(w, tx, title)
+		return
+	}
+
+	// delete book subscriptions from collection_subscriptions table first, recording the book leaving
+	// each collection so that harvesters of a collection learn of its removal
+	collections, err := removeSubscriptions(tx, title)
+	if err == nil {
+		for _, collection := range collections {
+			subscription := api.CollectionSubscription{CollectionName: collection, BookTitle: title}
+			if err = recordAudit(tx, r, "remove-book", "collection", collection, subscription, nil); err != nil {
+				break
+			}
+		}
+	}
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error removing book from collection_subscriptions")
 		return
 	}
 
+	_, err = tx.Exec(`DELETE FROM book_readings WHERE book_title = $1`, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book reading")
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM book_identifiers WHERE book_title = $1`, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book identifiers")
+		return
+	}
+
+	// the cover image is deleted after the commit, unless another book has the same cover
+	var coverHash string
+	err = tx.QueryRow(`DELETE FROM book_covers WHERE book_title = $1 RETURNING hash`, title).Scan(&coverHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book cover")
+		return
+	}
+
+	// attached files are deleted after the commit, unless another book has the same file
+	fileHashes, err := removeAttachments(tx, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book files")
+		return
+	}
+
 	// remove book from books table
-	_, err = h.db.Exec(`DELETE FROM books WHERE title = $1`, title)
+	_, err = tx.Exec(`DELETE FROM books WHERE title = $1`, title)
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error removing book")
 		return
 	}
 
+	// the last revision of a removed book is its final state
+	_, err = recordRevision(tx, r, "remove", nil, *before)
+	if err == nil {
+		err = recordAudit(tx, r, "remove", "book", title, before, nil)
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book")
+		return
+	}
+	if coverHash != "" {
+		h.removeUnusedCover(coverHash)
+	}
+	for _, hash := range fileHashes {
+		h.removeUnusedAttachment(hash)
+	}
+
 	respondJSON(w, nil, "Book removed successfully", http.StatusOK)
 }
 
-// listBooks returns all books
-func (h *Handler) listBooks(w http.ResponseWriter, r *http.Request) {
+// removeSubscriptions takes a book out of every collection, returning the names of the collections
+func removeSubscriptions(q querier, title string) ([]string, error) {
+	rows, err := q.Query(`DELETE FROM collection_subscriptions WHERE book_title = $1 RETURNING collection_name`, title)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []string
+	for rows.Next() {
+		var collection string
+		if err := rows.Scan(&collection); err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	return collections, rows.Err()
+}
+
+// bookFilterFields are the fields of book filter expressions
+var bookFilterFields = map[string]filter.Field{
+	"title":        {Expr: "title", Type: filter.Text},
+	"author":       {Expr: "author", Type: filter.Text},
+	"genre":        {Expr: "genre", Type: filter.Text},
+	"edition":      {Expr: "edition", Type: filter.Text},
+	"publisher":    {Expr: "publisher", Type: filter.Text},
+	"description":  {Expr: "description", Type: filter.Text},
+	"publish_date": {Expr: "publish_date", Type: filter.Date, EndExpr: publishDateEndSQL},
+	"version":      {Expr: "version", Type: filter.Integer},
+}
+
+// parseBookFilters returns the SQL conditions for the book filter URL parameters
+// (title, genre, author, match, publish_start and publish_end) shared by the book listings,
+// and for the filter expression in the expressionParam parameter if it is not empty
+func parseBookFilters(r *http.Request, expressionParam string) ([]string, []any, error) {
 	title := r.URL.Query().Get("title")
 	genre := r.URL.Query().Get("genre")
 	author := r.URL.Query().Get("author")
-	publishStartDate := r.URL.Query().Get("publish_start")
-	publishEndDate := r.URL.Query().Get("publish_end")
+	mode, err := parseMatchMode(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	publishStartDate, err := api.ParseDate(r.URL.Query().Get("publish_start"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid publish_start: %w", err)
+	}
+	publishEndDate, err := api.ParseDate(r.URL.Query().Get("publish_end"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid publish_end: %w", err)
+	}
 
-	if publishStartDate != "" && publishEndDate != "" && publishStartDate > publishEndDate {
-		respondError(w, nil, http.StatusBadRequest, "publish_start cannot be greater than publish_end")
-		return
+	if !publishStartDate.IsZero() && !publishEndDate.IsZero() && publishStartDate.Start().After(publishEndDate.End()) {
+		return nil, nil, errors.New("publish_start cannot be greater than publish_end")
 	}
 
-	// add filter conditions to query
-	query := "SELECT title, author, publish_date, edition, description, genre FROM books"
 	conditions := []string{}
 	values := []any{}
 	counter := 1
 	if title != "" {
-		genSQLConditions(&conditions, &values, "=", "title", title, &counter)
+		genMatchCondition(&conditions, &values, mode, "title", title, &counter)
 	}
 	if genre != "" {
-		genSQLConditions(&conditions, &values, "=", "genre", genre, &counter)
+		genMatchCondition(&conditions, &values, mode, "genre", genre, &counter)
 	}
 	if author != "" {
-		genSQLConditions(&conditions, &values, "=", "author", author, &counter)
+		genMatchCondition(&conditions, &values, mode, "author", author, &counter)
+	}
+	// partial dates cover a range of days, books match if their range overlaps the filter range
+	if !publishStartDate.IsZero() {
+		genSQLConditions(&conditions, &values, ">=", publishDateEndSQL, publishStartDate.Start().Format(api.PublishTimeLayoutDMY), &counter)
+	}
+	if !publishEndDate.IsZero() {
+		genSQLConditions(&conditions, &values, "<=", "publish_date", publishEndDate.End().Format(api.PublishTimeLayoutDMY), &counter)
+	}
+
+	if expression := r.URL.Query().Get(expressionParam); expressionParam != "" && expression != "" {
+		condition, expressionValues, err := filter.Compile(expression, bookFilterFields, counter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", expressionParam, err)
+		}
+		conditions = append(conditions, condition)
+		values = append(values, expressionValues...)
+	}
+
+	return conditions, values, nil
+}
+
+// listBooks returns a page of books matching the filters, by default ordered by title
+func (h *Handler) listBooks(w http.ResponseWriter, r *http.Request) {
+	conditions, values, err := parseBookFilters(r, "q")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
 	}
-	if publishStartDate != "" {
-		genSQLConditions(&conditions, &values, ">=", "publish_date", publishStartDate, &counter)
+
+	keys, err := parseSort(r, bookSortFields, "title", "title")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
 	}
-	if publishEndDate != "" {
-		genSQLConditions(&conditions, &values, "<=", "publish_date", publishEndDate, &counter)
+	p, err := parsePage(r, keys)
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
 	}
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+
+	total, err := countRows(h.db, "books", conditions, values)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting books")
+		return
 	}
 
+	query, values := pageQuery("SELECT "+bookColumns, "books", conditions, values, keys, p)
 	rows, err := h.db.Query(query, values...)
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error getting books")
@@ -194,18 +527,29 @@ func (h *Handler) listBooks(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	books := make([]api.Book, 0)
-
+	rowCount := 0
+	var lastKeys []sql.NullString
 	for rows.Next() {
-		var book api.Book
-		err := rows.Scan(&book.Title, &book.Author, &book.PublishDate, &book.Edition, &book.Description, &book.Genre)
+		keyValues, keyDests := sortKeyDests(keys)
+		book, err := scanBook(rows, keyDests...)
 		if err != nil {
 			respondError(w, err, http.StatusInternalServerError, "Error getting books")
 			return
 		}
-		books = append(books, book)
+		rowCount++
+		if rowCount <= p.limit {
+			books = append(books, book)
+			lastKeys = keyValues
+		}
+	}
+
+	// an exact title lookup identifies a single book, so its version can be returned as the ETag
+	if r.URL.Query().Get("title") != "" && r.URL.Query().Get("match") == "" && len(books) == 1 {
+		w.Header().Set("ETag", etag(books[0].Version))
 	}
 
-	respondJSON(w, books, "Books retrieved successfully", http.StatusOK)
+	pagination := &api.Pagination{NextCursor: nextCursor(rowCount, p, lastKeys), Total: total}
+	respondJSONPage(w, books, pagination, "Books retrieved successfully", http.StatusOK)
 }
 
 // createCollection creates a collection
@@ -213,13 +557,31 @@ func (h *Handler) createCollection(w http.ResponseWriter, r *http.Request) {
 	// get parameter from URL with chi library
 	collectionName := r.URL.Query().Get("collection_name")
 
-	_, err := h.db.Exec(`INSERT INTO collections (name) VALUES ($1)`, collectionName)
+	if collectionName == "" {
+		respondError(w, nil, http.StatusBadRequest, "collection_name cannot be empty")
+		return
+	}
+
+	tx, err := h.db.Begin()
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error creating collection")
 		return
 	}
+	defer tx.Rollback()
 
-	respondJSON(w, nil, "Collection created successfully", http.StatusOK)
+	_, err = tx.Exec(`INSERT INTO collections (name) VALUES ($1)`, collectionName)
+	if err == nil {
+		err = recordAudit(tx, r, "create", "collection", collectionName, nil, api.Collection{Name: collectionName})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error creating collection")
+		return
+	}
+
+	respondJSON(w, nil, "Collection created successfully", http.StatusCreated)
 }
 
 // removeCollection removes a collection
@@ -231,26 +593,63 @@ func (h *Handler) removeCollection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing collection")
+		return
+	}
+	defer tx.Rollback()
+
 	// remove all subscribed books in collection_subscription table first
-	_, err := h.db.Exec(`DELETE FROM collection_subscriptions WHERE collection_name = $1`, collectionName)
+	_, err = tx.Exec(`DELETE FROM collection_subscriptions WHERE collection_name = $1`, collectionName)
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error removing collection")
 		return
 	}
 
 	// remove collection in collections table
-	_, err = h.db.Exec(`DELETE FROM collections WHERE name = $1`, collectionName)
+	result, err := tx.Exec(`DELETE FROM collections WHERE name = $1`, collectionName)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing collection")
+		return
+	}
+
+	removed, err := result.RowsAffected()
+	if err == nil && removed > 0 {
+		err = recordAudit(tx, r, "remove", "collection", collectionName, api.Collection{Name: collectionName}, nil)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error removing collection")
 		return
 	}
 
-	respondJSON(w, nil, "Collection removed successfully", http.StatusCreated)
+	respondJSON(w, nil, "Collection removed successfully", http.StatusOK)
 }
 
-// getCollections returns all books in a collection
+// getCollections returns a page of collections
 func (h *Handler) getCollections(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.db.Query("SELECT name FROM collections")
+	keys, err := parseSort(r, collectionSortFields, "name", "name")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parsePage(r, keys)
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	total, err := countRows(h.db, "collections", nil, nil)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting collections")
+		return
+	}
+
+	query, values := pageQuery("SELECT name", "collections", nil, nil, keys, p)
+	rows, err := h.db.Query(query, values...)
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error getting collections")
 		return
@@ -258,18 +657,25 @@ func (h *Handler) getCollections(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	collections := make([]string, 0)
-
+	rowCount := 0
+	var lastKeys []sql.NullString
 	for rows.Next() {
 		var collection string
-		err := rows.Scan(&collection)
+		keyValues, keyDests := sortKeyDests(keys)
+		err := rows.Scan(append([]any{&collection}, keyDests...)...)
 		if err != nil {
 			respondError(w, err, http.StatusInternalServerError, "Error getting collections")
 			return
 		}
-		collections = append(collections, collection)
+		rowCount++
+		if rowCount <= p.limit {
+			collections = append(collections, collection)
+			lastKeys = keyValues
+		}
 	}
 
-	respondJSON(w, collections, "Collections retrieved successfully", http.StatusOK)
+	pagination := &api.Pagination{NextCursor: nextCursor(rowCount, p, lastKeys), Total: total}
+	respondJSONPage(w, collections, pagination, "Collections retrieved successfully", http.StatusOK)
 }
 
 // addBookToCollection adds a book to a collection
@@ -278,7 +684,32 @@ func (h *Handler) addBookToCollection(w http.ResponseWriter, r *http.Request) {
 	collectionName := r.URL.Query().Get("collection_name")
 	bookTitle := r.URL.Query().Get("book_title")
 
-	_, err := h.db.Exec(`INSERT INTO collection_subscriptions(collection_name, book_title) VALUES ($1, $2)`, collectionName, bookTitle)
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error adding book to collection")
+		return
+	}
+	defer tx.Rollback()
+
+	// a missing book is reported with suggestions, rather than by the foreign key violation
+	book, err := getBook(tx, bookTitle)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error adding book to collection")
+		return
+	}
+	if book == nil {
+		respondBookNotFound(w, tx, bookTitle)
+		return
+	}
+
+	subscription := api.CollectionSubscription{CollectionName: collectionName, BookTitle: bookTitle}
+	_, err = tx.Exec(`INSERT INTO collection_subscriptions(collection_name, book_title) VALUES ($1, $2)`, collectionName, bookTitle)
+	if err == nil {
+		err = recordAudit(tx, r, "add-book", "collection", collectionName, nil, subscription)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error adding book to collection")
 		return
@@ -292,7 +723,27 @@ func (h *Handler) removeBookFromCollection(w http.ResponseWriter, r *http.Reques
 	collectionName := r.URL.Query().Get("collection_name")
 	bookTitle := r.URL.Query().Get("book_title")
 
-	_, err := h.db.Exec(`DELETE FROM collection_subscriptions WHERE collection_name = $1 AND book_title = $2`, collectionName, bookTitle)
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book from collection")
+		return
+	}
+	defer tx.Rollback()
+
+	subscription := api.CollectionSubscription{CollectionName: collectionName, BookTitle: bookTitle}
+	result, err := tx.Exec(`DELETE FROM collection_subscriptions WHERE collection_name = $1 AND book_title = $2`, collectionName, bookTitle)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error removing book from collection")
+		return
+	}
+
+	removed, err := result.RowsAffected()
+	if err == nil && removed > 0 {
+		err = recordAudit(tx, r, "remove-book", "collection", collectionName, subscription, nil)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error removing book from collection")
 		return
@@ -301,11 +752,31 @@ func (h *Handler) removeBookFromCollection(w http.ResponseWriter, r *http.Reques
 	respondJSON(w, nil, "Book removed from collection successfully", http.StatusOK)
 }
 
-// getBooksInCollection returns all books in a collection
+// getBooksInCollection returns a page of the books in a collection
 func (h *Handler) getBooksInCollection(w http.ResponseWriter, r *http.Request) {
 	collectionName := r.URL.Query().Get("collection_name")
 
-	rows, err := h.db.Query("SELECT book_title FROM collection_subscriptions WHERE collection_name = $1", collectionName)
+	keys, err := parseSort(r, collectionBookSortFields, "title", "book_title")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parsePage(r, keys)
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conditions := []string{"collection_name = $1"}
+	values := []any{collectionName}
+	total, err := countRows(h.db, "collection_subscriptions", conditions, values)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting books in collection")
+		return
+	}
+
+	query, values := pageQuery("SELECT book_title", "collection_subscriptions", conditions, values, keys, p)
+	rows, err := h.db.Query(query, values...)
 	if err != nil {
 		respondError(w, err, http.StatusInternalServerError, "Error getting books in collection")
 		return
@@ -313,15 +784,23 @@ func (h *Handler) getBooksInCollection(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	books := make([]string, 0)
+	rowCount := 0
+	var lastKeys []sql.NullString
 	for rows.Next() {
 		var book string
-		err := rows.Scan(&book)
+		keyValues, keyDests := sortKeyDests(keys)
+		err := rows.Scan(append([]any{&book}, keyDests...)...)
 		if err != nil {
 			respondError(w, err, http.StatusInternalServerError, "Error getting books in collection")
 			return
 		}
-		books = append(books, book)
+		rowCount++
+		if rowCount <= p.limit {
+			books = append(books, book)
+			lastKeys = keyValues
+		}
 	}
 
-	respondJSON(w, books, "Books in collection retrieved successfully", http.StatusOK)
+	pagination := &api.Pagination{NextCursor: nextCursor(rowCount, p, lastKeys), Total: total}
+	respondJSONPage(w, books, pagination, "Books in collection retrieved successfully", http.StatusOK)
 }
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"bms/shared/api"
+	"net/http"
+)
+
+// getBookIdentifiers returns the identifiers of a book, such as its isbn, ordered by type
+func (h *Handler) getBookIdentifiers(w http.ResponseWriter, r *http.Request) {
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		respondError(w, nil, http.StatusBadRequest, "Title cannot be empty")
+		return
+	}
+
+	var exists bool
+	err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM books WHERE title = $1)`, title).Scan(&exists)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book identifiers")
+		return
+	}
+	if !exists {
+		respondBookNotFound(w, h.db, title)
+		return
+	}
+
+	rows, err := h.db.Query(`SELECT book_title, type, value FROM book_identifiers WHERE book_title = $1 ORDER BY type`, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book identifiers")
+		return
+	}
+	defer rows.Close()
+
+	identifiers := make([]api.Identifier, 0)
+	for rows.Next() {
+		var identifier api.Identifier
+		if err := rows.Scan(&identifier.BookTitle, &identifier.Type, &identifier.Value); err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting book identifiers")
+			return
+		}
+		identifiers = append(identifiers, identifier)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book identifiers")
+		return
+	}
+
+	respondJSON(w, identifiers, "Book identifiers retrieved successfully", http.StatusOK)
+}
@@ -0,0 +1,371 @@
+package app
+
+import (
+	"bms/shared/api"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// maxImportRows is the largest number of books imported by one request
+const maxImportRows = 10000
+
+// importBooks creates or updates the books of a JSON array in one transaction. Existing titles are
+// skipped, overwritten or merged depending on the mode. Every book not skipped is added to the
+// collections listed with it, which are created if missing, and its rating, read date and identifiers
+// are recorded. If any row fails nothing is imported and the report of every row is returned with a
+// 422, a dry run reports the outcome without importing.
+func (h *Handler) importBooks(w http.ResponseWriter, r *http.Request) {
+	mode := api.ImportMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = api.ImportSkip
+	}
+	if mode != api.ImportSkip && mode != api.ImportOverwrite && mode != api.ImportMerge {
+		respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("invalid mode %q, must be skip, overwrite or merge", mode))
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			respondError(w, nil, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+
+	var books []api.ImportBook
+	err := json.NewDecoder(r.Body).Decode(&books)
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(books) == 0 {
+		respondError(w, nil, http.StatusBadRequest, "No books to import")
+		return
+	}
+	if len(books) > maxImportRows {
+		respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("Cannot import more than %d books at once", maxImportRows))
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error importing books")
+		return
+	}
+	defer tx.Rollback()
+
+	report := api.ImportReport{DryRun: dryRun, Rows: make([]api.ImportRow, 0, len(books))}
+	seen := map[string]int{}
+	for i, book := range books {
+		row := api.ImportRow{Index: i, Title: book.Title}
+		if book.Title == "" {
+			row.Status, row.Error = api.ImportFailed, "Title cannot be empty"
+			report.Add(row)
+			continue
+		}
+		if first, ok := seen[book.Title]; ok {
+			row.Status, row.Error = api.ImportFailed, fmt.Sprintf("Title is repeated from book %d", first+1)
+			report.Add(row)
+			continue
+		}
+		seen[book.Title] = i
+		if message := identifiersError(book.Identifiers); message != "" {
+			row.Status, row.Error = api.ImportFailed, message
+			report.Add(row)
+			continue
+		}
+		if book.Rating != 0 && (book.Rating < 0.5 || book.Rating > 5 || book.Rating*2 != math.Trunc(book.Rating*2)) {
+			row.Status, row.Error = api.ImportFailed, "Rating must be from 0.5 to 5 in half stars"
+			report.Add(row)
+			continue
+		}
+
+		// each row runs in a savepoint, so that a row rejected by the database does not abort the others
+		_, err = tx.Exec("SAVEPOINT import_row")
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error importing books")
+			return
+		}
+		row.Status, err = importBook(tx, r, mode, book.Book)
+		// a skipped book is left as it is, including its collections, reading and identifiers
+		skipped := row.Status == api.ImportSkipped
+		if err == nil && !skipped {
+			err = addToCollections(tx, r, book, &report)
+		}
+		if err == nil && !skipped {
+			err = recordReading(tx, r, book, &report)
+		}
+		if err == nil && !skipped {
+			err = recordIdentifiers(tx, r, book, &report)
+		}
+		if err == nil {
+			_, err = tx.Exec("RELEASE SAVEPOINT import_row")
+		} else if message, ok := importRowError(err); ok {
+			row.Status, row.Error = api.ImportFailed, message
+			_, err = tx.Exec("ROLLBACK TO SAVEPOINT import_row")
+		}
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error importing books")
+			return
+		}
+		report.Add(row)
+	}
+
+	if report.Failed > 0 {
+		respondErrorCode(w, report, http.StatusUnprocessableEntity, api.CodeValidationFailed,
+			fmt.Sprintf("%d of %d books failed, nothing was imported", report.Failed, len(books)))
+		return
+	}
+	if dryRun {
+		respondJSON(w, report, "Dry run, nothing was imported", http.StatusOK)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error importing books")
+		return
+	}
+
+	respondJSON(w, report, "Books imported successfully", http.StatusOK)
+}
+
+// importRowError returns the message for an error caused by the data of a row,
+// ok is false for errors that fail the whole import
+func importRowError(err error) (message string, ok bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if _, _, message, ok := classifyPQError(pqErr); ok {
+			return message, true
+		}
+	}
+	return "", false
+}
+
+// importBook creates a book or updates the existing book with the same title, returning the row status
+func importBook(q querier, r *http.Request, mode api.ImportMode, book api.Book) (string, error) {
+	before, err := getBookForUpdate(q, book.Title)
+	if err != nil {
+		return "", err
+	}
+
+	if before == nil {
+		err = insertBook(q, book)
+		if err != nil {
+			return "", err
+		}
+		after, err := getBook(q, book.Title)
+		if err == nil {
+			_, err = recordRevision(q, r, "import", nil, *after)
+		}
+		if err == nil {
+			err = recordAudit(q, r, "import", "book", book.Title, nil, after)
+		}
+		return api.ImportCreated, err
+	}
+
+	target := book
+	switch mode {
+	case api.ImportSkip:
+		return api.ImportSkipped, nil
+	case api.ImportMerge:
+		target = mergeBook(*before, book)
+	}
+
+	// re-importing the same data leaves the book at its version
+	target.Version = before.Version
+	if target == *before {
+		return api.ImportUnchanged, nil
+	}
+
+	_, err = q.Exec(`UPDATE books SET author = $1, publish_date = $2, publish_date_precision = $3, edition = $4,
+		publisher = $5, description = $6, genre = $7, version = version + 1 WHERE title = $8`,
+		target.Author, target.PublishDate, datePrecision(target.PublishDate), target.Edition,
+		target.Publisher, target.Description, target.Genre, target.Title)
+	if err != nil {
+		return "", err
+	}
+
+	after, err := getBook(q, book.Title)
+	if err == nil {
+		_, err = recordRevision(q, r, "import", before, *after)
+	}
+	if err == nil {
+		err = recordAudit(q, r, "import", "book", book.Title, before, after)
+	}
+	return api.ImportUpdated, err
+}
+
+// addToCollections adds an imported book to its collections, creating the collections that do not exist.
+// Books already in a collection are left as they are.
+func addToCollections(q querier, r *http.Request, book api.ImportBook, report *api.ImportReport) error {
+	for _, name := range book.Collections {
+		if name == "" {
+			continue
+		}
+		result, err := q.Exec(`INSERT INTO collections (name) VALUES ($1) ON CONFLICT DO NOTHING`, name)
+		if err != nil {
+			return err
+		}
+		if created, _ := result.RowsAffected(); created > 0 {
+			err = recordAudit(q, r, "create", "collection", name, nil, api.Collection{Name: name})
+			if err != nil {
+				return err
+			}
+			report.CollectionsCreated++
+		}
+
+		result, err = q.Exec(`INSERT INTO collection_subscriptions(collection_name, book_title) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, name, book.Title)
+		if err != nil {
+			return err
+		}
+		if added, _ := result.RowsAffected(); added > 0 {
+			subscription := api.CollectionSubscription{CollectionName: name, BookTitle: book.Title}
+			err = recordAudit(q, r, "add-book", "collection", name, nil, subscription)
+			if err != nil {
+				return err
+			}
+			report.AddedToCollections++
+		}
+	}
+	return nil
+}
+
+// recordReading records the rating and read date of an imported book. A missing rating or read date
+// keeps the one already recorded, so that importing another log does not clear it.
+func recordReading(q querier, r *http.Request, book api.ImportBook, report *api.ImportReport) error {
+	if book.Rating == 0 && book.ReadDate.IsZero() {
+		return nil
+	}
+
+	var before *api.Reading
+	var rating sql.NullFloat64
+	var readDate sql.NullTime
+	var precision sql.NullString
+	err := q.QueryRow(`SELECT rating, read_date, read_date_precision FROM book_readings WHERE book_title = $1 FOR UPDATE`,
+		book.Title).Scan(&rating, &readDate, &precision)
+	if err == nil {
+		before = &api.Reading{
+			BookTitle: book.Title,
+			Rating:    rating.Float64,
+			ReadDate:  api.DateFromTime(readDate.Time, api.DatePrecision(precision.String)),
+		}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	after := api.Reading{BookTitle: book.Title, Rating: book.Rating, ReadDate: book.ReadDate}
+	if before != nil {
+		if after.Rating == 0 {
+			after.Rating = before.Rating
+		}
+		if after.ReadDate.IsZero() {
+			after.ReadDate = before.ReadDate
+		}
+		if after == *before {
+			return nil
+		}
+	}
+
+	_, err = q.Exec(`INSERT INTO book_readings (book_title, rating, read_date, read_date_precision) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (book_title) DO UPDATE SET rating = EXCLUDED.rating, read_date = EXCLUDED.read_date,
+		read_date_precision = EXCLUDED.read_date_precision`,
+		book.Title, sql.NullFloat64{Float64: after.Rating, Valid: after.Rating != 0}, after.ReadDate, datePrecision(after.ReadDate))
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		err = recordAudit(q, r, "import", "reading", book.Title, nil, after)
+	} else {
+		err = recordAudit(q, r, "import", "reading", book.Title, *before, after)
+	}
+	if err != nil {
+		return err
+	}
+	report.ReadingsRecorded++
+	return nil
+}
+
+// identifiersError returns why identifiers are invalid, or "" if they are valid
+func identifiersError(identifiers map[string]string) string {
+	for kind, value := range identifiers {
+		if kind == "" || len(kind) > 50 {
+			return "Identifier type must be 1 to 50 characters"
+		}
+		if value == "" || len(value) > 255 {
+			return fmt.Sprintf("Identifier %s must be 1 to 255 characters", kind)
+		}
+	}
+	return ""
+}
+
+// recordIdentifiers records the identifiers of an imported book, replacing the value of a type that
+// changed. Identifiers missing from the import are kept.
+func recordIdentifiers(q querier, r *http.Request, book api.ImportBook, report *api.ImportReport) error {
+	kinds := make([]string, 0, len(book.Identifiers))
+	for kind := range book.Identifiers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		after := api.Identifier{BookTitle: book.Title, Type: kind, Value: book.Identifiers[kind]}
+		var value string
+		err := q.QueryRow(`SELECT value FROM book_identifiers WHERE book_title = $1 AND type = $2 FOR UPDATE`,
+			book.Title, kind).Scan(&value)
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = q.Exec(`INSERT INTO book_identifiers (book_title, type, value) VALUES ($1, $2, $3)`, book.Title, kind, after.Value)
+			if err == nil {
+				err = recordAudit(q, r, "import", "identifier", book.Title, nil, after)
+			}
+		case err != nil:
+		case value == after.Value:
+			continue
+		default:
+			_, err = q.Exec(`UPDATE book_identifiers SET value = $1 WHERE book_title = $2 AND type = $3`, after.Value, book.Title, kind)
+			if err == nil {
+				before := api.Identifier{BookTitle: book.Title, Type: kind, Value: value}
+				err = recordAudit(q, r, "import", "identifier", book.Title, before, after)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		report.IdentifiersRecorded++
+	}
+	return nil
+}
+
+// mergeBook returns the book with the non empty fields of change applied
+func mergeBook(book api.Book, change api.Book) api.Book {
+	if change.Author != "" {
+		book.Author = change.Author
+	}
+	if !change.PublishDate.IsZero() {
+		book.PublishDate = change.PublishDate
+	}
+	if change.Edition != "" {
+		book.Edition = change.Edition
+	}
+	if change.Publisher != "" {
+		book.Publisher = change.Publisher
+	}
+	if change.Description != "" {
+		book.Description = change.Description
+	}
+	if change.Genre != "" {
+		book.Genre = change.Genre
+	}
+	return book
+}
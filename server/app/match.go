@@ -0,0 +1,92 @@
+package app
+
+import (
+	"bms/shared/api"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Match modes of the text filters, selected with the match URL parameter
+const (
+	matchExact    = "exact"
+	matchIcase    = "icase"
+	matchPrefix   = "prefix"
+	matchContains = "contains"
+	matchTrigram  = "trigram"
+)
+
+// maxSuggestions is the number of similar titles returned when a book is not found
+const maxSuggestions = 5
+
+// likeEscaper escapes the LIKE wildcards so that a filter value only matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// parseMatchMode reads the match URL parameter, exact by default
+func parseMatchMode(r *http.Request) (string, error) {
+	mode := r.URL.Query().Get("match")
+	switch mode {
+	case "":
+		return matchExact, nil
+	case matchExact, matchIcase, matchPrefix, matchContains, matchTrigram:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid match %q, must be exact, icase, prefix, contains or trigram", mode)
+}
+
+// genMatchCondition adds the condition matching a text column to a filter value in the given mode.
+// Prefix and contains matches ignore case, trigram matches any similar word in the column.
+func genMatchCondition(conditions *[]string, values *[]any, mode string, field string, value string, counter *int) {
+	switch mode {
+	case matchIcase:
+		*conditions = append(*conditions, fmt.Sprintf("lower(%s) = lower($%d)", field, *counter))
+		*values = append(*values, value)
+		*counter++
+	case matchPrefix:
+		genSQLConditions(conditions, values, "ILIKE", field, likeEscaper.Replace(value)+"%", counter)
+	case matchContains:
+		genSQLConditions(conditions, values, "ILIKE", field, "%"+likeEscaper.Replace(value)+"%", counter)
+	case matchTrigram:
+		*conditions = append(*conditions, fmt.Sprintf("$%d <%% %s", *counter, field))
+		*values = append(*values, value)
+		*counter++
+	default:
+		genSQLConditions(conditions, values, "=", field, value, counter)
+	}
+}
+
+// suggestTitles returns the existing titles most similar to a title that was not found
+func suggestTitles(q querier, title string) ([]string, error) {
+	rows, err := q.Query(`SELECT title FROM books
+		WHERE title % $1 OR strpos(lower(title), lower($1)) > 0
+		ORDER BY similarity(title, $1) DESC, title
+		LIMIT $2`, title, maxSuggestions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := make([]string, 0)
+	for rows.Next() {
+		var suggestion string
+		err := rows.Scan(&suggestion)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
+
+// respondBookNotFound writes a 404 for a missing book with the most similar titles as suggestions
+func respondBookNotFound(w http.ResponseWriter, q querier, title string) {
+	// the suggestions are a convenience, failing to find them does not change the response
+	suggestions, err := suggestTitles(q, title)
+	if err != nil {
+		log.Printf("Error suggesting titles: %v", err)
+		suggestions = []string{}
+	}
+
+	respondErrorCode(w, api.NotFoundData{Suggestions: suggestions}, http.StatusNotFound, api.CodeBookNotFound, "Book not found")
+}
@@ -0,0 +1,477 @@
+package app
+
+import (
+	"bms/shared/api"
+	"bms/shared/dublincore"
+	"bms/shared/oai"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// oaiPageSize is the number of records or headers in a page of a list, the rest follow with a resumption token
+const oaiPageSize = 100
+
+// oaiRecordsSQL selects every record of the repository with its datestamp. A book's datestamp is the
+// last time it, its identifiers or its collections changed, so that harvesting from a date picks up
+// books moved between sets. Books removed since are deleted records, dated by their removal, for as
+// long as the audit log is kept. Membership changes are found by book with audit_log_book_title_idx,
+// whose condition the query repeats.
+const oaiRecordsSQL = `(SELECT books.title, false AS deleted, GREATEST(` + bookUpdatedSQL + `,
+		(SELECT MAX(created_at) FROM audit_log WHERE entity = 'identifier' AND entity_id = books.title),
+		(SELECT MAX(created_at) FROM audit_log WHERE entity = 'collection' AND COALESCE(after, before)->>'book_title' = books.title
+			AND action IN ('add-book', 'remove-book'))
+	) AS datestamp FROM books
+	UNION ALL
+	SELECT entity_id, true, MAX(created_at) FROM audit_log
+		WHERE entity = 'book' AND action = 'remove' AND entity_id NOT IN (SELECT title FROM books)
+		GROUP BY entity_id) AS records`
+
+// oaiSetSQL is the condition of the records of a set: the books in the collection, and the removed
+// books that were in it when they were removed, as removing a book records taking it out of its
+// collections at the time of its removal
+const oaiSetSQL = `CASE WHEN deleted THEN EXISTS (SELECT 1 FROM audit_log WHERE entity = 'collection'
+		AND COALESCE(after, before)->>'book_title' = title AND created_at = datestamp
+		AND action = 'remove-book' AND entity_id = $%[1]d)
+	ELSE title IN (SELECT book_title FROM collection_subscriptions WHERE collection_name = $%[1]d) END`
+
+// oaiArguments are the arguments each verb accepts, true for the required ones. A resumption token is
+// exclusive, it replaces the other arguments of a list.
+var oaiArguments = map[string]map[string]bool{
+	oai.VerbIdentify:            {},
+	oai.VerbListMetadataFormats: {"identifier": false},
+	oai.VerbListSets:            {"resumptionToken": false},
+	oai.VerbGetRecord:           {"identifier": true, "metadataPrefix": true},
+	oai.VerbListIdentifiers:     {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	oai.VerbListRecords:         {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+}
+
+// oaiError is an error condition of an OAI-PMH request, returned in the response rather than as an HTTP error
+type oaiError struct {
+	code    string
+	message string
+}
+
+// harvest is the selection of a list request, carried from page to page in the resumption token
+type harvest struct {
+	prefix string
+	set    string
+	from   string
+	until  string
+	// cursor is the number of records returned before the page
+	cursor int
+	after  []string
+}
+
+// oaiPMH serves the OAI-PMH verbs. Arguments are URL parameters of a GET request, or the form of a POST
+// request. Errors of the protocol are part of the response and have a 200 status.
+func (h *Handler) oaiPMH(w http.ResponseWriter, r *http.Request) {
+	response := oai.Response{
+		ResponseDate: oai.Datestamp(time.Now()),
+		Request:      oai.Request{BaseURL: oaiBaseURL(r)},
+	}
+	if err := r.ParseForm(); err != nil {
+		response.Errors = []oai.Error{{Code: oai.ErrBadArgument, Message: err.Error()}}
+		respondOAI(w, response)
+		return
+	}
+
+	args, oaiErr := parseOAIArguments(r.Form)
+	if oaiErr != nil {
+		response.Errors = []oai.Error{{Code: oaiErr.code, Message: oaiErr.message}}
+		respondOAI(w, response)
+		return
+	}
+	response.Request = oai.Request{
+		Verb:            args["verb"],
+		Identifier:      args["identifier"],
+		MetadataPrefix:  args["metadataPrefix"],
+		From:            args["from"],
+		Until:           args["until"],
+		Set:             args["set"],
+		ResumptionToken: args["resumptionToken"],
+		BaseURL:         response.Request.BaseURL,
+	}
+
+	var records []oai.Record
+	var token *oai.ResumptionToken
+	var err error
+	switch args["verb"] {
+	case oai.VerbIdentify:
+		response.Identify, err = h.oaiIdentify(r)
+	case oai.VerbListMetadataFormats:
+		response.ListMetadataFormats, oaiErr, err = h.oaiListMetadataFormats(args["identifier"])
+	case oai.VerbListSets:
+		response.ListSets, oaiErr, err = h.oaiListSets(args["resumptionToken"])
+	case oai.VerbGetRecord:
+		response.GetRecord, oaiErr, err = h.oaiGetRecord(args["identifier"], args["metadataPrefix"])
+	case oai.VerbListIdentifiers, oai.VerbListRecords:
+		records, token, oaiErr, err = h.oaiList(args, args["verb"] == oai.VerbListRecords)
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error serving OAI-PMH request")
+		return
+	}
+
+	if oaiErr != nil {
+		response.Errors = []oai.Error{{Code: oaiErr.code, Message: oaiErr.message}}
+	} else if args["verb"] == oai.VerbListRecords {
+		response.ListRecords = &oai.ListRecords{Records: records, ResumptionToken: token}
+	} else if args["verb"] == oai.VerbListIdentifiers {
+		headers := make([]oai.Header, len(records))
+		for i, record := range records {
+			headers[i] = record.Header
+		}
+		response.ListIdentifiers = &oai.ListIdentifiers{Headers: headers, ResumptionToken: token}
+	}
+	respondOAI(w, response)
+}
+
+// parseOAIArguments checks the verb and its arguments. Unknown, repeated and missing arguments are a
+// badArgument error, except with a resumption token, which must be the only argument.
+func parseOAIArguments(form url.Values) (map[string]string, *oaiError) {
+	verbs := form["verb"]
+	if len(verbs) != 1 {
+		return nil, &oaiError{oai.ErrBadVerb, "verb must be given once"}
+	}
+	allowed, ok := oaiArguments[verbs[0]]
+	if !ok {
+		return nil, &oaiError{oai.ErrBadVerb, fmt.Sprintf("illegal verb %q", verbs[0])}
+	}
+
+	args := map[string]string{"verb": verbs[0]}
+	for name, values := range form {
+		if name == "verb" {
+			continue
+		}
+		if _, ok := allowed[name]; !ok {
+			return nil, &oaiError{oai.ErrBadArgument, fmt.Sprintf("illegal argument %q for %s", name, verbs[0])}
+		}
+		if len(values) != 1 {
+			return nil, &oaiError{oai.ErrBadArgument, fmt.Sprintf("argument %q is repeated", name)}
+		}
+		args[name] = values[0]
+	}
+
+	if _, ok := args["resumptionToken"]; ok {
+		if len(args) > 2 {
+			return nil, &oaiError{oai.ErrBadArgument, "resumptionToken is an exclusive argument"}
+		}
+		return args, nil
+	}
+	for name, required := range allowed {
+		if _, ok := args[name]; required && !ok {
+			return nil, &oaiError{oai.ErrBadArgument, fmt.Sprintf("missing argument %q for %s", name, verbs[0])}
+		}
+	}
+	return args, nil
+}
+
+// oaiIdentify describes the repository, its earliest datestamp is that of the oldest record
+func (h *Handler) oaiIdentify(r *http.Request) (*oai.Identify, error) {
+	var earliest sql.NullTime
+	err := h.db.QueryRow("SELECT MIN(datestamp) FROM " + oaiRecordsSQL).Scan(&earliest)
+	if err != nil {
+		return nil, err
+	}
+	if !earliest.Valid {
+		earliest.Time = time.Now()
+	}
+
+	return &oai.Identify{
+		RepositoryName:    h.config.RepositoryName,
+		BaseURL:           oaiBaseURL(r),
+		ProtocolVersion:   "2.0",
+		AdminEmails:       []string{h.config.AdminEmail},
+		EarliestDatestamp: oai.Datestamp(earliest.Time),
+		// removals are only known while the audit log keeps them
+		DeletedRecord: "transient",
+		Granularity:   oai.Granularity,
+	}, nil
+}
+
+// oaiListMetadataFormats lists oai_dc, the only format, for the repository or for an existing record
+func (h *Handler) oaiListMetadataFormats(identifier string) (*oai.ListMetadataFormats, *oaiError, error) {
+	if identifier != "" {
+		records, _, err := h.oaiRecords(oaiIdentifierCondition(h.config.RepositoryIdentifier, identifier), page{limit: 1}, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(records) == 0 {
+			return nil, &oaiError{oai.ErrIDDoesNotExist, fmt.Sprintf("no record with identifier %q", identifier)}, nil
+		}
+	}
+
+	format := oai.MetadataFormat{Prefix: oai.DCPrefix, Schema: oai.DCSchema, Namespace: oai.DCNamespace}
+	return &oai.ListMetadataFormats{Formats: []oai.MetadataFormat{format}}, nil, nil
+}
+
+// oaiListSets lists a set for each collection. Sets are not paged, so every resumption token is bad.
+func (h *Handler) oaiListSets(token string) (*oai.ListSets, *oaiError, error) {
+	if token != "" {
+		return nil, &oaiError{oai.ErrBadResumptionToken, "sets are listed in a single response"}, nil
+	}
+
+	rows, err := h.db.Query("SELECT name FROM collections ORDER BY name")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	sets := make([]oai.Set, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, nil, err
+		}
+		sets = append(sets, oai.Set{Spec: oai.SetSpec(name), Name: name})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(sets) == 0 {
+		return nil, &oaiError{oai.ErrNoSetHierarchy, "there are no collections"}, nil
+	}
+	return &oai.ListSets{Sets: sets}, nil, nil
+}
+
+// oaiGetRecord returns the record of a book, or the header of a removed book
+func (h *Handler) oaiGetRecord(identifier string, prefix string) (*oai.GetRecord, *oaiError, error) {
+	records, _, err := h.oaiRecords(oaiIdentifierCondition(h.config.RepositoryIdentifier, identifier), page{limit: 1}, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, &oaiError{oai.ErrIDDoesNotExist, fmt.Sprintf("no record with identifier %q", identifier)}, nil
+	}
+	if prefix != oai.DCPrefix {
+		return nil, &oaiError{oai.ErrCannotDisseminateFormat, fmt.Sprintf("metadata format %q is not supported, use oai_dc", prefix)}, nil
+	}
+	return &oai.GetRecord{Record: records[0]}, nil, nil
+}
+
+// oaiList returns a page of the records selected by the metadata prefix, the datestamp range and the set
+// of a ListIdentifiers or ListRecords request, or by its resumption token. The token of the next page
+// holds the selection and the title of the last record, so a harvest continues where the page ended.
+func (h *Handler) oaiList(args map[string]string, withMetadata bool) ([]oai.Record, *oai.ResumptionToken, *oaiError, error) {
+	selection := harvest{prefix: args["metadataPrefix"], set: args["set"], from: args["from"], until: args["until"]}
+	if token := args["resumptionToken"]; token != "" {
+		values, err := decodeCursor(token)
+		if err != nil || len(values) != 6 {
+			return nil, nil, &oaiError{oai.ErrBadResumptionToken, "invalid resumption token"}, nil
+		}
+		cursor, err := strconv.Atoi(values[4])
+		if err != nil {
+			return nil, nil, &oaiError{oai.ErrBadResumptionToken, "invalid resumption token"}, nil
+		}
+		selection = harvest{prefix: values[0], set: values[1], from: values[2], until: values[3], cursor: cursor, after: values[5:]}
+	}
+	if selection.prefix != oai.DCPrefix {
+		return nil, nil, &oaiError{oai.ErrCannotDisseminateFormat, fmt.Sprintf("metadata format %q is not supported, use oai_dc", selection.prefix)}, nil
+	}
+
+	var conditions []string
+	var values []any
+	var fromDay, untilDay bool
+	if selection.from != "" {
+		from, day, err := oai.ParseDatestamp(selection.from, false)
+		if err != nil {
+			return nil, nil, &oaiError{oai.ErrBadArgument, err.Error()}, nil
+		}
+		fromDay = day
+		values = append(values, from)
+		conditions = append(conditions, fmt.Sprintf("datestamp >= $%d", len(values)))
+	}
+	if selection.until != "" {
+		until, day, err := oai.ParseDatestamp(selection.until, true)
+		if err != nil {
+			return nil, nil, &oaiError{oai.ErrBadArgument, err.Error()}, nil
+		}
+		untilDay = day
+		values = append(values, until)
+		conditions = append(conditions, fmt.Sprintf("date_trunc('second', datestamp) <= $%d", len(values)))
+	}
+	if selection.from != "" && selection.until != "" && fromDay != untilDay {
+		return nil, nil, &oaiError{oai.ErrBadArgument, "from and until must have the same granularity"}, nil
+	}
+	if selection.set != "" {
+		collection, err := oai.Collection(selection.set)
+		if err != nil {
+			return nil, nil, &oaiError{oai.ErrBadArgument, err.Error()}, nil
+		}
+		values = append(values, collection)
+		conditions = append(conditions, fmt.Sprintf(oaiSetSQL, len(values)))
+	}
+
+	total, err := countRows(h.db, oaiRecordsSQL, conditions, values)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	p := page{limit: oaiPageSize, after: selection.after}
+	records, more, err := h.oaiRecords(recordSelection{conditions, values}, p, withMetadata)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, &oaiError{oai.ErrNoRecordsMatch, "no records match the arguments"}, nil
+	}
+
+	// a complete list in a single response has no token, the last page of an incomplete list an empty one
+	var token *oai.ResumptionToken
+	if more || selection.cursor > 0 {
+		token = &oai.ResumptionToken{CompleteListSize: total, Cursor: selection.cursor}
+	}
+	if more {
+		last, _ := oai.Title(h.config.RepositoryIdentifier, records[len(records)-1].Header.Identifier)
+		token.Token = encodeCursor([]string{selection.prefix, selection.set, selection.from, selection.until,
+			strconv.Itoa(selection.cursor + len(records)), last})
+	}
+	return records, token, nil, nil
+}
+
+// recordSelection holds the conditions selecting records from oaiRecordsSQL and their values
+type recordSelection struct {
+	conditions []string
+	values     []any
+}
+
+// oaiIdentifierCondition selects the record with an identifier, or none for an identifier of another repository
+func oaiIdentifierCondition(repository string, identifier string) recordSelection {
+	title, ok := oai.Title(repository, identifier)
+	if !ok {
+		return recordSelection{conditions: []string{"false"}}
+	}
+	return recordSelection{conditions: []string{"title = $1"}, values: []any{title}}
+}
+
+// oaiRecords returns a page of the selected records ordered by title, and whether there are more. The
+// Dublin Core metadata of books is only read when withMetadata is set, deleted records have a header only.
+func (h *Handler) oaiRecords(selection recordSelection, p page, withMetadata bool) ([]oai.Record, bool, error) {
+	keys := []sortKey{{expr: "title"}}
+	selectSQL := "SELECT title, deleted, datestamp, ARRAY(SELECT collection_name FROM collection_subscriptions WHERE book_title = records.title ORDER BY collection_name)"
+	query, values := pageQuery(selectSQL, oaiRecordsSQL, selection.conditions, selection.values, keys, p)
+	rows, err := h.db.Query(query, values...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	records := make([]oai.Record, 0)
+	var titles []string
+	for rows.Next() {
+		var title, key string
+		var deleted bool
+		var datestamp time.Time
+		var collections []string
+		err := rows.Scan(&title, &deleted, &datestamp, pq.Array(&collections), &key)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(records) == p.limit {
+			return records, true, h.oaiMetadata(records, titles, withMetadata)
+		}
+
+		header := oai.Header{Identifier: oai.Identifier(h.config.RepositoryIdentifier, title), Datestamp: oai.Datestamp(datestamp)}
+		if deleted {
+			header.Status = "deleted"
+		} else {
+			titles = append(titles, title)
+		}
+		for _, collection := range collections {
+			header.SetSpecs = append(header.SetSpecs, oai.SetSpec(collection))
+		}
+		records = append(records, oai.Record{Header: header})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return records, false, h.oaiMetadata(records, titles, withMetadata)
+}
+
+// oaiMetadata reads the books of a page of records with their ISBNs and sets their Dublin Core metadata.
+// A book removed since its header was read becomes a deleted record.
+func (h *Handler) oaiMetadata(records []oai.Record, titles []string, withMetadata bool) error {
+	if !withMetadata || len(titles) == 0 {
+		return nil
+	}
+
+	books := map[string]api.Book{}
+	rows, err := h.db.Query("SELECT "+bookColumns+" FROM books WHERE title = ANY($1)", pq.Array(titles))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return err
+		}
+		books[book.Title] = book
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	isbns, err := bookISBNs(h.db, titles)
+	if err != nil {
+		return err
+	}
+
+	for i, record := range records {
+		if record.Header.Status == "deleted" {
+			continue
+		}
+		title, _ := oai.Title(h.config.RepositoryIdentifier, record.Header.Identifier)
+		book, ok := books[title]
+		if !ok {
+			records[i].Header.Status = "deleted"
+			records[i].Header.SetSpecs = nil
+			continue
+		}
+		records[i].Metadata = oai.NewMetadata(dublincore.FromBook(book, map[string]string{"isbn": isbns[title]}))
+	}
+	return nil
+}
+
+// bookISBNs returns the ISBNs of the books with the titles, by title
+func bookISBNs(q querier, titles []string) (map[string]string, error) {
+	rows, err := q.Query("SELECT book_title, value FROM book_identifiers WHERE type = 'isbn' AND book_title = ANY($1)", pq.Array(titles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	isbns := map[string]string{}
+	for rows.Next() {
+		var title, isbn string
+		if err := rows.Scan(&title, &isbn); err != nil {
+			return nil, err
+		}
+		isbns[title] = isbn
+	}
+	return isbns, rows.Err()
+}
+
+// oaiBaseURL returns the URL the repository is harvested from, on the host it was requested from
+func oaiBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/oai", scheme, r.Host)
+}
+
+// respondOAI writes an OAI-PMH response
+func respondOAI(w http.ResponseWriter, response oai.Response) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := oai.Write(w, response); err != nil {
+		log.Printf("Error writing OAI-PMH response: %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+package app
+
+import (
+	"bms/shared/api"
+	"bms/shared/opds"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// bookUpdatedSQL is the time a book was last changed, its latest revision or failing that its creation.
+// It is the datestamp of OAI-PMH records, so it only changes when the book does.
+const bookUpdatedSQL = `COALESCE((SELECT MAX(created_at) FROM book_revisions WHERE book_revisions.title = books.title), books.created_at)`
+
+// bookCreatedSortKey orders new additions by creation time, books without one sort last
+var bookCreatedSortKey = sortKey{expr: "COALESCE(books.created_at, '-infinity'::timestamptz)", desc: true}
+
+// opdsRoot serves the root navigation feed of the OPDS catalog, linking to all books, the new additions,
+// the collections and the search
+func (h *Handler) opdsRoot(w http.ResponseWriter, r *http.Request) {
+	now := opds.Timestamp(time.Now())
+	feed := opds.Feed{
+		ID:      "urn:bms:opds",
+		Title:   "Book catalog",
+		Updated: now,
+		Links:   opdsLinks(r, opds.NavigationType),
+		Entries: []opds.Entry{
+			opdsNavigationEntry("urn:bms:opds:books", "All books", now, "Every book ordered by title",
+				opds.Link{Rel: opds.RelSubsection, Href: "/opds/books", Type: opds.AcquisitionType}),
+			opdsNavigationEntry("urn:bms:opds:new", "New additions", now, "The books added most recently",
+				opds.Link{Rel: opds.RelNew, Href: "/opds/new", Type: opds.AcquisitionType}),
+			opdsNavigationEntry("urn:bms:opds:collections", "Collections", now, "Books grouped by collection",
+				opds.Link{Rel: opds.RelSubsection, Href: "/opds/collections", Type: opds.NavigationType}),
+		},
+	}
+	respondFeed(w, feed)
+}
+
+// opdsBooks serves an acquisition feed of the books, filtered, sorted and paged like /book/list
+func (h *Handler) opdsBooks(w http.ResponseWriter, r *http.Request) {
+	conditions, values, err := parseBookFilters(r, "q")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	keys, err := parseSort(r, bookSortFields, "title", "title")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	feed := opds.Feed{ID: "urn:bms:opds:books", Title: "All books"}
+	h.respondBookFeed(w, r, feed, "books", conditions, values, keys)
+}
+
+// opdsNew serves an acquisition feed of the books, the most recently added first
+func (h *Handler) opdsNew(w http.ResponseWriter, r *http.Request) {
+	keys := []sortKey{bookCreatedSortKey, {expr: "books.title"}}
+	feed := opds.Feed{ID: "urn:bms:opds:new", Title: "New additions"}
+	h.respondBookFeed(w, r, feed, "books", nil, nil, keys)
+}
+
+// opdsSearch serves an acquisition feed of the books matching the full-text query q, ranked like /book/search
+func (h *Handler) opdsSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		respondError(w, nil, http.StatusBadRequest, "q cannot be empty")
+		return
+	}
+
+	values := []any{q}
+	from := "books, websearch_to_tsquery('english', $1) AS query"
+	conditions := []string{"search_vector @@ query"}
+	keys := []sortKey{{expr: "ts_rank(search_vector, query)", desc: true}, {expr: "title"}}
+
+	feed := opds.Feed{ID: "urn:bms:opds:search:" + url.PathEscape(q), Title: fmt.Sprintf("Search results for %q", q)}
+	h.respondBookFeed(w, r, feed, from, conditions, values, keys)
+}
+
+// opdsSearchDescription serves the OpenSearch description of the catalog search. Clients fill the
+// template in, so it is an absolute URL on the host the description was requested from.
+func (h *Handler) opdsSearchDescription(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	description := opds.OpenSearchDescription{
+		ShortName:   "Books",
+		Description: "Search the books by title, author and description",
+		Template:    fmt.Sprintf("%s://%s/opds/search?q={searchTerms}", scheme, r.Host),
+	}
+
+	w.Header().Set("Content-Type", opds.OpenSearchType)
+	w.WriteHeader(http.StatusOK)
+	if err := opds.WriteOpenSearch(w, description); err != nil {
+		log.Printf("Error writing OpenSearch description: %v", err)
+	}
+}
+
+// opdsCollections serves a navigation feed with an entry for each collection, paged like /collection/list
+func (h *Handler) opdsCollections(w http.ResponseWriter, r *http.Request) {
+	keys, err := parseSort(r, collectionSortFields, "name", "name")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parsePage(r, keys)
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	total, err := countRows(h.db, "collections", nil, nil)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting collections")
+		return
+	}
+
+	selectSQL := "SELECT name, (SELECT COUNT(*) FROM collection_subscriptions WHERE collection_name = collections.name)"
+	query, values := pageQuery(selectSQL, "collections", nil, nil, keys, p)
+	rows, err := h.db.Query(query, values...)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting collections")
+		return
+	}
+	defer rows.Close()
+
+	now := opds.Timestamp(time.Now())
+	feed := opds.Feed{ID: "urn:bms:opds:collections", Title: "Collections", Updated: now, TotalResults: total, ItemsPerPage: p.limit}
+	rowCount := 0
+	var lastKeys []sql.NullString
+	for rows.Next() {
+		var name string
+		var count int
+		keyValues, keyDests := sortKeyDests(keys)
+		err := rows.Scan(append([]any{&name, &count}, keyDests...)...)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting collections")
+			return
+		}
+		rowCount++
+		if rowCount > p.limit {
+			continue
+		}
+		lastKeys = keyValues
+		href := "/opds/collection?" + url.Values{"collection_name": {name}}.Encode()
+		summary := fmt.Sprintf("%d books", count)
+		if count == 1 {
+			summary = "1 book"
+		}
+		feed.Entries = append(feed.Entries, opdsNavigationEntry("urn:bms:opds:collection:"+url.PathEscape(name), name, now, summary,
+			opds.Link{Rel: opds.RelSubsection, Href: href, Type: opds.AcquisitionType}))
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting collections")
+		return
+	}
+
+	feed.Links = append(opdsLinks(r, opds.NavigationType), opds.Link{Rel: opds.RelUp, Href: "/opds", Type: opds.NavigationType})
+	if cursor := nextCursor(rowCount, p, lastKeys); cursor != "" {
+		feed.Links = append(feed.Links, opds.Link{Rel: opds.RelNext, Href: opdsPageHref(r, cursor), Type: opds.NavigationType})
+	}
+	respondFeed(w, feed)
+}
+
+// opdsCollection serves an acquisition feed of the books in a collection, ordered and paged like
+// /collection/list/books
+func (h *Handler) opdsCollection(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.URL.Query().Get("collection_name")
+	if collectionName == "" {
+		respondError(w, nil, http.StatusBadRequest, "collection_name cannot be empty")
+		return
+	}
+	keys, err := parseSort(r, collectionBookSortFields, "title", "book_title")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRow("SELECT EXISTS (SELECT 1 FROM collections WHERE name = $1)", collectionName).Scan(&exists)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting books in collection")
+		return
+	}
+	if !exists {
+		respondErrorCode(w, nil, http.StatusNotFound, api.CodeCollectionNotFound, "Collection not found")
+		return
+	}
+
+	from := "collection_subscriptions JOIN books ON books.title = book_title"
+	feed := opds.Feed{ID: "urn:bms:opds:collection:" + url.PathEscape(collectionName), Title: collectionName}
+	h.respondBookFeed(w, r, feed, from, []string{"collection_name = $1"}, []any{collectionName}, keys)
+}
+
+// respondBookFeed writes an acquisition feed of a page of the books selected from the filtered table,
+// with a next link while there are more pages. Each entry links to the book's JSON representation, and
+// to its cover and attached files.
+func (h *Handler) respondBookFeed(w http.ResponseWriter, r *http.Request, feed opds.Feed, from string, conditions []string, values []any, keys []sortKey) {
+	p, err := parsePage(r, keys)
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	total, err := countRows(h.db, from, conditions, values)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting books")
+		return
+	}
+
+	columns := make([]string, 0)
+	for _, column := range strings.Split(bookColumns, ", ") {
+		columns = append(columns, "books."+column)
+	}
+	selectSQL := "SELECT " + strings.Join(columns, ", ") + ", " + bookUpdatedSQL
+	query, values := pageQuery(selectSQL, from, conditions, values, keys, p)
+	rows, err := h.db.Query(query, values...)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting books")
+		return
+	}
+	defer rows.Close()
+
+	var updated time.Time
+	var books []api.Book
+	var booksUpdated []time.Time
+	rowCount := 0
+	var lastKeys []sql.NullString
+	for rows.Next() {
+		var bookUpdated time.Time
+		keyValues, keyDests := sortKeyDests(keys)
+		book, err := scanBook(rows, append([]any{&bookUpdated}, keyDests...)...)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting books")
+			return
+		}
+		rowCount++
+		if rowCount > p.limit {
+			continue
+		}
+		lastKeys = keyValues
+		if bookUpdated.After(updated) {
+			updated = bookUpdated
+		}
+		books = append(books, book)
+		booksUpdated = append(booksUpdated, bookUpdated)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting books")
+		return
+	}
+	rows.Close()
+
+	titles := make([]string, len(books))
+	for i, book := range books {
+		titles[i] = book.Title
+	}
+	links, err := opdsBookLinks(h.db, titles)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting books")
+		return
+	}
+	for i, book := range books {
+		href := "/book/get?" + url.Values{"title": {book.Title}}.Encode()
+		entryLinks := append([]opds.Link{{Rel: opds.RelAlternate, Href: href, Type: "application/json"}}, links[book.Title]...)
+		feed.Entries = append(feed.Entries, opds.BookEntry(book, booksUpdated[i], entryLinks...))
+	}
+
+	// an empty feed was last updated when it was generated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = opds.Timestamp(updated)
+	feed.TotalResults = total
+	feed.ItemsPerPage = p.limit
+	feed.Links = append(opdsLinks(r, opds.AcquisitionType), opds.Link{Rel: opds.RelUp, Href: "/opds", Type: opds.NavigationType})
+	if cursor := nextCursor(rowCount, p, lastKeys); cursor != "" {
+		feed.Links = append(feed.Links, opds.Link{Rel: opds.RelNext, Href: opdsPageHref(r, cursor), Type: opds.AcquisitionType})
+	}
+	respondFeed(w, feed)
+}
+
+// opdsBookLinks returns the image links of the covers and the acquisition links of the attached files
+// of the books with the titles, by title. The thumbnail is the medium size, which catalog apps list.
+func opdsBookLinks(q querier, titles []string) (map[string][]opds.Link, error) {
+	rows, err := q.Query(`SELECT book_title, 'cover', content_type, '', hash, size FROM book_covers WHERE book_title = ANY($1)
+		UNION ALL
+		SELECT book_title, 'file', content_type, name, hash, size FROM book_files WHERE book_title = ANY($1)
+		ORDER BY 1, 2, 4, 5`, pq.Array(titles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := map[string][]opds.Link{}
+	for rows.Next() {
+		var title, kind, contentType, name, hash string
+		var size int64
+		if err := rows.Scan(&title, &kind, &contentType, &name, &hash, &size); err != nil {
+			return nil, err
+		}
+		href := "/book/" + url.PathEscape(title)
+		if kind == "cover" {
+			links[title] = append(links[title],
+				opds.Link{Rel: opds.RelImage, Href: href + "/cover", Type: contentType},
+				opds.Link{Rel: opds.RelThumbnail, Href: href + "/cover?size=medium", Type: "image/jpeg"})
+			continue
+		}
+		links[title] = append(links[title],
+			opds.Link{Rel: opds.RelAcquisition, Href: href + "/files/" + hash, Type: contentType, Title: name, Length: size})
+	}
+	return links, rows.Err()
+}
+
+// opdsNavigationEntry returns an entry of a navigation feed linking to another feed
+func opdsNavigationEntry(id string, title string, updated string, summary string, link opds.Link) opds.Entry {
+	return opds.Entry{
+		Title:   title,
+		ID:      id,
+		Updated: updated,
+		Content: &opds.Content{Type: "text", Text: summary},
+		Links:   []opds.Link{link},
+	}
+}
+
+// opdsLinks returns the links every feed has, to itself, to the root feed and to the search description
+func opdsLinks(r *http.Request, feedType string) []opds.Link {
+	return []opds.Link{
+		{Rel: opds.RelSelf, Href: r.URL.RequestURI(), Type: feedType},
+		{Rel: opds.RelStart, Href: "/opds", Type: opds.NavigationType},
+		{Rel: opds.RelSearch, Href: "/opds/opensearch.xml", Type: opds.OpenSearchType},
+	}
+}
+
+// opdsPageHref returns the request URL continuing at the cursor, keeping the other parameters
+func opdsPageHref(r *http.Request, cursor string) string {
+	params := r.URL.Query()
+	params.Set("cursor", cursor)
+	return r.URL.Path + "?" + params.Encode()
+}
+
+// respondFeed writes an OPDS feed, whose media type tells navigation and acquisition feeds apart
+func respondFeed(w http.ResponseWriter, feed opds.Feed) {
+	feedType := opds.NavigationType
+	for _, link := range feed.Links {
+		if link.Rel == opds.RelSelf {
+			feedType = link.Type
+		}
+	}
+	w.Header().Set("Content-Type", feedType+";charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := opds.Write(w, feed); err != nil {
+		log.Printf("Error writing feed: %v", err)
+	}
+}
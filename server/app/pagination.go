@@ -0,0 +1,180 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// sortKey is a column or expression a listing is ordered by.
+// The last sort key of a listing must be unique so that cursors are stable.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// page is the part of a listing requested with the limit and cursor parameters
+type page struct {
+	limit int
+	// after holds the sort key values of the last row of the previous page
+	after []string
+}
+
+// encodeCursor encodes the sort key values of the last row of a page as an opaque cursor
+func encodeCursor(values []string) string {
+	data, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes a cursor returned by encodeCursor
+func decodeCursor(cursor string) ([]string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var values []string
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return values, nil
+}
+
+// parsePage reads the limit and cursor URL parameters
+func parsePage(r *http.Request, keys []sortKey) (page, error) {
+	p := page{limit: defaultPageLimit}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		p.limit = limit
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return p, err
+		}
+		// a cursor from a listing with a different order cannot be continued
+		if len(after) != len(keys) {
+			return p, errors.New("cursor does not match the listing order")
+		}
+		p.after = after
+	}
+
+	return p, nil
+}
+
+// orderBy renders the ORDER BY clause for the sort keys
+func orderBy(keys []sortKey) string {
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		terms[i] = key.expr
+		if key.desc {
+			terms[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// sortKeyColumns selects the sort keys as text, to build the cursor from the last row of a page
+func sortKeyColumns(keys []sortKey) string {
+	columns := ""
+	for _, key := range keys {
+		columns += fmt.Sprintf(", (%s)::text", key.expr)
+	}
+	return columns
+}
+
+// keysetCondition returns the condition selecting the rows after the cursor of a page, or "" for the first page.
+// For keys (a, b) this is a > $1 OR (a = $1 AND b > $2), with < for descending keys.
+func keysetCondition(keys []sortKey, p page, values *[]any, counter *int) string {
+	if p.after == nil {
+		return ""
+	}
+
+	placeholders := make([]string, len(keys))
+	for i, value := range p.after {
+		placeholders[i] = fmt.Sprintf("$%d", *counter)
+		*values = append(*values, value)
+		*counter++
+	}
+
+	alternatives := make([]string, len(keys))
+	for i, key := range keys {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", keys[j].expr, placeholders[j]))
+		}
+		op := ">"
+		if key.desc {
+			op = "<"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", key.expr, op, placeholders[i]))
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// pageQuery returns the query for a page of rows selected by selectSQL from the filtered table,
+// with the sort keys appended to the selected columns. One more row than the limit is fetched
+// to find out whether there is a next page.
+func pageQuery(selectSQL string, from string, conditions []string, values []any, keys []sortKey, p page) (string, []any) {
+	counter := len(values) + 1
+	if condition := keysetCondition(keys, p, &values, &counter); condition != "" {
+		conditions = append(conditions, condition)
+	}
+
+	query := selectSQL + sortKeyColumns(keys) + " FROM " + from
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += orderBy(keys) + fmt.Sprintf(" LIMIT $%d", counter)
+	values = append(values, p.limit+1)
+	return query, values
+}
+
+// countRows returns the number of rows of the filtered table, the total across all pages
+func countRows(q querier, from string, conditions []string, values []any) (int, error) {
+	query := "SELECT COUNT(*) FROM " + from
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	var total int
+	err := q.QueryRow(query, values...).Scan(&total)
+	return total, err
+}
+
+// sortKeyDests returns scan destinations for the sort key columns of a page row
+func sortKeyDests(keys []sortKey) ([]sql.NullString, []any) {
+	keyValues := make([]sql.NullString, len(keys))
+	dests := make([]any, len(keys))
+	for i := range keyValues {
+		dests[i] = &keyValues[i]
+	}
+	return keyValues, dests
+}
+
+// nextCursor returns the cursor continuing after the last row of a page, or "" if it was the last page
+func nextCursor(rowCount int, p page, lastKeys []sql.NullString) string {
+	if rowCount <= p.limit {
+		return ""
+	}
+	values := make([]string, len(lastKeys))
+	for i, value := range lastKeys {
+		values[i] = value.String
+	}
+	return encodeCursor(values)
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"bms/shared/api"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+)
+
+// mergePatchColumns maps the book JSON keys that can be patched to their columns
+var mergePatchColumns = map[string]string{
+	"author":       "author",
+	"publish_date": "publish_date",
+	"edition":      "edition",
+	"publisher":    "publisher",
+	"description":  "description",
+	"genre":        "genre",
+}
+
+// patchBook updates a book with a JSON Merge Patch (RFC 7396) body.
+// Fields missing from the patch are not changed and fields set to null are cleared.
+func (h *Handler) patchBook(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != api.MergePatchContentType && mediaType != "application/json") {
+		respondError(w, nil, http.StatusUnsupportedMediaType, "Content-Type must be "+api.MergePatchContentType)
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// the title identifies the book and cannot itself be patched
+	var title string
+	if raw, ok := patch["title"]; ok {
+		err = json.Unmarshal(raw, &title)
+		if err != nil {
+			respondError(w, err, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		delete(patch, "title")
+	}
+	if title == "" {
+		respondError(w, nil, http.StatusBadRequest, "Title cannot be empty")
+		return
+	}
+
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	counter := 1
+	assignments := make([]string, 0)
+	values := make([]any, 0)
+	for _, field := range fields {
+		column, ok := mergePatchColumns[field]
+		if !ok {
+			respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("Field %q cannot be patched", field))
+			return
+		}
+
+		raw := patch[field]
+		if string(raw) == "null" {
+			assignments = append(assignments, column+" = NULL")
+			if column == "publish_date" {
+				assignments = append(assignments, "publish_date_precision = NULL")
+			}
+			continue
+		}
+
+		var value string
+		err = json.Unmarshal(raw, &value)
+		if err != nil {
+			respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("Field %q must be a string or null", field))
+			return
+		}
+		if column == "publish_date" {
+			publishDate, err := api.ParseDate(value)
+			if err != nil || publishDate.IsZero() {
+				respondError(w, err, http.StatusBadRequest, "Invalid publish_date")
+				return
+			}
+			value = publishDate.Start().Format(api.PublishTimeLayoutDMY)
+			genSQLConditions(&assignments, &values, "=", "publish_date_precision", string(publishDate.Precision), &counter)
+		}
+		genSQLConditions(&assignments, &values, "=", column, value, &counter)
+	}
+
+	if len(assignments) == 0 {
+		respondError(w, nil, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	h.updateBook(w, r, "patch", title, assignments, values)
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"bms/shared/api"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// recordRevision stores the after state of a book as its next numbered revision.
+// Books that existed before revisions were tracked get their before state stored as a baseline first.
+func recordRevision(q querier, r *http.Request, action string, before *api.Book, after api.Book) (int, error) {
+	var latest int
+	err := q.QueryRow(`SELECT COALESCE(MAX(revision), 0) FROM book_revisions WHERE title = $1`, after.Title).Scan(&latest)
+	if err != nil {
+		return 0, err
+	}
+
+	if latest == 0 && before != nil {
+		latest, err = insertRevision(q, r, "baseline", 1, *before)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return insertRevision(q, r, action, latest+1, after)
+}
+
+// insertRevision inserts a single book revision and returns its number
+func insertRevision(q querier, r *http.Request, action string, revision int, book api.Book) (int, error) {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = q.Exec(
+		`INSERT INTO book_revisions (title, revision, actor, action, book) VALUES ($1, $2, $3, $4, $5)`,
+		book.Title, revision, actorFromRequest(r), action, string(data))
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// getBookHistory returns every revision of a book, oldest first
+func (h *Handler) getBookHistory(w http.ResponseWriter, r *http.Request) {
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		respondError(w, nil, http.StatusBadRequest, "Title cannot be empty")
+		return
+	}
+
+	rows, err := h.db.Query(
+		`SELECT revision, actor, created_at, action, book FROM book_revisions WHERE title = $1 ORDER BY revision`, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting book history")
+		return
+	}
+	defer rows.Close()
+
+	revisions := make([]api.BookRevision, 0)
+	for rows.Next() {
+		var revision api.BookRevision
+		var data []byte
+		err := rows.Scan(&revision.Revision, &revision.Actor, &revision.Timestamp, &revision.Action, &data)
+		if err == nil {
+			err = json.Unmarshal(data, &revision.Book)
+		}
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error getting book history")
+			return
+		}
+		revisions = append(revisions, revision)
+	}
+
+	respondJSON(w, revisions, "Book history retrieved successfully", http.StatusOK)
+}
+
+// revertBook applies an earlier revision of a book as a new revision
+func (h *Handler) revertBook(w http.ResponseWriter, r *http.Request) {
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		respondError(w, nil, http.StatusBadRequest, "Title cannot be empty")
+		return
+	}
+
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil || to <= 0 {
+		respondError(w, err, http.StatusBadRequest, "to must be a positive revision number")
+		return
+	}
+
+	ifMatch, checkVersion, err := parseIfMatch(r)
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest, "Invalid request headers")
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error reverting book")
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := getBookForUpdate(tx, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error reverting book")
+		return
+	}
+	if checkVersion && !versionMatches(w, before, ifMatch) {
+		return
+	}
+	if before == nil {
+		respondBookNotFound(w, tx, title)
+		return
+	}
+
+	var data []byte
+	err = tx.QueryRow(`SELECT book FROM book_revisions WHERE title = $1 AND revision = $2`, title, to).Scan(&data)
+	if err == sql.ErrNoRows {
+		respondErrorCode(w, nil, http.StatusNotFound, api.CodeRevisionNotFound, fmt.Sprintf("Revision %d not found", to))
+		return
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error reverting book")
+		return
+	}
+
+	var target api.Book
+	err = json.Unmarshal(data, &target)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error reverting book")
+		return
+	}
+
+	_, err = tx.Exec(
+		`UPDATE books SET author = $1, publish_date = $2, publish_date_precision = $3, edition = $4, publisher = $5, description = $6, genre = $7, version = version + 1 WHERE title = $8`,
+		target.Author, target.PublishDate, datePrecision(target.PublishDate), target.Edition, target.Publisher, target.Description, target.Genre, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error reverting book")
+		return
+	}
+
+	after, err := getBook(tx, title)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error reverting book")
+		return
+	}
+
+	revision, err := recordRevision(tx, r, fmt.Sprintf("revert to %d", to), before, *after)
+	if err == nil {
+		err = recordAudit(tx, r, "revert", "book", title, before, after)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error reverting book")
+		return
+	}
+
+	w.Header().Set("ETag", etag(after.Version))
+	respondJSON(w, after, fmt.Sprintf("Book reverted to revision %d as revision %d", to, revision), http.StatusOK)
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"bms/shared/api"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// bookSearchVectorSQL is the full-text search document of a book. Title matches are weighted
+// above author matches, which are weighted above description matches.
+const bookSearchVectorSQL = `setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(author, '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(description, '')), 'C')`
+
+// searchBooks returns a page of the books matching a full-text query, most relevant first
+func (h *Handler) searchBooks(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		respondError(w, nil, http.StatusBadRequest, "q cannot be empty")
+		return
+	}
+
+	// the book filters narrow the search results, q is the search query rather than a filter expression
+	conditions, values, err := parseBookFilters(r, "")
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	values = append(values, q)
+	from := fmt.Sprintf("books, websearch_to_tsquery('english', $%d) AS query", len(values))
+	conditions = append(conditions, "search_vector @@ query")
+
+	keys := []sortKey{{expr: "ts_rank(search_vector, query)", desc: true}, {expr: "title"}}
+	p, err := parsePage(r, keys)
+	if err != nil {
+		respondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	total, err := countRows(h.db, from, conditions, values)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+
+	selectSQL := "SELECT " + bookColumns + `, ts_rank(search_vector, query),
+		ts_headline('english', concat_ws(' - ', title, author, description), query)`
+	query, values := pageQuery(selectSQL, from, conditions, values, keys, p)
+	rows, err := h.db.Query(query, values...)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+	defer rows.Close()
+
+	results := make([]api.SearchResult, 0)
+	rowCount := 0
+	var lastKeys []sql.NullString
+	for rows.Next() {
+		var result api.SearchResult
+		keyValues, keyDests := sortKeyDests(keys)
+		book, err := scanBook(rows, append([]any{&result.Rank, &result.Headline}, keyDests...)...)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error searching books")
+			return
+		}
+		result.Book = book
+		rowCount++
+		if rowCount <= p.limit {
+			results = append(results, result)
+			lastKeys = keyValues
+		}
+	}
+
+	pagination := &api.Pagination{NextCursor: nextCursor(rowCount, p, lastKeys), Total: total}
+	respondJSONPage(w, results, pagination, "Books searched successfully", http.StatusOK)
+}
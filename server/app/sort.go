@@ -0,0 +1,72 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// sortTitleSQL returns the expression a title column is sorted by, lower case without a leading
+// article so that "The Lord of the Rings" sorts under L
+func sortTitleSQL(column string) string {
+	return fmt.Sprintf(`regexp_replace(lower(%s), '^(the|an|a)\s+', '')`, column)
+}
+
+// bookSortFields maps the fields books can be sorted by to their sort expressions.
+// NULL values are replaced as keyset pagination cannot compare them, unknown publish dates sort last.
+var bookSortFields = map[string]string{
+	"title":        sortTitleSQL("title"),
+	"author":       "COALESCE(author, '')",
+	"genre":        "COALESCE(genre, '')",
+	"edition":      "COALESCE(edition, '')",
+	"publisher":    "COALESCE(publisher, '')",
+	"publish_date": "COALESCE(publish_date, 'infinity'::date)",
+	"version":      "version",
+}
+
+// collectionSortFields maps the fields collections can be sorted by to their sort expressions
+var collectionSortFields = map[string]string{
+	"name": "name",
+}
+
+// collectionBookSortFields maps the fields the books in a collection can be sorted by to their sort expressions
+var collectionBookSortFields = map[string]string{
+	"title": sortTitleSQL("book_title"),
+}
+
+// parseSort reads the sort URL parameter, comma separated fields each optionally prefixed with - for
+// descending order, falling back to defaultSort. The unique tiebreak column is appended as the last key
+// so that rows with equal sort values keep a stable order across pages.
+func parseSort(r *http.Request, fields map[string]string, defaultSort string, tiebreak string) ([]sortKey, error) {
+	value := r.URL.Query().Get("sort")
+	if value == "" {
+		value = defaultSort
+	}
+
+	keys := make([]sortKey, 0)
+	seen := map[string]bool{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		desc := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+
+		expr, ok := fields[field]
+		if !ok {
+			names := make([]string, 0, len(fields))
+			for name := range fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("cannot sort by %q, sort fields are %s", field, strings.Join(names, ", "))
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("cannot sort by %q more than once", field)
+		}
+		seen[field] = true
+
+		keys = append(keys, sortKey{expr: expr, desc: desc})
+	}
+
+	return append(keys, sortKey{expr: tiebreak}), nil
+}
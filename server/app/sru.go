@@ -0,0 +1,266 @@
+package app
+
+import (
+	"bms/server/cql"
+	"bms/server/filter"
+	"bms/shared/api"
+	"bms/shared/bookio"
+	"bms/shared/dublincore"
+	"bms/shared/marc"
+	"bms/shared/sru"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sruDefaultRecords = 10
+	sruMaxRecords     = 100
+)
+
+// sruIndexes maps the CQL indexes of SRU queries to book fields. dc.identifier is the ISBN, and
+// cql.serverChoice, used for a term on its own, searches the title, author and description.
+var sruIndexes = map[string]filter.Field{
+	"dc.title":         {Expr: "title", Type: filter.Text},
+	"dc.creator":       {Expr: "author", Type: filter.Text},
+	"dc.subject":       {Expr: "genre", Type: filter.Text},
+	"dc.description":   {Expr: "description", Type: filter.Text},
+	"dc.publisher":     {Expr: "publisher", Type: filter.Text},
+	"dc.date":          {Expr: "publish_date", Type: filter.Date, EndExpr: publishDateEndSQL},
+	"dc.identifier":    {Expr: "(SELECT value FROM book_identifiers WHERE book_title = books.title AND type = 'isbn')", Type: filter.Text},
+	"cql.serverchoice": {Expr: "concat_ws(' ', title, author, description)", Type: filter.Text},
+}
+
+// sruSchemas maps the names and identifiers of record schemas to the schemas served
+var sruSchemas = map[string]string{
+	"":                sru.SchemaDC,
+	"dc":              sru.SchemaDC,
+	sru.SchemaDC:      sru.SchemaDC,
+	"marcxml":         sru.SchemaMARCXML,
+	sru.SchemaMARCXML: sru.SchemaMARCXML,
+}
+
+// sruSearch serves SRU 1.2 searchRetrieve and explain requests. A request without an operation is an
+// explain request, unless it has a query. Errors are diagnostics in the response with a 200 status.
+func (h *Handler) sruSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	operation := params.Get("operation")
+	if operation == "" && params.Has("query") {
+		operation = "searchRetrieve"
+	}
+
+	var diagnostic *sru.Diagnostic
+	if version := params.Get("version"); version != "" && version != "1.1" && version != sru.Version {
+		d := sru.NewDiagnostic(sru.DiagUnsupportedVersion, "", sru.Version)
+		diagnostic = &d
+	}
+
+	switch operation {
+	case "searchRetrieve":
+		if diagnostic != nil {
+			respondSRU(w, &sru.SearchRetrieveResponse{Diagnostics: &sru.Diagnostics{Diagnostics: []sru.Diagnostic{*diagnostic}}})
+			return
+		}
+		h.sruSearchRetrieve(w, r)
+	case "", "explain":
+		respondSRU(w, sruExplain(r, diagnostic))
+	default:
+		d := sru.NewDiagnostic(sru.DiagUnsupportedOperation, "", operation)
+		respondSRU(w, sruExplain(r, &d))
+	}
+}
+
+// sruSearchRetrieve returns the page of the books matching a CQL query from startRecord, ordered by title,
+// as Dublin Core or MARCXML records
+func (h *Handler) sruSearchRetrieve(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	fail := func(diagnostic sru.Diagnostic) {
+		respondSRU(w, &sru.SearchRetrieveResponse{Diagnostics: &sru.Diagnostics{Diagnostics: []sru.Diagnostic{diagnostic}}})
+	}
+
+	query := params.Get("query")
+	if strings.TrimSpace(query) == "" {
+		fail(sru.NewDiagnostic(sru.DiagMandatoryParameter, "", "query"))
+		return
+	}
+	start := 1
+	if value := params.Get("startRecord"); value != "" {
+		var err error
+		start, err = strconv.Atoi(value)
+		if err != nil || start < 1 {
+			fail(sru.NewDiagnostic(sru.DiagUnsupportedValue, "", "startRecord"))
+			return
+		}
+	}
+	maximum := sruDefaultRecords
+	if value := params.Get("maximumRecords"); value != "" {
+		var err error
+		maximum, err = strconv.Atoi(value)
+		if err != nil || maximum < 0 || maximum > sruMaxRecords {
+			fail(sru.NewDiagnostic(sru.DiagUnsupportedValue, "", "maximumRecords"))
+			return
+		}
+	}
+	schema, ok := sruSchemas[params.Get("recordSchema")]
+	if !ok {
+		fail(sru.NewDiagnostic(sru.DiagUnknownSchema, "", params.Get("recordSchema")))
+		return
+	}
+	packing := params.Get("recordPacking")
+	if packing == "" {
+		packing = "xml"
+	}
+	if packing != "xml" && packing != "string" {
+		fail(sru.NewDiagnostic(sru.DiagUnsupportedPacking, "", packing))
+		return
+	}
+	if params.Get("sortKeys") != "" {
+		fail(sru.NewDiagnostic(sru.DiagSortUnsupported, "", "sortKeys"))
+		return
+	}
+
+	condition, values, err := cql.Compile(query, sruIndexes, 1)
+	var queryErr *cql.Error
+	if errors.As(err, &queryErr) {
+		fail(sru.NewDiagnostic(queryErr.Code, queryErr.Message(), queryErr.Details))
+		return
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+
+	total, err := countRows(h.db, "books", []string{condition}, values)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+	response := &sru.SearchRetrieveResponse{NumberOfRecords: total}
+	if total > 0 && start > total {
+		fail(sru.NewDiagnostic(sru.DiagFirstRecordOutOfRange, "", strconv.Itoa(start)))
+		return
+	}
+	if maximum == 0 || total == 0 {
+		respondSRU(w, response)
+		return
+	}
+
+	keys := []sortKey{{expr: sortTitleSQL("title")}, {expr: "title"}}
+	rows, err := h.db.Query("SELECT "+bookColumns+" FROM books WHERE "+condition+orderBy(keys)+
+		fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(values)+1, len(values)+2), append(values, maximum, start-1)...)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+	defer rows.Close()
+
+	books := make([]api.Book, 0)
+	var titles []string
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			respondError(w, err, http.StatusInternalServerError, "Error searching books")
+			return
+		}
+		books = append(books, book)
+		titles = append(titles, book.Title)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+	isbns, err := bookISBNs(h.db, titles)
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+
+	response.Records = &sru.Records{}
+	for i, book := range books {
+		isbn := isbns[book.Title]
+		var data sru.RecordData
+		if schema == sru.SchemaMARCXML {
+			record := bookio.MARCRecord(book)
+			if isbn != "" {
+				field := marc.Field{Tag: "020", Ind1: ' ', Ind2: ' ', Subfields: []marc.Subfield{{Code: 'a', Value: isbn}}}
+				record.Fields = append([]marc.Field{field}, record.Fields...)
+			}
+			data.MARC = &marc.XMLElement{Record: record}
+		} else {
+			data.DC = sru.NewDC(dublincore.FromBook(book, map[string]string{"isbn": isbn}))
+		}
+		if packing == "string" {
+			data, err = data.Packed()
+			if err != nil {
+				respondError(w, err, http.StatusInternalServerError, "Error searching books")
+				return
+			}
+		}
+		response.Records.Records = append(response.Records.Records, sru.Record{Schema: schema, Packing: packing, Data: data, Position: start + i})
+	}
+	if next := start + len(books); next <= total {
+		response.NextRecordPosition = next
+	}
+	respondSRU(w, response)
+}
+
+// sruExplain describes the indexes, record schemas and limits of searchRetrieve requests
+func sruExplain(r *http.Request, diagnostic *sru.Diagnostic) *sru.ExplainResponse {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, "80"
+		if r.TLS != nil {
+			port = "443"
+		}
+	}
+
+	explain := &sru.Explain{
+		Xmlns:        sru.ExplainNamespace,
+		ServerInfo:   sru.ServerInfo{Protocol: "SRU", Version: sru.Version, Host: host, Port: port, Database: "sru"},
+		DatabaseInfo: sru.DatabaseInfo{Title: "Book catalog", Description: "The books of the catalog, searched with CQL"},
+		IndexInfo: sru.IndexInfo{Sets: []sru.ContextSet{
+			{Name: "dc", Identifier: "info:srw/cql-context-set/1/dc-v1.1"},
+			{Name: "cql", Identifier: "info:srw/cql-context-set/1/cql-v1.2"},
+		}},
+		SchemaInfo: sru.SchemaInfo{Schemas: []sru.Schema{
+			{Identifier: sru.SchemaDC, Name: "dc", Title: "Dublin Core"},
+			{Identifier: sru.SchemaMARCXML, Name: "marcxml", Title: "MARC21 in MARCXML"},
+		}},
+		ConfigInfo: sru.ConfigInfo{
+			Defaults: []sru.Setting{{Type: "numberOfRecords", Value: strconv.Itoa(sruDefaultRecords)}},
+			Settings: []sru.Setting{{Type: "maximumRecords", Value: strconv.Itoa(sruMaxRecords)}},
+		},
+	}
+	names := make([]string, 0, len(sruIndexes))
+	for name := range sruIndexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		set, index, _ := strings.Cut(name, ".")
+		if index == "serverchoice" {
+			index = "serverChoice"
+		}
+		explain.IndexInfo.Indexes = append(explain.IndexInfo.Indexes, sru.Index{Title: index, Map: sru.IndexMap{Name: sru.IndexName{Set: set, Name: index}}})
+	}
+
+	response := &sru.ExplainResponse{Record: sru.Record{Schema: sru.ExplainNamespace, Packing: "xml", Data: sru.RecordData{Explain: explain}}}
+	if diagnostic != nil {
+		response.Diagnostics = &sru.Diagnostics{Diagnostics: []sru.Diagnostic{*diagnostic}}
+	}
+	return response
+}
+
+// respondSRU writes a searchRetrieve or explain response
+func respondSRU(w http.ResponseWriter, response any) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := sru.Write(w, response); err != nil {
+		log.Printf("Error writing SRU response: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"bms/shared/api"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultStatsMonths = 12
+	maxStatsMonths     = 120
+)
+
+// getStats returns the library statistics: totals, books per genre and per collection,
+// books added per month and a histogram of publish years
+func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
+	months := defaultStatsMonths
+	if value := r.URL.Query().Get("months"); value != "" {
+		var err error
+		months, err = strconv.Atoi(value)
+		if err != nil || months <= 0 || months > maxStatsMonths {
+			respondError(w, nil, http.StatusBadRequest, fmt.Sprintf("months must be between 1 and %d", maxStatsMonths))
+			return
+		}
+	}
+
+	// the statistics are read from one snapshot so that they add up
+	tx, err := h.db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting stats")
+		return
+	}
+	defer tx.Rollback()
+
+	var stats api.Stats
+	err = tx.QueryRow(`SELECT (SELECT COUNT(*) FROM books), (SELECT COUNT(*) FROM collections)`).
+		Scan(&stats.TotalBooks, &stats.TotalCollections)
+	if err == nil {
+		stats.BooksPerGenre, err = queryCounts(tx,
+			`SELECT NULLIF(genre, '') AS value, COUNT(*) FROM books GROUP BY value ORDER BY COUNT(*) DESC, value`)
+	}
+	if err == nil {
+		stats.BooksPerCollection, err = queryCounts(tx, `SELECT name, COUNT(book_title) FROM collections
+			LEFT JOIN collection_subscriptions ON collection_name = name
+			GROUP BY name ORDER BY COUNT(book_title) DESC, name`)
+	}
+	if err == nil {
+		// every month is listed, including those without additions
+		stats.AdditionsPerMonth, err = queryCounts(tx, `SELECT to_char(month, 'YYYY-MM'), COUNT(title)
+			FROM generate_series(date_trunc('month', now()) - ($1 - 1) * INTERVAL '1 month', date_trunc('month', now()), INTERVAL '1 month') AS month
+			LEFT JOIN books ON date_trunc('month', created_at) = month
+			GROUP BY month ORDER BY month`, months)
+	}
+	if err == nil {
+		stats.PublishYears, err = queryCounts(tx, `SELECT year::text, COUNT(*)
+			FROM (SELECT date_part('year', publish_date)::int AS year FROM books WHERE publish_date IS NOT NULL) AS years
+			GROUP BY year ORDER BY year`)
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError, "Error getting stats")
+		return
+	}
+
+	respondJSON(w, stats, "Stats retrieved successfully", http.StatusOK)
+}
+
+// queryCounts reads rows of a value and its count
+func queryCounts(q querier, query string, args ...any) ([]api.FacetValue, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]api.FacetValue, 0)
+	for rows.Next() {
+		var value sql.NullString
+		var count int
+		err := rows.Scan(&value, &count)
+		if err != nil {
+			return nil, err
+		}
+		facetValue := api.FacetValue{Count: count}
+		if value.Valid {
+			facetValue.Value = &value.String
+		}
+		counts = append(counts, facetValue)
+	}
+	return counts, rows.Err()
+}
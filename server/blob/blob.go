@@ -0,0 +1,97 @@
+// Package blob stores binary objects, such as cover images, by key
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned for a key that has no blob
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs by key. Keys are slash separated paths such as covers/<hash>, and a blob put
+// under an existing key replaces it.
+type Store interface {
+	Put(key string, r io.Reader) error
+	// Get opens a blob, which is seekable so that it can be served in ranges
+	Get(key string) (io.ReadSeekCloser, error)
+	// Delete removes a blob, deleting a missing blob is not an error
+	Delete(key string) error
+}
+
+// FileStore keeps blobs as files in a folder
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store in a folder, creating it if it does not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of a key, rejecting keys that would leave the folder
+func (s *FileStore) path(key string) (string, error) {
+	clean := filepath.ToSlash(filepath.Clean("/" + key))
+	if key == "" || clean != "/"+key || strings.Contains(key, `\`) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes a blob to a temporary file renamed into place, so that readers never see part of a blob
+func (s *FileStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Get opens the file of a blob
+func (s *FileStore) Get(key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete removes the file of a blob
+func (s *FileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,380 @@
+// Package cql parses CQL, the Contextual Query Language of SRU, in queries such as
+//
+//	dc.title = "lord of the rings" and dc.date < 1960 or dc.creator any "tolkien lewis"
+//
+// and compiles them to parameterized SQL conditions over the indexes of a catalogue. An index without
+// a context set prefix is in the dc set, and a term on its own is searched in cql.serverChoice.
+// Errors carry the SRU diagnostic code that describes them.
+package cql
+
+import (
+	"bms/server/filter"
+	"bms/shared/api"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxLength is the longest query that is compiled
+const MaxLength = 4096
+
+// maxDepth limits the nesting of parentheses
+const maxDepth = 32
+
+// the SRU diagnostics of queries that cannot be compiled
+const (
+	DiagSyntax                      = 10
+	DiagUnsupportedContextSet       = 15
+	DiagUnsupportedIndex            = 16
+	DiagUnsupportedRelation         = 19
+	DiagUnsupportedRelationModifier = 20
+	DiagEmptyTerm                   = 27
+	DiagInvalidTerm                 = 36
+	DiagUnsupportedBoolean          = 37
+	DiagUnsupportedBooleanModifier  = 46
+	DiagSortUnsupported             = 80
+)
+
+// diagnosticMessages are the messages of the SRU diagnostics
+var diagnosticMessages = map[int]string{
+	DiagSyntax:                      "Query syntax error",
+	DiagUnsupportedContextSet:       "Unsupported context set",
+	DiagUnsupportedIndex:            "Unsupported index",
+	DiagUnsupportedRelation:         "Unsupported relation",
+	DiagUnsupportedRelationModifier: "Unsupported relation modifier",
+	DiagEmptyTerm:                   "Empty term unsupported",
+	DiagInvalidTerm:                 "Term in invalid format for index or relation",
+	DiagUnsupportedBoolean:          "Unsupported boolean operator",
+	DiagUnsupportedBooleanModifier:  "Unsupported boolean modifier",
+	DiagSortUnsupported:             "Sort not supported",
+}
+
+// Error is a query that cannot be compiled, Code is its SRU diagnostic
+type Error struct {
+	Code    int
+	Details string
+}
+
+// Message returns the message of the diagnostic
+func (e *Error) Message() string {
+	return diagnosticMessages[e.Code]
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Message(), e.Details)
+}
+
+// named relations, a comparator such as = or <> is its own relation
+var relations = map[string]bool{"adj": true, "all": true, "any": true, "exact": true, "within": true, "encloses": true, "scr": true}
+
+// Compile compiles a query to an SQL condition over the indexes, named with their context set such as
+// dc.title. Indexes are Text or Date filter fields. Parameters are numbered from $firstParam and their
+// values are returned in order.
+//
+// Text indexes are matched case insensitively: = and adj find the term in the field, all and any find
+// all or any of its words, and == and exact match the whole field. Terms may be masked with * and ?,
+// and anchored to the start or end of the field with ^. Date indexes are compared like filter
+// expressions, so a partial date such as 1954 covers the whole year, and within takes two dates.
+func Compile(query string, indexes map[string]filter.Field, firstParam int) (string, []any, error) {
+	if len(query) > MaxLength {
+		return "", nil, &Error{Code: DiagSyntax, Details: fmt.Sprintf("query is longer than %d characters", MaxLength)}
+	}
+
+	tokens, err := lex(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	p := &parser{tokens: tokens, indexes: indexes, param: firstParam, values: make([]any, 0)}
+	condition, err := p.parseQuery()
+	if err != nil {
+		return "", nil, err
+	}
+	next := p.peek()
+	if next.word("sortby") {
+		return "", nil, &Error{Code: DiagSortUnsupported, Details: "sortBy"}
+	}
+	if next.kind != tokenEOF {
+		return "", nil, p.unexpected(next, "a boolean operator or end of query")
+	}
+	return condition, p.values, nil
+}
+
+// parser is a recursive descent parser emitting SQL. CQL booleans have equal precedence and
+// associate to the left, so a or b and c is (a or b) and c.
+type parser struct {
+	tokens  []token
+	pos     int
+	indexes map[string]filter.Field
+	param   int
+	values  []any
+	depth   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// unexpected returns a syntax error for a token that does not fit the query
+func (p *parser) unexpected(t token, expected string) error {
+	return &Error{Code: DiagSyntax, Details: fmt.Sprintf("expected %s but found %s at position %d", expected, t, t.pos+1)}
+}
+
+// addParam adds a parameter value and returns its placeholder
+func (p *parser) addParam(value any) string {
+	p.values = append(p.values, value)
+	placeholder := fmt.Sprintf("$%d", p.param)
+	p.param++
+	return placeholder
+}
+
+// rejectModifiers returns an error if modifiers follow a relation or a boolean, none are supported
+func (p *parser) rejectModifiers(code int) error {
+	if p.peek().kind != tokenSlash {
+		return nil
+	}
+	p.next()
+	modifier := p.next()
+	if modifier.kind != tokenTerm {
+		return p.unexpected(modifier, "a modifier")
+	}
+	return &Error{Code: code, Details: modifier.text}
+}
+
+// parseQuery parses clauses joined by booleans
+func (p *parser) parseQuery() (string, error) {
+	if prefix := p.peek(); prefix.kind == tokenPrefix {
+		return "", &Error{Code: DiagUnsupportedContextSet, Details: fmt.Sprintf("prefix assignment at position %d", prefix.pos+1)}
+	}
+
+	left, err := p.parseClause()
+	if err != nil {
+		return "", err
+	}
+	for p.peek().word("and", "or", "not", "prox") {
+		boolean := strings.ToLower(p.next().text)
+		if boolean == "prox" {
+			return "", &Error{Code: DiagUnsupportedBoolean, Details: boolean}
+		}
+		if err := p.rejectModifiers(DiagUnsupportedBooleanModifier); err != nil {
+			return "", err
+		}
+		right, err := p.parseClause()
+		if err != nil {
+			return "", err
+		}
+
+		switch boolean {
+		case "and":
+			left = "(" + left + " AND " + right + ")"
+		case "or":
+			left = "(" + left + " OR " + right + ")"
+		case "not":
+			left = "(" + left + " AND NOT " + right + ")"
+		}
+	}
+	return left, nil
+}
+
+// parseClause parses a parenthesised query, an index, relation and term, or a term on its own
+func (p *parser) parseClause() (string, error) {
+	t := p.next()
+	if t.kind == tokenLParen {
+		p.depth++
+		if p.depth > maxDepth {
+			return "", &Error{Code: DiagSyntax, Details: fmt.Sprintf("query is nested more than %d levels deep", maxDepth)}
+		}
+		condition, err := p.parseQuery()
+		p.depth--
+		if err != nil {
+			return "", err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return "", p.unexpected(closing, `")"`)
+		}
+		return condition, nil
+	}
+	if t.kind != tokenTerm {
+		return "", p.unexpected(t, "an index, a term or (")
+	}
+
+	next := p.peek()
+	if next.kind != tokenComparator && !(next.kind == tokenTerm && !next.quoted && relations[relationName(next.text)]) {
+		return p.compile("cql.serverchoice", "=", t)
+	}
+	relation := p.next()
+	if err := p.rejectModifiers(DiagUnsupportedRelationModifier); err != nil {
+		return "", err
+	}
+	term := p.next()
+	if term.kind != tokenTerm {
+		return "", p.unexpected(term, "a term")
+	}
+	name := relation.text
+	if relation.kind == tokenTerm {
+		name = relationName(relation.text)
+	}
+	return p.compile(t.text, name, term)
+}
+
+// relationName returns a named relation without the cql prefix, in lower case
+func relationName(name string) string {
+	name = strings.ToLower(name)
+	return strings.TrimPrefix(name, "cql.")
+}
+
+// compile compiles the comparison of an index to a term
+func (p *parser) compile(index string, relation string, term token) (string, error) {
+	name := strings.ToLower(index)
+	if !strings.Contains(name, ".") {
+		name = "dc." + name
+	}
+	if name == "cql.allrecords" {
+		return "TRUE", nil
+	}
+	field, ok := p.indexes[name]
+	if !ok {
+		names := make([]string, 0, len(p.indexes))
+		for indexName := range p.indexes {
+			names = append(names, indexName)
+		}
+		sort.Strings(names)
+		return "", &Error{Code: DiagUnsupportedIndex, Details: fmt.Sprintf("%s, indexes are %s", index, strings.Join(names, ", "))}
+	}
+
+	if field.Type == filter.Date {
+		return p.compileDate(field, relation, term)
+	}
+	return p.compileText(field, relation, term)
+}
+
+// compileText compiles the comparison of a text index to a term
+func (p *parser) compileText(field filter.Field, relation string, term token) (string, error) {
+	match := func(pattern string) string {
+		return fmt.Sprintf("COALESCE(%s ILIKE %s, false)", field.Expr, p.addParam(pattern))
+	}
+
+	switch relation {
+	case "=", "adj", "scr":
+		if strings.TrimSpace(term.text) == "" {
+			return "", &Error{Code: DiagEmptyTerm, Details: relation}
+		}
+		return match(likePattern(term.text, true)), nil
+	case "all", "any":
+		words := strings.Fields(term.text)
+		if len(words) == 0 {
+			return "", &Error{Code: DiagEmptyTerm, Details: relation}
+		}
+		conditions := make([]string, len(words))
+		for i, word := range words {
+			conditions[i] = match(likePattern(word, true))
+		}
+		if relation == "all" {
+			return "(" + strings.Join(conditions, " AND ") + ")", nil
+		}
+		return "(" + strings.Join(conditions, " OR ") + ")", nil
+	case "==", "exact":
+		return match(likePattern(term.text, false)), nil
+	case "<>":
+		// like NOT in filter expressions, a missing value is not equal to the term
+		return "NOT " + match(likePattern(term.text, false)), nil
+	}
+	return "", &Error{Code: DiagUnsupportedRelation, Details: relation}
+}
+
+// compileDate compiles the comparison of a date index to a term holding a date, or two for within
+func (p *parser) compileDate(field filter.Field, relation string, term token) (string, error) {
+	dates := strings.Fields(term.text)
+	parsed := make([]api.Date, len(dates))
+	for i, value := range dates {
+		date, err := api.ParseDate(value)
+		if err == nil && date.IsZero() {
+			err = fmt.Errorf("empty date")
+		}
+		if err != nil {
+			return "", &Error{Code: DiagInvalidTerm, Details: fmt.Sprintf("%q: %v", value, err)}
+		}
+		parsed[i] = date
+	}
+
+	if relation == "within" {
+		if len(parsed) != 2 {
+			return "", &Error{Code: DiagInvalidTerm, Details: fmt.Sprintf("within takes two dates but found %q", term.text)}
+		}
+		from, err := filter.DateCondition(field, ">=", parsed[0], p.addParam)
+		if err != nil {
+			return "", err
+		}
+		until, err := filter.DateCondition(field, "<=", parsed[1], p.addParam)
+		if err != nil {
+			return "", err
+		}
+		return "(" + from + " AND " + until + ")", nil
+	}
+	if len(parsed) != 1 {
+		return "", &Error{Code: DiagInvalidTerm, Details: fmt.Sprintf("expected a date but found %q", term.text)}
+	}
+
+	operator := relation
+	switch relation {
+	case "=", "==", "adj", "exact", "scr":
+		operator = "="
+	}
+	condition, err := filter.DateCondition(field, operator, parsed[0], p.addParam)
+	if err != nil {
+		return "", &Error{Code: DiagUnsupportedRelation, Details: relation}
+	}
+	return condition, nil
+}
+
+// likePattern converts a term to an ILIKE pattern. * masks any characters and ? a single one, a
+// backslash escapes the next character, and the wildcards of LIKE are matched literally. A contained
+// term is found anywhere in the field unless it is anchored with ^ at its start or end.
+func likePattern(term string, contained bool) string {
+	start, end := contained, contained
+	if strings.HasPrefix(term, "^") {
+		term = term[1:]
+		start = false
+	}
+	if strings.HasSuffix(term, "^") && !strings.HasSuffix(term, `\^`) {
+		term = term[:len(term)-1]
+		end = false
+	}
+
+	var pattern strings.Builder
+	if start {
+		pattern.WriteByte('%')
+	}
+	escaped := false
+	for _, r := range term {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+			continue
+		case r == '*':
+			pattern.WriteByte('%')
+			continue
+		case r == '?':
+			pattern.WriteByte('_')
+			continue
+		}
+		if r == '%' || r == '_' || r == '\\' {
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteRune(r)
+	}
+	if end {
+		pattern.WriteByte('%')
+	}
+	return pattern.String()
+}
@@ -0,0 +1,116 @@
+package cql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the kind of a lexical token of a CQL query
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenTerm
+	tokenComparator
+	tokenLParen
+	tokenRParen
+	tokenSlash
+	tokenPrefix
+)
+
+// token is a lexical token and its character offset in the query. Index names, booleans and named
+// relations are unquoted terms, told apart by the parser from their place in the query.
+type token struct {
+	kind   tokenKind
+	text   string
+	quoted bool
+	pos    int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenTerm:
+		if t.quoted {
+			return fmt.Sprintf("term %q", t.text)
+		}
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// word reports whether the token is the unquoted word, matched case insensitively
+func (t token) word(words ...string) bool {
+	if t.kind != tokenTerm || t.quoted {
+		return false
+	}
+	for _, word := range words {
+		if strings.EqualFold(t.text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// lex splits a query into tokens. Quoted terms keep their backslash escapes, which are interpreted
+// with the masking characters when the term is compiled.
+func lex(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case r == '/':
+			tokens = append(tokens, token{kind: tokenSlash, text: "/", pos: i})
+			i++
+		case r == '"':
+			start := i
+			var value strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, &Error{Code: DiagSyntax, Details: fmt.Sprintf("unterminated term at position %d", start+1)}
+				}
+				if runes[i] == '"' {
+					i++
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					value.WriteRune(runes[i])
+					i++
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenTerm, text: value.String(), quoted: true, pos: start})
+		case r == '>' && len(tokens) == 0 || r == '>' && tokens[len(tokens)-1].kind == tokenLParen:
+			// a > starting a query assigns a prefix to a context set rather than comparing
+			tokens = append(tokens, token{kind: tokenPrefix, text: ">", pos: i})
+			i++
+		case strings.ContainsRune("=<>", r):
+			start := i
+			op := string(r)
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				op += string(runes[i+1])
+			}
+			i += len(op)
+			tokens = append(tokens, token{kind: tokenComparator, text: op, pos: start})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()/"=<>`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenTerm, text: string(runes[start:i]), pos: start})
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
@@ -0,0 +1,401 @@
+// Package filter parses boolean filter expressions such as
+//
+//	genre IN ('fantasy', 'sci-fi') AND NOT author = 'X' AND publish_date > 1990 AND description IS NULL
+//
+// and compiles them to parameterized SQL conditions. Fields are checked against a whitelist and
+// values are only passed as query parameters, so an expression cannot inject SQL.
+package filter
+
+import (
+	"bms/shared/api"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of the values a field is compared to
+type Type int
+
+const (
+	Text Type = iota
+	Integer
+	Date
+)
+
+// Field is a field that can be used in an expression
+type Field struct {
+	// Expr is the SQL expression of the field, the first day covered for a Date field
+	Expr string
+	Type Type
+	// EndExpr is the last day covered by a Date field that holds partial dates, Expr is used if empty
+	EndExpr string
+}
+
+// MaxLength is the longest expression that is compiled
+const MaxLength = 4096
+
+// maxDepth limits the nesting of parentheses and NOT
+const maxDepth = 32
+
+// SyntaxError is an invalid expression, Pos is the character offset of the error
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos+1)
+}
+
+// Compile compiles an expression to an SQL condition over the fields. Parameters are numbered
+// from $firstParam and their values are returned in order.
+//
+// Comparisons with a missing (NULL) value are false rather than unknown, so NOT author = 'X' also
+// matches books without an author. A Text field IS NULL when it is NULL or empty.
+func Compile(input string, fields map[string]Field, firstParam int) (string, []any, error) {
+	if len(input) > MaxLength {
+		return "", nil, &SyntaxError{Pos: MaxLength, Msg: fmt.Sprintf("expression is longer than %d characters", MaxLength)}
+	}
+
+	tokens, err := lex(input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	p := &parser{tokens: tokens, fields: fields, param: firstParam, values: make([]any, 0)}
+	condition, err := p.parseOr()
+	if err != nil {
+		return "", nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return "", nil, p.unexpected(next, "AND, OR or end of expression")
+	}
+	return condition, p.values, nil
+}
+
+// parser is a recursive descent parser emitting SQL, by precedence OR < AND < NOT < comparison
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+	fields map[string]Field
+	param  int
+	values []any
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// acceptKeyword consumes the next token if it is the keyword
+func (p *parser) acceptKeyword(keyword string) bool {
+	if t := p.peek(); t.kind == tokenKeyword && t.text == keyword {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) unexpected(t token, expected string) error {
+	return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expected %s but found %s", expected, t)}
+}
+
+// addParam adds a parameter value and returns its placeholder
+func (p *parser) addParam(value any) string {
+	p.values = append(p.values, value)
+	placeholder := fmt.Sprintf("$%d", p.param)
+	p.param++
+	return placeholder
+}
+
+// nest guards against expressions nested deep enough to exhaust the stack
+func (p *parser) nest(t token) error {
+	p.depth++
+	if p.depth > maxDepth {
+		return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expression is nested more than %d levels deep", maxDepth)}
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " OR " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (string, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return "", err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " AND " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (string, error) {
+	t := p.peek()
+	if !p.acceptKeyword("NOT") {
+		return p.parsePrimary()
+	}
+	if err := p.nest(t); err != nil {
+		return "", err
+	}
+	operand, err := p.parseNot()
+	p.depth--
+	if err != nil {
+		return "", err
+	}
+	return "NOT " + operand, nil
+}
+
+func (p *parser) parsePrimary() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		if err := p.nest(t); err != nil {
+			return "", err
+		}
+		condition, err := p.parseOr()
+		p.depth--
+		if err != nil {
+			return "", err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return "", p.unexpected(closing, `")"`)
+		}
+		return condition, nil
+	case tokenIdent:
+		return p.parseComparison(t)
+	}
+	return "", p.unexpected(t, "a field name, NOT or (")
+}
+
+// parseComparison parses the comparison following a field name
+func (p *parser) parseComparison(name token) (string, error) {
+	field, ok := p.fields[strings.ToLower(name.text)]
+	if !ok {
+		names := make([]string, 0, len(p.fields))
+		for fieldName := range p.fields {
+			names = append(names, fieldName)
+		}
+		sort.Strings(names)
+		return "", &SyntaxError{Pos: name.pos, Msg: fmt.Sprintf("unknown field %q, fields are %s", name.text, strings.Join(names, ", "))}
+	}
+
+	t := p.next()
+	if t.kind == tokenOperator {
+		return p.compare(field, t)
+	}
+	if t.kind != tokenKeyword {
+		return "", p.unexpected(t, "a comparison, IN, LIKE or IS")
+	}
+
+	switch t.text {
+	case "IS":
+		negate := p.acceptKeyword("NOT")
+		if null := p.next(); null.kind != tokenKeyword || null.text != "NULL" {
+			return "", p.unexpected(null, "NULL")
+		}
+		return isNull(field, negate), nil
+	case "NOT":
+		condition, err := p.parseMembership(field, p.next())
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + condition, nil
+	}
+	return p.parseMembership(field, t)
+}
+
+// parseMembership parses the IN list or LIKE pattern following the keyword t
+func (p *parser) parseMembership(field Field, t token) (string, error) {
+	if t.kind == tokenKeyword && t.text == "IN" {
+		return p.parseIn(field)
+	}
+	if t.kind == tokenKeyword && (t.text == "LIKE" || t.text == "ILIKE") {
+		if field.Type != Text {
+			return "", &SyntaxError{Pos: t.pos, Msg: t.text + " can only be used on text fields"}
+		}
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return "", p.unexpected(pattern, "a quoted pattern")
+		}
+		return fmt.Sprintf("COALESCE(%s %s %s, false)", field.Expr, t.text, p.addParam(pattern.text)), nil
+	}
+	return "", p.unexpected(t, "IN or LIKE")
+}
+
+// parseIn parses a parenthesised list of values
+func (p *parser) parseIn(field Field) (string, error) {
+	if open := p.next(); open.kind != tokenLParen {
+		return "", p.unexpected(open, `"("`)
+	}
+
+	alternatives := make([]string, 0)
+	placeholders := make([]string, 0)
+	for {
+		t := p.next()
+		if field.Type == Date {
+			date, err := parseDate(t)
+			if err != nil {
+				return "", err
+			}
+			condition, err := DateCondition(field, "=", date, p.addParam)
+			if err != nil {
+				return "", err
+			}
+			alternatives = append(alternatives, condition)
+		} else {
+			value, err := parseValue(field, t)
+			if err != nil {
+				return "", err
+			}
+			placeholders = append(placeholders, p.addParam(value))
+		}
+
+		separator := p.next()
+		if separator.kind == tokenRParen {
+			break
+		}
+		if separator.kind != tokenComma {
+			return "", p.unexpected(separator, `"," or ")"`)
+		}
+	}
+
+	if field.Type == Date {
+		return "(" + strings.Join(alternatives, " OR ") + ")", nil
+	}
+	return fmt.Sprintf("COALESCE(%s IN (%s), false)", field.Expr, strings.Join(placeholders, ", ")), nil
+}
+
+// compare compiles a comparison of a field to the value following the operator
+func (p *parser) compare(field Field, op token) (string, error) {
+	operator := op.text
+	if operator == "!=" {
+		operator = "<>"
+	}
+
+	t := p.next()
+	if field.Type == Date {
+		date, err := parseDate(t)
+		if err != nil {
+			return "", err
+		}
+		condition, err := DateCondition(field, operator, date, p.addParam)
+		if err != nil {
+			return "", &SyntaxError{Pos: op.pos, Msg: err.Error()}
+		}
+		return condition, nil
+	}
+
+	value, err := parseValue(field, t)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("COALESCE(%s %s %s, false)", field.Expr, operator, p.addParam(value)), nil
+}
+
+// DateCondition compiles a comparison of a Date field to a possibly partial date, adding the bounds
+// of the date as parameters with param. A field matches when any day it covers satisfies the
+// comparison, so 1954-07 = 1954 and 1954 > 1954-03. The operator is one of =, <>, !=, <, <=, > and >=,
+// any other is an error.
+func DateCondition(field Field, operator string, date api.Date, param func(value any) string) (string, error) {
+	endExpr := field.EndExpr
+	if endExpr == "" {
+		endExpr = field.Expr
+	}
+	start := date.Start().Format(api.PublishTimeLayoutDMY)
+	end := date.End().Format(api.PublishTimeLayoutDMY)
+
+	var condition string
+	switch operator {
+	case "<":
+		condition = fmt.Sprintf("%s < %s", field.Expr, param(start))
+	case "<=":
+		condition = fmt.Sprintf("%s <= %s", field.Expr, param(end))
+	case ">":
+		condition = fmt.Sprintf("%s > %s", endExpr, param(end))
+	case ">=":
+		condition = fmt.Sprintf("%s >= %s", endExpr, param(start))
+	case "=":
+		condition = fmt.Sprintf("%s <= %s AND %s >= %s", field.Expr, param(end), endExpr, param(start))
+	case "<>", "!=":
+		condition = fmt.Sprintf("NOT (%s <= %s AND %s >= %s)", field.Expr, param(end), endExpr, param(start))
+	default:
+		return "", fmt.Errorf("unsupported date operator %q", operator)
+	}
+	return "COALESCE(" + condition + ", false)", nil
+}
+
+// isNull compiles IS NULL, an empty text field is treated as missing
+func isNull(field Field, negate bool) string {
+	if field.Type == Text {
+		if negate {
+			return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", field.Expr, field.Expr)
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s = '')", field.Expr, field.Expr)
+	}
+	if negate {
+		return field.Expr + " IS NOT NULL"
+	}
+	return field.Expr + " IS NULL"
+}
+
+// parseValue returns the value of a literal compared to a Text or Integer field
+func parseValue(field Field, t token) (any, error) {
+	if field.Type == Integer {
+		if t.kind != tokenNumber {
+			return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expected a number but found %s", t)}
+		}
+		value, err := strconv.Atoi(t.text)
+		if err != nil {
+			return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("invalid number %q", t.text)}
+		}
+		return value, nil
+	}
+
+	// unquoted numbers are accepted for text fields such as edition
+	if t.kind != tokenString && t.kind != tokenNumber {
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expected a quoted string but found %s", t)}
+	}
+	return t.text, nil
+}
+
+// parseDate returns the date of a literal compared to a Date field, YYYY, YYYY-MM or YYYY-MM-DD
+func parseDate(t token) (api.Date, error) {
+	if t.kind != tokenString && t.kind != tokenNumber {
+		return api.Date{}, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expected a date but found %s", t)}
+	}
+	date, err := api.ParseDate(t.text)
+	if err == nil && date.IsZero() {
+		err = fmt.Errorf("empty date")
+	}
+	if err != nil {
+		return api.Date{}, &SyntaxError{Pos: t.pos, Msg: err.Error()}
+	}
+	return date, nil
+}
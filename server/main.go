@@ -14,6 +14,12 @@ func main() {
 		DbPassword: "password",
 		DbName:     "bms_db",
 		ServerPort: "8080",
+
+		RepositoryName:       "Book catalog",
+		RepositoryIdentifier: "bms.localhost",
+		AdminEmail:           "admin@bms.localhost",
+
+		BlobDir: "data/blobs",
 	}
 
 	app := app.NewApp(config)
@@ -0,0 +1,67 @@
+// Package thumbnail scales images down to thumbnails with the standard library image packages
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+// Quality is the JPEG quality thumbnails are encoded with
+const Quality = 85
+
+// Fit scales an image down to fit in a square of size pixels, keeping its aspect ratio. Each pixel is the
+// average of the pixels it covers, and transparent pixels are blended with white, as thumbnails are JPEG
+// images. Images that already fit keep their size.
+func Fit(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			// the colors are alpha premultiplied, so adding the missing alpha in white blends with white
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			white := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + white) >> 8),
+				G: uint8((g/n + white) >> 8),
+				B: uint8((b/n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// Encode writes a thumbnail as a JPEG image
+func Encode(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: Quality})
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,14 @@
+package api
+
+import "time"
+
+// Attachment is an EPUB or PDF file attached to a book. The file is stored by the SHA-256 hash of its
+// content, which identifies it among the files of the book.
+type Attachment struct {
+	BookTitle   string    `json:"book_title"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Hash        string    `json:"hash"`
+	CreatedAt   time.Time `json:"created_at"`
+}
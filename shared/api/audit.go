@@ -0,0 +1,23 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ActorHeader is the request header used to identify who made a change
+var ActorHeader = "X-BMS-Actor"
+
+// AuditEntry is a single recorded mutation
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Timestamp time.Time       `json:"timestamp"`
+	Method    string          `json:"method"`
+	Endpoint  string          `json:"endpoint"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
@@ -0,0 +1,14 @@
+package api
+
+import "time"
+
+// Cover is the cover image of a book. The image is stored by the SHA-256 hash of its content.
+type Cover struct {
+	BookTitle   string    `json:"book_title"`
+	ContentType string    `json:"content_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Size        int64     `json:"size"`
+	Hash        string    `json:"hash"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatePrecision is how much of a Date is known
+type DatePrecision string
+
+const (
+	PrecisionYear  DatePrecision = "year"
+	PrecisionMonth DatePrecision = "month"
+	PrecisionDay   DatePrecision = "day"
+)
+
+// Date is a calendar date known to a year, month or day, written as YYYY, YYYY-MM or YYYY-MM-DD.
+// The zero Date is an unknown date and is written as JSON null.
+type Date struct {
+	Year      int
+	Month     time.Month
+	Day       int
+	Precision DatePrecision
+}
+
+// NewDate returns a day precision Date
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{Year: year, Month: month, Day: day, Precision: PrecisionDay}
+}
+
+// DateFromTime returns the Date of t truncated to the given precision. An empty precision is an unknown
+// date, as the precision marks whether a date is known, so that January 1 of year 1 is a date.
+func DateFromTime(t time.Time, precision DatePrecision) Date {
+	switch precision {
+	case "":
+		return Date{}
+	case PrecisionYear:
+		return Date{Year: t.Year(), Precision: PrecisionYear}
+	case PrecisionMonth:
+		return Date{Year: t.Year(), Month: t.Month(), Precision: PrecisionMonth}
+	}
+	return NewDate(t.Year(), t.Month(), t.Day())
+}
+
+// ParseDate parses YYYY, YYYY-MM or YYYY-MM-DD. RFC 3339 timestamps are accepted as day precision
+// dates for compatibility with records written before partial dates were supported.
+func ParseDate(value string) (Date, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Date{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return DateFromTime(t, PrecisionDay), nil
+	}
+
+	parts := strings.Split(value, "-")
+	if len(parts) > 3 || len(parts[0]) != 4 {
+		return Date{}, fmt.Errorf("invalid date %q, must be YYYY, YYYY-MM or YYYY-MM-DD", value)
+	}
+
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || (i > 0 && len(part) != 2) {
+			return Date{}, fmt.Errorf("invalid date %q, must be YYYY, YYYY-MM or YYYY-MM-DD", value)
+		}
+		numbers[i] = number
+	}
+
+	date := Date{Year: numbers[0], Month: time.January, Day: 1, Precision: PrecisionYear}
+	if len(numbers) > 1 {
+		date.Month = time.Month(numbers[1])
+		date.Precision = PrecisionMonth
+	}
+	if len(numbers) > 2 {
+		date.Day = numbers[2]
+		date.Precision = PrecisionDay
+	}
+
+	// time.Date normalises out of range values, so a changed date was invalid
+	t := time.Date(date.Year, date.Month, date.Day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != date.Year || t.Month() != date.Month || t.Day() != date.Day {
+		return Date{}, fmt.Errorf("invalid date %q", value)
+	}
+	return DateFromTime(t, date.Precision), nil
+}
+
+// IsZero reports whether the date is unknown
+func (d Date) IsZero() bool {
+	return d.Precision == ""
+}
+
+// String formats the date to its precision, an unknown date is empty
+func (d Date) String() string {
+	switch d.Precision {
+	case PrecisionYear:
+		return fmt.Sprintf("%04d", d.Year)
+	case PrecisionMonth:
+		return fmt.Sprintf("%04d-%02d", d.Year, d.Month)
+	case PrecisionDay:
+		return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+	}
+	return ""
+}
+
+// Start returns the first day covered by the date
+func (d Date) Start() time.Time {
+	switch d.Precision {
+	case PrecisionYear:
+		return time.Date(d.Year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	case PrecisionMonth:
+		return time.Date(d.Year, d.Month, 1, 0, 0, 0, 0, time.UTC)
+	case PrecisionDay:
+		return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// End returns the last day covered by the date, so 1954 ends on 1954-12-31
+func (d Date) End() time.Time {
+	switch d.Precision {
+	case PrecisionYear:
+		return d.Start().AddDate(1, 0, -1)
+	case PrecisionMonth:
+		return d.Start().AddDate(0, 1, -1)
+	}
+	return d.Start()
+}
+
+// MarshalJSON writes the date as a string to its precision, or null if unknown
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads a date string, null and "" are an unknown date
+func (d *Date) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*d = Date{}
+		return nil
+	}
+
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	date, err := ParseDate(value)
+	if err != nil {
+		return err
+	}
+	*d = date
+	return nil
+}
+
+// Value stores the first day of the date in a DATE column, the precision is stored separately
+func (d Date) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.Start().Format(PublishTimeLayoutDMY), nil
+}
+
+// Set parses a date given as a command line flag
+func (d *Date) Set(value string) error {
+	date, err := ParseDate(value)
+	if err != nil {
+		return err
+	}
+	*d = date
+	return nil
+}
+
+// Type names the flag value type in command line help
+func (d *Date) Type() string {
+	return "date"
+}
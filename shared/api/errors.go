@@ -0,0 +1,42 @@
+package api
+
+// ProblemContentType is the content type of RFC 7807 problem details responses
+var ProblemContentType = "application/problem+json"
+
+// ProblemTypePrefix prefixes the error code to form the problem details type URI
+var ProblemTypePrefix = "urn:bms:error:"
+
+// Machine readable error codes returned in Response.Code
+const (
+	CodeInvalidRequest          = "invalid_request"
+	CodeValidationFailed        = "validation_failed"
+	CodeUnsupportedMediaType    = "unsupported_media_type"
+	CodeNotFound                = "not_found"
+	CodeBookNotFound            = "book_not_found"
+	CodeCollectionNotFound      = "collection_not_found"
+	CodeRevisionNotFound        = "revision_not_found"
+	CodeCoverNotFound           = "cover_not_found"
+	CodeFileNotFound            = "file_not_found"
+	CodeConflict                = "conflict"
+	CodeBookExists              = "book_exists"
+	CodeCollectionExists        = "collection_exists"
+	CodeBookAlreadyInCollection = "book_already_in_collection"
+	CodeVersionConflict         = "version_conflict"
+	CodeInternal                = "internal_error"
+)
+
+// ProblemDetails is an RFC 7807 error response, sent instead of Response
+// when the client accepts application/problem+json
+type ProblemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Code   string `json:"code"`
+	Data   any    `json:"data,omitempty"`
+}
+
+// NotFoundData is the data of a not found error, listing the existing names closest to the one requested
+type NotFoundData struct {
+	Suggestions []string `json:"suggestions"`
+}
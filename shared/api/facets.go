@@ -0,0 +1,19 @@
+package api
+
+// BookFacets are the counts of the books matching a filter by the values of facet fields
+type BookFacets struct {
+	Total  int     `json:"total"`
+	Facets []Facet `json:"facets"`
+}
+
+// Facet is the number of books with each value of a field, most common first
+type Facet struct {
+	Field  string       `json:"field"`
+	Values []FacetValue `json:"values"`
+}
+
+// FacetValue is a value of a facet field and its number of books, Value is nil for books without a value
+type FacetValue struct {
+	Value *string `json:"value"`
+	Count int     `json:"count"`
+}
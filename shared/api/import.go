@@ -0,0 +1,90 @@
+package api
+
+// ImportMode is how a bulk import treats books whose title already exists
+type ImportMode string
+
+const (
+	// ImportSkip leaves existing books unchanged
+	ImportSkip ImportMode = "skip"
+	// ImportOverwrite replaces every field of existing books, clearing fields missing from the import
+	ImportOverwrite ImportMode = "overwrite"
+	// ImportMerge sets the fields given in the import and keeps the other fields of existing books
+	ImportMerge ImportMode = "merge"
+)
+
+// Statuses of an imported row
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportSkipped   = "skipped"
+	ImportFailed    = "failed"
+)
+
+// ImportBook is a book to import and the collections to add it to, missing collections are created.
+// A rating or read date from a reading log is recorded as the reading of the book, and identifiers
+// such as an ISBN are recorded by type.
+type ImportBook struct {
+	Book
+	Collections []string          `json:"collections,omitempty"`
+	Rating      float64           `json:"rating,omitempty"`
+	ReadDate    Date              `json:"read_date"`
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+}
+
+// Identifier is a value that identifies a book in a scheme such as isbn, goodreads or amazon
+type Identifier struct {
+	BookTitle string `json:"book_title"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+}
+
+// Reading is the rating out of 5, in half stars, and read date of a book, zero values are unknown
+type Reading struct {
+	BookTitle string  `json:"book_title"`
+	Rating    float64 `json:"rating,omitempty"`
+	ReadDate  Date    `json:"read_date"`
+}
+
+// ImportRow is the outcome of importing one book, Index is its position in the request
+type ImportRow struct {
+	Index  int    `json:"index"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport is the outcome of a bulk import. Nothing is imported if any row failed or on a dry run.
+type ImportReport struct {
+	DryRun    bool `json:"dry_run"`
+	Created   int  `json:"created"`
+	Updated   int  `json:"updated"`
+	Unchanged int  `json:"unchanged"`
+	Skipped   int  `json:"skipped"`
+	Failed    int  `json:"failed"`
+	// CollectionsCreated and AddedToCollections count the collections created and the books added to collections
+	CollectionsCreated int `json:"collections_created"`
+	AddedToCollections int `json:"added_to_collections"`
+	// ReadingsRecorded counts the books whose rating or read date was recorded or changed
+	ReadingsRecorded int `json:"readings_recorded"`
+	// IdentifiersRecorded counts the identifiers that were recorded or changed
+	IdentifiersRecorded int         `json:"identifiers_recorded"`
+	Rows                []ImportRow `json:"rows"`
+}
+
+// Add records the outcome of a row and counts it by status
+func (report *ImportReport) Add(row ImportRow) {
+	switch row.Status {
+	case ImportCreated:
+		report.Created++
+	case ImportUpdated:
+		report.Updated++
+	case ImportUnchanged:
+		report.Unchanged++
+	case ImportSkipped:
+		report.Skipped++
+	case ImportFailed:
+		report.Failed++
+	}
+	report.Rows = append(report.Rows, row)
+}
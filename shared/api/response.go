@@ -1,21 +1,49 @@
 package api
 
-import "time"
-
 var PublishTimeLayoutDMY = "2006-01-02"
 
+// MergePatchContentType is the content type of JSON Merge Patch (RFC 7396) request bodies
+var MergePatchContentType = "application/merge-patch+json"
+
 type Book struct {
-	Title       string    `json:"title"`
-	Author      string    `json:"author"`
-	PublishDate time.Time `json:"publish_date"`
-	Edition     string    `json:"edition"`
-	Description string    `json:"description"`
-	Genre       string    `json:"genre"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	PublishDate Date   `json:"publish_date"`
+	Edition     string `json:"edition"`
+	Publisher   string `json:"publisher"`
+	Description string `json:"description"`
+	Genre       string `json:"genre"`
+	Version     int    `json:"version,omitempty"`
 }
 
 type Response struct {
-	Type       string `json:"type"`
-	StatusCode int    `json:"status_code"`
-	Message    string `json:"message"`
-	Data       any    `json:"data"`
+	Type       string      `json:"type"`
+	StatusCode int         `json:"status_code"`
+	Code       string      `json:"code,omitempty"`
+	Message    string      `json:"message"`
+	Data       any         `json:"data"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination is returned by list endpoints, NextCursor is empty on the last page
+type Pagination struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int    `json:"total"`
+}
+
+type Collection struct {
+	Name string `json:"name"`
+}
+
+type CollectionSubscription struct {
+	CollectionName string `json:"collection_name"`
+	BookTitle      string `json:"book_title"`
+}
+
+// SearchResult is a book matching a full-text search, with its relevance and the matching text.
+// Matched words in the headline are wrapped in <b></b>.
+type SearchResult struct {
+	Book     Book    `json:"book"`
+	Rank     float64 `json:"rank"`
+	Headline string  `json:"headline"`
 }
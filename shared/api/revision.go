@@ -0,0 +1,12 @@
+package api
+
+import "time"
+
+// BookRevision is a numbered snapshot of a book record
+type BookRevision struct {
+	Revision  int       `json:"revision"`
+	Actor     string    `json:"actor"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Book      Book      `json:"book"`
+}
@@ -0,0 +1,15 @@
+package api
+
+// Stats are the library statistics returned by the stats endpoint
+type Stats struct {
+	TotalBooks       int `json:"total_books"`
+	TotalCollections int `json:"total_collections"`
+	// BooksPerGenre is most common first, books without a genre are counted under a nil value
+	BooksPerGenre []FacetValue `json:"books_per_genre"`
+	// BooksPerCollection includes empty collections, largest first
+	BooksPerCollection []FacetValue `json:"books_per_collection"`
+	// AdditionsPerMonth counts the books created in each of the last months as YYYY-MM, oldest first
+	AdditionsPerMonth []FacetValue `json:"additions_per_month"`
+	// PublishYears is a histogram of publish years, oldest first, books without a publish date are not counted
+	PublishYears []FacetValue `json:"publish_years"`
+}
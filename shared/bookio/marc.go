@@ -0,0 +1,255 @@
+package bookio
+
+import (
+	"bms/shared/api"
+	"bms/shared/marc"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// marcFields maps the subfields of the MARC fields read as books to book fields. Only the first
+// occurrence of each field is read, except for 520 summaries which are joined.
+var marcFields = map[string]map[byte]string{
+	"100": {'a': "author"},
+	"245": {'a': "title", 'b': "title"},
+	"250": {'a': "edition"},
+	"260": {'b': "publisher", 'c': "publish_date"},
+	"264": {'b': "publisher", 'c': "publish_date"},
+	"520": {'a': "description"},
+	"650": {'a': "genre"},
+}
+
+// marcIgnoredFields are control fields identifying the record in the catalogue it came from
+var marcIgnoredFields = map[string]bool{"001": true, "003": true, "005": true}
+
+// yearPattern finds the year in a publication date such as "c1954." or "[1954?]"
+var yearPattern = regexp.MustCompile(`\d{4}`)
+
+// isMARCLeader reports whether data starts with the record length and fixed positions of an ISO 2709 leader
+func isMARCLeader(data []byte) bool {
+	if len(data) < 24 {
+		return false
+	}
+	for _, c := range data[:5] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return string(data[20:24]) == "4500"
+}
+
+// readMARC reads every record with read and maps it to book fields
+func readMARC(read func() (marc.Record, error)) ([]Record, error) {
+	var records []Record
+	for {
+		record, err := read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values, unmapped := marcValues(record)
+		records = append(records, Record{Row: len(records) + 1, Values: values, Unmapped: unmapped})
+	}
+}
+
+// marcValues maps a MARC record to book field values, and lists the fields and subfields that
+// were not mapped as a tag, or a tag and subfield code such as 245$c
+func marcValues(record marc.Record) (map[string]string, []string) {
+	values := map[string]string{}
+	var unmapped []string
+	reported := map[string]bool{}
+	report := func(name string) {
+		if !reported[name] {
+			reported[name] = true
+			unmapped = append(unmapped, name)
+		}
+	}
+
+	// RDA records describe publication in 264 with second indicator 1, older records in 260
+	publication := -1
+	for i, field := range record.Fields {
+		if field.Tag == "264" && field.Ind2 == '1' {
+			publication = i
+			break
+		}
+		if (field.Tag == "260" || field.Tag == "264") && publication == -1 {
+			publication = i
+		}
+	}
+
+	read := map[string]bool{}
+	fixedDate := ""
+	var descriptions []string
+	for i, field := range record.Fields {
+		switch {
+		case marcIgnoredFields[field.Tag]:
+			continue
+		case field.Tag == "008":
+			// the first date of the fixed length data is used if there is no publication date
+			if len(field.Value) >= 11 && yearPattern.MatchString(field.Value[7:11]) {
+				fixedDate = field.Value[7:11]
+			}
+			continue
+		}
+
+		subfields, mapped := marcFields[field.Tag]
+		switch field.Tag {
+		case "260", "264":
+			mapped = i == publication
+		case "520":
+		default:
+			mapped = mapped && !read[field.Tag]
+		}
+		if !mapped {
+			report(field.Tag)
+			continue
+		}
+		read[field.Tag] = true
+
+		for _, subfield := range field.Subfields {
+			name, ok := subfields[subfield.Code]
+			if !ok {
+				report(field.Tag + "$" + string(subfield.Code))
+				continue
+			}
+
+			value := subfield.Value
+			switch name {
+			case "title":
+				value = cleanMARC(value, true)
+				if values[name] != "" {
+					value = values[name] + ": " + value
+				}
+			case "description":
+				descriptions = append(descriptions, strings.TrimSpace(value))
+				continue
+			case "publish_date":
+				date, ok := marcDate(value)
+				if !ok {
+					report(field.Tag + "$" + string(subfield.Code))
+					continue
+				}
+				value = date
+			case "edition":
+				value = cleanMARC(value, false)
+			default:
+				value = cleanMARC(value, true)
+			}
+			values[name] = value
+		}
+	}
+
+	if len(descriptions) > 0 {
+		values["description"] = strings.Join(descriptions, "\n\n")
+	}
+	if values["publish_date"] == "" && fixedDate != "" {
+		values["publish_date"] = fixedDate
+	}
+	return values, unmapped
+}
+
+// cleanMARC removes the ISBD punctuation that separates MARC subfields, such as the " /" after a
+// title. A final period is removed unless it ends an initial or abbreviation such as "J.R.R."
+func cleanMARC(value string, period bool) string {
+	value = strings.TrimRight(strings.TrimSpace(value), " /:;,=")
+	if period && strings.HasSuffix(value, ".") {
+		words := strings.Fields(value)
+		last := words[len(words)-1]
+		if len(last) > 2 && !strings.Contains(strings.TrimSuffix(last, "."), ".") {
+			value = strings.TrimSuffix(value, ".")
+		}
+	}
+	return value
+}
+
+// marcDate reads a publication date written as a bms date, or finds its year in a transcribed
+// date such as "c1954."
+func marcDate(value string) (string, bool) {
+	value = strings.Trim(strings.TrimSpace(value), "[].?")
+	if date, err := api.ParseDate(value); err == nil && !date.IsZero() {
+		return date.String(), true
+	}
+	year := yearPattern.FindString(value)
+	return year, year != ""
+}
+
+// MARCRecord maps a book to a MARC bibliographic record
+func MARCRecord(book api.Book) marc.Record {
+	record := marc.Record{Leader: marc.NewLeader()}
+	add := func(tag string, ind1 byte, ind2 byte, code byte, value string) {
+		if value != "" {
+			record.Fields = append(record.Fields, marc.Field{Tag: tag, Ind1: ind1, Ind2: ind2, Subfields: []marc.Subfield{{Code: code, Value: value}}})
+		}
+	}
+
+	// an author with a comma is entered by surname
+	authorType := byte('0')
+	if strings.Contains(book.Author, ",") {
+		authorType = '1'
+	}
+	add("100", authorType, ' ', 'a', book.Author)
+
+	// the first indicator records whether there is a main entry, the second how many
+	// characters of a leading article to skip when filing the title
+	titleEntry := byte('0')
+	if book.Author != "" {
+		titleEntry = '1'
+	}
+	add("245", titleEntry, nonfilingCharacters(book.Title), 'a', book.Title)
+
+	add("250", ' ', ' ', 'a', book.Edition)
+	if book.Publisher != "" || !book.PublishDate.IsZero() {
+		field := marc.Field{Tag: "264", Ind1: ' ', Ind2: '1'}
+		if book.Publisher != "" {
+			field.Subfields = append(field.Subfields, marc.Subfield{Code: 'b', Value: book.Publisher})
+		}
+		if !book.PublishDate.IsZero() {
+			field.Subfields = append(field.Subfields, marc.Subfield{Code: 'c', Value: book.PublishDate.String()})
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	add("520", ' ', ' ', 'a', book.Description)
+	add("650", ' ', '4', 'a', book.Genre)
+	return record
+}
+
+// nonfilingCharacters returns the number of characters of a leading article, as a title indicator
+func nonfilingCharacters(title string) byte {
+	lower := strings.ToLower(title)
+	for _, article := range []string{"the ", "an ", "a "} {
+		if strings.HasPrefix(lower, article) {
+			return byte('0' + len(article))
+		}
+	}
+	return '0'
+}
+
+// marcWriter writes books as ISO 2709 records
+type marcWriter struct {
+	writer *marc.Writer
+}
+
+func (m *marcWriter) Write(book api.Book) error {
+	return m.writer.Write(MARCRecord(book))
+}
+
+func (m *marcWriter) Close() error {
+	return nil
+}
+
+// marcXMLWriter writes books as a MARCXML collection
+type marcXMLWriter struct {
+	writer *marc.XMLWriter
+}
+
+func (m *marcXMLWriter) Write(book api.Book) error {
+	return m.writer.Write(MARCRecord(book))
+}
+
+func (m *marcXMLWriter) Close() error {
+	return m.writer.Close()
+}
@@ -0,0 +1,387 @@
+// Package bookio reads and writes books in the file formats used for bulk import and export
+package bookio
+
+import (
+	"bms/shared/api"
+	"bms/shared/marc"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is a file format books are imported from or exported to
+type Format string
+
+const (
+	CSV    Format = "csv"
+	JSON   Format = "json"
+	NDJSON Format = "ndjson"
+	// YAML is only written, books are not imported from YAML
+	YAML    Format = "yaml"
+	MARC    Format = "marc"
+	MARCXML Format = "marcxml"
+)
+
+// Fields are the columns of a book file in the order they are written
+var Fields = []string{"title", "author", "publish_date", "edition", "publisher", "description", "genre"}
+
+// ignoredFields are columns written by exports that are not imported
+var ignoredFields = map[string]bool{"version": true}
+
+// byteOrderMark starts UTF-8 files saved by some spreadsheet programs
+const byteOrderMark = "\uFEFF"
+
+// DropColumn is the mapping target of a column that is not imported
+const DropColumn = "-"
+
+// DetectFormat returns the format of a file from its extension, or from its first bytes
+// for standard input and unknown extensions
+func DetectFormat(name string, head []byte) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return CSV, nil
+	case ".json":
+		return JSON, nil
+	case ".ndjson", ".jsonl":
+		return NDJSON, nil
+	case ".mrc", ".marc":
+		return MARC, nil
+	case ".xml", ".marcxml":
+		return MARCXML, nil
+	}
+
+	head = bytes.TrimSpace(bytes.TrimPrefix(head, []byte(byteOrderMark)))
+	switch {
+	case len(head) == 0:
+		return "", errors.New("cannot detect the format of an empty file")
+	case head[0] == '[':
+		return JSON, nil
+	case head[0] == '{':
+		return NDJSON, nil
+	case head[0] == '<':
+		return MARCXML, nil
+	case isMARCLeader(head):
+		return MARC, nil
+	}
+	return CSV, nil
+}
+
+// Record is a row of a book file by column name. Row counts records from 1 and Line is the line the
+// record starts on, or 0 if unknown. Unmapped lists the parts of a MARC record that have no book field.
+type Record struct {
+	Row      int
+	Line     int
+	Values   map[string]string
+	Unmapped []string
+}
+
+// Position describes where the record is in its file for error messages
+func (r Record) Position() string {
+	if r.Line > 0 {
+		return fmt.Sprintf("row %d (line %d)", r.Row, r.Line)
+	}
+	return fmt.Sprintf("row %d", r.Row)
+}
+
+// ReadRecords reads every record of a CSV file with a header row, a JSON array of objects,
+// newline delimited JSON objects, or MARC21 records in ISO 2709 or MARCXML
+func ReadRecords(r io.Reader, format Format) ([]Record, error) {
+	switch format {
+	case CSV:
+		return readCSV(r)
+	case JSON:
+		return readJSON(r)
+	case NDJSON:
+		return readNDJSON(r)
+	case MARC:
+		return readMARC(marc.NewReader(r).Read)
+	case MARCXML:
+		return readMARC(marc.NewXMLReader(r).Read)
+	}
+	return nil, fmt.Errorf("cannot read format %q, must be csv, json, ndjson, marc or marcxml", format)
+}
+
+// readCSV reads records keyed by the header row, column names are not case sensitive
+func readCSV(r io.Reader) ([]Record, error) {
+	return ReadDelimited(r, ',')
+}
+
+// ReadDelimited reads a CSV file whose fields are separated by comma, such as a tab for TSV files.
+// Column names are the header row in lower case.
+func ReadDelimited(r io.Reader, comma rune) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	// tab separated files are usually written without quoting
+	reader.LazyQuotes = comma == '\t'
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV file has no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, byteOrderMark)
+		}
+		columns[i] = strings.ToLower(strings.TrimSpace(name))
+		if columns[i] == "" {
+			return nil, fmt.Errorf("CSV header column %d has no name", i+1)
+		}
+		if seen[columns[i]] {
+			return nil, fmt.Errorf("CSV header column %d repeats column %q", i+1, columns[i])
+		}
+		seen[columns[i]] = true
+	}
+
+	var records []Record
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		record := Record{Row: len(records) + 1, Line: line, Values: make(map[string]string, len(columns))}
+		for i, column := range columns {
+			record.Values[column] = values[i]
+		}
+		records = append(records, record)
+	}
+}
+
+// readJSON reads a JSON array of objects
+func readJSON(r io.Reader) ([]Record, error) {
+	var objects []map[string]json.RawMessage
+	err := json.NewDecoder(r).Decode(&objects)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON, must be an array of objects: %w", err)
+	}
+
+	records := make([]Record, len(objects))
+	for i, object := range objects {
+		records[i] = Record{Row: i + 1}
+		records[i].Values, err = objectValues(object)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", records[i].Position(), err)
+		}
+	}
+	return records, nil
+}
+
+// readNDJSON reads one JSON object per line, blank lines are skipped
+func readNDJSON(r io.Reader) ([]Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		record := Record{Row: len(records) + 1, Line: i + 1}
+		var object map[string]json.RawMessage
+		err := json.Unmarshal(line, &object)
+		if err == nil && object == nil {
+			err = errors.New("must be an object")
+		}
+		if err == nil {
+			record.Values, err = objectValues(object)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid JSON: %w", record.Position(), err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// objectValues converts the values of a JSON object to strings with lower case keys.
+// null is empty and nested values are rejected.
+func objectValues(object map[string]json.RawMessage) (map[string]string, error) {
+	values := make(map[string]string, len(object))
+	for key, raw := range object {
+		var value any
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+
+		key = strings.ToLower(key)
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("field %q is repeated", key)
+		}
+		switch value := value.(type) {
+		case nil:
+			values[key] = ""
+		case string:
+			values[key] = value
+		case json.Number:
+			values[key] = value.String()
+		case bool:
+			values[key] = strconv.FormatBool(value)
+		default:
+			return nil, fmt.Errorf("field %q must be a string, number or boolean", key)
+		}
+	}
+	return values, nil
+}
+
+// ParseMapping parses column mappings written as column=field, a field of - drops the column. A column
+// is mapped once and a field from one column, so that a book does not depend on the order of the columns.
+func ParseMapping(pairs []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(pairs))
+	columns := map[string]string{}
+	for _, pair := range pairs {
+		column, field, ok := strings.Cut(pair, "=")
+		column = strings.ToLower(strings.TrimSpace(column))
+		field = strings.TrimSpace(field)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid mapping %q, must be column=field", pair)
+		}
+		if field != DropColumn && !isField(field) {
+			return nil, fmt.Errorf("invalid mapping %q, field must be one of %s or %s", pair, strings.Join(Fields, ", "), DropColumn)
+		}
+		if _, ok := mapping[column]; ok {
+			return nil, fmt.Errorf("invalid mapping %q, column %q is already mapped", pair, column)
+		}
+		if other, ok := columns[field]; ok && field != DropColumn {
+			return nil, fmt.Errorf("invalid mapping %q, column %q is already mapped to %s", pair, other, field)
+		}
+		mapping[column] = field
+		columns[field] = column
+	}
+	return mapping, nil
+}
+
+// isField reports whether name is a book field
+func isField(name string) bool {
+	for _, field := range Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RowError is a record that is not a valid book
+type RowError struct {
+	Record Record
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Record.Position(), e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// UnknownColumns returns the columns of the records that are neither book fields nor mapped, in order of appearance
+func UnknownColumns(records []Record, mapping map[string]string) []string {
+	seen := map[string]bool{}
+	var unknown []string
+	for _, record := range records {
+		columns := make([]string, 0, len(record.Values))
+		for column := range record.Values {
+			columns = append(columns, column)
+		}
+		// map order is random, so unknown columns of a record are listed alphabetically
+		sort.Strings(columns)
+		for _, column := range columns {
+			if _, ok := mapping[column]; ok || isField(column) || ignoredFields[column] || seen[column] {
+				continue
+			}
+			seen[column] = true
+			unknown = append(unknown, column)
+		}
+	}
+	return unknown
+}
+
+// DuplicateFields returns an error naming two columns of the records that are imported as the same
+// field, such as a column mapped to title next to a title column
+func DuplicateFields(records []Record, mapping map[string]string) error {
+	for _, record := range records {
+		columns := make([]string, 0, len(record.Values))
+		for column := range record.Values {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		fields := map[string]string{}
+		for _, column := range columns {
+			field := column
+			if mapped, ok := mapping[column]; ok {
+				field = mapped
+			}
+			if !isField(field) {
+				continue
+			}
+			if other, ok := fields[field]; ok {
+				return fmt.Errorf("columns %q and %q are both imported as %s", other, column, field)
+			}
+			fields[field] = column
+		}
+	}
+	return nil
+}
+
+// ToBook converts a record to a book with the column mapping. Unknown columns are ignored and
+// columns imported as the same field conflict, use UnknownColumns and DuplicateFields to reject
+// them first.
+func ToBook(record Record, mapping map[string]string) (api.Book, error) {
+	var book api.Book
+	for column, value := range record.Values {
+		field := column
+		if mapped, ok := mapping[column]; ok {
+			field = mapped
+		}
+
+		value = strings.TrimSpace(value)
+		switch field {
+		case "title":
+			book.Title = value
+		case "author":
+			book.Author = value
+		case "publish_date":
+			date, err := api.ParseDate(value)
+			if err != nil {
+				return api.Book{}, &RowError{Record: record, Err: fmt.Errorf("%s: %w", column, err)}
+			}
+			book.PublishDate = date
+		case "edition":
+			book.Edition = value
+		case "publisher":
+			book.Publisher = value
+		case "description":
+			book.Description = value
+		case "genre":
+			book.Genre = value
+		}
+	}
+
+	if book.Title == "" {
+		return api.Book{}, &RowError{Record: record, Err: errors.New("title cannot be empty")}
+	}
+	return book, nil
+}
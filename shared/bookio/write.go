@@ -0,0 +1,187 @@
+package bookio
+
+import (
+	"bms/shared/api"
+	"bms/shared/marc"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// exportFields are the columns of an export, the version is written for reference and ignored on import
+var exportFields = append(append([]string{}, Fields...), "version")
+
+// Writer writes books one at a time, so that an export never holds more than one book in memory
+type Writer interface {
+	// Write writes a book
+	Write(book api.Book) error
+	// Close finishes the file, such as closing a JSON array, but does not close the underlying writer
+	Close() error
+}
+
+// NewWriter returns a Writer for the format
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case CSV:
+		return &csvWriter{writer: csv.NewWriter(w)}, nil
+	case JSON:
+		return &jsonWriter{w: w}, nil
+	case NDJSON:
+		return &ndjsonWriter{encoder: json.NewEncoder(w)}, nil
+	case YAML:
+		return &yamlWriter{w: w}, nil
+	case MARC:
+		return &marcWriter{writer: marc.NewWriter(w)}, nil
+	case MARCXML:
+		return &marcXMLWriter{writer: marc.NewXMLWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("invalid format %q, must be csv, json, ndjson, yaml, marc or marcxml", format)
+}
+
+// ContentType returns the media type of a format
+func ContentType(format Format) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case JSON:
+		return "application/json"
+	case NDJSON:
+		return "application/x-ndjson"
+	case YAML:
+		return "application/yaml"
+	case MARC:
+		return "application/marc"
+	case MARCXML:
+		return "application/marcxml+xml"
+	}
+	return "application/octet-stream"
+}
+
+// bookValues returns the values of a book in exportFields order
+func bookValues(book api.Book) []string {
+	return []string{book.Title, book.Author, book.PublishDate.String(), book.Edition, book.Publisher, book.Description, book.Genre, strconv.Itoa(book.Version)}
+}
+
+// csvWriter writes a header row before the first book
+type csvWriter struct {
+	writer  *csv.Writer
+	started bool
+}
+
+func (c *csvWriter) Write(book api.Book) error {
+	if !c.started {
+		c.started = true
+		if err := c.writer.Write(exportFields); err != nil {
+			return err
+		}
+	}
+	if err := c.writer.Write(bookValues(book)); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvWriter) Close() error {
+	// an empty export still has a header row
+	if !c.started {
+		c.started = true
+		if err := c.writer.Write(exportFields); err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// jsonWriter writes an array with one book per line
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonWriter) Write(book api.Book) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if j.count == 0 {
+		separator = "[\n"
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", separator, data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// ndjsonWriter writes one book per line
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(book api.Book) error {
+	return n.encoder.Encode(book)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// yamlWriter writes a sequence of mappings. Strings are double quoted with JSON escapes, which
+// YAML reads the same way, so that values such as "yes" or "1954" stay strings.
+type yamlWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (y *yamlWriter) Write(book api.Book) error {
+	var builder strings.Builder
+	for i, value := range bookValues(book) {
+		prefix := "  "
+		if i == 0 {
+			prefix = "- "
+		}
+		field := exportFields[i]
+		switch {
+		case field == "version":
+			value = strconv.Itoa(book.Version)
+		case field == "publish_date" && value == "":
+			value = "null"
+		default:
+			value = yamlQuote(value)
+		}
+		fmt.Fprintf(&builder, "%s%s: %s\n", prefix, field, value)
+	}
+	y.count++
+	_, err := io.WriteString(y.w, builder.String())
+	return err
+}
+
+// yamlQuote double quotes a string with JSON escapes, leaving HTML characters such as & as they are
+func yamlQuote(value string) string {
+	var builder strings.Builder
+	encoder := json.NewEncoder(&builder)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+func (y *yamlWriter) Close() error {
+	if y.count == 0 {
+		_, err := io.WriteString(y.w, "[]\n")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+package citation
+
+import (
+	"bms/shared/api"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// bibtexMonths are the month macros defined by every BibTeX style
+var bibtexMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+// bibtexEscapes replaces the characters that are special to LaTeX
+var bibtexEscapes = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// writeBibTeX writes each entry as a @book. Values are written in UTF-8 with the LaTeX special
+// characters escaped, and the month as a macro so that styles can abbreviate it.
+func writeBibTeX(w io.Writer, entries []Entry) error {
+	buf := bufio.NewWriter(w)
+	for i, entry := range entries {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		book := entry.Book
+		fmt.Fprintf(buf, "@book{%s,\n", entry.Key)
+		writeBibTeXField(buf, "title", book.Title)
+		writeBibTeXField(buf, "author", strings.Join(splitAuthors(book.Author), " and "))
+		writeBibTeXField(buf, "edition", book.Edition)
+		writeBibTeXField(buf, "publisher", book.Publisher)
+		if !book.PublishDate.IsZero() {
+			writeBibTeXField(buf, "year", strconv.Itoa(book.PublishDate.Year))
+			if book.PublishDate.Precision != api.PrecisionYear {
+				fmt.Fprintf(buf, "  month = %s,\n", bibtexMonths[book.PublishDate.Month-1])
+			}
+			if book.PublishDate.Precision == api.PrecisionDay {
+				writeBibTeXField(buf, "date", book.PublishDate.String())
+			}
+		}
+		writeBibTeXField(buf, "abstract", book.Description)
+		writeBibTeXField(buf, "keywords", book.Genre)
+		buf.WriteString("}\n")
+	}
+	return buf.Flush()
+}
+
+// writeBibTeXField writes a braced field, skipping empty values. Line breaks become spaces, since a
+// blank line inside a value starts a new paragraph.
+func writeBibTeXField(w *bufio.Writer, name string, value string) {
+	if value == "" {
+		return
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	fmt.Fprintf(w, "  %s = {%s},\n", name, bibtexEscapes.Replace(value))
+}
+
+// bibtexParser reads BibTeX entries. It accepts braced, quoted and numeric values, macros defined by
+// @string and the month macros, and # concatenation. @comment and @preamble are skipped.
+type bibtexParser struct {
+	input   []rune
+	pos     int
+	macros  map[string]string
+	entries []Entry
+}
+
+// readBibTeX reads every entry in a BibTeX file as a book
+func readBibTeX(input string) ([]Entry, error) {
+	p := &bibtexParser{input: []rune(input), macros: map[string]string{}}
+	for i, month := range bibtexMonths {
+		p.macros[month] = strconv.Itoa(i + 1)
+	}
+
+	for {
+		// anything outside an entry is a comment
+		for p.pos < len(p.input) && p.input[p.pos] != '@' {
+			p.pos++
+		}
+		if p.pos >= len(p.input) {
+			return p.entries, nil
+		}
+		p.pos++
+		if err := p.parseEntry(); err != nil {
+			return nil, fmt.Errorf("entry %d (line %d): %w", len(p.entries)+1, p.line(), err)
+		}
+	}
+}
+
+// line returns the line number of the current position
+func (p *bibtexParser) line() int {
+	line := 1
+	for _, r := range p.input[:p.pos] {
+		if r == '\n' {
+			line++
+		}
+	}
+	return line
+}
+
+func (p *bibtexParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// identifier reads an entry type, key, field or macro name
+func (p *bibtexParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		if unicode.IsSpace(r) || strings.ContainsRune(`{}(),=#"`, r) {
+			break
+		}
+		p.pos++
+	}
+	return string(p.input[start:p.pos])
+}
+
+// expect skips spaces and reads the given character
+func (p *bibtexParser) expect(r rune) error {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return fmt.Errorf("expected %q, but the file ended", r)
+	}
+	if p.input[p.pos] != r {
+		return fmt.Errorf("expected %q, but got %q", r, p.input[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+func (p *bibtexParser) parseEntry() error {
+	p.skipSpace()
+	kind := strings.ToLower(p.identifier())
+	if err := p.expectOpen(); err != nil {
+		return err
+	}
+	closing := '}'
+	if p.input[p.pos-1] == '(' {
+		closing = ')'
+	}
+
+	switch kind {
+	case "comment", "preamble":
+		p.pos--
+		_, err := p.delimited(closing)
+		return err
+	case "string":
+		fields, err := p.fields(closing)
+		for name, value := range fields {
+			p.macros[name] = value
+		}
+		return err
+	}
+
+	p.skipSpace()
+	key := p.identifier()
+	if err := p.expect(','); err != nil {
+		// an entry with only a key has no fields
+		if p.pos < len(p.input) && p.input[p.pos] == closing {
+			p.pos++
+			return p.addEntry(key, nil)
+		}
+		return err
+	}
+	fields, err := p.fields(closing)
+	if err != nil {
+		return err
+	}
+	return p.addEntry(key, fields)
+}
+
+func (p *bibtexParser) expectOpen() error {
+	p.skipSpace()
+	if p.pos < len(p.input) && (p.input[p.pos] == '{' || p.input[p.pos] == '(') {
+		p.pos++
+		return nil
+	}
+	return fmt.Errorf("expected \"{\" after the entry type")
+}
+
+// fields reads name = value pairs separated by commas up to the closing delimiter of the entry
+func (p *bibtexParser) fields(closing rune) (map[string]string, error) {
+	fields := map[string]string{}
+	for {
+		p.skipSpace()
+		if p.pos < len(p.input) && p.input[p.pos] == closing {
+			p.pos++
+			return fields, nil
+		}
+		name := strings.ToLower(p.identifier())
+		if name == "" {
+			if p.pos >= len(p.input) {
+				return nil, fmt.Errorf("expected %q, but the file ended", closing)
+			}
+			return nil, fmt.Errorf("expected a field name, but got %q", p.input[p.pos])
+		}
+		if err := p.expect('='); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		fields[name] = value
+
+		p.skipSpace()
+		if p.pos < len(p.input) && p.input[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.input) || p.input[p.pos] != closing {
+			return nil, fmt.Errorf("expected \",\" or %q after field %s", closing, name)
+		}
+	}
+}
+
+// value reads a value made of parts joined by #
+func (p *bibtexParser) value() (string, error) {
+	var builder strings.Builder
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return "", fmt.Errorf("expected a value, but the file ended")
+		}
+		switch r := p.input[p.pos]; {
+		case r == '{':
+			part, err := p.delimited('}')
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(part)
+		case r == '"':
+			part, err := p.delimited('"')
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(part)
+		default:
+			name := p.identifier()
+			if name == "" {
+				return "", fmt.Errorf("expected a value, but got %q", r)
+			}
+			if _, err := strconv.Atoi(name); err == nil {
+				builder.WriteString(name)
+			} else if macro, ok := p.macros[strings.ToLower(name)]; ok {
+				builder.WriteString(macro)
+			} else {
+				return "", fmt.Errorf("undefined macro %q", name)
+			}
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != '#' {
+			return builder.String(), nil
+		}
+		p.pos++
+	}
+}
+
+// delimited reads the text between the delimiters, keeping nested braces for the LaTeX decoding
+func (p *bibtexParser) delimited(closing rune) (string, error) {
+	start := p.pos + 1
+	depth := 0
+	for p.pos++; p.pos < len(p.input); p.pos++ {
+		switch r := p.input[p.pos]; {
+		case r == '\\':
+			p.pos++
+		case r == '{':
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+		case r == closing && depth == 0:
+			p.pos++
+			return string(p.input[start : p.pos-1]), nil
+		}
+	}
+	return "", fmt.Errorf("expected %q, but the file ended", closing)
+}
+
+// addEntry converts the fields of an entry to a book. The date field is preferred over year and month.
+func (p *bibtexParser) addEntry(key string, fields map[string]string) error {
+	for name, value := range fields {
+		fields[name] = decodeLaTeX(value)
+	}
+
+	book := api.Book{
+		Title:       fields["title"],
+		Author:      fields["author"],
+		Edition:     fields["edition"],
+		Publisher:   fields["publisher"],
+		Description: fields["abstract"],
+		Genre:       firstKeyword(fields["keywords"]),
+	}
+	var err error
+	switch {
+	case fields["date"] != "":
+		book.PublishDate, err = api.ParseDate(fields["date"])
+	case fields["year"] != "":
+		value := fields["year"]
+		if month := fields["month"]; month != "" {
+			value += "-" + bibtexMonth(month)
+		}
+		book.PublishDate, err = api.ParseDate(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid date: %w", err)
+	}
+
+	p.entries = append(p.entries, Entry{Key: key, Book: book})
+	return nil
+}
+
+// bibtexMonth returns a month given as a number, macro or name as two digits
+func bibtexMonth(month string) string {
+	if number, err := strconv.Atoi(month); err == nil {
+		return fmt.Sprintf("%02d", number)
+	}
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), strings.ToLower(month)) {
+			return fmt.Sprintf("%02d", int(m))
+		}
+	}
+	return month
+}
+
+// firstKeyword returns the first keyword of a list separated by commas or semicolons
+func firstKeyword(keywords string) string {
+	keyword, _, _ := strings.Cut(strings.ReplaceAll(keywords, ";", ","), ",")
+	return strings.TrimSpace(keyword)
+}
+
+// latexAccents lists, for each accent command, pairs of a letter and the letter with the accent
+var latexAccents = map[rune]string{
+	'\'': "aáeéiíoóuúyýcćnńsśzźAÁEÉIÍOÓUÚYÝCĆNŃSŚZŹ",
+	'`':  "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+	'^':  "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+	'"':  "aäeëiïoöuüyÿAÄEËIÏOÖUÜ",
+	'~':  "aãnñoõAÃNÑOÕ",
+	'=':  "aāeēiīoōuūAĀEĒIĪOŌUŪ",
+	'.':  "zżZŻ",
+	'c':  "cçsşCÇSŞ",
+	'v':  "cčsšzžrřeěnňCČSŠZŽRŘEĚNŇ",
+	'H':  "oőuűOŐUŰ",
+	'u':  "aăgğAĂGĞ",
+}
+
+// latexSymbols are the commands that stand for a character
+var latexSymbols = map[string]string{
+	"textbackslash": `\`, "textasciitilde": "~", "textasciicircum": "^",
+	"ss": "ß", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ", "o": "ø", "O": "Ø",
+	"aa": "å", "AA": "Å", "l": "ł", "L": "Ł", "i": "i", "j": "j",
+}
+
+// decodeLaTeX turns escaped characters, accents and symbols into plain text and removes the braces
+// that protect capitalisation
+func decodeLaTeX(value string) string {
+	input := []rune(value)
+	var builder strings.Builder
+	for i := 0; i < len(input); i++ {
+		r := input[i]
+		switch {
+		case r == '{' || r == '}':
+		case r == '~':
+			builder.WriteRune(' ')
+		case r == '\\' && i+1 < len(input):
+			i++
+			command := input[i]
+			if letters, ok := latexAccents[command]; ok && (!unicode.IsLetter(command) || i+1 < len(input) && !unicode.IsLetter(input[i+1])) {
+				letter, next := accentArgument(input, i+1)
+				builder.WriteString(applyAccent(letters, letter))
+				i = next - 1
+				continue
+			}
+			if !unicode.IsLetter(command) {
+				builder.WriteRune(command)
+				continue
+			}
+			start := i
+			for i+1 < len(input) && unicode.IsLetter(input[i+1]) {
+				i++
+			}
+			name := string(input[start : i+1])
+			if symbol, ok := latexSymbols[name]; ok {
+				builder.WriteString(symbol)
+			}
+			// an empty group or a space ends a command name
+			if i+2 < len(input) && input[i+1] == '{' && input[i+2] == '}' {
+				i += 2
+			} else if i+1 < len(input) && input[i+1] == ' ' {
+				i++
+			}
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+// accentArgument returns the letter an accent applies to, written as x, {x} or {\i}, and the position after it
+func accentArgument(input []rune, i int) (string, int) {
+	for i < len(input) && input[i] == ' ' {
+		i++
+	}
+	if i >= len(input) {
+		return "", i
+	}
+	if input[i] != '{' {
+		return string(input[i]), i + 1
+	}
+	end := i + 1
+	for end < len(input) && input[end] != '}' {
+		end++
+	}
+	letter := strings.TrimPrefix(string(input[i+1:end]), `\`)
+	return letter, end + 1
+}
+
+// applyAccent returns the letter with the accent, or the letter unchanged if the pair is unknown
+func applyAccent(letters string, letter string) string {
+	pairs := []rune(letters)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if string(pairs[i]) == letter {
+			return string(pairs[i+1])
+		}
+	}
+	return letter
+}
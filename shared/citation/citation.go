@@ -0,0 +1,248 @@
+// Package citation writes books as BibTeX, RIS and CSL-JSON citations, and reads BibTeX and RIS entries as books
+package citation
+
+import (
+	"bms/shared/api"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Format is a citation format
+type Format string
+
+const (
+	BibTeX  Format = "bibtex"
+	RIS     Format = "ris"
+	CSLJSON Format = "csl-json"
+)
+
+// Entry is a book with the key it is cited by
+type Entry struct {
+	Key  string
+	Book api.Book
+}
+
+// ContentType returns the media type of a format
+func ContentType(format Format) string {
+	switch format {
+	case BibTeX:
+		return "application/x-bibtex; charset=utf-8"
+	case RIS:
+		return "application/x-research-info-systems; charset=utf-8"
+	case CSLJSON:
+		return "application/vnd.citationstyles.csl+json"
+	}
+	return "application/octet-stream"
+}
+
+// Extension returns the file extension of a format
+func Extension(format Format) string {
+	switch format {
+	case BibTeX:
+		return ".bib"
+	case RIS:
+		return ".ris"
+	}
+	return ".json"
+}
+
+// Write writes the entries in a format
+func Write(w io.Writer, format Format, entries []Entry) error {
+	switch format {
+	case BibTeX:
+		return writeBibTeX(w, entries)
+	case RIS:
+		return writeRIS(w, entries)
+	case CSLJSON:
+		return writeCSLJSON(w, entries)
+	}
+	return fmt.Errorf("invalid format %q, must be bibtex, ris or csl-json", format)
+}
+
+// DetectFormat returns the format of a citation file from its extension or its first bytes
+func DetectFormat(name string, data []byte) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".bib", ".bibtex":
+		return BibTeX, nil
+	case ".ris":
+		return RIS, nil
+	}
+
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\uFEFF")))
+	switch {
+	case bytes.HasPrefix(data, []byte("TY  -")):
+		return RIS, nil
+	case bytes.Contains(data, []byte("@")):
+		return BibTeX, nil
+	}
+	return "", errors.New("cannot detect the citation format, must be bibtex or ris")
+}
+
+// Read reads the entries of a BibTeX or RIS file
+func Read(r io.Reader, format Format) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case BibTeX:
+		return readBibTeX(string(data))
+	case RIS:
+		return readRIS(string(data))
+	}
+	return nil, fmt.Errorf("cannot read format %q, must be bibtex or ris", format)
+}
+
+// Name is a personal name split into family and given names, or a Literal name that cannot be split
+type Name struct {
+	Family  string
+	Given   string
+	Literal string
+}
+
+// Authors splits an author field into names. Several authors are separated by " and " as in
+// BibTeX, and each name is written "Family, Given" or "Given Family".
+func Authors(author string) []Name {
+	var names []Name
+	for _, part := range strings.Split(author, " and ") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if family, given, ok := strings.Cut(part, ","); ok {
+			names = append(names, Name{Family: strings.TrimSpace(family), Given: strings.TrimSpace(given)})
+			continue
+		}
+		words := strings.Fields(part)
+		if len(words) == 1 {
+			names = append(names, Name{Literal: part})
+			continue
+		}
+		names = append(names, Name{Family: words[len(words)-1], Given: strings.Join(words[:len(words)-1], " ")})
+	}
+	return names
+}
+
+// splitAuthors returns the authors of an author field as written
+func splitAuthors(author string) []string {
+	var authors []string
+	for _, part := range strings.Split(author, " and ") {
+		if part = strings.TrimSpace(part); part != "" {
+			authors = append(authors, part)
+		}
+	}
+	return authors
+}
+
+// Keys returns the citation keys of books: the family name of the first author, or the first word
+// of the title without an author, followed by the publish year or "nd". Books with the same key
+// also get the first word of their title, and books with the same word a short hash of their
+// title, so the key of a book does not depend on the order of the books.
+func Keys(books []api.Book) []string {
+	keys := make([]string, len(books))
+	counts := map[string]int{}
+	for i, book := range books {
+		keys[i] = keyName(book)
+		if book.PublishDate.IsZero() {
+			keys[i] += "nd"
+		} else {
+			keys[i] += strconv.Itoa(book.PublishDate.Year)
+		}
+		counts[keys[i]]++
+	}
+
+	for i, book := range books {
+		if counts[keys[i]] > 1 {
+			keys[i] += keyWord(book.Title)
+		}
+	}
+	counts = map[string]int{}
+	for _, key := range keys {
+		counts[key]++
+	}
+	for i, book := range books {
+		if counts[keys[i]] > 1 {
+			sum := sha256.Sum256([]byte(book.Title))
+			keys[i] += hex.EncodeToString(sum[:3])
+		}
+	}
+	return keys
+}
+
+// keyName returns the name part of a citation key
+func keyName(book api.Book) string {
+	name := ""
+	if authors := Authors(book.Author); len(authors) > 0 {
+		name = keyText(authors[0].Family)
+		if name == "" {
+			name = keyText(authors[0].Literal)
+		}
+	} else {
+		name = keyWord(book.Title)
+	}
+	if name == "" {
+		return "anon"
+	}
+	return name
+}
+
+// keyWord returns the first word of a title other than an article as keyText
+func keyWord(title string) string {
+	for _, word := range strings.Fields(title) {
+		if lower := strings.ToLower(word); lower == "the" || lower == "a" || lower == "an" {
+			continue
+		}
+		if text := keyText(word); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// keyText returns a value in lower case ASCII letters and digits
+func keyText(value string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(foldASCII(value)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// foldASCII replaces accented Latin letters with their base letter, so that Brontë is keyed as bronte
+func foldASCII(value string) string {
+	var builder strings.Builder
+	for _, r := range value {
+		if r <= unicode.MaxASCII {
+			builder.WriteRune(r)
+			continue
+		}
+		if base, ok := asciiFolds[r]; ok {
+			builder.WriteString(base)
+		}
+	}
+	return builder.String()
+}
+
+// asciiFolds maps accented letters to ASCII, built from the accent table used to decode LaTeX
+var asciiFolds = buildASCIIFolds()
+
+func buildASCIIFolds() map[rune]string {
+	folds := map[rune]string{'ß': "ss", 'æ': "ae", 'Æ': "AE", 'ø': "o", 'Ø': "O", 'å': "a", 'Å': "A", 'ł': "l", 'Ł': "L", 'œ': "oe", 'Œ': "OE"}
+	for _, letters := range latexAccents {
+		pairs := []rune(letters)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			folds[pairs[i+1]] = string(pairs[i])
+		}
+	}
+	return folds
+}
@@ -0,0 +1,67 @@
+package citation
+
+import (
+	"bms/shared/api"
+	"encoding/json"
+	"io"
+)
+
+// cslItem is a CSL-JSON item as read by citation processors such as citeproc
+type cslItem struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Author    []cslName `json:"author,omitempty"`
+	Issued    *cslDate  `json:"issued,omitempty"`
+	Edition   string    `json:"edition,omitempty"`
+	Publisher string    `json:"publisher,omitempty"`
+	Abstract  string    `json:"abstract,omitempty"`
+	Genre     string    `json:"genre,omitempty"`
+}
+
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+// cslDate holds the known parts of a date, so a year is [[1954]]
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// writeCSLJSON writes the entries as an array of CSL-JSON book items
+func writeCSLJSON(w io.Writer, entries []Entry) error {
+	items := make([]cslItem, len(entries))
+	for i, entry := range entries {
+		book := entry.Book
+		item := cslItem{
+			ID:        entry.Key,
+			Type:      "book",
+			Title:     book.Title,
+			Edition:   book.Edition,
+			Publisher: book.Publisher,
+			Abstract:  book.Description,
+			Genre:     book.Genre,
+		}
+		for _, name := range Authors(book.Author) {
+			item.Author = append(item.Author, cslName(name))
+		}
+		if !book.PublishDate.IsZero() {
+			parts := []int{book.PublishDate.Year}
+			if book.PublishDate.Precision != api.PrecisionYear {
+				parts = append(parts, int(book.PublishDate.Month))
+			}
+			if book.PublishDate.Precision == api.PrecisionDay {
+				parts = append(parts, book.PublishDate.Day)
+			}
+			item.Issued = &cslDate{DateParts: [][]int{parts}}
+		}
+		items[i] = item
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(items)
+}
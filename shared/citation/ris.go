@@ -0,0 +1,148 @@
+package citation
+
+import (
+	"bms/shared/api"
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// risTag matches a tagged RIS line, two characters followed by two spaces and a dash
+var risTag = regexp.MustCompile(`^([A-Z][A-Z0-9])  -(?: (.*))?$`)
+
+// writeRIS writes each entry as a BOOK record with one AU line per author
+func writeRIS(w io.Writer, entries []Entry) error {
+	buf := bufio.NewWriter(w)
+	for i, entry := range entries {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		book := entry.Book
+		writeRISField(buf, "TY", "BOOK")
+		writeRISField(buf, "ID", entry.Key)
+		writeRISField(buf, "TI", book.Title)
+		for _, author := range splitAuthors(book.Author) {
+			writeRISField(buf, "AU", author)
+		}
+		if !book.PublishDate.IsZero() {
+			writeRISField(buf, "PY", fmt.Sprintf("%04d", book.PublishDate.Year))
+			writeRISField(buf, "DA", risDate(book.PublishDate))
+		}
+		writeRISField(buf, "ET", book.Edition)
+		writeRISField(buf, "PB", book.Publisher)
+		writeRISField(buf, "AB", book.Description)
+		writeRISField(buf, "KW", book.Genre)
+		buf.WriteString("ER  - \n")
+	}
+	return buf.Flush()
+}
+
+// writeRISField writes a tagged line, skipping empty values. Values are joined onto one line since
+// RIS has no escaping for line breaks.
+func writeRISField(w *bufio.Writer, tag string, value string) {
+	if value = strings.Join(strings.Fields(value), " "); value != "" {
+		fmt.Fprintf(w, "%s  - %s\n", tag, value)
+	}
+}
+
+// risDate writes a date as YYYY/MM/DD/, leaving out the parts that are not known
+func risDate(date api.Date) string {
+	switch date.Precision {
+	case api.PrecisionMonth:
+		return fmt.Sprintf("%04d/%02d//", date.Year, date.Month)
+	case api.PrecisionDay:
+		return fmt.Sprintf("%04d/%02d/%02d/", date.Year, date.Month, date.Day)
+	}
+	return fmt.Sprintf("%04d///", date.Year)
+}
+
+// readRIS reads every record in a RIS file as a book. Untagged lines continue the previous value.
+func readRIS(input string) ([]Entry, error) {
+	var entries []Entry
+	var fields map[string][]string
+	var last string
+	start := 0
+	for i, line := range strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n") {
+		line = strings.TrimPrefix(line, "\uFEFF")
+		match := risTag.FindStringSubmatch(strings.TrimRight(line, " "))
+		if match == nil {
+			if values := fields[last]; len(values) > 0 && strings.TrimSpace(line) != "" {
+				values[len(values)-1] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+
+		tag, value := match[1], strings.TrimSpace(match[2])
+		switch {
+		case tag == "TY":
+			if fields != nil {
+				return nil, fmt.Errorf("entry %d (line %d): expected ER before TY", len(entries)+1, i+1)
+			}
+			fields = map[string][]string{}
+			start = i + 1
+		case fields == nil:
+			return nil, fmt.Errorf("entry %d (line %d): expected TY before %s", len(entries)+1, i+1, tag)
+		case tag == "ER":
+			entry, err := risEntry(fields)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d (line %d): %w", len(entries)+1, start, err)
+			}
+			entries = append(entries, entry)
+			fields = nil
+		default:
+			fields[tag] = append(fields[tag], value)
+		}
+		last = tag
+	}
+	if fields != nil {
+		return nil, fmt.Errorf("entry %d (line %d): expected ER before the end of the file", len(entries)+1, start)
+	}
+	return entries, nil
+}
+
+// risEntry converts the fields of a record to a book, accepting the older tags for each field
+func risEntry(fields map[string][]string) (Entry, error) {
+	first := func(tags ...string) string {
+		for _, tag := range tags {
+			if values := fields[tag]; len(values) > 0 && values[0] != "" {
+				return values[0]
+			}
+		}
+		return ""
+	}
+
+	var authors []string
+	for _, tag := range []string{"AU", "A1"} {
+		authors = append(authors, fields[tag]...)
+	}
+	book := api.Book{
+		Title:       first("TI", "T1", "BT"),
+		Author:      strings.Join(authors, " and "),
+		Edition:     first("ET"),
+		Publisher:   first("PB"),
+		Description: first("AB", "N2"),
+		Genre:       first("KW"),
+	}
+
+	// DA holds the full date, PY or Y1 often only the year
+	if date := first("DA", "PY", "Y1"); date != "" {
+		var parts []string
+		for _, part := range strings.Split(date, "/") {
+			if part == "" {
+				break
+			}
+			parts = append(parts, part)
+		}
+		if len(parts) > 3 {
+			parts = parts[:3]
+		}
+		var err error
+		book.PublishDate, err = api.ParseDate(strings.Join(parts, "-"))
+		if err != nil {
+			return Entry{}, fmt.Errorf("invalid date: %w", err)
+		}
+	}
+	return Entry{Key: first("ID"), Book: book}, nil
+}
@@ -0,0 +1,47 @@
+package dublincore
+
+import (
+	"bms/shared/api"
+	"strings"
+)
+
+// Namespace is the namespace of the Dublin Core elements
+const Namespace = "http://purl.org/dc/elements/1.1/"
+
+// Record holds the simple Dublin Core elements of a book. It is written inside the container element
+// of each protocol, such as oai_dc:dc, which declares the dc prefix.
+type Record struct {
+	Title       string   `xml:"dc:title"`
+	Creators    []string `xml:"dc:creator"`
+	Subject     string   `xml:"dc:subject,omitempty"`
+	Description string   `xml:"dc:description,omitempty"`
+	Publisher   string   `xml:"dc:publisher,omitempty"`
+	Date        string   `xml:"dc:date,omitempty"`
+	Type        string   `xml:"dc:type"`
+	Identifiers []string `xml:"dc:identifier"`
+}
+
+// FromBook returns the Dublin Core record of a book. Authors joined with " and " are separate creators,
+// the genre is the subject, and the publish date keeps its precision, so a year is written as 1954.
+// An ISBN from the book's identifiers is written as a urn:isbn identifier.
+func FromBook(book api.Book, identifiers map[string]string) Record {
+	record := Record{
+		Title:       book.Title,
+		Subject:     book.Genre,
+		Description: book.Description,
+		Publisher:   book.Publisher,
+		Type:        "Text",
+	}
+	for _, name := range strings.Split(book.Author, " and ") {
+		if name = strings.TrimSpace(name); name != "" {
+			record.Creators = append(record.Creators, name)
+		}
+	}
+	if !book.PublishDate.IsZero() {
+		record.Date = book.PublishDate.String()
+	}
+	if isbn := identifiers["isbn"]; isbn != "" {
+		record.Identifiers = append(record.Identifiers, "urn:isbn:"+isbn)
+	}
+	return record
+}
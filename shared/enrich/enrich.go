@@ -0,0 +1,77 @@
+// Package enrich looks up the metadata of books by ISBN from online catalogues, or from a fixture
+// file for offline use, to fill in the fields of a book
+package enrich
+
+import (
+	"bms/shared/api"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned by a provider that has no metadata for an ISBN
+var ErrNotFound = errors.New("no metadata found")
+
+// MetadataProvider looks up a book by its ISBN. The fields it does not know are left empty, and
+// ErrNotFound is returned for an ISBN it does not know at all.
+type MetadataProvider interface {
+	// Name names the provider in messages, such as Open Library
+	Name() string
+	LookupISBN(isbn string) (api.Book, error)
+}
+
+// NormalizeISBN removes the hyphens and spaces of an ISBN-10 or ISBN-13 and checks its check digit
+func NormalizeISBN(value string) (string, error) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(value)))
+
+	sum := 0
+	switch len(isbn) {
+	case 10:
+		// the digits are weighted 10 down to 1, and the check digit X is 10
+		for i, r := range isbn {
+			digit := int(r - '0')
+			if r == 'X' && i == 9 {
+				digit = 10
+			} else if r < '0' || r > '9' {
+				return "", fmt.Errorf("invalid ISBN %q", value)
+			}
+			sum += digit * (10 - i)
+		}
+		if sum%11 != 0 {
+			return "", fmt.Errorf("invalid ISBN %q, the check digit is wrong", value)
+		}
+	case 13:
+		// the digits are weighted 1 and 3 alternately
+		for i, r := range isbn {
+			if r < '0' || r > '9' {
+				return "", fmt.Errorf("invalid ISBN %q", value)
+			}
+			sum += int(r-'0') * (1 + 2*(i%2))
+		}
+		if sum%10 != 0 {
+			return "", fmt.Errorf("invalid ISBN %q, the check digit is wrong", value)
+		}
+	default:
+		return "", fmt.Errorf("invalid ISBN %q, must have 10 or 13 digits", value)
+	}
+	return isbn, nil
+}
+
+// Merge returns the book with the fields found by a provider. Only empty fields are filled, unless
+// overwrite is set, in which case every field the provider knows replaces the book's. The title is kept.
+func Merge(book api.Book, found api.Book, overwrite bool) api.Book {
+	fill := func(field *string, value string) {
+		if value != "" && (overwrite || *field == "") {
+			*field = value
+		}
+	}
+	fill(&book.Author, found.Author)
+	fill(&book.Edition, found.Edition)
+	fill(&book.Publisher, found.Publisher)
+	fill(&book.Description, found.Description)
+	fill(&book.Genre, found.Genre)
+	if !found.PublishDate.IsZero() && (overwrite || book.PublishDate.IsZero()) {
+		book.PublishDate = found.PublishDate
+	}
+	return book
+}
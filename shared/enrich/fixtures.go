@@ -0,0 +1,53 @@
+package enrich
+
+import (
+	"bms/shared/api"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// FixtureProvider looks up books in a JSON file, for offline use and tests
+type FixtureProvider struct {
+	path  string
+	books map[string]api.Book
+}
+
+// ReadFixtures reads a fixture file, a JSON object of books keyed by ISBN, such as
+// {"9780261103252": {"title": "The Lord of the Rings", "author": "J.R.R. Tolkien"}}
+func ReadFixtures(path string) (*FixtureProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var books map[string]api.Book
+	if err := json.Unmarshal(data, &books); err != nil {
+		return nil, fmt.Errorf("invalid fixture file %s: %w", path, err)
+	}
+
+	// keys are normalized so that fixtures may be written with hyphens
+	provider := &FixtureProvider{path: path, books: make(map[string]api.Book, len(books))}
+	for key, book := range books {
+		isbn, err := NormalizeISBN(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixture file %s: %w", path, err)
+		}
+		provider.books[isbn] = book
+	}
+	return provider, nil
+}
+
+// Name names the fixture file
+func (p *FixtureProvider) Name() string {
+	return p.path
+}
+
+// LookupISBN returns the book of an ISBN in the fixture file
+func (p *FixtureProvider) LookupISBN(isbn string) (api.Book, error) {
+	book, ok := p.books[isbn]
+	if !ok {
+		return api.Book{}, ErrNotFound
+	}
+	return book, nil
+}
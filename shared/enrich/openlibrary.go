@@ -0,0 +1,144 @@
+package enrich
+
+import (
+	"bms/shared/api"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OpenLibraryURL is the address of Open Library
+const OpenLibraryURL = "https://openlibrary.org"
+
+// OpenLibrary looks up books with the Open Library Books API
+type OpenLibrary struct {
+	// BaseURL is the address of Open Library, changed to test against a local server
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewOpenLibrary returns an Open Library provider that gives up on requests after ten seconds
+func NewOpenLibrary() *OpenLibrary {
+	return &OpenLibrary{BaseURL: OpenLibraryURL, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// openLibraryDetails is the edition in a Books API response with jscmd=details. The description is
+// either a string or a typed text object.
+type openLibraryDetails struct {
+	Details struct {
+		Title   string `json:"title"`
+		Authors []struct {
+			Name string `json:"name"`
+		} `json:"authors"`
+		Publishers  []string        `json:"publishers"`
+		PublishDate string          `json:"publish_date"`
+		Subjects    []string        `json:"subjects"`
+		Description json.RawMessage `json:"description"`
+		EditionName string          `json:"edition_name"`
+	} `json:"details"`
+}
+
+// openLibraryDateLayouts are the ways Open Library writes publish dates, with their precision
+var openLibraryDateLayouts = []struct {
+	layout    string
+	precision api.DatePrecision
+}{
+	{"2006-01-02", api.PrecisionDay},
+	{"January 2, 2006", api.PrecisionDay},
+	{"Jan 2, 2006", api.PrecisionDay},
+	{"2 January 2006", api.PrecisionDay},
+	{"January 2006", api.PrecisionMonth},
+	{"Jan 2006", api.PrecisionMonth},
+	{"2006-01", api.PrecisionMonth},
+}
+
+// openLibraryYear finds the year in a publish date written another way, such as "c1954"
+var openLibraryYear = regexp.MustCompile(`(?:^|\D)((?:1[5-9]|20)\d\d)(?:\D|$)`)
+
+// Name names Open Library
+func (p *OpenLibrary) Name() string {
+	return "Open Library"
+}
+
+// LookupISBN returns the edition of an ISBN. Authors are joined with " and ", the first subject is
+// the genre and the first publisher the publisher.
+func (p *OpenLibrary) LookupISBN(isbn string) (api.Book, error) {
+	params := url.Values{}
+	params.Set("bibkeys", "ISBN:"+isbn)
+	params.Set("format", "json")
+	params.Set("jscmd", "details")
+
+	resp, err := p.Client.Get(p.BaseURL + "/api/books?" + params.Encode())
+	if err != nil {
+		return api.Book{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return api.Book{}, fmt.Errorf("unexpected response from Open Library: %s", resp.Status)
+	}
+
+	// the response is keyed by the bibkey, and empty for an unknown ISBN
+	var editions map[string]openLibraryDetails
+	if err := json.NewDecoder(resp.Body).Decode(&editions); err != nil {
+		return api.Book{}, fmt.Errorf("unexpected response from Open Library: %w", err)
+	}
+	edition, ok := editions["ISBN:"+isbn]
+	if !ok {
+		return api.Book{}, ErrNotFound
+	}
+	details := edition.Details
+
+	book := api.Book{
+		Title:       strings.TrimSpace(details.Title),
+		Edition:     strings.TrimSpace(details.EditionName),
+		PublishDate: parseOpenLibraryDate(details.PublishDate),
+		Description: openLibraryText(details.Description),
+	}
+	var authors []string
+	for _, author := range details.Authors {
+		if name := strings.TrimSpace(author.Name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	book.Author = strings.Join(authors, " and ")
+	if len(details.Publishers) > 0 {
+		book.Publisher = strings.TrimSpace(details.Publishers[0])
+	}
+	if len(details.Subjects) > 0 {
+		book.Genre = strings.TrimSpace(details.Subjects[0])
+	}
+	return book, nil
+}
+
+// parseOpenLibraryDate parses a publish date to the precision it is written in, falling back to the year
+// found in it. A date that cannot be read is unknown.
+func parseOpenLibraryDate(value string) api.Date {
+	value = strings.TrimSpace(value)
+	for _, format := range openLibraryDateLayouts {
+		if t, err := time.Parse(format.layout, value); err == nil {
+			return api.DateFromTime(t, format.precision)
+		}
+	}
+	if match := openLibraryYear.FindStringSubmatch(value); match != nil {
+		date, _ := api.ParseDate(match[1])
+		return date
+	}
+	return api.Date{}
+}
+
+// openLibraryText reads a text field written as a string or as {"type": "/type/text", "value": "..."}
+func openLibraryText(data json.RawMessage) string {
+	var text string
+	if json.Unmarshal(data, &text) != nil {
+		var typed struct {
+			Value string `json:"value"`
+		}
+		json.Unmarshal(data, &typed)
+		text = typed.Value
+	}
+	return strings.TrimSpace(text)
+}
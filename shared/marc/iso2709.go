@@ -0,0 +1,192 @@
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ISO 2709 delimiters
+const (
+	subfieldDelimiter = 0x1F
+	fieldTerminator   = 0x1E
+	recordTerminator  = 0x1D
+)
+
+const (
+	leaderLength         = 24
+	directoryEntryLength = 12
+)
+
+// Reader reads binary MARC21 records one at a time
+type Reader struct {
+	reader *bufio.Reader
+	count  int
+}
+
+// NewReader returns a Reader of ISO 2709 records
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Read returns the next record, or io.EOF after the last record
+func (r *Reader) Read() (Record, error) {
+	data, err := r.reader.ReadBytes(recordTerminator)
+	// line breaks between records are written by some tools
+	data = bytes.TrimLeft(data, "\r\n")
+	if err == io.EOF && len(bytes.TrimSpace(data)) == 0 {
+		return Record{}, io.EOF
+	}
+	r.count++
+	if err == io.EOF {
+		return Record{}, fmt.Errorf("record %d: missing record terminator", r.count)
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	record, err := parseRecord(data)
+	if err != nil {
+		return Record{}, fmt.Errorf("record %d: %w", r.count, err)
+	}
+	return record, nil
+}
+
+// parseRecord parses a record ending with the record terminator
+func parseRecord(data []byte) (Record, error) {
+	if len(data) < leaderLength+1 {
+		return Record{}, errors.New("record is shorter than its leader")
+	}
+	leader := string(data[:leaderLength])
+	// position 9 is a for UCS/Unicode, a blank is MARC-8, which would be garbled if read as UTF-8
+	if leader[9] != 'a' {
+		return Record{}, fmt.Errorf("character coding %q is not supported, only UTF-8 records (a in leader position 9) can be read", leader[9])
+	}
+	baseAddress, ok := parseDigits(leader[12:17])
+	if !ok || baseAddress <= leaderLength || baseAddress > len(data) {
+		return Record{}, fmt.Errorf("invalid base address %q", leader[12:17])
+	}
+
+	directory := data[leaderLength : baseAddress-1]
+	if len(directory)%directoryEntryLength != 0 || data[baseAddress-1] != fieldTerminator {
+		return Record{}, errors.New("invalid directory")
+	}
+
+	record := Record{Leader: leader}
+	for entry := 0; entry < len(directory); entry += directoryEntryLength {
+		tag := string(directory[entry : entry+3])
+		length, lengthOK := parseDigits(string(directory[entry+3 : entry+7]))
+		start, startOK := parseDigits(string(directory[entry+7 : entry+12]))
+		end := baseAddress + start + length
+		if !lengthOK || !startOK || length < 1 || end > len(data) {
+			return Record{}, fmt.Errorf("invalid directory entry for field %s", tag)
+		}
+
+		// the field data ends with a field terminator, which is not part of the value
+		value := data[baseAddress+start : end-1]
+		field, err := parseField(tag, value)
+		if err != nil {
+			return Record{}, err
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record, nil
+}
+
+// parseDigits parses a number of the leader or directory, which is only digits without a sign
+func parseDigits(value string) (int, bool) {
+	if value == "" {
+		return 0, false
+	}
+	number := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, false
+		}
+		number = number*10 + int(value[i]-'0')
+	}
+	return number, true
+}
+
+// parseField parses the data of a field without its terminator
+func parseField(tag string, value []byte) (Field, error) {
+	field := Field{Tag: tag}
+	if field.IsControl() {
+		field.Value = string(value)
+		return field, nil
+	}
+
+	if len(value) < 2 {
+		return Field{}, fmt.Errorf("field %s has no indicators", tag)
+	}
+	field.Ind1, field.Ind2 = value[0], value[1]
+	for _, subfield := range bytes.Split(value[2:], []byte{subfieldDelimiter}) {
+		if len(subfield) == 0 {
+			continue
+		}
+		field.Subfields = append(field.Subfields, Subfield{Code: subfield[0], Value: string(subfield[1:])})
+	}
+	return field, nil
+}
+
+// Writer writes binary MARC21 records
+type Writer struct {
+	writer io.Writer
+}
+
+// NewWriter returns a Writer of ISO 2709 records
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: w}
+}
+
+// Write writes a record in UTF-8, computing the record length, base address and directory
+func (w *Writer) Write(record Record) error {
+	var directory, fields bytes.Buffer
+	for _, field := range record.Fields {
+		start := fields.Len()
+		if field.IsControl() {
+			fields.WriteString(field.Value)
+		} else {
+			fields.WriteByte(indicator(field.Ind1))
+			fields.WriteByte(indicator(field.Ind2))
+			for _, subfield := range field.Subfields {
+				fields.WriteByte(subfieldDelimiter)
+				fields.WriteByte(subfield.Code)
+				fields.WriteString(subfield.Value)
+			}
+		}
+		fields.WriteByte(fieldTerminator)
+
+		length := fields.Len() - start
+		if len(field.Tag) != 3 || length > 9999 {
+			return fmt.Errorf("field %q cannot be written as ISO 2709", field.Tag)
+		}
+		fmt.Fprintf(&directory, "%s%04d%05d", field.Tag, length, start)
+	}
+	directory.WriteByte(fieldTerminator)
+
+	baseAddress := leaderLength + directory.Len()
+	recordLength := baseAddress + fields.Len() + 1
+	if recordLength > 99999 {
+		return errors.New("record is longer than 99999 bytes")
+	}
+
+	leader := []byte(record.Leader)
+	if len(leader) != leaderLength {
+		leader = []byte(NewLeader())
+	}
+	copy(leader[0:5], fmt.Sprintf("%05d", recordLength))
+	// records are always written in UTF-8, whatever coding the leader was read with
+	leader[9] = 'a'
+	copy(leader[12:17], fmt.Sprintf("%05d", baseAddress))
+
+	data := make([]byte, 0, recordLength)
+	data = append(data, leader...)
+	data = append(data, directory.Bytes()...)
+	data = append(data, fields.Bytes()...)
+	data = append(data, recordTerminator)
+	_, err := w.writer.Write(data)
+	return err
+}
@@ -0,0 +1,67 @@
+// Package marc reads and writes bibliographic records in MARC21, as ISO 2709 binary records or as MARCXML
+package marc
+
+import (
+	"strings"
+)
+
+// Record is a MARC record, a leader followed by control and data fields in order
+type Record struct {
+	Leader string
+	Fields []Field
+}
+
+// Field is a control field (tags 001 to 009) with a Value, or a data field with indicators and subfields
+type Field struct {
+	Tag       string
+	Value     string
+	Ind1      byte
+	Ind2      byte
+	Subfields []Subfield
+}
+
+// Subfield is a subfield of a data field, identified by a single character code
+type Subfield struct {
+	Code  byte
+	Value string
+}
+
+// IsControl reports whether the field is a control field, which has a value instead of subfields
+func (f Field) IsControl() bool {
+	return strings.HasPrefix(f.Tag, "00")
+}
+
+// Subfield returns the value of the first subfield with the code, or "" if there is none
+func (f Field) Subfield(code byte) string {
+	for _, subfield := range f.Subfields {
+		if subfield.Code == code {
+			return subfield.Value
+		}
+	}
+	return ""
+}
+
+// FieldsByTag returns the fields of the record with the tag, in order
+func (r Record) FieldsByTag(tag string) []Field {
+	var fields []Field
+	for _, field := range r.Fields {
+		if field.Tag == tag {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// NewLeader returns the leader of a new bibliographic record for a book (language material,
+// monograph) encoded in UTF-8. The length and base address are set when the record is written.
+func NewLeader() string {
+	return "00000nam a2200000 i 4500"
+}
+
+// indicator returns a blank for an unset indicator
+func indicator(value byte) byte {
+	if value == 0 {
+		return ' '
+	}
+	return value
+}
@@ -0,0 +1,176 @@
+package marc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Namespace is the MARCXML slim schema namespace
+const Namespace = "http://www.loc.gov/MARC21/slim"
+
+// xmlRecord is the MARCXML representation of a record
+type xmlRecord struct {
+	XMLName       xml.Name          `xml:"record"`
+	Leader        string            `xml:"leader"`
+	ControlFields []xmlControlField `xml:"controlfield"`
+	DataFields    []xmlDataField    `xml:"datafield"`
+}
+
+type xmlControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlDataField struct {
+	Tag       string        `xml:"tag,attr"`
+	Ind1      string        `xml:"ind1,attr"`
+	Ind2      string        `xml:"ind2,attr"`
+	Subfields []xmlSubfield `xml:"subfield"`
+}
+
+type xmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// XMLReader reads the record elements of a MARCXML document, in a collection element or on their own
+type XMLReader struct {
+	decoder *xml.Decoder
+	count   int
+}
+
+// NewXMLReader returns a reader of MARCXML records
+func NewXMLReader(r io.Reader) *XMLReader {
+	return &XMLReader{decoder: xml.NewDecoder(r)}
+}
+
+// Read returns the next record, or io.EOF after the last record
+func (r *XMLReader) Read() (Record, error) {
+	for {
+		token, err := r.decoder.Token()
+		if err != nil {
+			return Record{}, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+
+		r.count++
+		var element xmlRecord
+		err = r.decoder.DecodeElement(&element, &start)
+		if err != nil {
+			return Record{}, fmt.Errorf("record %d: %w", r.count, err)
+		}
+		return element.record()
+	}
+}
+
+// record converts the element to a record. Elements are grouped by kind in MARCXML, so control
+// fields are placed before data fields as in ISO 2709.
+func (e xmlRecord) record() (Record, error) {
+	record := Record{Leader: e.Leader}
+	for _, control := range e.ControlFields {
+		record.Fields = append(record.Fields, Field{Tag: control.Tag, Value: control.Value})
+	}
+	for _, data := range e.DataFields {
+		field := Field{Tag: data.Tag, Ind1: xmlIndicator(data.Ind1), Ind2: xmlIndicator(data.Ind2)}
+		for _, subfield := range data.Subfields {
+			if len(subfield.Code) != 1 {
+				return Record{}, fmt.Errorf("field %s has invalid subfield code %q", data.Tag, subfield.Code)
+			}
+			field.Subfields = append(field.Subfields, Subfield{Code: subfield.Code[0], Value: subfield.Value})
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record, nil
+}
+
+// xmlIndicator returns the indicator of an attribute, a blank if it is empty
+func xmlIndicator(value string) byte {
+	if value == "" {
+		return ' '
+	}
+	return value[0]
+}
+
+// XMLWriter writes records in a MARCXML collection element
+type XMLWriter struct {
+	writer  io.Writer
+	encoder *xml.Encoder
+	started bool
+	records int
+}
+
+// NewXMLWriter returns a writer of a MARCXML collection, Close must be called to end it
+func NewXMLWriter(w io.Writer) *XMLWriter {
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("  ", "  ")
+	return &XMLWriter{writer: w, encoder: encoder}
+}
+
+// start writes the XML declaration and opens the collection element
+func (w *XMLWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	_, err := fmt.Fprintf(w.writer, "%s<collection xmlns=\"%s\">\n", xml.Header, Namespace)
+	return err
+}
+
+// Write writes a record
+func (w *XMLWriter) Write(record Record) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	// the encoder starts every record after the first on a new line
+	w.records++
+	return w.encoder.Encode(xmlElement(record))
+}
+
+// xmlElement returns the MARCXML representation of a record
+func xmlElement(record Record) xmlRecord {
+	element := xmlRecord{Leader: record.Leader}
+	if element.Leader == "" {
+		element.Leader = NewLeader()
+	}
+	for _, field := range record.Fields {
+		if field.IsControl() {
+			element.ControlFields = append(element.ControlFields, xmlControlField{Tag: field.Tag, Value: field.Value})
+			continue
+		}
+		data := xmlDataField{Tag: field.Tag, Ind1: string(indicator(field.Ind1)), Ind2: string(indicator(field.Ind2))}
+		for _, subfield := range field.Subfields {
+			data.Subfields = append(data.Subfields, xmlSubfield{Code: string(subfield.Code), Value: subfield.Value})
+		}
+		element.DataFields = append(element.DataFields, data)
+	}
+	return element
+}
+
+// XMLElement marshals a record as a MARCXML record element declaring the MARCXML namespace, to be
+// embedded in other XML documents such as SRU responses
+type XMLElement struct {
+	Record Record
+}
+
+// MarshalXML writes the record element, whatever the name of the field holding it
+func (e XMLElement) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	return encoder.EncodeElement(xmlElement(e.Record), xml.StartElement{Name: xml.Name{Space: Namespace, Local: "record"}})
+}
+
+// Close ends the collection element
+func (w *XMLWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	end := "</collection>\n"
+	if w.records > 0 {
+		end = "\n" + end
+	}
+	_, err := io.WriteString(w.writer, end)
+	return err
+}
@@ -0,0 +1,280 @@
+package oai
+
+import (
+	"bms/shared/dublincore"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Namespace is the namespace of OAI-PMH responses
+	Namespace = "http://www.openarchives.org/OAI/2.0/"
+	// DCNamespace is the namespace of the oai_dc container of Dublin Core records
+	DCNamespace = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	// DCSchema is the schema of oai_dc records
+	DCSchema = "http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+	// DCPrefix is the metadata prefix of Dublin Core records, the only format served
+	DCPrefix = "oai_dc"
+	// Granularity is the granularity of datestamps, to the second
+	Granularity = "YYYY-MM-DDThh:mm:ssZ"
+
+	xsiNamespace   = "http://www.w3.org/2001/XMLSchema-instance"
+	schemaLocation = Namespace + " http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd"
+)
+
+// the verbs of OAI-PMH requests
+const (
+	VerbIdentify            = "Identify"
+	VerbListMetadataFormats = "ListMetadataFormats"
+	VerbListSets            = "ListSets"
+	VerbListIdentifiers     = "ListIdentifiers"
+	VerbListRecords         = "ListRecords"
+	VerbGetRecord           = "GetRecord"
+)
+
+// the error codes of OAI-PMH responses
+const (
+	ErrBadArgument             = "badArgument"
+	ErrBadResumptionToken      = "badResumptionToken"
+	ErrBadVerb                 = "badVerb"
+	ErrCannotDisseminateFormat = "cannotDisseminateFormat"
+	ErrIDDoesNotExist          = "idDoesNotExist"
+	ErrNoRecordsMatch          = "noRecordsMatch"
+	ErrNoSetHierarchy          = "noSetHierarchy"
+)
+
+// Response is an OAI-PMH response, holding either errors or the element of the verb
+type Response struct {
+	XMLName             xml.Name             `xml:"OAI-PMH"`
+	Xmlns               string               `xml:"xmlns,attr"`
+	XmlnsXSI            string               `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string               `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string               `xml:"responseDate"`
+	Request             Request              `xml:"request"`
+	Errors              []Error              `xml:"error"`
+	Identify            *Identify            `xml:"Identify,omitempty"`
+	ListMetadataFormats *ListMetadataFormats `xml:"ListMetadataFormats,omitempty"`
+	ListSets            *ListSets            `xml:"ListSets,omitempty"`
+	GetRecord           *GetRecord           `xml:"GetRecord,omitempty"`
+	ListIdentifiers     *ListIdentifiers     `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *ListRecords         `xml:"ListRecords,omitempty"`
+}
+
+// Request echoes the base URL and the arguments of the request. The arguments are left out of
+// badVerb and badArgument errors.
+type Request struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+// Error is an error condition of a request
+type Error struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+// Identify describes the repository
+type Identify struct {
+	RepositoryName    string   `xml:"repositoryName"`
+	BaseURL           string   `xml:"baseURL"`
+	ProtocolVersion   string   `xml:"protocolVersion"`
+	AdminEmails       []string `xml:"adminEmail"`
+	EarliestDatestamp string   `xml:"earliestDatestamp"`
+	DeletedRecord     string   `xml:"deletedRecord"`
+	Granularity       string   `xml:"granularity"`
+}
+
+// ListMetadataFormats lists the metadata formats records are available in
+type ListMetadataFormats struct {
+	Formats []MetadataFormat `xml:"metadataFormat"`
+}
+
+// MetadataFormat is a metadata format, identified in requests by its prefix
+type MetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+// ListSets lists the sets of the repository
+type ListSets struct {
+	Sets []Set `xml:"set"`
+}
+
+// Set is a group of records for selective harvesting
+type Set struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+// GetRecord holds a single record
+type GetRecord struct {
+	Record Record `xml:"record"`
+}
+
+// ListIdentifiers holds the headers of a page of records
+type ListIdentifiers struct {
+	Headers         []Header         `xml:"header"`
+	ResumptionToken *ResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+// ListRecords holds a page of records
+type ListRecords struct {
+	Records         []Record         `xml:"record"`
+	ResumptionToken *ResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+// Header identifies a record, a deleted record has the status deleted and no metadata
+type Header struct {
+	Status     string   `xml:"status,attr,omitempty"`
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+// Record is the header and the metadata of a book
+type Record struct {
+	Header   Header    `xml:"header"`
+	Metadata *Metadata `xml:"metadata,omitempty"`
+}
+
+// Metadata holds the Dublin Core record of a book in the oai_dc container
+type Metadata struct {
+	DC DC `xml:"oai_dc:dc"`
+}
+
+// DC is the oai_dc container of a Dublin Core record
+type DC struct {
+	XmlnsOAIDC     string `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string `xml:"xmlns:dc,attr"`
+	XmlnsXSI       string `xml:"xmlns:xsi,attr"`
+	SchemaLocation string `xml:"xsi:schemaLocation,attr"`
+	dublincore.Record
+}
+
+// NewMetadata returns the metadata of a record in oai_dc
+func NewMetadata(record dublincore.Record) *Metadata {
+	return &Metadata{DC: DC{
+		XmlnsOAIDC:     DCNamespace,
+		XmlnsDC:        dublincore.Namespace,
+		XmlnsXSI:       xsiNamespace,
+		SchemaLocation: DCNamespace + " " + DCSchema,
+		Record:         record,
+	}}
+}
+
+// ResumptionToken continues an incomplete list. The last page of a list has an empty token.
+// Cursor is the number of records returned before the page.
+type ResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+// Write writes a response as an XML document
+func Write(w io.Writer, response Response) error {
+	response.Xmlns = Namespace
+	response.XmlnsXSI = xsiNamespace
+	response.SchemaLocation = schemaLocation
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(response); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Datestamp formats a time at the granularity of the repository
+func Datestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// ParseDatestamp parses the from or until argument, a day or a time to the second. Until is
+// inclusive, so the end of the day is returned for a day, and dayGranularity tells them apart
+// so that from and until can be checked for the same granularity.
+func ParseDatestamp(value string, until bool) (t time.Time, dayGranularity bool, err error) {
+	if t, err := time.Parse("2006-01-02T15:04:05Z", value); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid datestamp %q, must be YYYY-MM-DD or YYYY-MM-DDThh:mm:ssZ", value)
+	}
+	if until {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, true, nil
+}
+
+// Identifier returns the OAI identifier of a book in the repository, such as oai:books.example.org:Dune
+func Identifier(repository string, title string) string {
+	return "oai:" + repository + ":" + url.PathEscape(title)
+}
+
+// Title returns the title of the book an identifier of the repository refers to
+func Title(repository string, identifier string) (string, bool) {
+	escaped, ok := strings.CutPrefix(identifier, "oai:"+repository+":")
+	if !ok {
+		return "", false
+	}
+	title, err := url.PathUnescape(escaped)
+	if err != nil || title == "" {
+		return "", false
+	}
+	return title, true
+}
+
+// setSpecSafe reports whether a byte may appear in a set spec unescaped. The unreserved URI characters
+// are allowed in set specs, except ~ which escapes other bytes and : which separates levels.
+func setSpecSafe(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_.!*'()", c) >= 0
+}
+
+// SetSpec returns the set spec of a collection. Bytes that may not appear in a set spec, such as
+// spaces, are written as ~ and two hex digits, so "Science Fiction" is Science~20Fiction.
+func SetSpec(collection string) string {
+	var spec strings.Builder
+	for i := 0; i < len(collection); i++ {
+		if c := collection[i]; setSpecSafe(c) {
+			spec.WriteByte(c)
+		} else {
+			fmt.Fprintf(&spec, "~%02X", c)
+		}
+	}
+	return spec.String()
+}
+
+// Collection returns the collection name of a set spec returned by SetSpec
+func Collection(spec string) (string, error) {
+	var name strings.Builder
+	for i := 0; i < len(spec); i++ {
+		c := spec[i]
+		if c == '~' && i+2 < len(spec) {
+			if b, err := strconv.ParseUint(spec[i+1:i+3], 16, 8); err == nil {
+				name.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		if !setSpecSafe(c) {
+			return "", fmt.Errorf("invalid set spec %q", spec)
+		}
+		name.WriteByte(c)
+	}
+	return name.String(), nil
+}
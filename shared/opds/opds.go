@@ -0,0 +1,195 @@
+package opds
+
+import (
+	"bms/shared/api"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// AtomNamespace is the namespace of Atom feeds, which OPDS catalogs are written in
+	AtomNamespace = "http://www.w3.org/2005/Atom"
+	// DCNamespace is the namespace of the Dublin Core terms used for book metadata in entries
+	DCNamespace = "http://purl.org/dc/terms/"
+	// OPDSNamespace is the namespace of the OPDS catalog extensions
+	OPDSNamespace = "http://opds-spec.org/2010/catalog"
+	// OpenSearchNamespace is the namespace of OpenSearch descriptions and result counts
+	OpenSearchNamespace = "http://a9.com/-/spec/opensearch/1.1/"
+)
+
+const (
+	// NavigationType is the media type of a feed whose entries link to other feeds
+	NavigationType = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	// AcquisitionType is the media type of a feed whose entries are books
+	AcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	// OpenSearchType is the media type of an OpenSearch description
+	OpenSearchType = "application/opensearchdescription+xml"
+)
+
+// link relations defined by Atom, OPDS and OpenSearch
+const (
+	RelSelf       = "self"
+	RelStart      = "start"
+	RelUp         = "up"
+	RelNext       = "next"
+	RelAlternate  = "alternate"
+	RelSubsection = "subsection"
+	RelSearch     = "search"
+	RelNew        = "http://opds-spec.org/sort/new"
+	// RelAcquisition links a book entry to a file of the book
+	RelAcquisition = "http://opds-spec.org/acquisition"
+	// RelImage and RelThumbnail link a book entry to its cover and a small version of it
+	RelImage     = "http://opds-spec.org/image"
+	RelThumbnail = "http://opds-spec.org/image/thumbnail"
+)
+
+// Feed is an OPDS catalog feed. Entries of a navigation feed link to other feeds, entries of an
+// acquisition feed are books.
+type Feed struct {
+	XMLName      xml.Name `xml:"feed"`
+	ID           string   `xml:"id"`
+	Title        string   `xml:"title"`
+	Updated      string   `xml:"updated"`
+	Author       *Person  `xml:"author,omitempty"`
+	TotalResults int      `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage int      `xml:"opensearch:itemsPerPage,omitempty"`
+	Links        []Link   `xml:"link"`
+	Entries      []Entry  `xml:"entry"`
+}
+
+// Entry is an entry of a feed, a book or a link to another feed
+type Entry struct {
+	Title      string     `xml:"title"`
+	ID         string     `xml:"id"`
+	Updated    string     `xml:"updated"`
+	Authors    []Person   `xml:"author"`
+	Publisher  string     `xml:"dc:publisher,omitempty"`
+	Issued     string     `xml:"dc:issued,omitempty"`
+	Categories []Category `xml:"category"`
+	Content    *Content   `xml:"content,omitempty"`
+	Links      []Link     `xml:"link"`
+}
+
+// Person is the author of a feed or an entry
+type Person struct {
+	Name string `xml:"name"`
+}
+
+// Link is a link from a feed or an entry to a feed, a book or a file, with the size in bytes of a file
+type Link struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Title  string `xml:"title,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+// Category classifies an entry, books are classified by genre
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// Content is the text content of an entry
+type Content struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// Timestamp formats a time as an Atom date
+func Timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// BookID returns the URN identifying a book in feeds. Titles are unique, so the title is the identifier.
+func BookID(title string) string {
+	return "urn:bms:book:" + url.PathEscape(title)
+}
+
+// BookEntry returns the entry of a book in an acquisition feed. Authors joined with " and " are
+// listed separately, and the genre is the category of the entry.
+func BookEntry(book api.Book, updated time.Time, links ...Link) Entry {
+	entry := Entry{
+		Title:     book.Title,
+		ID:        BookID(book.Title),
+		Updated:   Timestamp(updated),
+		Publisher: book.Publisher,
+		Links:     links,
+	}
+	if book.Author != "" {
+		for _, name := range strings.Split(book.Author, " and ") {
+			if name = strings.TrimSpace(name); name != "" {
+				entry.Authors = append(entry.Authors, Person{Name: name})
+			}
+		}
+	}
+	if !book.PublishDate.IsZero() {
+		entry.Issued = book.PublishDate.String()
+	}
+	if book.Genre != "" {
+		entry.Categories = []Category{{Term: book.Genre, Label: book.Genre}}
+	}
+	if book.Description != "" {
+		entry.Content = &Content{Type: "text", Text: book.Description}
+	}
+	return entry
+}
+
+// Write writes a feed as an XML document, declaring the namespaces of the extensions used in entries
+func Write(w io.Writer, feed Feed) error {
+	document := struct {
+		Feed
+		Xmlns           string `xml:"xmlns,attr"`
+		XmlnsDC         string `xml:"xmlns:dc,attr"`
+		XmlnsOPDS       string `xml:"xmlns:opds,attr"`
+		XmlnsOpenSearch string `xml:"xmlns:opensearch,attr"`
+	}{feed, AtomNamespace, DCNamespace, OPDSNamespace, OpenSearchNamespace}
+	return writeXML(w, document)
+}
+
+// OpenSearchDescription describes how a catalog is searched, its template has a {searchTerms} placeholder
+type OpenSearchDescription struct {
+	ShortName   string
+	Description string
+	Template    string
+}
+
+// WriteOpenSearch writes an OpenSearch description whose search results are acquisition feeds
+func WriteOpenSearch(w io.Writer, description OpenSearchDescription) error {
+	type searchURL struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	}
+	document := struct {
+		XMLName       xml.Name  `xml:"OpenSearchDescription"`
+		Xmlns         string    `xml:"xmlns,attr"`
+		ShortName     string    `xml:"ShortName"`
+		Description   string    `xml:"Description"`
+		InputEncoding string    `xml:"InputEncoding"`
+		URL           searchURL `xml:"Url"`
+	}{
+		Xmlns:         OpenSearchNamespace,
+		ShortName:     description.ShortName,
+		Description:   description.Description,
+		InputEncoding: "UTF-8",
+		URL:           searchURL{Type: AcquisitionType, Template: description.Template},
+	}
+	return writeXML(w, document)
+}
+
+// writeXML writes an indented XML document ending with a new line
+func writeXML(w io.Writer, document any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,159 @@
+package sources
+
+import (
+	"bms/shared/api"
+	"database/sql"
+	"fmt"
+	"html"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CalibreOptions choose how a Calibre library is mapped to collections
+type CalibreOptions struct {
+	// TagCollections adds books to a collection for each of their tags
+	TagCollections bool
+}
+
+// calibreUndefinedYear is the year Calibre stores for an unknown publish date
+const calibreUndefinedYear = 101
+
+// calibreQuery selects the fields of every book, with lists joined by a separator that does not appear in names
+const calibreQuery = `SELECT b.id, b.title, b.pubdate,
+	COALESCE((SELECT group_concat(name, char(31)) FROM (SELECT a.name FROM books_authors_link l
+		JOIN authors a ON a.id = l.author WHERE l.book = b.id ORDER BY l.id)), ''),
+	COALESCE((SELECT s.name FROM books_series_link l JOIN series s ON s.id = l.series WHERE l.book = b.id), ''),
+	COALESCE((SELECT group_concat(name, char(31)) FROM (SELECT t.name FROM books_tags_link l
+		JOIN tags t ON t.id = l.tag WHERE l.book = b.id ORDER BY l.id)), ''),
+	COALESCE((SELECT p.name FROM books_publishers_link l JOIN publishers p ON p.id = l.publisher WHERE l.book = b.id), ''),
+	COALESCE((SELECT c.text FROM comments c WHERE c.book = b.id), ''),
+	COALESCE((SELECT r.rating FROM books_ratings_link l JOIN ratings r ON r.id = l.rating WHERE l.book = b.id), 0),
+	COALESCE((SELECT group_concat(type || ':' || val, char(31)) FROM identifiers i WHERE i.book = b.id), '')
+	FROM books b ORDER BY b.id`
+
+// ReadCalibre reads the metadata.db of a Calibre library, given the library folder or the database file.
+// Authors are joined with " and ", the series becomes a collection, the first tag the genre, the
+// comments the description, and identifiers such as isbn are kept by type. Calibre ratings are out of
+// 10 and become half stars.
+func ReadCalibre(path string, options CalibreOptions) (Result, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "metadata.db")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return Result{}, fmt.Errorf("not a Calibre library, %w", err)
+	}
+
+	// the library is only read, so that Calibre may be running during the import
+	db, err := sql.Open("sqlite", "file:"+(&url.URL{Path: path}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return Result{}, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(calibreQuery)
+	if err != nil {
+		return Result{}, fmt.Errorf("not a Calibre library: %w", err)
+	}
+	defer rows.Close()
+
+	var result Result
+	seen := map[string]string{}
+	for rows.Next() {
+		var id, rating int
+		var title, authors, series, tags, publisher, comments, identifiers string
+		var pubdate sql.NullString
+		err := rows.Scan(&id, &title, &pubdate, &authors, &series, &tags, &publisher, &comments, &rating, &identifiers)
+		if err != nil {
+			return Result{}, err
+		}
+		position := fmt.Sprintf("book %d", id)
+
+		book := api.ImportBook{
+			Book: api.Book{
+				Title:       strings.TrimSpace(title),
+				Author:      strings.Join(splitCalibreList(authors), " and "),
+				Publisher:   publisher,
+				Description: plainText(comments),
+			},
+			Rating: float64(rating) / 2,
+		}
+		tagList := splitCalibreList(tags)
+		if len(tagList) > 0 {
+			book.Genre = tagList[0]
+		}
+		if series != "" {
+			book.Collections = append(book.Collections, series)
+		}
+		if options.TagCollections {
+			book.Collections = append(book.Collections, tagList...)
+		}
+		for _, identifier := range splitCalibreList(identifiers) {
+			if kind, value, ok := strings.Cut(identifier, ":"); ok && value != "" {
+				if book.Identifiers == nil {
+					book.Identifiers = map[string]string{}
+				}
+				book.Identifiers[kind] = value
+			}
+		}
+
+		book.PublishDate, err = calibreDate(pubdate.String)
+		if err != nil {
+			result.Skipped = append(result.Skipped, Skip{Position: position, Reason: err.Error()})
+			continue
+		}
+		result.addAt(position, book, seen)
+	}
+	return result, rows.Err()
+}
+
+// splitCalibreList splits a list joined by calibreQuery
+func splitCalibreList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, "\x1f")
+}
+
+// calibreDate reads a publish date stored as a timestamp, which is unknown in year 101. The driver
+// returns timestamp columns as times, which are scanned as RFC 3339.
+func calibreDate(value string) (api.Date, error) {
+	if value == "" {
+		return api.Date{}, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999-07:00", "2006-01-02 15:04:05-07:00", time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if t.Year() <= calibreUndefinedYear {
+				return api.Date{}, nil
+			}
+			return api.DateFromTime(t.UTC(), api.PrecisionDay), nil
+		}
+	}
+	return api.Date{}, fmt.Errorf("invalid publish date %q", value)
+}
+
+var (
+	// htmlParagraph and htmlBreak match the tags that end a paragraph or a line in Calibre comments
+	// and EPUB descriptions
+	htmlParagraph = regexp.MustCompile(`(?i)</(?:p|div|h[1-6]|ul|ol|blockquote)>`)
+	htmlBreak     = regexp.MustCompile(`(?i)<br\s*/?>|</li>`)
+	htmlTag       = regexp.MustCompile(`<[^>]*>`)
+	blankLine     = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// plainText turns the HTML of Calibre comments or an EPUB description into text, keeping paragraphs apart
+func plainText(comments string) string {
+	text := htmlParagraph.ReplaceAllString(comments, "\n\n")
+	text = htmlBreak.ReplaceAllString(text, "\n")
+	text = html.UnescapeString(htmlTag.ReplaceAllString(text, ""))
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	text = strings.TrimSpace(strings.Join(lines, "\n"))
+	return blankLine.ReplaceAllString(text, "\n\n")
+}
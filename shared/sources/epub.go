@@ -0,0 +1,213 @@
+package sources
+
+import (
+	"archive/zip"
+	"bms/shared/api"
+	"bms/shared/enrich"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// EPUBType is the media type of EPUB books, which the mimetype entry of the archive holds
+	EPUBType = "application/epub+zip"
+	// epubMaxEntryBytes bounds the container and package documents read, as archives are compressed
+	epubMaxEntryBytes = 1 << 20
+)
+
+// epubContainer is META-INF/container.xml, which names the OPF package document of an EPUB
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubPackage is the metadata of an OPF package document, in EPUB 2 or EPUB 3
+type epubPackage struct {
+	Metadata struct {
+		Titles       []epubElement `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Creators     []epubElement `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Dates        []epubElement `xml:"http://purl.org/dc/elements/1.1/ date"`
+		Descriptions []epubElement `xml:"http://purl.org/dc/elements/1.1/ description"`
+		Identifiers  []epubElement `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+		Publishers   []epubElement `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+		Subjects     []epubElement `xml:"http://purl.org/dc/elements/1.1/ subject"`
+		Metas        []struct {
+			Refines  string `xml:"refines,attr"`
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+// epubElement is a Dublin Core element. EPUB 2 qualifies elements with opf attributes, where EPUB 3
+// refines them with meta elements naming their id.
+type epubElement struct {
+	ID     string `xml:"id,attr"`
+	Role   string `xml:"http://www.idpf.org/2007/opf role,attr"`
+	Scheme string `xml:"http://www.idpf.org/2007/opf scheme,attr"`
+	Event  string `xml:"http://www.idpf.org/2007/opf event,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// IsEPUB reports whether a zip archive is an EPUB, whose first entry is a mimetype file holding its media type
+func IsEPUB(r io.ReaderAt, size int64) bool {
+	archive, err := zip.NewReader(r, size)
+	if err != nil || len(archive.File) == 0 || archive.File[0].Name != "mimetype" {
+		return false
+	}
+	data, err := readEntry(archive.File[0])
+	return err == nil && strings.TrimSpace(string(data)) == EPUBType
+}
+
+// ReadEPUB reads the metadata of an EPUB book from its OPF package document. The authors among its
+// creators are joined with "and", its description is turned from HTML into text, its first publisher
+// and subject are its publisher and genre, and an ISBN among its identifiers is kept as isbn.
+func ReadEPUB(r io.ReaderAt, size int64) (api.ImportBook, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return api.ImportBook{}, fmt.Errorf("not an EPUB: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var container epubContainer
+	if err := readXMLEntry(files, "META-INF/container.xml", &container); err != nil {
+		return api.ImportBook{}, err
+	}
+	rootfile := ""
+	for _, candidate := range container.Rootfiles {
+		if candidate.MediaType == "application/oebps-package+xml" || candidate.MediaType == "" {
+			rootfile = candidate.FullPath
+			break
+		}
+	}
+	if rootfile == "" {
+		return api.ImportBook{}, errors.New("not an EPUB, META-INF/container.xml names no package document")
+	}
+
+	var opf epubPackage
+	if err := readXMLEntry(files, path.Clean(rootfile), &opf); err != nil {
+		return api.ImportBook{}, err
+	}
+	metadata := opf.Metadata
+
+	// EPUB 3 gives the role of a creator and the scheme of an identifier in meta elements
+	roles := map[string]string{}
+	schemes := map[string]string{}
+	for _, meta := range metadata.Metas {
+		id := strings.TrimPrefix(meta.Refines, "#")
+		switch meta.Property {
+		case "role":
+			roles[id] = strings.TrimSpace(meta.Value)
+		case "identifier-type":
+			schemes[id] = strings.TrimSpace(meta.Value)
+		}
+	}
+
+	book := api.ImportBook{Book: api.Book{
+		Title:     firstValue(metadata.Titles),
+		Publisher: firstValue(metadata.Publishers),
+		Genre:     firstValue(metadata.Subjects),
+	}}
+
+	for _, description := range metadata.Descriptions {
+		// descriptions are often HTML, escaped in the element
+		if book.Description = plainText(description.Value); book.Description != "" {
+			break
+		}
+	}
+
+	var authors []string
+	for _, creator := range metadata.Creators {
+		role := creator.Role
+		if role == "" {
+			role = roles[creator.ID]
+		}
+		name := strings.Join(strings.Fields(creator.Value), " ")
+		if name != "" && (role == "" || role == "aut") {
+			authors = append(authors, name)
+		}
+	}
+	book.Author = strings.Join(authors, " and ")
+
+	for _, date := range metadata.Dates {
+		// EPUB 2 dates may be of other events, such as the creation of the file, and a date that
+		// cannot be read is left out rather than rejecting the book
+		if date.Event != "" && date.Event != "publication" {
+			continue
+		}
+		if published, err := parseDate(date.Value); err == nil && !published.IsZero() {
+			book.PublishDate = published
+			break
+		}
+	}
+
+	for _, identifier := range metadata.Identifiers {
+		scheme := identifier.Scheme
+		if scheme == "" {
+			scheme = schemes[identifier.ID]
+		}
+		value := strings.TrimSpace(identifier.Value)
+		if strings.HasPrefix(strings.ToLower(value), "urn:isbn:") {
+			value, scheme = value[len("urn:isbn:"):], "isbn"
+		}
+		// identifiers without a scheme are often ISBNs, which their check digit tells apart
+		if scheme != "" && !strings.EqualFold(scheme, "isbn") && scheme != "15" && scheme != "02" {
+			continue
+		}
+		if isbn, err := enrich.NormalizeISBN(value); err == nil {
+			book.Identifiers = map[string]string{"isbn": isbn}
+			break
+		}
+	}
+	return book, nil
+}
+
+// readXMLEntry decodes an XML file of an archive
+func readXMLEntry(files map[string]*zip.File, name string, v any) error {
+	file, ok := files[name]
+	if !ok {
+		return fmt.Errorf("not an EPUB, %s is missing", name)
+	}
+	data, err := readEntry(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", name, err)
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// readEntry reads a file of an archive, up to epubMaxEntryBytes
+func readEntry(file *zip.File) ([]byte, error) {
+	content, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	data, err := io.ReadAll(io.LimitReader(content, epubMaxEntryBytes+1))
+	if err == nil && len(data) > epubMaxEntryBytes {
+		err = fmt.Errorf("larger than %d KiB", epubMaxEntryBytes>>10)
+	}
+	return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), err
+}
+
+// firstValue returns the first non empty value of elements, with its spaces collapsed
+func firstValue(elements []epubElement) string {
+	for _, element := range elements {
+		if value := strings.Join(strings.Fields(element.Value), " "); value != "" {
+			return value
+		}
+	}
+	return ""
+}
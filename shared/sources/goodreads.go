@@ -0,0 +1,56 @@
+package sources
+
+import (
+	"bms/shared/api"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadGoodreads reads a Goodreads library export. The shelves of a book, including its exclusive shelf
+// such as read or to-read, become collections. A rating of 0 means the book was not rated.
+func ReadGoodreads(r io.Reader) (Result, error) {
+	records, err := readExport(r, "Goodreads", "title", "author", "my rating", "bookshelves", "exclusive shelf")
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	seen := map[string]string{}
+	for _, record := range records {
+		values := record.Values
+		book := api.ImportBook{
+			Book: api.Book{
+				Title:     strings.TrimSpace(values["title"]),
+				Author:    strings.TrimSpace(values["author"]),
+				Publisher: strings.TrimSpace(values["publisher"]),
+			},
+			Collections: splitList(values["bookshelves"], values["exclusive shelf"]),
+		}
+
+		// the edition year, or the year the work was first published
+		year := values["year published"]
+		if strings.TrimSpace(year) == "" {
+			year = values["original publication year"]
+		}
+		book.PublishDate, err = parseDate(year)
+		if err == nil {
+			book.ReadDate, err = parseDate(values["date read"])
+		}
+		if err != nil {
+			result.skip(record, err)
+			continue
+		}
+		if rating := strings.TrimSpace(values["my rating"]); rating != "" {
+			stars, err := strconv.Atoi(rating)
+			if err != nil || stars < 0 || stars > 5 {
+				result.skip(record, fmt.Errorf("invalid rating %q", rating))
+				continue
+			}
+			book.Rating = float64(stars)
+		}
+		result.add(record, book, seen)
+	}
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package sources
+
+import (
+	"bms/shared/api"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// libraryThingEdition matches the edition in the publication details, such as "Edition: 1st"
+var libraryThingEdition = regexp.MustCompile(`Edition:\s*([^,]+)`)
+
+// ReadLibraryThing reads a LibraryThing CSV or tab separated export. Collections, such as Your library
+// or Wishlist, become collections, and the first tag becomes the genre. Ratings may be in half stars.
+func ReadLibraryThing(r io.Reader) (Result, error) {
+	records, err := readExport(r, "LibraryThing", "title", "primary author", "rating", "collections")
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	seen := map[string]string{}
+	for _, record := range records {
+		values := record.Values
+		tags := splitList(values["tags"])
+		book := api.ImportBook{
+			Book: api.Book{
+				Title:  strings.TrimSpace(values["title"]),
+				Author: strings.TrimSpace(values["primary author"]),
+			},
+			Collections: splitList(values["collections"]),
+		}
+		if len(tags) > 0 {
+			book.Genre = tags[0]
+		}
+
+		// publication details are written as "Publisher (1954), Edition: 1st, Hardcover, 423 pages"
+		publication := values["publication"]
+		publisher, _, _ := strings.Cut(publication, "(")
+		publisher, _, _ = strings.Cut(publisher, ",")
+		book.Publisher = strings.TrimSpace(publisher)
+		if match := libraryThingEdition.FindStringSubmatch(publication); match != nil {
+			book.Edition = strings.TrimSpace(match[1])
+		}
+
+		book.PublishDate, err = parseDate(values["date"])
+		if err == nil {
+			book.ReadDate, err = parseDate(values["date read"])
+		}
+		if err != nil {
+			result.skip(record, err)
+			continue
+		}
+		if rating := strings.TrimSpace(values["rating"]); rating != "" {
+			stars, err := strconv.ParseFloat(rating, 64)
+			if err != nil || stars < 0 || stars > 5 {
+				result.skip(record, fmt.Errorf("invalid rating %q", rating))
+				continue
+			}
+			book.Rating = stars
+		}
+		result.add(record, book, seen)
+	}
+	return result, nil
+}
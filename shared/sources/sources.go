@@ -0,0 +1,117 @@
+// Package sources reads the exports of other book catalogues, such as Goodreads and LibraryThing,
+// as books to import
+package sources
+
+import (
+	"bms/shared/api"
+	"bms/shared/bookio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Entry is a book read from an export, with its position in the file for messages
+type Entry struct {
+	Position string
+	Book     api.ImportBook
+}
+
+// Skip is a row of an export that is not imported and the reason why
+type Skip struct {
+	Position string
+	Reason   string
+}
+
+// Result is what was read from an export
+type Result struct {
+	Entries []Entry
+	Skipped []Skip
+}
+
+// add records a book, skipping it if it has no title or its title was read before
+func (result *Result) add(record bookio.Record, book api.ImportBook, seen map[string]string) {
+	result.addAt(record.Position(), book, seen)
+}
+
+// addAt records a book found at a position in the export
+func (result *Result) addAt(position string, book api.ImportBook, seen map[string]string) {
+	if book.Title == "" {
+		result.Skipped = append(result.Skipped, Skip{Position: position, Reason: "title is empty"})
+		return
+	}
+	if first, ok := seen[book.Title]; ok {
+		result.Skipped = append(result.Skipped, Skip{Position: position, Reason: "title is repeated from " + first})
+		return
+	}
+	seen[book.Title] = position
+	result.Entries = append(result.Entries, Entry{Position: position, Book: book})
+}
+
+// skip records a row that cannot be imported
+func (result *Result) skip(record bookio.Record, err error) {
+	result.Skipped = append(result.Skipped, Skip{Position: record.Position(), Reason: err.Error()})
+}
+
+// readExport reads a comma or tab separated export, checking that it has the given columns
+func readExport(r io.Reader, source string, columns ...string) ([]bookio.Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	comma := ','
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(header, []byte("\t")) > bytes.Count(header, []byte(",")) {
+		comma = '\t'
+	}
+	records, err := bookio.ReadDelimited(bytes.NewReader(data), comma)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > 0 {
+		var missing []string
+		for _, column := range columns {
+			if _, ok := records[0].Values[column]; !ok {
+				missing = append(missing, fmt.Sprintf("%q", column))
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("not a %s export, the columns %s are missing", source, strings.Join(missing, ", "))
+		}
+	}
+	return records, nil
+}
+
+// splitList splits a comma separated list, dropping empty and repeated values
+func splitList(values ...string) []string {
+	var list []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" && !seen[item] {
+				seen[item] = true
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// datePattern matches a year, year and month, or full date separated by dashes or slashes
+var datePattern = regexp.MustCompile(`\d{4}(?:[-/]\d{2}(?:[-/]\d{2})?)?`)
+
+// parseDate reads the first date in a value such as "2023/05/14", "c1954" or "[2023-05]"
+func parseDate(value string) (api.Date, error) {
+	if strings.TrimSpace(value) == "" {
+		return api.Date{}, nil
+	}
+	match := datePattern.FindString(value)
+	if match == "" {
+		return api.Date{}, fmt.Errorf("invalid date %q", value)
+	}
+	return api.ParseDate(strings.ReplaceAll(match, "/", "-"))
+}
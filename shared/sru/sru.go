@@ -0,0 +1,248 @@
+package sru
+
+import (
+	"bms/shared/dublincore"
+	"bms/shared/marc"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Namespace is the namespace of SRU 1.2 responses
+	Namespace = "http://www.loc.gov/zing/srw/"
+	// DiagnosticNamespace is the namespace of SRU diagnostics
+	DiagnosticNamespace = "http://www.loc.gov/zing/srw/diagnostic/"
+	// ExplainNamespace is the namespace of ZeeRex explain records
+	ExplainNamespace = "http://explain.z3950.org/dtd/2.0/"
+	// DCNamespace is the namespace of the srw_dc container of Dublin Core records
+	DCNamespace = "info:srw/schema/1/dc-schema"
+	// Version is the version of the protocol served
+	Version = "1.2"
+)
+
+// the schemas records are returned in, identified in requests by their short name or identifier
+const (
+	SchemaDC      = "info:srw/schema/1/dc-v1.1"
+	SchemaMARCXML = "info:srw/schema/1/marcxml-v1.1"
+)
+
+// the SRU diagnostics of requests that cannot be served, queries have their own in package cql
+const (
+	DiagUnsupportedOperation  = 4
+	DiagUnsupportedVersion    = 5
+	DiagUnsupportedValue      = 6
+	DiagMandatoryParameter    = 7
+	DiagFirstRecordOutOfRange = 61
+	DiagUnknownSchema         = 66
+	DiagUnsupportedPacking    = 71
+	DiagSortUnsupported       = 80
+)
+
+// diagnosticMessages are the messages of the SRU diagnostics
+var diagnosticMessages = map[int]string{
+	DiagUnsupportedOperation:  "Unsupported operation",
+	DiagUnsupportedVersion:    "Unsupported version",
+	DiagUnsupportedValue:      "Unsupported parameter value",
+	DiagMandatoryParameter:    "Mandatory parameter not supplied",
+	DiagFirstRecordOutOfRange: "First record position out of range",
+	DiagUnknownSchema:         "Unknown schema for retrieval",
+	DiagUnsupportedPacking:    "Unsupported record packing",
+	DiagSortUnsupported:       "Sort not supported",
+}
+
+// SearchRetrieveResponse is the response to a searchRetrieve request
+type SearchRetrieveResponse struct {
+	XMLName            xml.Name     `xml:"searchRetrieveResponse"`
+	Xmlns              string       `xml:"xmlns,attr"`
+	Version            string       `xml:"version"`
+	NumberOfRecords    int          `xml:"numberOfRecords"`
+	Records            *Records     `xml:"records,omitempty"`
+	NextRecordPosition int          `xml:"nextRecordPosition,omitempty"`
+	Diagnostics        *Diagnostics `xml:"diagnostics,omitempty"`
+}
+
+// Records holds the records of a page of results
+type Records struct {
+	Records []Record `xml:"record"`
+}
+
+// Record is a result, or the explain record
+type Record struct {
+	Schema   string     `xml:"recordSchema"`
+	Packing  string     `xml:"recordPacking"`
+	Data     RecordData `xml:"recordData"`
+	Position int        `xml:"recordPosition,omitempty"`
+}
+
+// RecordData holds a record as XML, or for the string packing as escaped text
+type RecordData struct {
+	DC      *DC              `xml:"srw_dc:dc,omitempty"`
+	MARC    *marc.XMLElement `xml:"record,omitempty"`
+	Explain *Explain         `xml:"explain,omitempty"`
+	Text    string           `xml:",chardata"`
+}
+
+// DC is the srw_dc container of a Dublin Core record
+type DC struct {
+	XmlnsSRWDC string `xml:"xmlns:srw_dc,attr"`
+	XmlnsDC    string `xml:"xmlns:dc,attr"`
+	dublincore.Record
+}
+
+// NewDC returns a Dublin Core record in its srw_dc container
+func NewDC(record dublincore.Record) *DC {
+	return &DC{XmlnsSRWDC: DCNamespace, XmlnsDC: dublincore.Namespace, Record: record}
+}
+
+// Packed returns the record data for the string packing, the record written as escaped XML text
+func (d RecordData) Packed() (RecordData, error) {
+	var text strings.Builder
+	encoder := xml.NewEncoder(&text)
+	var err error
+	switch {
+	case d.DC != nil:
+		err = encoder.EncodeElement(d.DC, xml.StartElement{Name: xml.Name{Local: "srw_dc:dc"}})
+	case d.MARC != nil:
+		err = encoder.Encode(d.MARC)
+	}
+	if err == nil {
+		err = encoder.Flush()
+	}
+	return RecordData{Text: text.String()}, err
+}
+
+// Diagnostics holds the errors of a request
+type Diagnostics struct {
+	Diagnostics []Diagnostic `xml:"diagnostic"`
+}
+
+// Diagnostic is an error of a request, identified by the number in its URI
+type Diagnostic struct {
+	Xmlns   string `xml:"xmlns,attr"`
+	URI     string `xml:"uri"`
+	Details string `xml:"details,omitempty"`
+	Message string `xml:"message,omitempty"`
+}
+
+// NewDiagnostic returns the diagnostic with a number. The message is given for diagnostics of
+// other packages, and looked up for the diagnostics of this one when empty.
+func NewDiagnostic(code int, message string, details string) Diagnostic {
+	if message == "" {
+		message = diagnosticMessages[code]
+	}
+	return Diagnostic{
+		Xmlns:   DiagnosticNamespace,
+		URI:     fmt.Sprintf("info:srw/diagnostic/1/%d", code),
+		Details: details,
+		Message: message,
+	}
+}
+
+// ExplainResponse is the response to an explain request, describing the server in a ZeeRex record
+type ExplainResponse struct {
+	XMLName     xml.Name     `xml:"explainResponse"`
+	Xmlns       string       `xml:"xmlns,attr"`
+	Version     string       `xml:"version"`
+	Record      Record       `xml:"record"`
+	Diagnostics *Diagnostics `xml:"diagnostics,omitempty"`
+}
+
+// Explain is a ZeeRex record describing the indexes, schemas and limits of the server
+type Explain struct {
+	Xmlns        string       `xml:"xmlns,attr"`
+	ServerInfo   ServerInfo   `xml:"serverInfo"`
+	DatabaseInfo DatabaseInfo `xml:"databaseInfo"`
+	IndexInfo    IndexInfo    `xml:"indexInfo"`
+	SchemaInfo   SchemaInfo   `xml:"schemaInfo"`
+	ConfigInfo   ConfigInfo   `xml:"configInfo"`
+}
+
+// ServerInfo is where the server is reached
+type ServerInfo struct {
+	Protocol string `xml:"protocol,attr"`
+	Version  string `xml:"version,attr"`
+	Host     string `xml:"host"`
+	Port     string `xml:"port"`
+	Database string `xml:"database"`
+}
+
+// DatabaseInfo describes the catalogue
+type DatabaseInfo struct {
+	Title       string `xml:"title"`
+	Description string `xml:"description,omitempty"`
+}
+
+// IndexInfo lists the context sets and the indexes of queries
+type IndexInfo struct {
+	Sets    []ContextSet `xml:"set"`
+	Indexes []Index      `xml:"index"`
+}
+
+// ContextSet is a context set of index names, such as dc
+type ContextSet struct {
+	Name       string `xml:"name,attr"`
+	Identifier string `xml:"identifier,attr"`
+}
+
+// Index is an index of queries, named in a context set
+type Index struct {
+	Title string   `xml:"title"`
+	Map   IndexMap `xml:"map"`
+}
+
+// IndexMap names an index in a context set
+type IndexMap struct {
+	Name IndexName `xml:"name"`
+}
+
+// IndexName is the name of an index in a context set
+type IndexName struct {
+	Set  string `xml:"set,attr"`
+	Name string `xml:",chardata"`
+}
+
+// SchemaInfo lists the schemas records are returned in
+type SchemaInfo struct {
+	Schemas []Schema `xml:"schema"`
+}
+
+// Schema is a record schema, requested by its name or identifier
+type Schema struct {
+	Identifier string `xml:"identifier,attr"`
+	Name       string `xml:"name,attr"`
+	Title      string `xml:"title"`
+}
+
+// ConfigInfo holds the defaults and limits of requests
+type ConfigInfo struct {
+	Defaults []Setting `xml:"default"`
+	Settings []Setting `xml:"setting"`
+}
+
+// Setting is a default or a limit of requests
+type Setting struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Write writes a searchRetrieve or explain response as an XML document
+func Write(w io.Writer, response any) error {
+	switch r := response.(type) {
+	case *SearchRetrieveResponse:
+		r.Xmlns, r.Version = Namespace, Version
+	case *ExplainResponse:
+		r.Xmlns, r.Version = Namespace, Version
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(response); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
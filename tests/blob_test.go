@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"bms/server/blob"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestFileStore tests putting, replacing, reading and deleting blobs in a folder
+func TestFileStore(t *testing.T) {
+	store, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+
+	read := func(key string) string {
+		content, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("Error getting %s: %v", key, err)
+		}
+		defer content.Close()
+		data, err := io.ReadAll(content)
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", key, err)
+		}
+		return string(data)
+	}
+
+	for _, value := range []string{"first", "second"} {
+		if err := store.Put("covers/abc", strings.NewReader(value)); err != nil {
+			t.Fatalf("Error putting blob: %v", err)
+		}
+		if data := read("covers/abc"); data != value {
+			t.Errorf("Expected %q, but got %q", value, data)
+		}
+	}
+
+	if err := store.Delete("covers/abc"); err != nil {
+		t.Errorf("Error deleting blob: %v", err)
+	}
+	if _, err := store.Get("covers/abc"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, but got %v", err)
+	}
+	if err := store.Delete("covers/abc"); err != nil {
+		t.Errorf("Expected deleting a missing blob to succeed, but got %v", err)
+	}
+
+	for _, key := range []string{"", "../outside", "covers/../../outside", "/covers/abc", "covers//abc"} {
+		if err := store.Put(key, strings.NewReader("x")); err == nil {
+			t.Errorf("Expected an error putting key %q", key)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"bms/shared/bookio"
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBookioRoundTrip tests that exported books are imported unchanged in every readable format
+func TestBookioRoundTrip(t *testing.T) {
+	books := []api.Book{
+		{Title: "The Hobbit", Author: "J.R.R. Tolkien", PublishDate: api.NewDate(1937, time.September, 21), Genre: "Fantasy", Version: 3},
+		{Title: "Quotes, \"commas\"\nand lines", Description: "true", Edition: "1954", PublishDate: api.Date{Year: 1954, Precision: api.PrecisionYear}},
+		{Title: "Untitled draft"},
+		{Title: "A Wizard of Earthsea", Author: "Le Guin, Ursula K.", Publisher: "Parnassus Press", PublishDate: api.Date{Year: 1968, Month: time.November, Precision: api.PrecisionMonth}},
+	}
+
+	for _, format := range []bookio.Format{bookio.CSV, bookio.JSON, bookio.NDJSON, bookio.MARC, bookio.MARCXML} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			writer, err := bookio.NewWriter(&buf, format)
+			if err != nil {
+				t.Fatalf("Error creating writer: %v", err)
+			}
+			for _, book := range books {
+				if err := writer.Write(book); err != nil {
+					t.Fatalf("Error writing %q: %v", book.Title, err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Error closing writer: %v", err)
+			}
+
+			detected, err := bookio.DetectFormat("-", buf.Bytes())
+			if err != nil || detected != format {
+				t.Errorf("Expected to detect %v, but got %v (%v)", format, detected, err)
+			}
+
+			records, err := bookio.ReadRecords(&buf, format)
+			if err != nil {
+				t.Fatalf("Error reading records: %v", err)
+			}
+			if len(records) != len(books) {
+				t.Fatalf("Expected %d records, but got %d", len(books), len(records))
+			}
+			if unknown := bookio.UnknownColumns(records, nil); len(unknown) > 0 {
+				t.Errorf("Expected no unknown columns, but got %v", unknown)
+			}
+			for i, record := range records {
+				book, err := bookio.ToBook(record, nil)
+				if err != nil {
+					t.Fatalf("Error converting %s: %v", record.Position(), err)
+				}
+				// the version is exported for reference only
+				expected := books[i]
+				expected.Version = 0
+				if book != expected {
+					t.Errorf("Expected %+v, but got %+v", expected, book)
+				}
+			}
+		})
+	}
+}
+
+// TestMARCMapping tests how MARC fields are mapped to books and which fields are reported as unmapped
+func TestMARCMapping(t *testing.T) {
+	expected := []struct {
+		book     api.Book
+		unmapped []string
+	}{
+		{
+			book: api.Book{
+				Title:       "The fellowship of the ring: being the first part of The lord of the rings",
+				Author:      "Tolkien, J. R. R.",
+				Edition:     "2nd ed.",
+				Publisher:   "Allen & Unwin",
+				PublishDate: api.Date{Year: 1954, Precision: api.PrecisionYear},
+				Genre:       "Fantasy fiction",
+			},
+			unmapped: []string{"020", "100$d", "245$c", "260$a"},
+		},
+		{
+			// the publish date falls back to the first date of field 008
+			book: api.Book{
+				Title:       "A wizard of Earthsea",
+				Author:      "Le Guin, Ursula K.",
+				Publisher:   "Houghton Mifflin Harcourt",
+				PublishDate: api.Date{Year: 2013, Precision: api.PrecisionYear},
+				Description: "A young wizard learns the cost of power.",
+				Genre:       "Wizards",
+			},
+			unmapped: []string{"245$c", "264$a", "650$v", "650"},
+		},
+	}
+
+	file, err := os.Open("resources/import_books.marcxml")
+	if err != nil {
+		t.Fatalf("Error opening MARCXML file: %v", err)
+	}
+	defer file.Close()
+
+	records, err := bookio.ReadRecords(file, bookio.MARCXML)
+	if err != nil {
+		t.Fatalf("Error reading records: %v", err)
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, but got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		book, err := bookio.ToBook(record, nil)
+		if err != nil {
+			t.Fatalf("Error converting %s: %v", record.Position(), err)
+		}
+		if book != expected[i].book {
+			t.Errorf("Expected %+v, but got %+v", expected[i].book, book)
+		}
+		if !reflect.DeepEqual(record.Unmapped, expected[i].unmapped) {
+			t.Errorf("Expected unmapped fields %v, but got %v", expected[i].unmapped, record.Unmapped)
+		}
+	}
+}
+
+// TestReadRecordsErrors tests that files with repeated columns are rejected, as the value read would
+// depend on the order of the columns
+func TestReadRecordsErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		format   bookio.Format
+		data     string
+		expected string
+	}{
+		{name: "Repeated CSV column", format: bookio.CSV, data: "title,Author,author\nbook1,a,b\n", expected: "CSV header column 3 repeats column \"author\""},
+		{name: "Repeated JSON field", format: bookio.JSON, data: `[{"title": "book1", "Title": "book2"}]`, expected: "row 1: field \"title\" is repeated"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := bookio.ReadRecords(strings.NewReader(tc.data), tc.format)
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("Expected error %q, but got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"bms/shared/citation"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCitationKeys tests that keys are made from the family name and year, with the first title word
+// and then a title hash for repeated keys, whatever the order of the books
+func TestCitationKeys(t *testing.T) {
+	books := []api.Book{
+		{Title: "The Hobbit", Author: "J.R.R. Tolkien", PublishDate: api.NewDate(1937, time.September, 21)},
+		{Title: "Jane Eyre", Author: "Brontë, Charlotte", PublishDate: api.Date{Year: 1847, Precision: api.PrecisionYear}},
+		{Title: "The Silmarillion", Author: "Tolkien, J. R. R. and Tolkien, Christopher", PublishDate: api.Date{Year: 1937, Precision: api.PrecisionYear}},
+		{Title: "The Anonymous Book"},
+		{Title: "Farmer Giles of Ham", Author: "J.R.R. Tolkien", PublishDate: api.Date{Year: 1937, Precision: api.PrecisionYear}},
+		{Title: "Dune", Author: "Frank Herbert", PublishDate: api.Date{Year: 1965, Precision: api.PrecisionYear}},
+		{Title: "Dune (illustrated)", Author: "Frank Herbert", PublishDate: api.Date{Year: 1965, Precision: api.PrecisionYear}},
+	}
+	expected := []string{"tolkien1937hobbit", "bronte1847", "tolkien1937silmarillion", "anonymousnd", "tolkien1937farmer", "herbert1965dune", "herbert1965dune"}
+	sums := []string{"", "", "", "", "", "Dune", "Dune (illustrated)"}
+	for i, title := range sums {
+		if title != "" {
+			sum := sha256.Sum256([]byte(title))
+			expected[i] += hex.EncodeToString(sum[:3])
+		}
+	}
+
+	keys := citation.Keys(books)
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v, but got %v", expected, keys)
+	}
+
+	reversed := make([]api.Book, len(books))
+	for i, book := range books {
+		reversed[len(books)-1-i] = book
+	}
+	for i, key := range citation.Keys(reversed) {
+		if key != keys[len(books)-1-i] {
+			t.Errorf("Expected key %q for %q in reverse order, but got %q", keys[len(books)-1-i], reversed[i].Title, key)
+		}
+	}
+}
+
+// TestCitationRoundTrip tests that cited books are imported unchanged from BibTeX and RIS
+func TestCitationRoundTrip(t *testing.T) {
+	books := []api.Book{
+		{Title: "The Hobbit", Author: "J.R.R. Tolkien", PublishDate: api.NewDate(1937, time.September, 21), Edition: "1", Publisher: "Allen & Unwin", Genre: "Fantasy"},
+		{Title: "100% {braces} $cost_of #things ~ ^ \\", Author: "Le Guin, Ursula K. and Doe, Jane", PublishDate: api.Date{Year: 1968, Month: time.November, Precision: api.PrecisionMonth}, Description: "A summary"},
+		{Title: "Untitled draft"},
+	}
+	keys := citation.Keys(books)
+	entries := make([]citation.Entry, len(books))
+	for i, book := range books {
+		entries[i] = citation.Entry{Key: keys[i], Book: book}
+	}
+
+	for _, format := range []citation.Format{citation.BibTeX, citation.RIS} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := citation.Write(&buf, format, entries); err != nil {
+				t.Fatalf("Error writing citations: %v", err)
+			}
+			detected, err := citation.DetectFormat("-", buf.Bytes())
+			if err != nil || detected != format {
+				t.Errorf("Expected to detect %v, but got %v (%v)", format, detected, err)
+			}
+
+			read, err := citation.Read(&buf, format)
+			if err != nil {
+				t.Fatalf("Error reading citations: %v", err)
+			}
+			if !reflect.DeepEqual(read, entries) {
+				t.Errorf("Expected %+v, but got %+v", entries, read)
+			}
+		})
+	}
+}
+
+// TestBibTeXImport tests macros, concatenation, entry delimiters and LaTeX decoding
+func TestBibTeXImport(t *testing.T) {
+	expected := []citation.Entry{
+		{Key: "tolkien1954", Book: api.Book{
+			Title:       "The Fellowship of the Ring",
+			Author:      "Tolkien, J. R. R.",
+			Edition:     "1",
+			Publisher:   "Allen & Unwin",
+			PublishDate: api.Date{Year: 1954, Month: time.July, Precision: api.PrecisionMonth},
+			Genre:       "Fantasy",
+		}},
+		{Key: "bronte1847", Book: api.Book{
+			Author:      "Brontë, Charlotte",
+			PublishDate: api.NewDate(1847, time.October, 16),
+			Description: "Orphaned Jane grows up at Thornfield & beyond",
+			Title:       "Jane Eyre: An Autobiography",
+		}},
+	}
+
+	file, err := os.Open("resources/import_citations.bib")
+	if err != nil {
+		t.Fatalf("Error opening BibTeX file: %v", err)
+	}
+	defer file.Close()
+
+	entries, err := citation.Read(file, citation.BibTeX)
+	if err != nil {
+		t.Fatalf("Error reading BibTeX file: %v", err)
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %+v, but got %+v", expected, entries)
+	}
+}
+
+// TestCSLJSON tests that names are split into family and given names and dates into their known parts
+func TestCSLJSON(t *testing.T) {
+	entries := []citation.Entry{{Key: "tolkien1954", Book: api.Book{
+		Title:       "The Lord of the Rings",
+		Author:      "J.R.R. Tolkien and Plato",
+		Publisher:   "Allen & Unwin",
+		PublishDate: api.Date{Year: 1954, Month: time.July, Precision: api.PrecisionMonth},
+	}}}
+	expected := `[{"id": "tolkien1954", "type": "book", "title": "The Lord of the Rings",
+		"author": [{"family": "Tolkien", "given": "J.R.R."}, {"literal": "Plato"}],
+		"issued": {"date-parts": [[1954, 7]]}, "publisher": "Allen & Unwin"}]`
+
+	var buf bytes.Buffer
+	if err := citation.Write(&buf, citation.CSLJSON, entries); err != nil {
+		t.Fatalf("Error writing CSL-JSON: %v", err)
+	}
+	var got, want any
+	json.Unmarshal(buf.Bytes(), &got)
+	json.Unmarshal([]byte(expected), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %s, but got %s", expected, buf.String())
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -34,6 +36,22 @@ func compareJSON(str1, str2 string) bool {
 	return equal
 }
 
+// resetFlags restores every flag of a command and its subcommands to its default,
+// as cobra keeps flag values between executions of the same command
+func resetFlags(command *cobra.Command) {
+	command.Flags().VisitAll(func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	})
+	for _, subcommand := range command.Commands() {
+		resetFlags(subcommand)
+	}
+}
+
 // TestCommands tests the cobra commands for bms cli client
 func TestCommands(t *testing.T) {
 	mockBookListData, _ := readJsonFile("resources/mock_books.json")
@@ -43,9 +61,12 @@ func TestCommands(t *testing.T) {
 		name               string
 		args               []string
 		flags              map[string]string
+		stdin              string
 		cmdHandler         func(cmd *cobra.Command, args []string) string
 		expectedStatusCode int
 		expectedOutput     string
+		expectedError      string
+		expectedExitCode   int
 	}{
 		{
 			name:               "Valid create book",
@@ -59,7 +80,8 @@ func TestCommands(t *testing.T) {
 			args:               []string{"book", "create", ""},
 			flags:              map[string]string{},
 			expectedStatusCode: http.StatusBadRequest,
-			expectedOutput:     "Error: Title cannot be empty\n",
+			expectedError:      "Error: Title cannot be empty\n",
+			expectedExitCode:   cmd.ExitInvalid,
 		},
 		{
 			name:               "List books",
@@ -68,6 +90,647 @@ func TestCommands(t *testing.T) {
 			expectedStatusCode: http.StatusOK,
 			expectedOutput:     string(mockBookListData),
 		},
+		{
+			name:               "List first page of books",
+			args:               []string{"book", "list", "--limit", "1"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     `[{"title": "The Lord of the Rings", "author": "J.R.R. Tolkien", "genre": "Fantasy", "edition": "1", "publisher": "Allen & Unwin", "publish_date": "1954-07-29", "description": "The Lord of the Rings is an epic high-fantasy novel written by English author."}]`,
+			expectedError:      "Next page token: 1\n",
+		},
+		{
+			name:               "List all pages of books",
+			args:               []string{"book", "list", "--limit", "1", "--all"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     string(mockBookListData),
+		},
+		{
+			name:               "List books sorted by publish date",
+			args:               []string{"book", "list", "--sort", "-publish_date"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     `[{"title": "Harry Potter and the Philosopher's Stone", "author": "J.K. Rowling", "genre": "Fantasy", "edition": "1", "publisher": "Bloomsbury", "publish_date": "1997-06-26", "description": "Harry Potter and the Philosopher's Stone is a fantasy novel written by British author J. K. Rowling."}, {"title": "The Lord of the Rings", "author": "J.R.R. Tolkien", "genre": "Fantasy", "edition": "1", "publisher": "Allen & Unwin", "publish_date": "1954-07-29", "description": "The Lord of the Rings is an epic high-fantasy novel written by English author."}]`,
+		},
+		{
+			name:               "List books with invalid sort",
+			args:               []string{"book", "list", "--sort", "isbn"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      "Error: cannot sort by \"isbn\"\n",
+			expectedExitCode:   cmd.ExitInvalid,
+		},
+		{
+			name:               "Search books",
+			args:               []string{"book", "search", "rings tolkien"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     `[{"book": {"title": "The Lord of the Rings", "author": "J.R.R. Tolkien", "genre": "Fantasy", "edition": "1", "publisher": "Allen & Unwin", "publish_date": "1954-07-29", "description": "The Lord of the Rings is an epic high-fantasy novel written by English author."}, "rank": 0.5, "headline": "The Lord of the Rings"}]`,
+		},
+		{
+			name:               "List books with invalid where expression",
+			args:               []string{"book", "list", "--where", "genre = fantasy"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedError:      "Error: invalid q: expected a quoted string but found \"fantasy\" at position 9\n",
+			expectedExitCode:   cmd.ExitInvalid,
+		},
+		{
+			name:               "Book facets",
+			args:               []string{"book", "facets", "--by", "genre,decade"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: "GENRE    BOOKS\n" +
+				"Fantasy  2\n" +
+				"\n" +
+				"DECADE  BOOKS\n" +
+				"1950s   1\n" +
+				"1990s   1\n" +
+				"\n" +
+				"2 books matched\n",
+		},
+		{
+			name:               "Stats with bar chart",
+			args:               []string{"stats", "--chart", "bar"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: "BOOKS  COLLECTIONS\n" +
+				"5      2\n" +
+				"\n" +
+				"GENRE    BOOKS\n" +
+				"Fantasy  4      ██████████████████████████████\n" +
+				"(none)   1      ███████▌\n" +
+				"\n" +
+				"COLLECTION  BOOKS\n" +
+				"favourites  2      ██████████████████████████████\n" +
+				"to read     0\n" +
+				"\n" +
+				"MONTH ADDED  BOOKS\n" +
+				"2026-08      1      ███████▌\n" +
+				"2026-09      0\n" +
+				"2026-10      4      ██████████████████████████████\n" +
+				"\n" +
+				"PUBLISH YEAR  BOOKS\n" +
+				"1954          2      ██████████████████████████████\n" +
+				"1956          1      ███████████████\n" +
+				"1997          2      ██████████████████████████████\n",
+		},
+		{
+			name:               "Stats with sparklines",
+			args:               []string{"stats", "--chart", "sparkline"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: "BOOKS  COLLECTIONS\n" +
+				"5      2\n" +
+				"\n" +
+				"GENRE    BOOKS\n" +
+				"Fantasy  4\n" +
+				"(none)   1\n" +
+				"\n" +
+				"COLLECTION  BOOKS\n" +
+				"favourites  2\n" +
+				"to read     0\n" +
+				"\n" +
+				"MONTH ADDED\n" +
+				"2026-08 ▂▁█ 2026-10 (max 4)\n" +
+				"\n" +
+				"PUBLISH YEAR\n" +
+				"1954 █▁▄" + strings.Repeat("▁", 40) + "█ 1997 (max 2)\n",
+		},
+		{
+			name:               "Stats as CSV",
+			args:               []string{"stats", "--format", "csv"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: "section,value,count\n" +
+				"total,books,5\n" +
+				"total,collections,2\n" +
+				"genre,Fantasy,4\n" +
+				"genre,,1\n" +
+				"collection,favourites,2\n" +
+				"collection,to read,0\n" +
+				"month_added,2026-08,1\n" +
+				"month_added,2026-09,0\n" +
+				"month_added,2026-10,4\n" +
+				"publish_year,1954,2\n" +
+				"publish_year,1956,1\n" +
+				"publish_year,1997,2\n",
+		},
+		{
+			name:               "List audit log by actor",
+			args:               []string{"audit", "list", "--actor", "alice", "--since", "30d"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     `[{"id": 2, "actor": "alice", "timestamp": "2023-06-01T10:00:00Z", "method": "PUT", "endpoint": "/book/set", "action": "set", "entity": "book", "entity_id": "The Lord of the Rings", "before": {"title": "The Lord of the Rings", "genre": "Adventure"}, "after": {"title": "The Lord of the Rings", "genre": "Fantasy"}}]`,
+		},
+		{
+			name:               "Book history",
+			args:               []string{"book", "history", "book1"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: "Revision 1 (create) by bob at 2023-05-01T10:00:00Z\n" +
+				"  title: \"\" -> \"book1\"\n" +
+				"  author: \"\" -> \"author1\"\n" +
+				"  publish_date: \"\" -> \"2000-01-01\"\n" +
+				"  edition: \"\" -> \"1\"\n" +
+				"  genre: \"\" -> \"adventure\"\n" +
+				"Revision 2 (set) by alice at 2023-06-01T10:00:00Z\n" +
+				"  genre: \"adventure\" -> \"fantasy\"\n",
+		},
+		{
+			name:               "Revert book at current version",
+			args:               []string{"book", "revert", "book1", "--to", "1"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     "Book reverted successfully\n",
+		},
+		{
+			name:               "Revert book at stale version",
+			args:               []string{"book", "revert", "book1", "--to", "1", "--if-match", "1"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusPreconditionFailed,
+			expectedExitCode:   cmd.ExitConflict,
+			expectedError: "Error: Book has been modified, expected version 1 but current version is 2\n" +
+				"The book was changed by someone else, re-run the command to apply your change to the latest version\n",
+		},
+		{
+			name:               "Set book at current version",
+			args:               []string{"book", "set", "book1", "--genre", "mystery"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     "Book updated successfully\n",
+		},
+		{
+			name:               "Unset book field",
+			args:               []string{"book", "set", "book1", "--unset", "author"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     "Book updated successfully\n",
+		},
+		{
+			name:               "Set book at stale version",
+			args:               []string{"book", "set", "book1", "--genre", "mystery", "--if-match", "1", "--show-diff"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusPreconditionFailed,
+			expectedExitCode:   cmd.ExitConflict,
+			expectedError: "Error: Book has been modified, expected version 1 but current version is 2\n" +
+				"The book was changed by someone else, re-run the command to apply your change to the latest version\n" +
+				"Your change against version 2:\n" +
+				"  genre: \"fantasy\" -> \"mystery\"\n",
+		},
+		{
+			name:             "Missing book argument",
+			args:             []string{"book", "remove"},
+			flags:            map[string]string{},
+			expectedError:    "Error: accepts 1 arg(s), received 0\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:               "Book not found",
+			args:               []string{"book", "set", "missing", "--genre", "mystery"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      "Error: Book not found\n",
+			expectedExitCode:   cmd.ExitNotFound,
+		},
+		{
+			name:               "Remove book not found with suggestion",
+			args:               []string{"book", "remove", "Book 1"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      "Error: Book not found\nDid you mean \"book1\"?\n",
+			expectedExitCode:   cmd.ExitNotFound,
+		},
+		{
+			name:               "Import books with column mapping",
+			args:               []string{"book", "import", "resources/import_books.csv", "--map", "writer=author", "--mode", "merge"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     "1 created, 1 updated, 0 unchanged, 0 skipped\n",
+		},
+		{
+			name:               "Import books dry run",
+			args:               []string{"book", "import", "resources/import_books.csv", "--map", "writer=author", "--dry-run"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     "Dry run, nothing was imported: 1 created, 0 updated, 0 unchanged, 1 skipped\n",
+		},
+		{
+			name:             "Import books with unknown column",
+			args:             []string{"book", "import", "resources/import_books.csv"},
+			flags:            map[string]string{},
+			expectedError:    "Error: unknown columns \"writer\", map them to a field with --map column=field or drop them with --map column=-\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:             "Import books with two columns mapped to a field",
+			args:             []string{"book", "import", "resources/import_books.csv", "--map", "writer=title"},
+			flags:            map[string]string{},
+			expectedError:    "Error: columns \"title\" and \"writer\" are both imported as title, drop one of them with --map column=-\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:             "Import books with a field mapped twice",
+			args:             []string{"book", "import", "resources/import_books.csv", "--map", "writer=author", "--map", "genre=author"},
+			flags:            map[string]string{},
+			expectedError:    "Error: invalid mapping \"genre=author\", column \"writer\" is already mapped to author\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:             "Import books with invalid rows",
+			args:             []string{"book", "import", "resources/import_invalid.ndjson"},
+			flags:            map[string]string{},
+			expectedError:    "Error: row 1 (line 1): publish_date: invalid date \"1960-13\"\nrow 2 (line 3): title cannot be empty\n2 of 3 rows are invalid, nothing was imported\n",
+			expectedExitCode: cmd.ExitInvalid,
+		},
+		{
+			name:               "Import books rejected by server",
+			args:               []string{"book", "import", "resources/import_duplicate.json"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedError:      "Error: row 2: Title is repeated from book 1\n1 of 2 books failed, nothing was imported\n",
+			expectedExitCode:   cmd.ExitInvalid,
+		},
+		{
+			name:               "Import books from MARCXML",
+			args:               []string{"book", "import", "resources/import_books.marcxml"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `2 created, 0 updated, 0 unchanged, 0 skipped
+Unmapped MARC fields, not imported:
+FIELD  RECORDS
+020    1
+100$d  1
+245$c  2
+260$a  1
+264$a  1
+650$v  1
+650    1
+`,
+		},
+		{
+			name:               "Export books as CSV",
+			args:               []string{"book", "export", "--format", "csv"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `title,author,publish_date,edition,publisher,description,genre,version
+The Lord of the Rings,J.R.R. Tolkien,1954-07-29,1,Allen & Unwin,The Lord of the Rings is an epic high-fantasy novel written by English author.,Fantasy,0
+Harry Potter and the Philosopher's Stone,J.K. Rowling,1997-06-26,1,Bloomsbury,Harry Potter and the Philosopher's Stone is a fantasy novel written by British author J. K. Rowling.,Fantasy,0
+`,
+		},
+		{
+			name:               "Export collection as YAML",
+			args:               []string{"book", "export", "--format", "yaml", "--collection", "favourites"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `- title: "The Lord of the Rings"
+  author: "J.R.R. Tolkien"
+  publish_date: "1954-07-29"
+  edition: "1"
+  publisher: "Allen & Unwin"
+  description: "The Lord of the Rings is an epic high-fantasy novel written by English author."
+  genre: "Fantasy"
+  version: 0
+- title: "Harry Potter and the Philosopher's Stone"
+  author: "J.K. Rowling"
+  publish_date: "1997-06-26"
+  edition: "1"
+  publisher: "Bloomsbury"
+  description: "Harry Potter and the Philosopher's Stone is a fantasy novel written by British author J. K. Rowling."
+  genre: "Fantasy"
+  version: 0
+`,
+		},
+		{
+			name:               "Export collection not found",
+			args:               []string{"book", "export", "--collection", "missing"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      "Error: Collection not found\n",
+			expectedExitCode:   cmd.ExitNotFound,
+		},
+		{
+			name:             "Export invalid format",
+			args:             []string{"book", "export", "--format", "xml"},
+			flags:            map[string]string{},
+			expectedError:    "Error: invalid format \"xml\", must be csv, json, ndjson, yaml, marc or marcxml\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:               "Cite collection as BibTeX",
+			args:               []string{"collection", "cite", "favourites"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `@book{rowling1997,
+  title = {Harry Potter and the Philosopher's Stone},
+  author = {J.K. Rowling},
+  edition = {1},
+  publisher = {Bloomsbury},
+  year = {1997},
+  month = jun,
+  date = {1997-06-26},
+  abstract = {Harry Potter and the Philosopher's Stone is a fantasy novel written by British author J. K. Rowling.},
+  keywords = {Fantasy},
+}
+
+@book{tolkien1954,
+  title = {The Lord of the Rings},
+  author = {J.R.R. Tolkien},
+  edition = {1},
+  publisher = {Allen \& Unwin},
+  year = {1954},
+  month = jul,
+  date = {1954-07-29},
+  abstract = {The Lord of the Rings is an epic high-fantasy novel written by English author.},
+  keywords = {Fantasy},
+}
+`,
+		},
+		{
+			name:               "Cite collection as RIS",
+			args:               []string{"collection", "cite", "favourites", "--format", "ris"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: "TY  - BOOK\nID  - rowling1997\nTI  - Harry Potter and the Philosopher's Stone\nAU  - J.K. Rowling\nPY  - 1997\nDA  - 1997/06/26/\nET  - 1\nPB  - Bloomsbury\n" +
+				"AB  - Harry Potter and the Philosopher's Stone is a fantasy novel written by British author J. K. Rowling.\nKW  - Fantasy\nER  - \n\n" +
+				"TY  - BOOK\nID  - tolkien1954\nTI  - The Lord of the Rings\nAU  - J.R.R. Tolkien\nPY  - 1954\nDA  - 1954/07/29/\nET  - 1\nPB  - Allen & Unwin\n" +
+				"AB  - The Lord of the Rings is an epic high-fantasy novel written by English author.\nKW  - Fantasy\nER  - \n",
+		},
+		{
+			name:               "Cite collection not found",
+			args:               []string{"collection", "cite", "missing", "--format", "csl-json"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusNotFound,
+			expectedError:      "Error: Collection not found\n",
+			expectedExitCode:   cmd.ExitNotFound,
+		},
+		{
+			name:             "Cite collection invalid format",
+			args:             []string{"collection", "cite", "favourites", "--format", "apa"},
+			flags:            map[string]string{},
+			expectedError:    "Error: invalid format \"apa\", must be bibtex, ris or csl-json\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:               "Import BibTeX into a new collection",
+			args:               []string{"collection", "import", "reading", "resources/import_citations.bib"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     "2 created, 0 updated, 0 unchanged, 0 skipped\nCollections: 1 created, 2 books added\n",
+		},
+		{
+			name:             "Import RIS with an invalid entry",
+			args:             []string{"collection", "import", "favourites", "resources/import_citations.ris"},
+			flags:            map[string]string{},
+			expectedError:    "Error: entry 2: title cannot be empty\n1 of 2 entries are invalid, nothing was imported\n",
+			expectedExitCode: cmd.ExitInvalid,
+		},
+		{
+			name:               "Import Goodreads export",
+			args:               []string{"import", "goodreads", "resources/goodreads_library_export.csv"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `1 created, 1 merged, 0 unchanged, 2 skipped
+Collections: 3 created, 4 books added
+Ratings and read dates: 1 recorded
+Skipped rows:
+  row 3 (line 4): title is empty
+  row 4 (line 5): title is repeated from row 1 (line 2)
+`,
+		},
+		{
+			// book1 is skipped, so it is not added to its to-read shelf
+			name:               "Import Goodreads export skipping existing books",
+			args:               []string{"import", "goodreads", "resources/goodreads_library_export.csv", "--mode", "skip"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `1 created, 0 updated, 0 unchanged, 3 skipped
+Collections: 2 created, 3 books added
+Ratings and read dates: 1 recorded
+Skipped rows:
+  row 3 (line 4): title is empty
+  row 4 (line 5): title is repeated from row 1 (line 2)
+`,
+		},
+		{
+			name:               "Import LibraryThing export dry run",
+			args:               []string{"import", "librarything", "resources/librarything_export.tsv", "--dry-run"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `Dry run, nothing was imported: 2 created, 0 merged, 0 unchanged, 0 skipped
+Collections: 3 created, 3 books added
+Ratings and read dates: 1 recorded
+`,
+		},
+		{
+			name:             "Import Goodreads with the wrong file",
+			args:             []string{"import", "goodreads", "resources/librarything_export.tsv"},
+			flags:            map[string]string{},
+			expectedError:    "Error: not a Goodreads export, the columns \"author\", \"my rating\", \"bookshelves\", \"exclusive shelf\" are missing\n",
+			expectedExitCode: cmd.ExitInvalid,
+		},
+		{
+			name:               "Import Calibre library",
+			args:               []string{"import", "calibre", "resources/calibre"},
+			flags:              map[string]string{},
+			expectedStatusCode: http.StatusOK,
+			expectedOutput: `3 created, 0 merged, 0 unchanged, 0 skipped
+Collections: 1 created, 1 books added
+Ratings and read dates: 1 recorded
+Identifiers: 3 recorded
+`,
+		},
+		{
+			name:             "Import Calibre library not found",
+			args:             []string{"import", "calibre", "resources/missing"},
+			flags:            map[string]string{},
+			expectedError:    "Error: not a Calibre library, stat resources/missing: no such file or directory\n",
+			expectedExitCode: cmd.ExitInvalid,
+		},
+		{
+			name:  "Enrich book from its recorded ISBN",
+			args:  []string{"book", "enrich", "book1", "--fixtures", "resources/mock_metadata.json"},
+			flags: map[string]string{},
+			stdin: "y\n",
+			expectedOutput: `Changes from resources/mock_metadata.json for ISBN 9780261103252:
+  publish_date: "" -> "1995"
+  publisher: "" -> "HarperCollins"
+  description: "" -> "An epic high-fantasy novel."
+Apply these changes? [y/N] Book updated successfully
+`,
+		},
+		{
+			name:  "Enrich book declined",
+			args:  []string{"book", "enrich", "book1", "--fixtures", "resources/mock_metadata.json"},
+			flags: map[string]string{},
+			stdin: "n\n",
+			expectedOutput: `Changes from resources/mock_metadata.json for ISBN 9780261103252:
+  publish_date: "" -> "1995"
+  publisher: "" -> "HarperCollins"
+  description: "" -> "An epic high-fantasy novel."
+Apply these changes? [y/N] No changes were made
+`,
+		},
+		{
+			name:  "Enrich book overwriting fields without confirmation",
+			args:  []string{"book", "enrich", "book1", "--fixtures", "resources/mock_metadata.json", "--overwrite", "--yes"},
+			flags: map[string]string{},
+			expectedOutput: `Changes from resources/mock_metadata.json for ISBN 9780261103252:
+  author: "author1" -> "J.R.R. Tolkien"
+  publish_date: "" -> "1995"
+  publisher: "" -> "HarperCollins"
+  description: "" -> "An epic high-fantasy novel."
+  genre: "fantasy" -> "Fantasy"
+Book updated successfully
+`,
+		},
+		{
+			name:             "Enrich book with an unknown ISBN",
+			args:             []string{"book", "enrich", "book1", "--fixtures", "resources/mock_metadata.json", "--isbn", "978-0-14-044913-6"},
+			flags:            map[string]string{},
+			expectedError:    "Error: resources/mock_metadata.json has no book with ISBN 9780140449136\n",
+			expectedExitCode: cmd.ExitNotFound,
+		},
+		{
+			name:             "Enrich book with an invalid ISBN",
+			args:             []string{"book", "enrich", "book1", "--fixtures", "resources/mock_metadata.json", "--isbn", "9780261103253"},
+			flags:            map[string]string{},
+			expectedError:    "Error: invalid ISBN \"9780261103253\", the check digit is wrong\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:  "Create book from ISBN",
+			args:  []string{"book", "create", "--from-isbn", "0-7475-3269-9", "--fixtures", "resources/mock_metadata.json", "--yes"},
+			flags: map[string]string{},
+			expectedOutput: `Book from resources/mock_metadata.json for ISBN 0747532699:
+  title: "" -> "Harry Potter and the Philosopher's Stone"
+  author: "" -> "J.K. Rowling"
+  publish_date: "" -> "1997-06-26"
+  publisher: "" -> "Bloomsbury"
+  genre: "" -> "Fantasy"
+Book created successfully
+`,
+		},
+		{
+			name:             "Create book from ISBN without a title",
+			args:             []string{"book", "create", "--from-isbn", "9780441172719", "--fixtures", "resources/mock_metadata.json"},
+			flags:            map[string]string{},
+			expectedError:    "Error: resources/mock_metadata.json has no title for ISBN 9780441172719, give the title as an argument\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:  "Create book from ISBN that already exists",
+			args:  []string{"book", "create", "book1", "--from-isbn", "9780441172719", "--fixtures", "resources/mock_metadata.json", "--genre", "Science fiction"},
+			flags: map[string]string{},
+			stdin: "yes\n",
+			expectedOutput: `Book from resources/mock_metadata.json for ISBN 9780441172719:
+  title: "" -> "book1"
+  author: "" -> "Frank Herbert"
+  genre: "" -> "Science fiction"
+Create this book? [y/N] `,
+			expectedError:    "Error: Book \"book1\" already exists, use bms book enrich to fill in its fields\n",
+			expectedExitCode: cmd.ExitConflict,
+		},
+		{
+			name:           "Set book cover",
+			args:           []string{"book", "cover", "set", "book1", "resources/cover.png"},
+			flags:          map[string]string{},
+			expectedOutput: "Cover set successfully\n",
+		},
+		{
+			name:             "Set cover of a missing book",
+			args:             []string{"book", "cover", "set", "Book 1", "resources/cover.png"},
+			flags:            map[string]string{},
+			expectedError:    "Error: Book not found\nDid you mean \"book1\"?\n",
+			expectedExitCode: cmd.ExitNotFound,
+		},
+		{
+			name:             "Set cover that is not an image",
+			args:             []string{"book", "cover", "set", "book1", "resources/import_books.csv"},
+			flags:            map[string]string{},
+			expectedError:    "Error: resources/import_books.csv is not a JPEG, PNG or GIF image\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:           "Attach a PDF to a book",
+			args:           []string{"book", "attach", "book1", "resources/book.pdf"},
+			flags:          map[string]string{},
+			expectedOutput: "File attached successfully\n",
+		},
+		{
+			name:           "Attach an EPUB to a missing book",
+			args:           []string{"book", "attach", "The Hobbit", "resources/book.epub"},
+			flags:          map[string]string{},
+			expectedOutput: "Book \"The Hobbit\" created from the EPUB metadata and file attached\n",
+		},
+		{
+			name:           "Import an EPUB",
+			args:           []string{"book", "attach", "resources/book.epub"},
+			flags:          map[string]string{},
+			expectedOutput: "Book \"The Hobbit\" created from the EPUB metadata and file attached\n",
+		},
+		{
+			name:             "Import a PDF",
+			args:             []string{"book", "attach", "resources/book.pdf"},
+			flags:            map[string]string{},
+			expectedError:    "Error: resources/book.pdf is a PDF, give the title of the book to attach it to\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:             "Attach a PDF to a missing book",
+			args:             []string{"book", "attach", "Book 1", "resources/book.pdf"},
+			flags:            map[string]string{},
+			expectedError:    "Error: Book not found\nDid you mean \"book1\"?\n",
+			expectedExitCode: cmd.ExitNotFound,
+		},
+		{
+			name:             "Attach a file that is not an EPUB or PDF",
+			args:             []string{"book", "attach", "book1", "resources/import_books.csv"},
+			flags:            map[string]string{},
+			expectedError:    "Error: resources/import_books.csv is not an EPUB or PDF\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:  "List book files",
+			args:  []string{"book", "files", "book1"},
+			flags: map[string]string{},
+			expectedOutput: `NAME       TYPE             SIZE  HASH          ATTACHED
+book1.pdf  application/pdf  15 B  3f2a9c1d7e5b  2024-03-01T12:00:00Z
+`,
+		},
+		{
+			name:           "Download the file of a book",
+			args:           []string{"book", "download", "book1", "--output", "-"},
+			flags:          map[string]string{},
+			expectedOutput: "%PDF-1.4\n%%EOF\n",
+		},
+		{
+			name:           "Download a file by hash",
+			args:           []string{"book", "download", "book1", "3f2a9c", "--output", "-"},
+			flags:          map[string]string{},
+			expectedOutput: "%PDF-1.4\n%%EOF\n",
+		},
+		{
+			name:             "Download a file that is not attached",
+			args:             []string{"book", "download", "book1", "book1.epub", "--output", "-"},
+			flags:            map[string]string{},
+			expectedError:    "Error: No file \"book1.epub\" attached to \"book1\"\n",
+			expectedExitCode: cmd.ExitNotFound,
+		},
+		{
+			name:             "Download a file whose name is not a file name",
+			args:             []string{"book", "download", "AC/DC"},
+			flags:            map[string]string{},
+			expectedError:    "Error: \"..\" is not a file name, give a path to download to with --output\n",
+			expectedExitCode: cmd.ExitUsage,
+		},
+		{
+			name:             "Download the file of a missing book",
+			args:             []string{"book", "download", "Book 1"},
+			flags:            map[string]string{},
+			expectedError:    "Error: Book not found\nDid you mean \"book1\"?\n",
+			expectedExitCode: cmd.ExitNotFound,
+		},
 		// Add more tests for each command as necessary
 	}
 
@@ -78,9 +741,13 @@ func TestCommands(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// Create a buffer to capture the output
+			// Create buffers to capture the output and errors
 			buf := new(bytes.Buffer)
+			errBuf := new(bytes.Buffer)
 			cmd.RootCmd.SetOut(buf)
+			cmd.RootCmd.SetErr(errBuf)
+			cmd.RootCmd.SetIn(strings.NewReader(tc.stdin))
+			resetFlags(cmd.RootCmd)
 
 			// set flags and args
 			for k, v := range tc.flags {
@@ -91,17 +758,20 @@ func TestCommands(t *testing.T) {
 			}
 			cmd.RootCmd.SetArgs(tc.args)
 
-			// Execute the command
+			// Execute the command and compare the exit code
 			err := cmd.RootCmd.Execute()
-			if err != nil {
-				t.Errorf("Error executing add command: %v", err)
+			if exitCode := cmd.ExitCode(err); exitCode != tc.expectedExitCode {
+				t.Errorf("Expected exit code %v, but got %v (%v)", tc.expectedExitCode, exitCode, err)
 			}
 
-			// Get the captured output and compare
+			// Get the captured output and errors and compare
 			cmdOutput := buf.String()
 			if cmdOutput != tc.expectedOutput && !compareJSON(cmdOutput, tc.expectedOutput) {
 				t.Errorf("Expected body %v, but got %v", tc.expectedOutput, cmdOutput)
 			}
+			if cmdError := errBuf.String(); cmdError != tc.expectedError {
+				t.Errorf("Expected error %v, but got %v", tc.expectedError, cmdError)
+			}
 		})
 	}
 }
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"bms/server/cql"
+	"bms/server/filter"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestCQLCompile tests compiling CQL queries to parameterized SQL, and the SRU diagnostics of invalid queries
+func TestCQLCompile(t *testing.T) {
+	indexes := map[string]filter.Field{
+		"dc.title":         {Expr: "title", Type: filter.Text},
+		"dc.creator":       {Expr: "author", Type: filter.Text},
+		"dc.subject":       {Expr: "genre", Type: filter.Text},
+		"dc.date":          {Expr: "publish_date", Type: filter.Date, EndExpr: "publish_end"},
+		"cql.serverchoice": {Expr: "title", Type: filter.Text},
+	}
+
+	// table driven tests
+	testCases := []struct {
+		name           string
+		input          string
+		expectedSQL    string
+		expectedValues []any
+		expectedCode   int
+		expectedError  string
+	}{
+		{
+			name:           "Term in an index",
+			input:          `dc.title = "lord of the rings"`,
+			expectedSQL:    "COALESCE(title ILIKE $2, false)",
+			expectedValues: []any{"%lord of the rings%"},
+		},
+		{
+			name:           "Term on its own",
+			input:          "hobbit",
+			expectedSQL:    "COALESCE(title ILIKE $2, false)",
+			expectedValues: []any{"%hobbit%"},
+		},
+		{
+			name:           "Booleans associate to the left",
+			input:          "title = hobbit OR dc.creator = tolkien and ring not subject = horror",
+			expectedSQL:    "(((COALESCE(title ILIKE $2, false) OR COALESCE(author ILIKE $3, false)) AND COALESCE(title ILIKE $4, false)) AND NOT COALESCE(genre ILIKE $5, false))",
+			expectedValues: []any{"%hobbit%", "%tolkien%", "%ring%", "%horror%"},
+		},
+		{
+			name:           "Parentheses",
+			input:          `dc.creator any "tolkien lewis" and (dc.subject exact fantasy or dc.subject <> horror)`,
+			expectedSQL:    "((COALESCE(author ILIKE $2, false) OR COALESCE(author ILIKE $3, false)) AND (COALESCE(genre ILIKE $4, false) OR NOT COALESCE(genre ILIKE $5, false)))",
+			expectedValues: []any{"%tolkien%", "%lewis%", "fantasy", "horror"},
+		},
+		{
+			name:           "All words",
+			input:          `dc.title all "ring lord"`,
+			expectedSQL:    "(COALESCE(title ILIKE $2, false) AND COALESCE(title ILIKE $3, false))",
+			expectedValues: []any{"%ring%", "%lord%"},
+		},
+		{
+			name:           "Masking and anchoring",
+			input:          `dc.title = "^the lord*" and dc.title == "100\% ?ure\*"`,
+			expectedSQL:    "(COALESCE(title ILIKE $2, false) AND COALESCE(title ILIKE $3, false))",
+			expectedValues: []any{"the lord%%", `100\% _ure*`},
+		},
+		{
+			name:           "Partial dates",
+			input:          "dc.date > 1950 and dc.date <= 1960-06",
+			expectedSQL:    "(COALESCE(publish_end > $2, false) AND COALESCE(publish_date <= $3, false))",
+			expectedValues: []any{"1950-12-31", "1960-06-30"},
+		},
+		{
+			name:           "Dates within a range",
+			input:          `dc.date within "1950 1959"`,
+			expectedSQL:    "(COALESCE(publish_end >= $2, false) AND COALESCE(publish_date <= $3, false))",
+			expectedValues: []any{"1950-01-01", "1959-12-31"},
+		},
+		{
+			name:           "All records",
+			input:          "cql.allRecords = 1",
+			expectedSQL:    "TRUE",
+			expectedValues: []any{},
+		},
+		{
+			name:           "Injection is a parameter",
+			input:          `dc.title = "x'; DROP TABLE books; --"`,
+			expectedSQL:    "COALESCE(title ILIKE $2, false)",
+			expectedValues: []any{"%x'; DROP TABLE books; --%"},
+		},
+		{name: "Unknown index", input: "dc.rights = free", expectedCode: cql.DiagUnsupportedIndex,
+			expectedError: "Unsupported index: dc.rights, indexes are cql.serverchoice, dc.creator, dc.date, dc.subject, dc.title"},
+		{name: "Relation on text", input: "dc.title > m", expectedCode: cql.DiagUnsupportedRelation, expectedError: "Unsupported relation: >"},
+		{name: "Relation modifier", input: "dc.title =/stem ring", expectedCode: cql.DiagUnsupportedRelationModifier, expectedError: "Unsupported relation modifier: stem"},
+		{name: "Proximity", input: "ring prox lord", expectedCode: cql.DiagUnsupportedBoolean, expectedError: "Unsupported boolean operator: prox"},
+		{name: "Invalid date", input: "dc.date < 1990-13", expectedCode: cql.DiagInvalidTerm, expectedError: `Term in invalid format for index or relation: "1990-13": invalid date "1990-13"`},
+		{name: "Empty term", input: `dc.title = ""`, expectedCode: cql.DiagEmptyTerm, expectedError: "Empty term unsupported: ="},
+		{name: "Sort", input: "ring sortBy dc.title", expectedCode: cql.DiagSortUnsupported, expectedError: "Sort not supported: sortBy"},
+		{name: "Prefix assignment", input: `> dc = "info:srw/cql-context-set/1/dc-v1.1" dc.title = ring`, expectedCode: cql.DiagUnsupportedContextSet,
+			expectedError: "Unsupported context set: prefix assignment at position 1"},
+		{name: "Unclosed parenthesis", input: "(ring or lord", expectedCode: cql.DiagSyntax, expectedError: `Query syntax error: expected ")" but found end of query at position 14`},
+		{name: "Unterminated term", input: `dc.title = "ring`, expectedCode: cql.DiagSyntax, expectedError: "Query syntax error: unterminated term at position 12"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sql, values, err := cql.Compile(tc.input, indexes, 2)
+			if tc.expectedError != "" {
+				var queryErr *cql.Error
+				if !errors.As(err, &queryErr) || queryErr.Code != tc.expectedCode || err.Error() != tc.expectedError {
+					t.Errorf("Expected diagnostic %d %v, but got %v", tc.expectedCode, tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error compiling %q: %v", tc.input, err)
+			}
+
+			if sql != tc.expectedSQL {
+				t.Errorf("Expected SQL %v, but got %v", tc.expectedSQL, sql)
+			}
+			if !reflect.DeepEqual(values, tc.expectedValues) {
+				t.Errorf("Expected values %v, but got %v", tc.expectedValues, values)
+			}
+		})
+	}
+}
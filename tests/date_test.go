@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestDate tests parsing, formatting and the covered range of partial dates
+func TestDate(t *testing.T) {
+	// table driven tests
+	testCases := []struct {
+		name          string
+		input         string
+		expectedError bool
+		expected      string
+		expectedStart string
+		expectedEnd   string
+	}{
+		{name: "Year", input: "1954", expected: "1954", expectedStart: "1954-01-01", expectedEnd: "1954-12-31"},
+		{name: "Year and month", input: "1954-02", expected: "1954-02", expectedStart: "1954-02-01", expectedEnd: "1954-02-28"},
+		{name: "Full date", input: "1954-07-29", expected: "1954-07-29", expectedStart: "1954-07-29", expectedEnd: "1954-07-29"},
+		{name: "RFC 3339 timestamp", input: "1954-07-29T00:00:00Z", expected: "1954-07-29", expectedStart: "1954-07-29", expectedEnd: "1954-07-29"},
+		{name: "First day of year 1", input: "0001-01-01", expected: "0001-01-01", expectedStart: "0001-01-01", expectedEnd: "0001-01-01"},
+		{name: "RFC 3339 timestamp of year 1", input: "0001-01-01T00:00:00Z", expected: "0001-01-01", expectedStart: "0001-01-01", expectedEnd: "0001-01-01"},
+		{name: "Unknown", input: "", expected: ""},
+		{name: "Invalid month", input: "1954-13", expectedError: true},
+		{name: "Invalid day", input: "1954-02-30", expectedError: true},
+		{name: "Short year", input: "54", expectedError: true},
+		{name: "Unpadded month", input: "1954-7", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			date, err := api.ParseDate(tc.input)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error parsing %q, but got %v", tc.input, date)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error parsing %q: %v", tc.input, err)
+			}
+
+			if date.String() != tc.expected {
+				t.Errorf("Expected %v, but got %v", tc.expected, date.String())
+			}
+			if date.IsZero() {
+				return
+			}
+			if start := date.Start().Format(api.PublishTimeLayoutDMY); start != tc.expectedStart {
+				t.Errorf("Expected start %v, but got %v", tc.expectedStart, start)
+			}
+			if end := date.End().Format(api.PublishTimeLayoutDMY); end != tc.expectedEnd {
+				t.Errorf("Expected end %v, but got %v", tc.expectedEnd, end)
+			}
+		})
+	}
+}
+
+// TestDateJSON tests that books round trip partial and unknown publish dates through JSON
+func TestDateJSON(t *testing.T) {
+	books := []api.Book{
+		{Title: "book1", PublishDate: api.Date{Year: 1954, Precision: api.PrecisionYear}},
+		{Title: "book2", PublishDate: api.Date{Year: 1954, Month: time.July, Precision: api.PrecisionMonth}},
+		{Title: "book3", PublishDate: api.NewDate(1954, time.July, 29)},
+		{Title: "book4"},
+	}
+
+	data, err := json.Marshal(books)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[
+		{"title": "book1", "author": "", "publish_date": "1954", "edition": "", "publisher": "", "description": "", "genre": ""},
+		{"title": "book2", "author": "", "publish_date": "1954-07", "edition": "", "publisher": "", "description": "", "genre": ""},
+		{"title": "book3", "author": "", "publish_date": "1954-07-29", "edition": "", "publisher": "", "description": "", "genre": ""},
+		{"title": "book4", "author": "", "publish_date": null, "edition": "", "publisher": "", "description": "", "genre": ""}
+	]`
+	if !compareJSON(string(data), expected) {
+		t.Errorf("Expected %v, but got %v", expected, string(data))
+	}
+
+	var decoded []api.Book
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range books {
+		if decoded[i] != books[i] {
+			t.Errorf("Expected %v, but got %v", books[i], decoded[i])
+		}
+	}
+}
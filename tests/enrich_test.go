@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"bms/shared/enrich"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestNormalizeISBN tests that ISBNs are written without separators and that their check digits are checked
+func TestNormalizeISBN(t *testing.T) {
+	testCases := []struct {
+		input         string
+		expected      string
+		expectedError string
+	}{
+		{input: "978-0-261-10325-2", expected: "9780261103252"},
+		{input: "0 8044 2957 x", expected: "080442957X"},
+		{input: "9780261103253", expectedError: `invalid ISBN "9780261103253", the check digit is wrong`},
+		{input: "X804429570", expectedError: `invalid ISBN "X804429570"`},
+		{input: "97802611", expectedError: `invalid ISBN "97802611", must have 10 or 13 digits`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			isbn, err := enrich.NormalizeISBN(tc.input)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Errorf("Expected error %v, but got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil || isbn != tc.expected {
+				t.Errorf("Expected %v, but got %v (%v)", tc.expected, isbn, err)
+			}
+		})
+	}
+}
+
+// TestMergeMetadata tests that metadata fills empty fields, and replaces set fields only when overwriting
+func TestMergeMetadata(t *testing.T) {
+	book := api.Book{Title: "Dune", Author: "F. Herbert", Genre: "sci-fi", Version: 3}
+	found := api.Book{Title: "Dune (Ace)", Author: "Frank Herbert", Publisher: "Ace", PublishDate: api.NewDate(1990, time.September, 1)}
+
+	merged := enrich.Merge(book, found, false)
+	expected := api.Book{Title: "Dune", Author: "F. Herbert", Genre: "sci-fi", Publisher: "Ace", PublishDate: found.PublishDate, Version: 3}
+	if merged != expected {
+		t.Errorf("Expected %+v, but got %+v", expected, merged)
+	}
+
+	merged = enrich.Merge(book, found, true)
+	expected.Author = "Frank Herbert"
+	if merged != expected {
+		t.Errorf("Expected %+v when overwriting, but got %+v", expected, merged)
+	}
+}
+
+// TestOpenLibrary tests reading an edition from the Open Library Books API
+func TestOpenLibrary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/books" || r.URL.Query().Get("jscmd") != "details" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("bibkeys") != "ISBN:9780261103252" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(`{"ISBN:9780261103252": {"bib_key": "ISBN:9780261103252", "details": {
+			"title": "The Lord of the Rings",
+			"authors": [{"key": "/authors/OL26320A", "name": "J.R.R. Tolkien"}, {"key": "/authors/OL1A", "name": "Alan Lee"}],
+			"publishers": ["HarperCollins", "Grafton"],
+			"publish_date": "July 29, 1995",
+			"subjects": ["Fantasy", "Middle Earth"],
+			"description": {"type": "/type/text", "value": "An epic high-fantasy novel. "},
+			"edition_name": "Centenary ed."}}}`))
+	}))
+	defer server.Close()
+
+	provider := &enrich.OpenLibrary{BaseURL: server.URL, Client: server.Client()}
+	book, err := provider.LookupISBN("9780261103252")
+	if err != nil {
+		t.Fatalf("Error looking up ISBN: %v", err)
+	}
+	expected := api.Book{
+		Title:       "The Lord of the Rings",
+		Author:      "J.R.R. Tolkien and Alan Lee",
+		PublishDate: api.NewDate(1995, time.July, 29),
+		Edition:     "Centenary ed.",
+		Publisher:   "HarperCollins",
+		Description: "An epic high-fantasy novel.",
+		Genre:       "Fantasy",
+	}
+	if book != expected {
+		t.Errorf("Expected %+v, but got %+v", expected, book)
+	}
+
+	if _, err := provider.LookupISBN("9780441172719"); !errors.Is(err, enrich.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown ISBN, but got %v", err)
+	}
+}
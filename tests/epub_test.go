@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"archive/zip"
+	"bms/shared/api"
+	"bms/shared/sources"
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// makeEPUB builds an EPUB archive with a package document at OEBPS/content.opf
+func makeEPUB(t *testing.T, opf string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	entries := []struct{ name, content string }{
+		{"mimetype", sources.EPUBType},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{"OEBPS/content.opf", opf},
+	}
+	for _, entry := range entries {
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Store})
+		if err == nil {
+			_, err = writer.Write([]byte(entry.content))
+		}
+		if err != nil {
+			t.Fatalf("Error building EPUB: %v", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Error building EPUB: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestReadEPUB tests how the OPF metadata of EPUB 2 and EPUB 3 books is mapped to a book
+func TestReadEPUB(t *testing.T) {
+	fixture, err := os.ReadFile("resources/book.epub")
+	if err != nil {
+		t.Fatalf("Error reading resources/book.epub: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected api.ImportBook
+	}{
+		{
+			// the illustrator and the modification date are left out, and the ISBN is normalized
+			name: "EPUB 2",
+			data: fixture,
+			expected: api.ImportBook{
+				Book: api.Book{
+					Title:       "The Hobbit",
+					Author:      "J.R.R. Tolkien",
+					PublishDate: api.NewDate(1937, time.September, 21),
+					Description: "In a hole in the ground there lived a hobbit.\n\nBilbo Baggins is swept into a quest.",
+					Publisher:   "George Allen & Unwin",
+					Genre:       "Fantasy",
+				},
+				Identifiers: map[string]string{"isbn": "9780261102217"},
+			},
+		},
+		{
+			// roles and identifier types refine elements by id, and the date is an RFC 3339 timestamp
+			name: "EPUB 3",
+			data: makeEPUB(t, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">urn:uuid:6f0a1b5e-8c3d-4d6e-9a8b-2f1e3c4d5a6b</dc:identifier>
+    <dc:identifier id="isbn">urn:isbn:0-261-10221-4</dc:identifier>
+    <dc:title>  Good
+      Omens  </dc:title>
+    <dc:creator id="author1">Terry Pratchett</dc:creator>
+    <meta refines="#author1" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="author2">Neil Gaiman</dc:creator>
+    <dc:creator id="editor">Someone Else</dc:creator>
+    <meta refines="#editor" property="role" scheme="marc:relators">edt</meta>
+    <dc:date>1990-05-01T00:00:00Z</dc:date>
+    <meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
+  </metadata>
+</package>`),
+			expected: api.ImportBook{
+				Book: api.Book{
+					Title:       "Good Omens",
+					Author:      "Terry Pratchett and Neil Gaiman",
+					PublishDate: api.NewDate(1990, time.May, 1),
+				},
+				Identifiers: map[string]string{"isbn": "0261102214"},
+			},
+		},
+		{
+			// an identifier without a scheme is an ISBN only if its check digit holds
+			name: "Invalid ISBN and unknown date",
+			data: makeEPUB(t, `<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Untitled draft</dc:title>
+    <dc:identifier>9780261102218</dc:identifier>
+    <dc:date>unknown</dc:date>
+  </metadata>
+</package>`),
+			expected: api.ImportBook{Book: api.Book{Title: "Untitled draft"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !sources.IsEPUB(bytes.NewReader(tc.data), int64(len(tc.data))) {
+				t.Errorf("Expected an EPUB")
+			}
+			book, err := sources.ReadEPUB(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if err != nil {
+				t.Fatalf("Error reading EPUB: %v", err)
+			}
+			if !reflect.DeepEqual(book, tc.expected) {
+				t.Errorf("Expected %+v, but got %+v", tc.expected, book)
+			}
+		})
+	}
+}
+
+// TestReadEPUBErrors tests that archives which are not EPUB books are rejected
+func TestReadEPUBErrors(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	if _, err := archive.Create("notes.txt"); err != nil {
+		t.Fatalf("Error building archive: %v", err)
+	}
+	archive.Close()
+	pdf, err := os.ReadFile("resources/book.pdf")
+	if err != nil {
+		t.Fatalf("Error reading resources/book.pdf: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		isEPUB   bool
+		expected string
+	}{
+		{name: "Zip archive", data: buf.Bytes(), expected: "not an EPUB, META-INF/container.xml is missing"},
+		{name: "PDF", data: pdf, expected: "not an EPUB: zip: not a valid zip file"},
+		{name: "Invalid package document", data: makeEPUB(t, "<package><metadata>"), isEPUB: true, expected: "invalid OEBPS/content.opf: XML syntax error on line 1: unexpected EOF"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if isEPUB := sources.IsEPUB(bytes.NewReader(tc.data), int64(len(tc.data))); isEPUB != tc.isEPUB {
+				t.Errorf("Expected IsEPUB %v, but got %v", tc.isEPUB, isEPUB)
+			}
+			_, err := sources.ReadEPUB(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("Expected error %q, but got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"bms/server/filter"
+	"bms/shared/api"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestFilterCompile tests compiling filter expressions to parameterized SQL
+func TestFilterCompile(t *testing.T) {
+	fields := map[string]filter.Field{
+		"title":        {Expr: "title", Type: filter.Text},
+		"author":       {Expr: "author", Type: filter.Text},
+		"genre":        {Expr: "genre", Type: filter.Text},
+		"description":  {Expr: "description", Type: filter.Text},
+		"publish_date": {Expr: "publish_date", Type: filter.Date, EndExpr: "publish_end"},
+		"version":      {Expr: "version", Type: filter.Integer},
+	}
+
+	// table driven tests
+	testCases := []struct {
+		name           string
+		input          string
+		expectedSQL    string
+		expectedValues []any
+		expectedError  string
+	}{
+		{
+			name:           "Comparison",
+			input:          "genre = 'fantasy'",
+			expectedSQL:    "COALESCE(genre = $3, false)",
+			expectedValues: []any{"fantasy"},
+		},
+		{
+			name:           "Precedence",
+			input:          "genre = 'fantasy' OR genre = 'sci-fi' AND NOT author = \"O'Brien\"",
+			expectedSQL:    "(COALESCE(genre = $3, false) OR (COALESCE(genre = $4, false) AND NOT COALESCE(author = $5, false)))",
+			expectedValues: []any{"fantasy", "sci-fi", "O'Brien"},
+		},
+		{
+			name:           "Parentheses and keywords in any case",
+			input:          "(genre in ('fantasy', 'sci-fi') or version >= 2) and description is null",
+			expectedSQL:    "((COALESCE(genre IN ($3, $4), false) OR COALESCE(version >= $5, false)) AND (description IS NULL OR description = ''))",
+			expectedValues: []any{"fantasy", "sci-fi", 2},
+		},
+		{
+			name:           "Not like and is not null",
+			input:          "title NOT LIKE 'The %' AND author IS NOT NULL AND version != 1",
+			expectedSQL:    "((NOT COALESCE(title LIKE $3, false) AND (author IS NOT NULL AND author <> '')) AND COALESCE(version <> $4, false))",
+			expectedValues: []any{"The %", 1},
+		},
+		{
+			name:           "Partial dates",
+			input:          "publish_date > 1990 AND publish_date <= '2000-06'",
+			expectedSQL:    "(COALESCE(publish_end > $3, false) AND COALESCE(publish_date <= $4, false))",
+			expectedValues: []any{"1990-12-31", "2000-06-30"},
+		},
+		{
+			name:           "Date in list",
+			input:          "publish_date IN (1954, 1954-07-29)",
+			expectedSQL:    "(COALESCE(publish_date <= $3 AND publish_end >= $4, false) OR COALESCE(publish_date <= $5 AND publish_end >= $6, false))",
+			expectedValues: []any{"1954-12-31", "1954-01-01", "1954-07-29", "1954-07-29"},
+		},
+		{
+			name:           "Injection is a parameter",
+			input:          "title = 'x''; DROP TABLE books; --'",
+			expectedSQL:    "COALESCE(title = $3, false)",
+			expectedValues: []any{"x'; DROP TABLE books; --"},
+		},
+		{name: "Unknown field", input: "isbn = '1'", expectedError: `unknown field "isbn", fields are author, description, genre, publish_date, title, version at position 1`},
+		{name: "Missing value", input: "genre =", expectedError: "expected a quoted string but found end of expression at position 8"},
+		{name: "Unquoted string", input: "genre = fantasy", expectedError: `expected a quoted string but found "fantasy" at position 9`},
+		{name: "Number field", input: "version = 'two'", expectedError: `expected a number but found string "two" at position 11`},
+		{name: "Invalid date", input: "publish_date < 1990-13", expectedError: `invalid date "1990-13" at position 16`},
+		{name: "Double equals on text", input: "genre == 'x'", expectedError: `unknown operator "==", operators are =, !=, <>, <, <=, > and >= at position 7`},
+		{name: "Double equals on number", input: "version == 3", expectedError: `unknown operator "==", operators are =, !=, <>, <, <=, > and >= at position 9`},
+		{name: "Double equals on date", input: "publish_date == 1990", expectedError: `unknown operator "==", operators are =, !=, <>, <, <=, > and >= at position 14`},
+		{
+			name:           "Date not equal",
+			input:          "publish_date != 1990",
+			expectedSQL:    "COALESCE(NOT (publish_date <= $3 AND publish_end >= $4), false)",
+			expectedValues: []any{"1990-12-31", "1990-01-01"},
+		},
+		{name: "Like on number", input: "version LIKE '1%'", expectedError: "LIKE can only be used on text fields at position 9"},
+		{name: "Unterminated string", input: "genre = 'fantasy", expectedError: "unterminated string at position 9"},
+		{name: "Unclosed parenthesis", input: "(genre = 'a'", expectedError: `expected ")" but found end of expression at position 13`},
+		{name: "Trailing tokens", input: "genre = 'a' genre = 'b'", expectedError: `expected AND, OR or end of expression but found "genre" at position 13`},
+		{name: "Injected SQL", input: "genre = 'a'; DROP TABLE books", expectedError: "unexpected character ';' at position 12"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sql, values, err := filter.Compile(tc.input, fields, 3)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Errorf("Expected error %v, but got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error compiling %q: %v", tc.input, err)
+			}
+
+			if sql != tc.expectedSQL {
+				t.Errorf("Expected SQL %v, but got %v", tc.expectedSQL, sql)
+			}
+			if !reflect.DeepEqual(values, tc.expectedValues) {
+				t.Errorf("Expected values %v, but got %v", tc.expectedValues, values)
+			}
+		})
+	}
+}
+
+// TestDateConditionOperator tests that an operator DateCondition does not know is an error
+func TestDateConditionOperator(t *testing.T) {
+	field := filter.Field{Expr: "publish_date", Type: filter.Date}
+	params := 0
+	param := func(value any) string {
+		params++
+		return fmt.Sprintf("$%d", params)
+	}
+
+	_, err := filter.DateCondition(field, "==", api.Date{Year: 1954, Precision: api.PrecisionYear}, param)
+	if err == nil || err.Error() != `unsupported date operator "=="` {
+		t.Errorf("Expected an unsupported operator error, but got %v", err)
+	}
+	if params != 0 {
+		t.Errorf("Expected no parameters, but got %d", params)
+	}
+}
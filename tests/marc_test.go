@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"bms/shared/marc"
+	"bytes"
+	"testing"
+)
+
+// TestReadISO2709Errors tests that malformed or MARC-8 records are rejected rather than misread
+func TestReadISO2709Errors(t *testing.T) {
+	var buf bytes.Buffer
+	record := marc.Record{
+		Leader: marc.NewLeader(),
+		Fields: []marc.Field{
+			{Tag: "001", Value: "42"},
+			{Tag: "245", Ind1: '1', Ind2: '0', Subfields: []marc.Subfield{{Code: 'a', Value: "Les Misérables"}}},
+		},
+	}
+	if err := marc.NewWriter(&buf).Write(record); err != nil {
+		t.Fatalf("Error writing record: %v", err)
+	}
+	valid := buf.Bytes()
+
+	// change returns a copy of the record with bytes replaced at an offset
+	change := func(offset int, value string) []byte {
+		data := append([]byte(nil), valid...)
+		copy(data[offset:], value)
+		return data
+	}
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{name: "MARC-8", data: change(9, " "), expected: `record 1: character coding ' ' is not supported, only UTF-8 records (a in leader position 9) can be read`},
+		{name: "Signed base address", data: change(12, "-0049"), expected: `record 1: invalid base address "-0049"`},
+		{name: "Negative field start", data: change(24+7, "-9999"), expected: "record 1: invalid directory entry for field 001"},
+		{name: "Signed field length", data: change(24+3, "+003"), expected: "record 1: invalid directory entry for field 001"},
+	}
+
+	if _, err := marc.NewReader(bytes.NewReader(valid)).Read(); err != nil {
+		t.Fatalf("Error reading the unchanged record: %v", err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := marc.NewReader(bytes.NewReader(tc.data)).Read()
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("Expected error %q, but got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -1,12 +1,21 @@
 package tests
 
 import (
+	"bms/server/filter"
 	"bms/shared/api"
+	"bms/shared/bookio"
+	"bms/shared/citation"
+	"bms/shared/sources"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // readJsonFile reads a JSON file and returns the byte contents
@@ -65,8 +74,42 @@ func mockRouter(w http.ResponseWriter, r *http.Request) {
 	// handle book/create route
 	if r.Method == "POST" && r.URL.Path == "/book/create" {
 		mockCreateBook(w, r)
+	} else if r.Method == "POST" && r.URL.Path == "/book/import" {
+		mockImportBooks(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/book/export" {
+		mockExportBooks(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/collection/cite" {
+		mockCiteCollection(w, r)
 	} else if r.Method == "GET" && r.URL.Path == "/book/list" {
 		mockListBooks(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/book/search" {
+		mockSearchBooks(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/book/facets" {
+		mockBookFacets(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/book/get" {
+		mockGetBook(w, r)
+	} else if (r.Method == "PUT" || r.Method == "PATCH") && r.URL.Path == "/book/set" {
+		mockSetBook(w, r)
+	} else if r.Method == "DELETE" && r.URL.Path == "/book/remove" {
+		mockRemoveBook(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/book/history" {
+		mockBookHistory(w, r)
+	} else if r.Method == "POST" && r.URL.Path == "/book/revert" {
+		mockRevertBook(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/book/identifiers" {
+		mockBookIdentifiers(w, r)
+	} else if r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/book/") && strings.HasSuffix(r.URL.Path, "/cover") {
+		mockSetCover(w, r)
+	} else if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/book/") && strings.HasSuffix(r.URL.Path, "/files") {
+		mockAttachFile(w, r)
+	} else if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/book/") && strings.HasSuffix(r.URL.Path, "/files") {
+		mockListFiles(w, r)
+	} else if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/book/") && strings.Contains(r.URL.Path, "/files/") {
+		mockDownloadFile(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/stats" {
+		mockStats(w, r)
+	} else if r.Method == "GET" && r.URL.Path == "/audit" {
+		mockListAudit(w, r)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -95,6 +138,140 @@ func mockCreateBook(w http.ResponseWriter, r *http.Request) {
 	mockRespondJSON(w, nil, "Book created successfully")
 }
 
+// mockImportBooks mocks the book/import route, mockCurrentBook is the only existing book
+func mockImportBooks(w http.ResponseWriter, r *http.Request) {
+	var books []api.ImportBook
+	err := json.NewDecoder(r.Body).Decode(&books)
+	if err != nil {
+		mockRespondError(w, err, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	mode := api.ImportMode(r.URL.Query().Get("mode"))
+	report := api.ImportReport{DryRun: r.URL.Query().Get("dry_run") == "true"}
+	seen := map[string]int{}
+	collections := map[string]bool{"favourites": true}
+	for i, book := range books {
+		row := api.ImportRow{Index: i, Title: book.Title, Status: api.ImportCreated}
+		if first, ok := seen[book.Title]; ok {
+			row.Status, row.Error = api.ImportFailed, fmt.Sprintf("Title is repeated from book %d", first+1)
+		} else if book.Title == mockCurrentBook.Title && mode != api.ImportSkip {
+			row.Status = api.ImportUpdated
+		} else if book.Title == mockCurrentBook.Title {
+			row.Status = api.ImportSkipped
+		}
+		seen[book.Title] = i
+		report.Add(row)
+
+		// a skipped book is left as it is, so its collections, reading and identifiers are not recorded
+		if row.Status == api.ImportSkipped {
+			continue
+		}
+		for _, name := range book.Collections {
+			if !collections[name] {
+				collections[name] = true
+				report.CollectionsCreated++
+			}
+			report.AddedToCollections++
+		}
+		if book.Rating != 0 || !book.ReadDate.IsZero() {
+			report.ReadingsRecorded++
+		}
+		report.IdentifiersRecorded += len(book.Identifiers)
+	}
+
+	if report.Failed > 0 {
+		response := api.Response{
+			Type:       "error",
+			StatusCode: http.StatusUnprocessableEntity,
+			Code:       api.CodeValidationFailed,
+			Message:    fmt.Sprintf("%d of %d books failed, nothing was imported", report.Failed, len(books)),
+			Data:       report,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	mockRespondJSON(w, report, "Books imported successfully")
+}
+
+// mockExportBooks mocks the book/export route, writing mock_books.json in the requested format.
+// The only collection is "favourites", which holds every book.
+func mockExportBooks(w http.ResponseWriter, r *http.Request) {
+	var books []api.Book
+	data, err := readJsonFile("resources/mock_books.json")
+	if err == nil {
+		err = json.Unmarshal(data, &books)
+	}
+	if err != nil {
+		mockRespondError(w, err, http.StatusInternalServerError, "Error exporting books")
+		return
+	}
+
+	if collection := r.URL.Query().Get("collection"); collection != "" && collection != "favourites" {
+		response := api.Response{
+			Type:       "error",
+			StatusCode: http.StatusNotFound,
+			Code:       api.CodeCollectionNotFound,
+			Message:    "Collection not found",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	format := bookio.Format(r.URL.Query().Get("format"))
+	writer, err := bookio.NewWriter(w, format)
+	if err != nil {
+		mockRespondError(w, nil, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", bookio.ContentType(format))
+	for _, book := range books {
+		writer.Write(book)
+	}
+	writer.Close()
+}
+
+// mockCiteCollection mocks the collection/cite route, citing mock_books.json as the collection "favourites"
+func mockCiteCollection(w http.ResponseWriter, r *http.Request) {
+	var books []api.Book
+	data, err := readJsonFile("resources/mock_books.json")
+	if err == nil {
+		err = json.Unmarshal(data, &books)
+	}
+	if err != nil {
+		mockRespondError(w, err, http.StatusInternalServerError, "Error citing collection")
+		return
+	}
+
+	if r.URL.Query().Get("collection_name") != "favourites" {
+		response := api.Response{
+			Type:       "error",
+			StatusCode: http.StatusNotFound,
+			Code:       api.CodeCollectionNotFound,
+			Message:    "Collection not found",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	sort.Slice(books, func(i, j int) bool { return books[i].Title < books[j].Title })
+	keys := citation.Keys(books)
+	entries := make([]citation.Entry, len(books))
+	for i, book := range books {
+		entries[i] = citation.Entry{Key: keys[i], Book: book}
+	}
+	format := citation.Format(r.URL.Query().Get("format"))
+	w.Header().Set("Content-Type", citation.ContentType(format))
+	citation.Write(w, format, entries)
+}
+
 // mockListBooks mocks the book/list route
 func mockListBooks(w http.ResponseWriter, r *http.Request) {
 	// load mock_book_list.json file in current directory
@@ -108,5 +285,358 @@ func mockListBooks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mockRespondJSON(w, books, "Books listed successfully")
+	// the mock only validates filter expressions, matching books is covered by TestFilterCompile
+	if q := r.URL.Query().Get("q"); q != "" {
+		_, _, err := filter.Compile(q, map[string]filter.Field{"genre": {Expr: "genre", Type: filter.Text}}, 1)
+		if err != nil {
+			mockRespondError(w, nil, http.StatusBadRequest, "invalid q: "+err.Error())
+			return
+		}
+	}
+
+	switch r.URL.Query().Get("sort") {
+	case "":
+	case "-publish_date":
+		sort.Slice(books, func(i, j int) bool {
+			return books[i].PublishDate.Start().After(books[j].PublishDate.Start())
+		})
+	default:
+		mockRespondError(w, nil, http.StatusBadRequest, fmt.Sprintf("cannot sort by %q", r.URL.Query().Get("sort")))
+		return
+	}
+
+	// the mock cursor is the index of the first book of the page
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	end := len(books)
+	if limit, _ := strconv.Atoi(r.URL.Query().Get("limit")); limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	pagination := &api.Pagination{Total: len(books)}
+	if end < len(books) {
+		pagination.NextCursor = strconv.Itoa(end)
+	}
+
+	response := api.Response{
+		Type:       "success",
+		StatusCode: http.StatusOK,
+		Message:    "Books listed successfully",
+		Data:       books[start:end],
+		Pagination: pagination,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// mockSearchBooks mocks the book/search route, matching books that contain every query word
+func mockSearchBooks(w http.ResponseWriter, r *http.Request) {
+	var books []api.Book
+
+	data, err := readJsonFile("resources/mock_books.json")
+	err = json.Unmarshal(data, &books)
+	if err != nil {
+		mockRespondError(w, err, http.StatusInternalServerError, "Error searching books")
+		return
+	}
+
+	results := make([]api.SearchResult, 0)
+	for _, book := range books {
+		document := strings.ToLower(book.Title + " - " + book.Author + " - " + book.Description)
+		matches := true
+		for _, word := range strings.Fields(strings.ToLower(r.URL.Query().Get("q"))) {
+			matches = matches && strings.Contains(document, word)
+		}
+		if matches {
+			results = append(results, api.SearchResult{Book: book, Rank: 0.5, Headline: book.Title})
+		}
+	}
+
+	mockRespondJSON(w, results, "Books searched successfully")
+}
+
+// mockBookFacets mocks the book/facets route, counting the mock books by genre and decade
+func mockBookFacets(w http.ResponseWriter, r *http.Request) {
+	var books []api.Book
+
+	data, err := readJsonFile("resources/mock_books.json")
+	err = json.Unmarshal(data, &books)
+	if err != nil {
+		mockRespondError(w, err, http.StatusInternalServerError, "Error getting book facets")
+		return
+	}
+
+	facets := api.BookFacets{Total: len(books)}
+	for _, field := range strings.Split(r.URL.Query().Get("by"), ",") {
+		facet := api.Facet{Field: field}
+		counts := map[string]int{}
+		for _, book := range books {
+			value := book.Genre
+			if field == "decade" {
+				value = fmt.Sprintf("%ds", book.PublishDate.Year/10*10)
+			}
+			if counts[value] == 0 {
+				facet.Values = append(facet.Values, api.FacetValue{Value: &value})
+			}
+			counts[value]++
+		}
+		for i, value := range facet.Values {
+			facet.Values[i].Count = counts[*value.Value]
+		}
+		facets.Facets = append(facets.Facets, facet)
+	}
+
+	mockRespondJSON(w, facets, "Book facets retrieved successfully")
+}
+
+// mockStats mocks the stats route
+func mockStats(w http.ResponseWriter, r *http.Request) {
+	mockStatsFile(w, "resources/mock_stats.json")
+}
+
+// mockWideStats mocks the stats route of a library with a book dated year 1, whose publish years span
+// two thousand years
+func mockWideStats(w http.ResponseWriter, r *http.Request) {
+	mockStatsFile(w, "resources/mock_stats_wide.json")
+}
+
+// mockStatsFile responds with the stats in a resource file
+func mockStatsFile(w http.ResponseWriter, path string) {
+	var stats api.Stats
+
+	data, err := readJsonFile(path)
+	err = json.Unmarshal(data, &stats)
+	if err != nil {
+		mockRespondError(w, err, http.StatusInternalServerError, "Error getting stats")
+		return
+	}
+
+	mockRespondJSON(w, stats, "Stats retrieved successfully")
+}
+
+// mockListAudit mocks the audit route, filtering entries by actor
+func mockListAudit(w http.ResponseWriter, r *http.Request) {
+	var entries []api.AuditEntry
+
+	data, err := readJsonFile("resources/mock_audit.json")
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		mockRespondError(w, err, http.StatusInternalServerError, "Error getting audit log")
+		return
+	}
+
+	actor := r.URL.Query().Get("actor")
+	filtered := make([]api.AuditEntry, 0)
+	for _, entry := range entries {
+		if actor == "" || entry.Actor == actor {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	mockRespondJSON(w, filtered, "Audit log retrieved successfully")
+}
+
+// mockBookHistory mocks the book/history route
+func mockBookHistory(w http.ResponseWriter, r *http.Request) {
+	var revisions []api.BookRevision
+
+	data, err := readJsonFile("resources/mock_book_history.json")
+	err = json.Unmarshal(data, &revisions)
+	if err != nil {
+		mockRespondError(w, err, http.StatusInternalServerError, "Error getting book history")
+		return
+	}
+
+	mockRespondJSON(w, revisions, "Book history retrieved successfully")
+}
+
+// mockRevertBook mocks the book/revert route, rejecting reverts not based on the current version
+func mockRevertBook(w http.ResponseWriter, r *http.Request) {
+	if !mockVersionMatches(w, r) {
+		return
+	}
+	mockRespondJSON(w, nil, "Book reverted successfully")
+}
+
+// mockCurrentBook is the book returned by the mock book/get route, already at version 2
+var mockCurrentBook = api.Book{Title: "book1", Author: "author1", Genre: "fantasy", Edition: "1", Version: 2}
+
+// mockRespondBookNotFound mocks a book not found response, suggesting mockCurrentBook
+// for titles that differ from it only by case and spacing
+func mockRespondBookNotFound(w http.ResponseWriter, title string) {
+	suggestions := []string{}
+	if strings.ReplaceAll(strings.ToLower(title), " ", "") == mockCurrentBook.Title {
+		suggestions = append(suggestions, mockCurrentBook.Title)
+	}
+
+	response := api.Response{
+		Type:       "error",
+		StatusCode: http.StatusNotFound,
+		Code:       api.CodeBookNotFound,
+		Message:    "Book not found",
+		Data:       api.NotFoundData{Suggestions: suggestions},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(response)
+}
+
+// mockGetBook mocks the book/get route
+func mockGetBook(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("title") != mockCurrentBook.Title {
+		mockRespondBookNotFound(w, r.URL.Query().Get("title"))
+		return
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf("%q", fmt.Sprint(mockCurrentBook.Version)))
+	mockRespondJSON(w, mockCurrentBook, "Book retrieved successfully")
+}
+
+// mockBookIdentifiers mocks the book/identifiers route, mockCurrentBook has an isbn
+func mockBookIdentifiers(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("title") != mockCurrentBook.Title {
+		mockRespondBookNotFound(w, r.URL.Query().Get("title"))
+		return
+	}
+
+	identifiers := []api.Identifier{{BookTitle: mockCurrentBook.Title, Type: "isbn", Value: "9780261103252"}}
+	mockRespondJSON(w, identifiers, "Book identifiers retrieved successfully")
+}
+
+// mockSetCover mocks the book/{id}/cover route, only mockCurrentBook exists and only PNG covers are sent
+func mockSetCover(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/book/"), "/cover")
+	if title != mockCurrentBook.Title {
+		mockRespondBookNotFound(w, title)
+		return
+	}
+	if r.Header.Get("Content-Type") != "image/png" {
+		mockRespondError(w, nil, http.StatusUnsupportedMediaType, "Cover must be a JPEG, PNG or GIF image")
+		return
+	}
+
+	mockRespondJSON(w, nil, "Cover set successfully")
+}
+
+// mockAttachment is the file attached to mockCurrentBook, with the content mockAttachmentContent
+var mockAttachment = api.Attachment{
+	BookTitle:   "book1",
+	Name:        "book1.pdf",
+	ContentType: "application/pdf",
+	Size:        int64(len(mockAttachmentContent)),
+	Hash:        "3f2a9c1d7e5b4a6f8c0d2e4f6a8b0c1d3e5f7a9b1c3d5e7f9a0b2c4d6e8f0a1b",
+	CreatedAt:   time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC),
+}
+
+const mockAttachmentContent = "%PDF-1.4\n%%EOF\n"
+
+// mockAttachFile mocks the book/{id}/files and book/files routes. Only mockCurrentBook exists, and an
+// EPUB attached to a missing book or imported creates the book titled in its metadata.
+func mockAttachFile(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		mockRespondError(w, err, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != sources.EPUBType && contentType != "application/pdf" {
+		mockRespondError(w, nil, http.StatusUnsupportedMediaType, "File must be an EPUB or PDF")
+		return
+	}
+
+	title := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/book/"), "/files")
+	if r.URL.Path == "/book/files" {
+		metadata, err := sources.ReadEPUB(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			mockRespondError(w, err, http.StatusBadRequest, "Invalid EPUB")
+			return
+		}
+		title = metadata.Title
+	}
+	switch {
+	case title == mockCurrentBook.Title:
+		mockRespondJSON(w, nil, "File attached successfully")
+	case contentType == sources.EPUBType:
+		mockRespondJSON(w, nil, fmt.Sprintf("Book %q created from the EPUB metadata and file attached", title))
+	default:
+		mockRespondBookNotFound(w, title)
+	}
+}
+
+// mockListFiles mocks the book/{id}/files route, mockCurrentBook has mockAttachment attached and
+// AC/DC has a file named ..
+func mockListFiles(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/book/"), "/files")
+	if title == "AC/DC" {
+		// a file name that is not safe to download to
+		unsafe := mockAttachment
+		unsafe.BookTitle, unsafe.Name = title, ".."
+		mockRespondJSON(w, []api.Attachment{unsafe}, "Book files retrieved successfully")
+		return
+	}
+	if title != mockCurrentBook.Title {
+		mockRespondBookNotFound(w, title)
+		return
+	}
+	mockRespondJSON(w, []api.Attachment{mockAttachment}, "Book files retrieved successfully")
+}
+
+// mockDownloadFile mocks the book/{id}/files/{hash} route, serving mockAttachmentContent
+func mockDownloadFile(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/book/"+mockCurrentBook.Title+"/files/"+mockAttachment.Hash {
+		mockRespondError(w, nil, http.StatusNotFound, "File not found")
+		return
+	}
+	w.Header().Set("Content-Type", mockAttachment.ContentType)
+	io.WriteString(w, mockAttachmentContent)
+}
+
+// mockRemoveBook mocks the book/remove route, only mockCurrentBook exists
+func mockRemoveBook(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("title") != mockCurrentBook.Title {
+		mockRespondBookNotFound(w, r.URL.Query().Get("title"))
+		return
+	}
+
+	mockRespondJSON(w, nil, "Book removed successfully")
+}
+
+// mockVersionMatches reports whether a write is based on the current version of mockCurrentBook,
+// responding with a version conflict if it is not
+func mockVersionMatches(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("If-Match") != fmt.Sprintf("%q", fmt.Sprint(mockCurrentBook.Version)) {
+		response := api.Response{
+			Type:       "error",
+			StatusCode: http.StatusPreconditionFailed,
+			Code:       api.CodeVersionConflict,
+			Message:    "Book has been modified, expected version 1 but current version is 2",
+			Data:       mockCurrentBook,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(response)
+		return false
+	}
+	return true
+}
+
+// mockSetBook mocks the book/set route, rejecting writes not based on the current version
+func mockSetBook(w http.ResponseWriter, r *http.Request) {
+	if !mockVersionMatches(w, r) {
+		return
+	}
+
+	// merge patches must clear fields with an explicit null
+	if r.Method == "PATCH" {
+		var patch map[string]any
+		err := json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil || r.Header.Get("Content-Type") != api.MergePatchContentType {
+			mockRespondError(w, err, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if value, ok := patch["author"]; !ok || value != nil {
+			mockRespondError(w, nil, http.StatusBadRequest, "author was not cleared")
+			return
+		}
+	}
+
+	mockRespondJSON(w, nil, "Book updated successfully")
 }
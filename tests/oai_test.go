@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"bms/shared/dublincore"
+	"bms/shared/oai"
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestOAIRecords tests how books are written as oai_dc records in a ListRecords response
+func TestOAIRecords(t *testing.T) {
+	book := api.Book{
+		Title:       "Good Omens",
+		Author:      "Terry Pratchett and Neil Gaiman",
+		Publisher:   "Gollancz",
+		PublishDate: api.NewDate(1990, time.May, 1),
+		Genre:       "Humour",
+	}
+	response := oai.Response{
+		ResponseDate: "2024-03-01T12:00:00Z",
+		Request:      oai.Request{Verb: oai.VerbListRecords, MetadataPrefix: oai.DCPrefix, Set: "favourites", BaseURL: "http://localhost:8080/oai"},
+		ListRecords: &oai.ListRecords{
+			Records: []oai.Record{
+				{
+					Header: oai.Header{
+						Identifier: oai.Identifier("bms.localhost", book.Title),
+						Datestamp:  oai.Datestamp(time.Date(2024, time.February, 3, 9, 30, 0, 0, time.UTC)),
+						SetSpecs:   []string{oai.SetSpec("favourites")},
+					},
+					Metadata: oai.NewMetadata(dublincore.FromBook(book, map[string]string{"isbn": "9780575048003"})),
+				},
+				{
+					// a removed book is a deleted record without metadata
+					Header: oai.Header{Status: "deleted", Identifier: oai.Identifier("bms.localhost", "Dune"), Datestamp: "2024-02-04T10:00:00Z"},
+				},
+			},
+			ResumptionToken: &oai.ResumptionToken{CompleteListSize: 102, Cursor: 100},
+		},
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<OAI-PMH xmlns="http://www.openarchives.org/OAI/2.0/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd">
+  <responseDate>2024-03-01T12:00:00Z</responseDate>
+  <request verb="ListRecords" metadataPrefix="oai_dc" set="favourites">http://localhost:8080/oai</request>
+  <ListRecords>
+    <record>
+      <header>
+        <identifier>oai:bms.localhost:Good%20Omens</identifier>
+        <datestamp>2024-02-03T09:30:00Z</datestamp>
+        <setSpec>favourites</setSpec>
+      </header>
+      <metadata>
+        <oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd">
+          <dc:title>Good Omens</dc:title>
+          <dc:creator>Terry Pratchett</dc:creator>
+          <dc:creator>Neil Gaiman</dc:creator>
+          <dc:subject>Humour</dc:subject>
+          <dc:publisher>Gollancz</dc:publisher>
+          <dc:date>1990-05-01</dc:date>
+          <dc:type>Text</dc:type>
+          <dc:identifier>urn:isbn:9780575048003</dc:identifier>
+        </oai_dc:dc>
+      </metadata>
+    </record>
+    <record>
+      <header status="deleted">
+        <identifier>oai:bms.localhost:Dune</identifier>
+        <datestamp>2024-02-04T10:00:00Z</datestamp>
+      </header>
+    </record>
+    <resumptionToken completeListSize="102" cursor="100"></resumptionToken>
+  </ListRecords>
+</OAI-PMH>
+`
+
+	var out bytes.Buffer
+	if err := oai.Write(&out, response); err != nil {
+		t.Fatalf("Error writing response: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected %s, but got %s", expected, out.String())
+	}
+}
+
+// TestOAISetSpecs tests that collection names are escaped as set specs and read back
+func TestOAISetSpecs(t *testing.T) {
+	testCases := []struct {
+		collection string
+		spec       string
+	}{
+		{collection: "favourites", spec: "favourites"},
+		{collection: "Science Fiction", spec: "Science~20Fiction"},
+		{collection: "to-read:2024", spec: "to-read~3A2024"},
+		{collection: "Café ~ Books", spec: "Caf~C3~A9~20~7E~20Books"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.collection, func(t *testing.T) {
+			spec := oai.SetSpec(tc.collection)
+			if spec != tc.spec {
+				t.Errorf("Expected set spec %q, but got %q", tc.spec, spec)
+			}
+			collection, err := oai.Collection(spec)
+			if err != nil || collection != tc.collection {
+				t.Errorf("Expected collection %q, but got %q (%v)", tc.collection, collection, err)
+			}
+		})
+	}
+
+	if _, err := oai.Collection("Science Fiction"); err == nil {
+		t.Errorf("Expected an error reading a set spec with a space")
+	}
+}
+
+// TestOAIArguments tests the parsing of identifiers and datestamps in requests
+func TestOAIArguments(t *testing.T) {
+	identifier := oai.Identifier("bms.localhost", "The Lord of the Rings")
+	if title, ok := oai.Title("bms.localhost", identifier); !ok || title != "The Lord of the Rings" {
+		t.Errorf("Expected the title of %q, but got %q", identifier, title)
+	}
+	if _, ok := oai.Title("other.example.org", identifier); ok {
+		t.Errorf("Expected an identifier of another repository to be rejected")
+	}
+
+	testCases := []struct {
+		value    string
+		until    bool
+		expected time.Time
+		day      bool
+	}{
+		{value: "2024-02-03", expected: time.Date(2024, time.February, 3, 0, 0, 0, 0, time.UTC), day: true},
+		{value: "2024-02-03", until: true, expected: time.Date(2024, time.February, 3, 23, 59, 59, 0, time.UTC), day: true},
+		{value: "2024-02-03T09:30:00Z", until: true, expected: time.Date(2024, time.February, 3, 9, 30, 0, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		parsed, day, err := oai.ParseDatestamp(tc.value, tc.until)
+		if err != nil || !parsed.Equal(tc.expected) || day != tc.day {
+			t.Errorf("Expected %v (day %v) for %q, but got %v (day %v, %v)", tc.expected, tc.day, tc.value, parsed, day, err)
+		}
+	}
+	if _, _, err := oai.ParseDatestamp("2024-02-03T09:30", false); err == nil {
+		t.Errorf("Expected an error for a datestamp without seconds")
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"bms/shared/opds"
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestOPDSFeed tests how books are written as the entries of an OPDS acquisition feed
+func TestOPDSFeed(t *testing.T) {
+	updated := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	feed := opds.Feed{
+		ID:           "urn:bms:opds:books",
+		Title:        "All books",
+		Updated:      opds.Timestamp(updated),
+		TotalResults: 2,
+		ItemsPerPage: 100,
+		Links: []opds.Link{
+			{Rel: opds.RelSelf, Href: "/opds/books", Type: opds.AcquisitionType},
+			{Rel: opds.RelSearch, Href: "/opds/opensearch.xml", Type: opds.OpenSearchType},
+		},
+		Entries: []opds.Entry{
+			opds.BookEntry(api.Book{
+				Title:       "Good Omens",
+				Author:      "Terry Pratchett and Neil Gaiman",
+				Publisher:   "Gollancz",
+				PublishDate: api.Date{Year: 1990, Precision: api.PrecisionYear},
+				Genre:       "Humour",
+				Description: "The world ends on a Saturday & the angel is late.",
+			}, updated,
+				opds.Link{Rel: opds.RelAlternate, Href: "/book/get?title=Good+Omens", Type: "application/json"},
+				opds.Link{Rel: opds.RelImage, Href: "/book/Good%20Omens/cover", Type: "image/png"},
+				opds.Link{Rel: opds.RelThumbnail, Href: "/book/Good%20Omens/cover?size=medium", Type: "image/jpeg"},
+				opds.Link{Rel: opds.RelAcquisition, Href: "/book/Good%20Omens/files/5d41402a", Type: "application/epub+zip", Title: "Good Omens.epub", Length: 1024}),
+			// a book without metadata has only a title, an identifier and an updated time
+			opds.BookEntry(api.Book{Title: "Untitled Notes"}, updated),
+		},
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/terms/" xmlns:opds="http://opds-spec.org/2010/catalog" xmlns:opensearch="http://a9.com/-/spec/opensearch/1.1/">
+  <id>urn:bms:opds:books</id>
+  <title>All books</title>
+  <updated>2024-03-01T12:00:00Z</updated>
+  <opensearch:totalResults>2</opensearch:totalResults>
+  <opensearch:itemsPerPage>100</opensearch:itemsPerPage>
+  <link rel="self" href="/opds/books" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>
+  <link rel="search" href="/opds/opensearch.xml" type="application/opensearchdescription+xml"></link>
+  <entry>
+    <title>Good Omens</title>
+    <id>urn:bms:book:Good%20Omens</id>
+    <updated>2024-03-01T12:00:00Z</updated>
+    <author>
+      <name>Terry Pratchett</name>
+    </author>
+    <author>
+      <name>Neil Gaiman</name>
+    </author>
+    <dc:publisher>Gollancz</dc:publisher>
+    <dc:issued>1990</dc:issued>
+    <category term="Humour" label="Humour"></category>
+    <content type="text">The world ends on a Saturday &amp; the angel is late.</content>
+    <link rel="alternate" href="/book/get?title=Good+Omens" type="application/json"></link>
+    <link rel="http://opds-spec.org/image" href="/book/Good%20Omens/cover" type="image/png"></link>
+    <link rel="http://opds-spec.org/image/thumbnail" href="/book/Good%20Omens/cover?size=medium" type="image/jpeg"></link>
+    <link rel="http://opds-spec.org/acquisition" href="/book/Good%20Omens/files/5d41402a" type="application/epub+zip" title="Good Omens.epub" length="1024"></link>
+  </entry>
+  <entry>
+    <title>Untitled Notes</title>
+    <id>urn:bms:book:Untitled%20Notes</id>
+    <updated>2024-03-01T12:00:00Z</updated>
+  </entry>
+</feed>
+`
+
+	var out bytes.Buffer
+	if err := opds.Write(&out, feed); err != nil {
+		t.Fatalf("Error writing feed: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected %s, but got %s", expected, out.String())
+	}
+}
+
+// TestOpenSearchDescription tests that the search description points clients at an acquisition feed
+func TestOpenSearchDescription(t *testing.T) {
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+  <ShortName>Books</ShortName>
+  <Description>Search the books</Description>
+  <InputEncoding>UTF-8</InputEncoding>
+  <Url type="application/atom+xml;profile=opds-catalog;kind=acquisition" template="http://localhost:8080/opds/search?q={searchTerms}"></Url>
+</OpenSearchDescription>
+`
+
+	var out bytes.Buffer
+	err := opds.WriteOpenSearch(&out, opds.OpenSearchDescription{
+		ShortName:   "Books",
+		Description: "Search the books",
+		Template:    "http://localhost:8080/opds/search?q={searchTerms}",
+	})
+	if err != nil {
+		t.Fatalf("Error writing OpenSearch description: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected %s, but got %s", expected, out.String())
+	}
+}
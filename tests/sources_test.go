@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"bms/shared/sources"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSources tests how Goodreads and LibraryThing exports are mapped to books, collections and readings
+func TestSources(t *testing.T) {
+	testCases := []struct {
+		name     string
+		file     string
+		read     func(io.Reader) (sources.Result, error)
+		expected []api.ImportBook
+		skipped  []sources.Skip
+	}{
+		{
+			name: "Goodreads",
+			file: "resources/goodreads_library_export.csv",
+			read: sources.ReadGoodreads,
+			expected: []api.ImportBook{
+				{
+					Book:        api.Book{Title: "The Hobbit", Author: "J.R.R. Tolkien", Publisher: "Houghton Mifflin", PublishDate: api.Date{Year: 2002, Precision: api.PrecisionYear}},
+					Collections: []string{"fantasy", "favourites", "read"},
+					Rating:      5,
+					ReadDate:    api.NewDate(2023, time.May, 14),
+				},
+				{
+					// the original publication year is used without an edition year
+					Book:        api.Book{Title: "book1", Author: "J.K. Rowling", Publisher: "Scholastic", PublishDate: api.Date{Year: 1997, Precision: api.PrecisionYear}},
+					Collections: []string{"to-read"},
+				},
+			},
+			skipped: []sources.Skip{
+				{Position: "row 3 (line 4)", Reason: "title is empty"},
+				{Position: "row 4 (line 5)", Reason: "title is repeated from row 1 (line 2)"},
+			},
+		},
+		{
+			name: "LibraryThing",
+			file: "resources/librarything_export.tsv",
+			read: sources.ReadLibraryThing,
+			expected: []api.ImportBook{
+				{
+					Book: api.Book{Title: "A Wizard of Earthsea", Author: "Le Guin, Ursula K.", Publisher: "Parnassus Press", Edition: "1st",
+						PublishDate: api.Date{Year: 1968, Precision: api.PrecisionYear}, Genre: "fantasy"},
+					Collections: []string{"Your library", "Favorites"},
+					Rating:      4.5,
+					ReadDate:    api.NewDate(2022, time.November, 3),
+				},
+				{
+					Book:        api.Book{Title: "Dune", Author: "Herbert, Frank", Publisher: "Chilton Books", PublishDate: api.Date{Year: 1965, Precision: api.PrecisionYear}},
+					Collections: []string{"Wishlist"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := os.Open(tc.file)
+			if err != nil {
+				t.Fatalf("Error opening export: %v", err)
+			}
+			defer file.Close()
+
+			result, err := tc.read(file)
+			if err != nil {
+				t.Fatalf("Error reading export: %v", err)
+			}
+			books := make([]api.ImportBook, len(result.Entries))
+			for i, entry := range result.Entries {
+				books[i] = entry.Book
+			}
+			if !reflect.DeepEqual(books, tc.expected) {
+				t.Errorf("Expected %+v, but got %+v", tc.expected, books)
+			}
+			if !reflect.DeepEqual(result.Skipped, tc.skipped) {
+				t.Errorf("Expected skipped rows %+v, but got %+v", tc.skipped, result.Skipped)
+			}
+		})
+	}
+}
+
+// TestCalibre tests how a Calibre library is mapped to books, with its series and tags as collections
+func TestCalibre(t *testing.T) {
+	expected := []api.ImportBook{
+		{
+			Book: api.Book{
+				Title:       "The Fellowship of the Ring",
+				Author:      "J.R.R. Tolkien",
+				Publisher:   "Allen & Unwin",
+				PublishDate: api.NewDate(1954, time.July, 29),
+				Description: "The first volume of The Lord of the Rings.\n\nFrodo & Sam set out.",
+				Genre:       "Fantasy",
+			},
+			Collections: []string{"The Lord of the Rings", "Fantasy"},
+			Rating:      4.5,
+			Identifiers: map[string]string{"isbn": "9780261102354", "goodreads": "34"},
+		},
+		{
+			Book: api.Book{
+				Title:       "Good Omens",
+				Author:      "Terry Pratchett and Neil Gaiman",
+				Publisher:   "Gollancz",
+				PublishDate: api.NewDate(1990, time.May, 1),
+				Genre:       "Humour",
+			},
+			Collections: []string{"Humour", "Fantasy"},
+			Identifiers: map[string]string{"isbn": "9780575048003"},
+		},
+		{
+			// Calibre stores an unknown publish date in year 101
+			Book: api.Book{Title: "Untitled Notes"},
+		},
+	}
+
+	result, err := sources.ReadCalibre("resources/calibre", sources.CalibreOptions{TagCollections: true})
+	if err != nil {
+		t.Fatalf("Error reading Calibre library: %v", err)
+	}
+	books := make([]api.ImportBook, len(result.Entries))
+	for i, entry := range result.Entries {
+		books[i] = entry.Book
+	}
+	if !reflect.DeepEqual(books, expected) {
+		t.Errorf("Expected %+v, but got %+v", expected, books)
+	}
+
+	if _, err := sources.ReadCalibre("resources", sources.CalibreOptions{}); err == nil {
+		t.Errorf("Expected an error reading a folder without metadata.db")
+	}
+}
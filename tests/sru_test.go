@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"bms/shared/api"
+	"bms/shared/bookio"
+	"bms/shared/dublincore"
+	"bms/shared/marc"
+	"bms/shared/sru"
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestSRUSearchRetrieve tests how books are written as Dublin Core and MARCXML records in a searchRetrieve response
+func TestSRUSearchRetrieve(t *testing.T) {
+	book := api.Book{
+		Title:       "Good Omens",
+		Author:      "Terry Pratchett and Neil Gaiman",
+		PublishDate: api.NewDate(1990, time.May, 1),
+		Genre:       "Humour",
+	}
+	packed, err := sru.RecordData{DC: sru.NewDC(dublincore.FromBook(book, nil))}.Packed()
+	if err != nil {
+		t.Fatalf("Error packing record: %v", err)
+	}
+	response := &sru.SearchRetrieveResponse{
+		NumberOfRecords: 12,
+		Records: &sru.Records{Records: []sru.Record{
+			{Schema: sru.SchemaDC, Packing: "xml", Data: sru.RecordData{DC: sru.NewDC(dublincore.FromBook(book, map[string]string{"isbn": "9780575048003"}))}, Position: 1},
+			{Schema: sru.SchemaMARCXML, Packing: "xml", Data: sru.RecordData{MARC: &marc.XMLElement{Record: bookio.MARCRecord(book)}}, Position: 2},
+			{Schema: sru.SchemaDC, Packing: "string", Data: packed, Position: 3},
+		}},
+		NextRecordPosition: 4,
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<searchRetrieveResponse xmlns="http://www.loc.gov/zing/srw/">
+  <version>1.2</version>
+  <numberOfRecords>12</numberOfRecords>
+  <records>
+    <record>
+      <recordSchema>info:srw/schema/1/dc-v1.1</recordSchema>
+      <recordPacking>xml</recordPacking>
+      <recordData>
+        <srw_dc:dc xmlns:srw_dc="info:srw/schema/1/dc-schema" xmlns:dc="http://purl.org/dc/elements/1.1/">
+          <dc:title>Good Omens</dc:title>
+          <dc:creator>Terry Pratchett</dc:creator>
+          <dc:creator>Neil Gaiman</dc:creator>
+          <dc:subject>Humour</dc:subject>
+          <dc:date>1990-05-01</dc:date>
+          <dc:type>Text</dc:type>
+          <dc:identifier>urn:isbn:9780575048003</dc:identifier>
+        </srw_dc:dc>
+      </recordData>
+      <recordPosition>1</recordPosition>
+    </record>
+    <record>
+      <recordSchema>info:srw/schema/1/marcxml-v1.1</recordSchema>
+      <recordPacking>xml</recordPacking>
+      <recordData>
+        <record xmlns="http://www.loc.gov/MARC21/slim">
+          <leader>00000nam a2200000 i 4500</leader>
+          <datafield tag="100" ind1="0" ind2=" ">
+            <subfield code="a">Terry Pratchett and Neil Gaiman</subfield>
+          </datafield>
+          <datafield tag="245" ind1="1" ind2="0">
+            <subfield code="a">Good Omens</subfield>
+          </datafield>
+          <datafield tag="264" ind1=" " ind2="1">
+            <subfield code="c">1990-05-01</subfield>
+          </datafield>
+          <datafield tag="650" ind1=" " ind2="4">
+            <subfield code="a">Humour</subfield>
+          </datafield>
+        </record>
+      </recordData>
+      <recordPosition>2</recordPosition>
+    </record>
+    <record>
+      <recordSchema>info:srw/schema/1/dc-v1.1</recordSchema>
+      <recordPacking>string</recordPacking>
+      <recordData>&lt;srw_dc:dc xmlns:srw_dc=&#34;info:srw/schema/1/dc-schema&#34; xmlns:dc=&#34;http://purl.org/dc/elements/1.1/&#34;&gt;&lt;dc:title&gt;Good Omens&lt;/dc:title&gt;&lt;dc:creator&gt;Terry Pratchett&lt;/dc:creator&gt;&lt;dc:creator&gt;Neil Gaiman&lt;/dc:creator&gt;&lt;dc:subject&gt;Humour&lt;/dc:subject&gt;&lt;dc:date&gt;1990-05-01&lt;/dc:date&gt;&lt;dc:type&gt;Text&lt;/dc:type&gt;&lt;/srw_dc:dc&gt;</recordData>
+      <recordPosition>3</recordPosition>
+    </record>
+  </records>
+  <nextRecordPosition>4</nextRecordPosition>
+</searchRetrieveResponse>
+`
+
+	var out bytes.Buffer
+	if err := sru.Write(&out, response); err != nil {
+		t.Fatalf("Error writing response: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected %s, but got %s", expected, out.String())
+	}
+}
+
+// TestSRUDiagnostic tests a diagnostic of a query in a searchRetrieve response
+func TestSRUDiagnostic(t *testing.T) {
+	response := &sru.SearchRetrieveResponse{Diagnostics: &sru.Diagnostics{Diagnostics: []sru.Diagnostic{
+		sru.NewDiagnostic(sru.DiagUnsupportedValue, "", "maximumRecords"),
+	}}}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<searchRetrieveResponse xmlns="http://www.loc.gov/zing/srw/">
+  <version>1.2</version>
+  <numberOfRecords>0</numberOfRecords>
+  <diagnostics>
+    <diagnostic xmlns="http://www.loc.gov/zing/srw/diagnostic/">
+      <uri>info:srw/diagnostic/1/6</uri>
+      <details>maximumRecords</details>
+      <message>Unsupported parameter value</message>
+    </diagnostic>
+  </diagnostics>
+</searchRetrieveResponse>
+`
+
+	var out bytes.Buffer
+	if err := sru.Write(&out, response); err != nil {
+		t.Fatalf("Error writing response: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected %s, but got %s", expected, out.String())
+	}
+}
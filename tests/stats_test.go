@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"bms/client/cmd"
+	"bms/shared/api"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestYearValues tests that publish years are counted by year, and summed by decade, century or
+// millennium when they span more years than a sparkline fits
+func TestYearValues(t *testing.T) {
+	testCases := []struct {
+		name           string
+		years          []string
+		expectedValues []int
+		expectedBucket string
+	}{
+		{name: "Years", years: []string{"1954", "1956", "1957"}, expectedValues: []int{1, 0, 1, 1}},
+		{name: "Decades", years: []string{"1905", "1954", "1999"}, expectedValues: []int{1, 0, 0, 0, 0, 1, 0, 0, 0, 1}, expectedBucket: "decade"},
+		{name: "Centuries", years: []string{"0001", "1954", "1997"}, expectedValues: append(append([]int{1}, make([]int, 18)...), 2), expectedBucket: "century"},
+		{name: "Millennia", years: []string{"0001", "9999"}, expectedValues: []int{1, 0, 0, 0, 0, 0, 0, 0, 0, 1}, expectedBucket: "millennium"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			counts := make([]api.FacetValue, len(tc.years))
+			for i := range tc.years {
+				counts[i] = api.FacetValue{Value: &tc.years[i], Count: 1}
+			}
+			values, bucket := cmd.YearValues(counts)
+			if !reflect.DeepEqual(values, tc.expectedValues) || bucket != tc.expectedBucket {
+				t.Errorf("Expected %v by %q, but got %v by %q", tc.expectedValues, tc.expectedBucket, values, bucket)
+			}
+		})
+	}
+}
+
+// TestStatsWideSparkline tests the sparkline of publish years spanning two thousand years, which are
+// drawn by century
+func TestStatsWideSparkline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(mockWideStats))
+	defer server.Close()
+	serverURL := cmd.ServerUrl
+	cmd.ServerUrl = server.URL
+	defer func() { cmd.ServerUrl = serverURL }()
+
+	buf := new(bytes.Buffer)
+	cmd.RootCmd.SetOut(buf)
+	cmd.RootCmd.SetErr(new(bytes.Buffer))
+	resetFlags(cmd.RootCmd)
+	cmd.RootCmd.SetArgs([]string{"stats", "--chart", "sparkline"})
+	if err := cmd.RootCmd.Execute(); err != nil {
+		t.Fatalf("Error getting stats: %v", err)
+	}
+
+	expected := "PUBLISH YEAR\n0001 ▂" + strings.Repeat("▁", 18) + "█ 1997 (by century, max 5)\n"
+	if !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("Expected output ending with %v, but got %v", expected, buf.String())
+	}
+}
@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"bms/server/thumbnail"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// TestThumbnailFit tests that thumbnails keep the aspect ratio, average the pixels they cover and blend
+// transparency with white
+func TestThumbnailFit(t *testing.T) {
+	// a 300x200 image, black on the left half and transparent on the right half
+	src := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 150; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{A: 0xff})
+		}
+	}
+
+	testCases := []struct {
+		size           int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{size: 96, expectedWidth: 96, expectedHeight: 64},
+		{size: 100, expectedWidth: 100, expectedHeight: 66},
+		{size: 480, expectedWidth: 300, expectedHeight: 200},
+	}
+	for _, tc := range testCases {
+		thumb := thumbnail.Fit(src, tc.size)
+		if thumb.Bounds().Dx() != tc.expectedWidth || thumb.Bounds().Dy() != tc.expectedHeight {
+			t.Errorf("Expected %dx%d for size %d, but got %v", tc.expectedWidth, tc.expectedHeight, tc.size, thumb.Bounds())
+		}
+		if c := thumb.RGBAAt(0, 0); c != (color.RGBA{A: 0xff}) {
+			t.Errorf("Expected a black left edge for size %d, but got %v", tc.size, c)
+		}
+		if c := thumb.RGBAAt(thumb.Bounds().Dx()-1, 0); c != (color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}) {
+			t.Errorf("Expected a white right edge for size %d, but got %v", tc.size, c)
+		}
+	}
+
+	// a 2x1 thumbnail of a 4x1 image averages each pair of pixels
+	stripes := image.NewGray(image.Rect(0, 0, 4, 1))
+	stripes.Pix = []uint8{0, 200, 100, 100}
+	thumb := thumbnail.Fit(stripes, 2)
+	if left, right := thumb.RGBAAt(0, 0).R, thumb.RGBAAt(1, 0).R; left != 100 || right != 100 {
+		t.Errorf("Expected averaged pixels of 100, but got %d and %d", left, right)
+	}
+
+	var out bytes.Buffer
+	if err := thumbnail.Encode(&out, thumbnail.Fit(src, 96)); err != nil {
+		t.Fatalf("Error encoding thumbnail: %v", err)
+	}
+	config, err := jpeg.DecodeConfig(&out)
+	if err != nil || config.Width != 96 || config.Height != 64 {
+		t.Errorf("Expected a 96x64 JPEG, but got %+v (%v)", config, err)
+	}
+}